@@ -0,0 +1,111 @@
+/*
+Copyright © 2022 Adedayo Adetoye (aka Dayo)
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/0-trust/service/pkg/analysis"
+	"github.com/spf13/cobra"
+)
+
+var (
+	checkRules    string
+	checkDataPath string
+	checkFailOn   string
+	checkAsJSON   bool
+)
+
+// checkCmd represents the check command
+var checkCmd = &cobra.Command{
+	Use:   "check <model.yaml>",
+	Short: "Check an OTM threat model against zero trust rules",
+	Long: `Check an OTM threat model against the built-in zero trust rules and any rules in the --rules directory
+(YAML or Rego-like .rules files), which is the rules directory of the --data directory unless given, as for the API
+service. Exits with a non-zero status if there are findings at or above the --fail-on severity,
+so that it can be used as a CI gate`,
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if !analysis.IsSeverity(checkFailOn) {
+			return fmt.Errorf("--fail-on is %q, use one of %s", checkFailOn, strings.Join(analysis.Severities, ", "))
+		}
+		model, err := readModelFile(args[0])
+		if err != nil {
+			return fmt.Errorf("%s: %w", args[0], err)
+		}
+		rulesPath := checkRules
+		if rulesPath == "" {
+			rulesPath = path.Join(checkDataPath, "rules")
+		}
+		rules, err := analysis.LoadRules(rulesPath)
+		if err != nil {
+			return err
+		}
+
+		findings := analysis.CheckRules(model, rules)
+		if checkAsJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(findings); err != nil {
+				return err
+			}
+		} else {
+			for _, f := range findings {
+				fmt.Printf("%-8s %s: %s\n", f.Severity, f.RuleID, f.Message)
+			}
+		}
+
+		failed := 0
+		for _, f := range findings {
+			if f.AtLeast(checkFailOn) {
+				failed++
+			}
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d finding(s) at or above %s severity", failed, checkFailOn)
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(checkCmd)
+	checkCmd.Flags().StringVar(&checkRules, "rules", "", "Directory or file of additional rules (default is the rules directory of --data)")
+	checkCmd.Flags().StringVar(&checkDataPath, "data", "", "Base data directory of the projects")
+	checkCmd.Flags().StringVar(&checkFailOn, "fail-on", "low", "Fail if there are findings of this severity or above: info, low, medium, high or critical")
+	checkCmd.Flags().BoolVar(&checkAsJSON, "json", false, "Output the findings as JSON")
+}
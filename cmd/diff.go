@@ -0,0 +1,77 @@
+/*
+Copyright © 2022 Adedayo Adetoye (aka Dayo)
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	otm_transform "github.com/0-trust/service/pkg/otm"
+	"github.com/spf13/cobra"
+)
+
+var (
+	diffAsJSON bool
+)
+
+// diffCmd represents the diff command
+var diffCmd = &cobra.Command{
+	Use:   "diff <from.yaml> <to.yaml>",
+	Short: "Show the structural difference between two OTM threat models",
+	Long: `Show the trust zones, components, data flows, threats and mitigations that were added, removed or changed
+between two OTM threat models. Elements are matched by their IDs, so key ordering and formatting are ignored`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		from, err := readModelFile(args[0])
+		if err != nil {
+			return fmt.Errorf("%s: %w", args[0], err)
+		}
+		to, err := readModelFile(args[1])
+		if err != nil {
+			return fmt.Errorf("%s: %w", args[1], err)
+		}
+
+		diff := otm_transform.DiffModels(from, to)
+		if diffAsJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(diff)
+		}
+		fmt.Print(diff)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(diffCmd)
+	diffCmd.Flags().BoolVar(&diffAsJSON, "json", false, "Output the difference as JSON")
+}
@@ -0,0 +1,167 @@
+/*
+Copyright © 2022 Adedayo Adetoye (aka Dayo)
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/0-trust/service/pkg/analysis"
+	"github.com/0-trust/service/pkg/ingest"
+	"github.com/0-trust/service/pkg/projects"
+	otm "github.com/adedayo/open-threat-model/pkg"
+	"github.com/spf13/cobra"
+)
+
+var (
+	driftProject, driftDataPath, driftZones string
+	driftAsJSON, driftFail                  bool
+)
+
+// driftCmd represents the drift command
+var driftCmd = &cobra.Command{
+	Use:   "drift [model.yaml] <flows.csv|conn.log|capture.pcap|flow log>",
+	Short: "Compare the data flows of an OTM threat model with observed traffic",
+	Long: `Compare the data flows of an OTM threat model, given as a file or the model of the --project, with the traffic in
+a flow log, Zeek conn.log, packet capture or cloud flow log (see import flows for the formats and --zones). Reports
+the observed flows that aren't modelled (shadow connections), the modelled flows that weren't observed (candidates for
+removal) and the observed flows between trust zones that the model isolates. With --fail, exits with a non-zero status
+if there are shadow flows or isolation breaches`,
+	Args:          cobra.RangeArgs(1, 2),
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var model otm.OpenThreatModel
+		var err error
+		switch {
+		case len(args) == 2 && driftProject == "":
+			model, err = readModelFile(args[0])
+			if err != nil {
+				return fmt.Errorf("%s: %w", args[0], err)
+			}
+		case len(args) == 1 && driftProject != "":
+			if model, err = readProjectModel(driftDataPath, driftProject); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("give either a model file or a --project, and a flow log")
+		}
+
+		zones, err := readZoneMap(driftZones)
+		if err != nil {
+			return err
+		}
+		logFile := args[len(args)-1]
+		in, err := os.Open(logFile)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		observed, err := ingest.ReadFlows(in, zones)
+		if err != nil {
+			return fmt.Errorf("%s: %w", logFile, err)
+		}
+
+		report := analysis.DetectDrift(model, observed)
+		if driftAsJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(report); err != nil {
+				return err
+			}
+		} else {
+			printDrift(report)
+		}
+		if driftFail && len(report.Shadow)+len(report.IsolationBreaches) > 0 {
+			return fmt.Errorf("%d shadow flow(s) and %d isolation breach(es)", len(report.Shadow), len(report.IsolationBreaches))
+		}
+		return nil
+	},
+}
+
+func printDrift(report analysis.DriftReport) {
+	fmt.Printf("%d observed flows, %d modelled flows, %d observed flows match the model\n", report.Observed, report.Modelled, report.Matched)
+	observed := func(title string, flows []analysis.ObservedFlow) {
+		fmt.Printf("\n%s: %d\n", title, len(flows))
+		for _, f := range flows {
+			label := ""
+			if f.Label != "" {
+				label = " [" + f.Label + "]"
+			}
+			seen := ""
+			if f.FirstSeen != "" {
+				seen = fmt.Sprintf(", %d bytes, %s to %s", f.Bytes, f.FirstSeen, f.LastSeen)
+			}
+			fmt.Printf("  %s (%s) -> %s (%s)%s, %d observation(s)%s\n", f.Source, f.SourceZone, f.Target, f.TargetZone, label, f.Observations, seen)
+		}
+	}
+	observed("Shadow flows, observed but not modelled", report.Shadow)
+	observed("Isolation breaches, observed between isolated zones", report.IsolationBreaches)
+	fmt.Printf("\nUnobserved flows, modelled but not observed: %d\n", len(report.Unobserved))
+	for _, f := range report.Unobserved {
+		note := ""
+		if !f.EndpointsObserved {
+			note = " (an end wasn't seen in the traffic)"
+		}
+		fmt.Printf("  %s: %s -> %s%s\n", f.FlowID, f.Source, f.Destination, note)
+	}
+	if len(report.UnknownHosts) > 0 {
+		fmt.Printf("\nHosts not in the model: %s\n", strings.Join(report.UnknownHosts, ", "))
+	}
+}
+
+// readProjectModel reads the current threat model of a project
+func readProjectModel(dataPath, projectID string) (otm.OpenThreatModel, error) {
+	pm, err := projects.OpenDBProjectManager(dataPath)
+	if err != nil {
+		return otm.OpenThreatModel{}, err
+	}
+	if closer, closes := pm.(io.Closer); closes {
+		defer closer.Close()
+	}
+	msg, err := pm.GetModel(projectID)
+	if err != nil {
+		return otm.OpenThreatModel{}, fmt.Errorf("project %s: %w", projectID, err)
+	}
+	return otm.Parse(strings.NewReader(msg.ThreatModel))
+}
+
+func init() {
+	rootCmd.AddCommand(driftCmd)
+	driftCmd.Flags().StringVar(&driftProject, "project", "", "ID of the project whose model to compare")
+	driftCmd.Flags().StringVar(&driftDataPath, "data", "", "Base data directory of the projects")
+	driftCmd.Flags().StringVar(&driftZones, "zones", "", "CSV of CIDRs and the zones of the hosts in them")
+	driftCmd.Flags().BoolVar(&driftAsJSON, "json", false, "Output the report as JSON")
+	driftCmd.Flags().BoolVar(&driftFail, "fail", false, "Fail if there are shadow flows or isolation breaches")
+}
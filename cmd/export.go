@@ -0,0 +1,113 @@
+/*
+Copyright © 2022 Adedayo Adetoye (aka Dayo)
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	otm_transform "github.com/0-trust/service/pkg/otm"
+	"github.com/spf13/cobra"
+)
+
+var (
+	exportOutput string
+)
+
+// exportCmd represents the export command
+var exportCmd = &cobra.Command{
+	Use:   "export <format> <model.yaml>",
+	Short: "Export an OTM threat model to another format",
+	Long: fmt.Sprintf(`Export an OTM threat model to one of the formats: %s.
+The netpol format generates Kubernetes NetworkPolicy manifests that deny all traffic but DNS in the namespaces of the model,
+except for its data flows. Trust zones and components are mapped to Kubernetes by their namespace and labels
+attributes, and flows are limited to their port attribute (e.g. 443, 53/UDP or 8000-8080) or protocol.
+The istio format generates Istio AuthorizationPolicies that allow the data flows of the model by the service accounts
+(serviceAccount attribute) of their sources and the method and path attributes of HTTP flows, with STRICT mTLS
+PeerAuthentication and an allow-nothing policy in each namespace.
+The opa format generates an OPA bundle (.tar.gz) of a Rego policy, data.zerotrust.authz.allow, and data.json of the
+zones, component identities and allowed flows of the model, to test requests against with policy test. As a binary
+format, it is only written to a file (-o) or redirected output, not to a terminal`,
+		strings.Join(exportFormats(), ", ")),
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		model, err := readModelFile(args[1])
+		if err != nil {
+			return fmt.Errorf("%s: %w", args[1], err)
+		}
+		format := strings.ToLower(args[0])
+		if _, binary := otm_transform.BundleFormats[format]; binary {
+			if exportOutput == "" {
+				if info, err := os.Stdout.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+					return fmt.Errorf("the %s format is binary, write it to a file with -o or redirect the output", format)
+				}
+			}
+			bundle, err := otm_transform.ExportBundle(model, format)
+			if err != nil {
+				return err
+			}
+			if exportOutput == "" {
+				_, err = os.Stdout.Write(bundle)
+				return err
+			}
+			return os.WriteFile(exportOutput, bundle, 0644)
+		}
+
+		out, err := otm_transform.Export(model, format)
+		if err != nil {
+			return err
+		}
+		if exportOutput == "" {
+			_, err = fmt.Print(out)
+			return err
+		}
+		return os.WriteFile(exportOutput, []byte(out), 0644)
+	},
+}
+
+func exportFormats() []string {
+	formats := []string{}
+	for f := range otm_transform.ExportFormats {
+		formats = append(formats, f)
+	}
+	for f := range otm_transform.BundleFormats {
+		formats = append(formats, f)
+	}
+	sort.Strings(formats)
+	return formats
+}
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+	exportCmd.Flags().StringVarP(&exportOutput, "output", "o", "", "File to write the export to")
+}
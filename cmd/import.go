@@ -0,0 +1,286 @@
+/*
+Copyright © 2022 Adedayo Adetoye (aka Dayo)
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/0-trust/service/pkg/ingest"
+	otm_transform "github.com/0-trust/service/pkg/otm"
+	"github.com/0-trust/service/pkg/projects"
+	"github.com/spf13/cobra"
+)
+
+var (
+	importProject, importNewProject, importDataPath, importOutput string
+	importAPIComponent, importAPIClients, importZones             string
+)
+
+// importCmd represents the import command
+var importCmd = &cobra.Command{
+	Use:   "import",
+	Short: "Infer a draft OTM threat model from observed or declared infrastructure",
+	Long: `Infer a draft OTM threat model from a source such as network flow logs, Kubernetes manifests or OpenAPI specifications. The draft is
+written out as OTM YAML, saved as the threat model of a new project with --create, or, with --project, added to the
+threat model of a project as a new revision, keeping the elements the project already has`,
+}
+
+// importFlowsCmd represents the import flows command
+var importFlowsCmd = &cobra.Command{
+	Use:   "flows <flows.csv|conn.log|capture.pcap|flow log>",
+	Short: "Infer a draft OTM threat model from observed network flows",
+	Long: `Infer a draft OTM threat model from observed network flows: a CSV with the columns source zone, target zone,
+label (e.g. protocol), source host, target host and weight (e.g. connection count), or a header row naming them;
+a Zeek conn.log, tab separated or JSON; a libpcap capture; or exported AWS VPC Flow Logs (versions 2 to 5), GCP VPC
+flow logs (JSON) or Azure NSG flow logs. The connections of these logs and captures are aggregated into flows between
+hosts with their ports, protocols, byte counts and first and last seen times. Cloud flow logs put hosts in the zones
+of their subnets, VPCs or security groups. Other hosts without a zone are put in the zones of their networks in
+--zones, a CSV of CIDRs and zone names.
+Zones become trust zones, hosts become components and the flows between hosts become weighted data flows`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		zones, err := readZoneMap(importZones)
+		if err != nil {
+			return err
+		}
+		in, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		zf, err := ingest.ReadFlows(in, zones)
+		if err != nil {
+			return fmt.Errorf("%s: %w", args[0], err)
+		}
+		return writeDraft(zf.DraftModel(filepath.Base(args[0])))
+	},
+}
+
+// readZoneMap reads a CIDR to zone table (see ingest.ReadZoneMap), if a file is given
+func readZoneMap(path string) (*ingest.ZoneMap, error) {
+	if path == "" {
+		return nil, nil
+	}
+	in, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	zones, err := ingest.ReadZoneMap(in)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return zones, nil
+}
+
+// importKubernetesCmd represents the import kubernetes command
+var importKubernetesCmd = &cobra.Command{
+	Use:     "kubernetes <manifests>...",
+	Aliases: []string{"k8s"},
+	Short:   "Infer a draft OTM threat model from Kubernetes manifests",
+	Long: `Infer a draft OTM threat model from the Kubernetes manifests of YAML or JSON files, or directories of them.
+Namespaces become trust zones and workloads become components, and Ingresses become components in front of the
+workloads they route to. Data flows are inferred from the internet to Ingresses and LoadBalancer or NodePort Services,
+from workloads to the Services named in their container environment or arguments, and from NetworkPolicy rules.
+Pod labels, container ports and service accounts are kept as attributes`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		km, err := ingest.ReadKubernetesManifests(args...)
+		if err != nil {
+			return err
+		}
+		for _, warning := range km.Warnings {
+			fmt.Fprintln(os.Stderr, warning)
+		}
+		return writeDraft(km.DraftModel(filepath.Base(filepath.Clean(args[0]))))
+	},
+}
+
+// importTerraformCmd represents the import terraform command
+var importTerraformCmd = &cobra.Command{
+	Use:     "terraform <show.json>",
+	Aliases: []string{"tf"},
+	Short:   "Infer a draft OTM threat model from Terraform state or a plan",
+	Long: `Infer a draft OTM threat model from Terraform state or a plan, in the JSON output of terraform show -json.
+VPCs, networks and subnets become nested trust zones, and instances, databases, load balancers, functions and other
+services become components in their subnets. Security group, firewall and network security group rules become data
+flows. IDs are derived from Terraform addresses, so a changed configuration can be imported into the same project with
+--project`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		in, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		tf, err := ingest.ReadTerraformJSON(in)
+		if err != nil {
+			return fmt.Errorf("%s: %w", args[0], err)
+		}
+		return writeDraft(tf.DraftModel(filepath.Base(args[0])))
+	},
+}
+
+// importComposeCmd represents the import compose command
+var importComposeCmd = &cobra.Command{
+	Use:     "compose <docker-compose.yml>",
+	Aliases: []string{"docker-compose"},
+	Short:   "Infer a draft OTM threat model from a Compose file",
+	Long: `Infer a draft OTM threat model from a Compose file (docker-compose.yml). Networks become trust zones and
+services become components. Data flows are inferred from depends_on and links, and to the services listening on
+ports in a shared network. Services that publish ports on the host are marked internet facing, with flows from the internet`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		in, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		cf, err := ingest.ReadComposeFile(in)
+		if err != nil {
+			return fmt.Errorf("%s: %w", args[0], err)
+		}
+		source := filepath.Base(args[0])
+		if dir := filepath.Base(filepath.Dir(args[0])); dir != "." && dir != string(filepath.Separator) {
+			source = dir
+		}
+		return writeDraft(cf.DraftModel(source))
+	},
+}
+
+// importOpenAPICmd represents the import openapi command
+var importOpenAPICmd = &cobra.Command{
+	Use:   "openapi <spec>[=<component>]...",
+	Short: "Infer the interfaces of components and their data from OpenAPI specifications",
+	Long: `Infer a draft OTM threat model from OpenAPI 3 specifications, in YAML or JSON. Each specification is the API of
+the component whose ID follows it after =, or of --component, or else of a new component named after its title. Each
+operation becomes a data flow into the component from --clients (the internet by default), with the authentication it
+requires, or none. The schemas of request bodies and successful responses become data assets, rated by the
+sensitivity that their field names suggest, e.g. password, cardNumber or email`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		specs := ingest.NewOpenAPISpecs()
+		specs.Clients = importAPIClients
+		for _, arg := range args {
+			path, component, mapped := strings.Cut(arg, "=")
+			if !mapped {
+				component = importAPIComponent
+			}
+			if err := readOpenAPI(specs, path, component); err != nil {
+				return err
+			}
+		}
+		path, _, _ := strings.Cut(args[0], "=")
+		return writeDraft(specs.DraftModel(filepath.Base(path)))
+	},
+}
+
+func readOpenAPI(specs *ingest.OpenAPISpecs, path, component string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if err := specs.Read(in, component); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// writeDraft imports a draft model into the --project, creates a project of it, or writes it as an OTM document
+func writeDraft(m *ingest.Model) error {
+	if importProject != "" && importNewProject != "" {
+		return errors.New("use either --project or --create")
+	}
+	name := strings.TrimSuffix(m.Source, filepath.Ext(m.Source))
+	if importProject != "" || importNewProject != "" {
+		pm, err := projects.OpenDBProjectManager(importDataPath)
+		if err != nil {
+			return err
+		}
+		if closer, closes := pm.(io.Closer); closes {
+			defer closer.Close()
+		}
+		if importNewProject != "" {
+			proj, saved, err := ingest.CreateProject(pm, projects.ProjectDescription{
+				Name:        importNewProject,
+				Description: fmt.Sprintf("Imported from %s", m.Source),
+				Owner:       os.Getenv("USER"),
+			}, m, os.Getenv("USER"))
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "Created project %s (%s), revision %s\n", proj.Name, proj.ID, saved.Revision)
+			return nil
+		}
+		saved, added, err := ingest.ImportIntoProject(pm, importProject, m, os.Getenv("USER"))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Imported %d elements into project %s, revision %s\n", added, importProject, saved.Revision)
+		return nil
+	}
+
+	doc, err := m.Document(otm_transform.ProjectInfo{Name: name, ID: ingest.NewModel("").NewID(name)})
+	if err != nil {
+		return err
+	}
+	tm, err := doc.String()
+	if err != nil {
+		return err
+	}
+	if importOutput == "" {
+		fmt.Print(tm)
+		return nil
+	}
+	return os.WriteFile(importOutput, []byte(tm), 0644)
+}
+
+func init() {
+	rootCmd.AddCommand(importCmd)
+	importCmd.AddCommand(importFlowsCmd)
+	importFlowsCmd.Flags().StringVar(&importZones, "zones", "", "CSV of CIDRs and the zones of the hosts in them")
+	importCmd.AddCommand(importKubernetesCmd)
+	importCmd.AddCommand(importTerraformCmd)
+	importCmd.AddCommand(importComposeCmd)
+	importCmd.AddCommand(importOpenAPICmd)
+	importOpenAPICmd.Flags().StringVar(&importAPIComponent, "component", "", "ID of the component that implements the APIs")
+	importOpenAPICmd.Flags().StringVar(&importAPIClients, "clients", "", "ID of the component that calls the APIs, instead of the internet")
+	importCmd.PersistentFlags().StringVar(&importProject, "project", "", "ID of the project to import the draft model into")
+	importCmd.PersistentFlags().StringVar(&importNewProject, "create", "", "Name of a new project to create with the draft model")
+	importCmd.PersistentFlags().StringVar(&importDataPath, "data", "", "Base data directory of the projects")
+	importCmd.PersistentFlags().StringVarP(&importOutput, "output", "o", "", "File to write the draft model to, if not importing into a project")
+}
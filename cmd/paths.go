@@ -0,0 +1,89 @@
+/*
+Copyright © 2022 Adedayo Adetoye (aka Dayo)
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/0-trust/service/pkg/analysis"
+	"github.com/spf13/cobra"
+)
+
+var (
+	pathsOptions analysis.AttackPathOptions
+	pathsAsJSON  bool
+)
+
+// pathsCmd represents the paths command
+var pathsCmd = &cobra.Command{
+	Use:   "paths <model.yaml>",
+	Short: "Find attack paths from entry points to high value components of an OTM threat model",
+	Long: `Follow the data flows of an OTM threat model from entry points to high value targets, and list the shortest
+path to each target reached and the most likely paths overall. Entering more trusted zones, authenticated flows and
+implemented mitigations make a step less likely. Without --from, components tagged entry-point (or else those in
+internet-facing zones) are the entry points; without --to, components tagged high-value, crown-jewel or pii
+(or else the data stores) are the targets`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		model, err := readModelFile(args[0])
+		if err != nil {
+			return fmt.Errorf("%s: %w", args[0], err)
+		}
+		paths := analysis.FindAttackPaths(model, pathsOptions)
+		if pathsAsJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(paths)
+		}
+
+		fmt.Printf("Entry points: %s\nTargets: %s\n", strings.Join(paths.EntryPoints, ", "), strings.Join(paths.Targets, ", "))
+		fmt.Println("\nMost likely paths:")
+		for i, p := range paths.MostLikely {
+			fmt.Printf("%3d. %6.2f%%  %s\n", i+1, p.Likelihood*100, strings.Join(p.Nodes, " -> "))
+		}
+		fmt.Println("\nShortest paths:")
+		for _, p := range paths.Shortest {
+			fmt.Printf("%3d hop(s)  %s\n", p.Hops, strings.Join(p.Nodes, " -> "))
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(pathsCmd)
+	pathsCmd.Flags().StringSliceVar(&pathsOptions.EntryPoints, "from", nil, "IDs of the entry points")
+	pathsCmd.Flags().StringSliceVar(&pathsOptions.Targets, "to", nil, "IDs of the targets")
+	pathsCmd.Flags().IntVarP(&pathsOptions.K, "k", "k", analysis.DefaultAttackPaths, "Number of most likely paths to find")
+	pathsCmd.Flags().BoolVar(&pathsAsJSON, "json", false, "Output the paths as JSON")
+}
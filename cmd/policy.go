@@ -0,0 +1,266 @@
+/*
+Copyright © 2022 Adedayo Adetoye (aka Dayo)
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	otm_transform "github.com/0-trust/service/pkg/otm"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	policyProject, policyDataPath, policyOPA string
+	policyRequest                 otm_transform.PolicyRequest
+	policyAsJSON                  bool
+)
+
+// policyTestCase is a sample request with the decision expected of it, if any
+type policyTestCase struct {
+	Name                         string `json:"name,omitempty" yaml:"name,omitempty"`
+	otm_transform.PolicyRequest  `yaml:",inline"`
+	Expect                       string `json:"expect,omitempty" yaml:"expect,omitempty"` //allow or deny
+	otm_transform.PolicyDecision `yaml:"-"`
+	Passed                       *bool `json:"passed,omitempty" yaml:"-"`
+}
+
+// policyCmd represents the policy command
+var policyCmd = &cobra.Command{
+	Use:   "policy",
+	Short: "Work with the connectivity policies generated from OTM threat models",
+	Long: `Work with the connectivity policies generated from OTM threat models. The OPA bundle of a model is exported with
+export opa, and allows exactly the data flows of the model`,
+}
+
+// policyTestCmd represents the policy test command
+var policyTestCmd = &cobra.Command{
+	Use:   "test [model.yaml|bundle.tar.gz] [requests.yaml]",
+	Short: "Evaluate sample requests against the policy generated from a threat model",
+	Long: `Evaluate sample requests locally against the policy of the OPA bundle of a threat model, given as a model file, a
+bundle exported with export opa, or the model of the --project. The Rego policy is evaluated with opa eval if opa is
+on the PATH or given with --opa, and otherwise by a built-in evaluator that makes the same decisions. The requests are
+a YAML or JSON list such as
+
+  - name: web reads orders
+    source: web
+    destination: db
+    port: 5432
+    expect: allow
+  - {source: 10.20.1.7, destination: web, method: DELETE, path: /orders/1, expect: deny}
+
+or a single request given with --source, --destination, --port, --protocol, --method and --path. Hosts are element
+IDs or names, host attributes, service identities such as spiffe://cluster.local/ns/shop/sa/web, or IP addresses.
+Exits with a non-zero status if a decision isn't the expected one`,
+	Args:          cobra.MaximumNArgs(2),
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		single := policyRequest.Source != "" || policyRequest.Destination != ""
+		policyArgs := 1
+		if policyProject != "" {
+			policyArgs = 0
+		}
+		if !single {
+			policyArgs++
+		}
+		if len(args) != policyArgs {
+			return fmt.Errorf("give either a model file, a bundle or a --project, and either a requests file or a --source and --destination")
+		}
+
+		var data otm_transform.PolicyData
+		var bundle []byte
+		var err error
+		if policyProject != "" {
+			model, err := readProjectModel(policyDataPath, policyProject)
+			if err != nil {
+				return err
+			}
+			data = otm_transform.OtmToPolicyData(model)
+			if bundle, err = otm_transform.OtmToOPABundle(model); err != nil {
+				return err
+			}
+		} else if file := args[0]; strings.HasSuffix(file, ".tar.gz") || strings.HasSuffix(file, ".tgz") {
+			if bundle, err = os.ReadFile(file); err != nil {
+				return err
+			}
+			if data, err = otm_transform.ReadOPABundle(bytes.NewReader(bundle)); err != nil {
+				return fmt.Errorf("%s: %w", file, err)
+			}
+		} else {
+			model, err := readModelFile(file)
+			if err != nil {
+				return fmt.Errorf("%s: %w", file, err)
+			}
+			data = otm_transform.OtmToPolicyData(model)
+			if bundle, err = otm_transform.OtmToOPABundle(model); err != nil {
+				return err
+			}
+		}
+
+		cases := []policyTestCase{{PolicyRequest: policyRequest}}
+		if !single {
+			file := args[len(args)-1]
+			if cases, err = readPolicyTestCases(file); err != nil {
+				return fmt.Errorf("%s: %w", file, err)
+			}
+		}
+		requests := []otm_transform.PolicyRequest{}
+		for _, c := range cases {
+			requests = append(requests, c.PolicyRequest)
+		}
+		decisions, err := decidePolicyRequests(data, bundle, requests)
+		if err != nil {
+			return err
+		}
+
+		failed := 0
+		for i, c := range cases {
+			c.PolicyDecision = decisions[i]
+			if expect := strings.ToLower(c.Expect); expect != "" {
+				passed := c.Allow == (expect == "allow")
+				c.Passed = &passed
+				if !passed {
+					failed++
+				}
+			}
+			cases[i] = c
+		}
+		if policyAsJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(cases); err != nil {
+				return err
+			}
+		} else {
+			printPolicyTests(cases)
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d of %d request(s) weren't decided as expected", failed, len(cases))
+		}
+		return nil
+	},
+}
+
+// decidePolicyRequests decides requests with opa eval on the bundle of the policy data if opa is available, and
+// otherwise with the built-in evaluator of the policy
+func decidePolicyRequests(data otm_transform.PolicyData, bundle []byte, requests []otm_transform.PolicyRequest) ([]otm_transform.PolicyDecision, error) {
+	opa := policyOPA
+	if opa == "" {
+		path, err := exec.LookPath("opa")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "opa isn't on the PATH, so the requests are decided by the built-in evaluator of the policy")
+			decisions := []otm_transform.PolicyDecision{}
+			for _, req := range requests {
+				decisions = append(decisions, data.Evaluate(req))
+			}
+			return decisions, nil
+		}
+		opa = path
+	}
+	return otm_transform.EvaluateWithOPA(opa, bundle, requests)
+}
+
+// readPolicyTestCases reads a list of sample requests, or a single one
+func readPolicyTestCases(file string) ([]policyTestCase, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var node yaml.Node
+	if err := yaml.Unmarshal(data, &node); err != nil {
+		return nil, err
+	}
+	cases := []policyTestCase{}
+	if len(node.Content) > 0 && node.Content[0].Kind == yaml.MappingNode {
+		var c policyTestCase
+		err = node.Decode(&c)
+		cases = append(cases, c)
+	} else {
+		err = node.Decode(&cases)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i, c := range cases {
+		if c.Source == "" || c.Destination == "" {
+			return nil, fmt.Errorf("request %d needs a source and a destination", i+1)
+		}
+		if e := strings.ToLower(c.Expect); e != "" && e != "allow" && e != "deny" {
+			return nil, fmt.Errorf("request %d: expect is allow or deny, not %q", i+1, c.Expect)
+		}
+	}
+	return cases, nil
+}
+
+func printPolicyTests(cases []policyTestCase) {
+	for _, c := range cases {
+		decision := "DENY "
+		if c.Allow {
+			decision = "ALLOW"
+		}
+		result := ""
+		if c.Passed != nil && *c.Passed {
+			result = "ok     "
+		} else if c.Passed != nil {
+			result = "FAILED "
+		}
+		name := ""
+		if c.Name != "" {
+			name = c.Name + ": "
+		}
+		flows := ""
+		if len(c.Flows) > 0 {
+			flows = " (" + strings.Join(c.Flows, ", ") + ")"
+		}
+		fmt.Printf("%s%s %s%s%s\n", result, decision, name, c.PolicyRequest, flows)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(policyCmd)
+	policyCmd.AddCommand(policyTestCmd)
+	policyTestCmd.Flags().StringVar(&policyProject, "project", "", "ID of the project whose model to test")
+	policyTestCmd.Flags().StringVar(&policyDataPath, "data", "", "Base data directory of the projects")
+	policyTestCmd.Flags().StringVar(&policyRequest.Source, "source", "", "Source of a single request")
+	policyTestCmd.Flags().StringVar(&policyRequest.Destination, "destination", "", "Destination of a single request")
+	policyTestCmd.Flags().IntVar(&policyRequest.Port, "port", 0, "Port of a single request")
+	policyTestCmd.Flags().StringVar(&policyRequest.Protocol, "protocol", "", "Transport protocol of a single request (TCP by default)")
+	policyTestCmd.Flags().StringVar(&policyRequest.Method, "method", "", "HTTP method of a single request")
+	policyTestCmd.Flags().StringVar(&policyRequest.Path, "path", "", "HTTP path of a single request")
+	policyTestCmd.Flags().StringVar(&policyOPA, "opa", "", "Path of the opa executable that evaluates the Rego policy (opa on the PATH by default)")
+	policyTestCmd.Flags().BoolVar(&policyAsJSON, "json", false, "Output the decisions as JSON")
+}
@@ -0,0 +1,99 @@
+/*
+Copyright © 2022 Adedayo Adetoye (aka Dayo)
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/0-trust/service/pkg/analysis"
+	otm_transform "github.com/0-trust/service/pkg/otm"
+	"github.com/spf13/cobra"
+)
+
+var (
+	renderOutput, renderFormat string
+	renderAttackPaths          bool
+)
+
+// renderCmd represents the render command
+var renderCmd = &cobra.Command{
+	Use:   "render <model.yaml>",
+	Short: "Render an OTM threat model as an SVG or PNG diagram",
+	Long: `Render the trust zones, components and data flows of an OTM threat model as an SVG or PNG diagram.
+The format is taken from the extension of the output file, unless --format is given. Without an output file,
+the diagram is written to the standard output. With --attack-paths, the flows of the most likely attack paths
+(see the paths command) are highlighted`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		model, err := readModelFile(args[0])
+		if err != nil {
+			return fmt.Errorf("%s: %w", args[0], err)
+		}
+
+		format := strings.ToLower(renderFormat)
+		if format == "" {
+			format = strings.TrimPrefix(strings.ToLower(filepath.Ext(renderOutput)), ".")
+		}
+		var highlight []string
+		if renderAttackPaths {
+			highlight = analysis.FindAttackPaths(model, analysis.AttackPathOptions{}).Flows()
+		}
+		var diagram []byte
+		switch format {
+		case "", "svg":
+			svg, e := otm_transform.OtmToSVGHighlighting(model, highlight)
+			diagram, err = []byte(svg), e
+		case "png":
+			diagram, err = otm_transform.OtmToPNGHighlighting(model, highlight)
+		default:
+			return fmt.Errorf("unsupported format %s, use svg or png", format)
+		}
+		if err != nil {
+			return err
+		}
+
+		if renderOutput == "" {
+			_, err = os.Stdout.Write(diagram)
+			return err
+		}
+		return os.WriteFile(renderOutput, diagram, 0644)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(renderCmd)
+	renderCmd.Flags().StringVarP(&renderOutput, "output", "o", "", "File to write the diagram to")
+	renderCmd.Flags().StringVar(&renderFormat, "format", "", "Diagram format: svg or png")
+	renderCmd.Flags().BoolVar(&renderAttackPaths, "attack-paths", false, "Highlight the most likely attack paths")
+}
@@ -0,0 +1,89 @@
+/*
+Copyright © 2022 Adedayo Adetoye (aka Dayo)
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice,
+   this list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its contributors
+   may be used to endorse or promote products derived from this software
+   without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/0-trust/service/pkg/analysis"
+	"github.com/spf13/cobra"
+)
+
+var (
+	threatsAsJSON bool
+	threatsOutput string
+)
+
+// threatsCmd represents the threats command
+var threatsCmd = &cobra.Command{
+	Use:   "threats <model.yaml>",
+	Short: "Propose STRIDE threats and mitigations for an OTM threat model",
+	Long: `Walk the trust zones, components and data flows of an OTM threat model and propose STRIDE threats with
+suggested mitigations, e.g. spoofing on flows from less trusted zones and tampering on unauthenticated flows.
+The model is written out with the proposals added to its threats and mitigations sections, marked as generated,
+unless --json is given, which lists the proposals instead`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		model, err := readModelFile(args[0])
+		if err != nil {
+			return fmt.Errorf("%s: %w", args[0], err)
+		}
+		proposals := analysis.GenerateThreats(model)
+		if threatsAsJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(proposals)
+		}
+
+		doc, err := os.ReadFile(args[0])
+		if err != nil {
+			return err
+		}
+		tm, added, err := analysis.AddThreats(string(doc), proposals)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Added %d threats\n", added)
+		if threatsOutput == "" {
+			fmt.Print(tm)
+			return nil
+		}
+		return os.WriteFile(threatsOutput, []byte(tm), 0644)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(threatsCmd)
+	threatsCmd.Flags().BoolVar(&threatsAsJSON, "json", false, "List the proposed threats as JSON instead of writing the model")
+	threatsCmd.Flags().StringVarP(&threatsOutput, "output", "o", "", "File to write the model with the threats to")
+}
@@ -0,0 +1,218 @@
+package analysis
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/0-trust/service/pkg/ingest"
+	otm_transform "github.com/0-trust/service/pkg/otm"
+	otm "github.com/adedayo/open-threat-model/pkg"
+)
+
+// DriftReport compares the data flows of a model with observed traffic
+type DriftReport struct {
+	//observed flows that the model doesn't have: shadow connections
+	Shadow []ObservedFlow `json:"shadow"`
+	//modelled flows that weren't observed: candidates for removal under least privilege
+	Unobserved []ModelledFlow `json:"unobserved"`
+	//observed flows between trust zones that the model has no flows between, or that are marked isolated
+	IsolationBreaches []ObservedFlow `json:"isolationBreaches"`
+	//observed hosts that match no component of the model
+	UnknownHosts []string `json:"unknownHosts"`
+	Observed     int      `json:"observed"` //number of distinct observed flows
+	Modelled     int      `json:"modelled"`
+	Matched      int      `json:"matched"` //number of observed flows that match a modelled flow
+}
+
+// ObservedFlow is a distinct flow of observed traffic, with the elements of the model that its hosts match
+type ObservedFlow struct {
+	Source       string  `json:"source"` //observed host
+	Target       string  `json:"target"`
+	Label        string  `json:"label,omitempty"`
+	SourceZone   string  `json:"sourceZone,omitempty"` //observed zone
+	TargetZone   string  `json:"targetZone,omitempty"`
+	Weight       float64 `json:"weight"`
+	Observations int     `json:"observations"`
+	//of flows aggregated from connections, e.g. of Zeek logs or packet captures
+	Ports     string `json:"ports,omitempty"`
+	Bytes     int64  `json:"bytes,omitempty"`
+	FirstSeen string `json:"firstSeen,omitempty"`
+	LastSeen  string `json:"lastSeen,omitempty"`
+	//the components and trust zones of the model that the ends of the flow match, if any
+	SourceElement   string `json:"sourceElement,omitempty"`
+	TargetElement   string `json:"targetElement,omitempty"`
+	SourceTrustZone string `json:"sourceTrustZone,omitempty"`
+	TargetTrustZone string `json:"targetTrustZone,omitempty"`
+}
+
+// ModelledFlow is a data flow of the model that wasn't observed
+type ModelledFlow struct {
+	FlowID      string `json:"flowID"`
+	Name        string `json:"name"`
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	//both ends of the flow were seen in the traffic, so the absence of the flow is significant
+	EndpointsObserved bool `json:"endpointsObserved"`
+}
+
+// DetectDrift compares the data flows of a model with observed traffic. Observed hosts match components by ID, name,
+// or a host, hostname, ip or address attribute, and observed zones match trust zones by ID or name. Two trust zones are
+// isolated from one another if the model has no flow between them, or either has the attribute isolated: true
+func DetectDrift(model otm.OpenThreatModel, observed ingest.ZoneFlows) DriftReport {
+	ix := newModelIndex(model)
+	hosts := ix.hostIndex()
+	zoneNames := make(map[string]string)
+	for _, tz := range model.TrustZones {
+		zoneNames[strings.ToLower(tz.ID)], zoneNames[strings.ToLower(tz.Name)] = tz.ID, tz.ID
+	}
+	zoneOf := func(element, observedZone string) string {
+		if tz, found := ix.zoneOf(element); found {
+			return tz.ID
+		}
+		return zoneNames[strings.ToLower(observedZone)]
+	}
+
+	//the modelled flows and zone pairs, both ways for bidirectional flows
+	modelled := make(map[[2]string][]string)
+	zonePairs := make(map[[2]string]bool)
+	for _, df := range model.DataFlows {
+		ends := [][2]string{{df.Source, df.Destination}}
+		if df.Bidirectional {
+			ends = append(ends, [2]string{df.Destination, df.Source})
+		}
+		for _, e := range ends {
+			modelled[e] = append(modelled[e], df.ID)
+			zonePairs[[2]string{zoneOf(e[0], ""), zoneOf(e[1], "")}] = true
+		}
+	}
+
+	report := DriftReport{
+		Shadow:            []ObservedFlow{},
+		Unobserved:        []ModelledFlow{},
+		IsolationBreaches: []ObservedFlow{},
+		UnknownHosts:      []string{},
+		Modelled:          len(model.DataFlows),
+	}
+	seenFlows := make(map[string]bool)
+	seenElements := make(map[string]bool)
+	unknown := make(map[string]bool)
+	for _, of := range aggregateFlows(observed) {
+		of.SourceElement, of.TargetElement = hosts[strings.ToLower(of.Source)], hosts[strings.ToLower(of.Target)]
+		of.SourceTrustZone, of.TargetTrustZone = zoneOf(of.SourceElement, of.SourceZone), zoneOf(of.TargetElement, of.TargetZone)
+		for _, end := range [][2]string{{of.Source, of.SourceElement}, {of.Target, of.TargetElement}} {
+			if end[1] == "" {
+				unknown[end[0]] = true
+			} else {
+				seenElements[end[1]] = true
+			}
+		}
+
+		report.Observed++
+		if flows := modelled[[2]string{of.SourceElement, of.TargetElement}]; of.SourceElement != "" && of.TargetElement != "" && len(flows) > 0 {
+			report.Matched++
+			for _, id := range flows {
+				seenFlows[id] = true
+			}
+		} else {
+			report.Shadow = append(report.Shadow, of)
+		}
+
+		from, to := of.SourceTrustZone, of.TargetTrustZone
+		if from != "" && to != "" && from != to &&
+			(!zonePairs[[2]string{from, to}] || isIsolated(ix.zones[from]) || isIsolated(ix.zones[to])) {
+			report.IsolationBreaches = append(report.IsolationBreaches, of)
+		}
+	}
+
+	for _, df := range model.DataFlows {
+		if !seenFlows[df.ID] {
+			report.Unobserved = append(report.Unobserved, ModelledFlow{
+				FlowID:            df.ID,
+				Name:              df.Name,
+				Source:            df.Source,
+				Destination:       df.Destination,
+				EndpointsObserved: seenElements[df.Source] && seenElements[df.Destination],
+			})
+		}
+	}
+	for host := range unknown {
+		report.UnknownHosts = append(report.UnknownHosts, host)
+	}
+	sort.Strings(report.UnknownHosts)
+	return report
+}
+
+// hostIndex maps the lower-cased names by which hosts may appear in traffic to the components of the model
+func (ix *modelIndex) hostIndex() map[string]string {
+	hosts := make(map[string]string)
+	add := func(name, id string) {
+		for _, n := range []string{name, ingest.BasicHostName(name)} {
+			if n = strings.ToLower(strings.TrimSpace(n)); n != "" {
+				if _, exists := hosts[n]; !exists {
+					hosts[n] = id
+				}
+			}
+		}
+	}
+	//explicit host attributes take precedence over IDs and names
+	for _, c := range ix.model.Components {
+		for _, k := range otm_transform.HostAttributes {
+			for _, h := range strings.FieldsFunc(otm_transform.Attribute(c.Attributes, k), func(r rune) bool { return r == ',' || r == ' ' }) {
+				add(h, c.ID)
+			}
+		}
+	}
+	for _, c := range ix.model.Components {
+		add(c.ID, c.ID)
+		add(c.Name, c.ID)
+	}
+	return hosts
+}
+
+func isIsolated(tz otm.TrustZone) bool {
+	return otm_transform.Asserted(tz.Attributes, "isolated")
+}
+
+// aggregateFlows combines the observed flows between two hosts with the same label
+func aggregateFlows(zf ingest.ZoneFlows) []ObservedFlow {
+	flows := []ObservedFlow{}
+	index := make(map[[3]string]int)
+	for _, of := range zf.Flows() {
+		key := [3]string{of.Source, of.Target, of.Label}
+		i, exists := index[key]
+		if !exists {
+			i = len(flows)
+			index[key] = i
+			flows = append(flows, ObservedFlow{
+				Source:     of.Source,
+				Target:     of.Target,
+				Label:      of.Label,
+				SourceZone: of.SourceZone,
+				TargetZone: of.TargetZone,
+			})
+		}
+		f := &flows[i]
+		f.Weight += float64(of.Weight)
+		f.Observations++
+		if of.Port != "" && !strings.Contains(","+f.Ports+",", ","+of.Port+",") {
+			f.Ports = strings.TrimPrefix(f.Ports+","+of.Port, ",")
+		}
+		f.Bytes += of.Bytes
+		if first := timestamp(of.FirstSeen); first != "" && (f.FirstSeen == "" || first < f.FirstSeen) {
+			f.FirstSeen = first
+		}
+		if last := timestamp(of.LastSeen); last > f.LastSeen {
+			f.LastSeen = last
+		}
+	}
+	return flows
+}
+
+// timestamp formats a time in UTC as RFC 3339, which sorts in time order, or empty if the time isn't known
+func timestamp(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,353 @@
+package analysis
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	otm_transform "github.com/0-trust/service/pkg/otm"
+)
+
+// condition is a parsed rule condition, evaluated against the facts of an element.
+// The condition language is a small boolean expression language, e.g.
+//
+//	crossesBoundary and not (encrypted or attributes.encryption exists)
+//	source.zone.internetFacing and destination.kind in ["datastore", "queue"]
+//	zone.trustRating < 50 and attributes.owner missing
+//
+// A fact on its own is true if it is true, or a non-empty string that doesn't deny (e.g. "none" or "false")
+type condition interface {
+	eval(facts map[string]interface{}) bool
+}
+
+type andCondition []condition
+type orCondition []condition
+type notCondition struct{ c condition }
+type comparison struct {
+	fact  string
+	op    string //one of == != < <= > >= in contains exists missing, or empty for a truth test
+	value interface{}
+}
+
+func (a andCondition) eval(facts map[string]interface{}) bool {
+	for _, c := range a {
+		if !c.eval(facts) {
+			return false
+		}
+	}
+	return true
+}
+
+func (o orCondition) eval(facts map[string]interface{}) bool {
+	for _, c := range o {
+		if c.eval(facts) {
+			return true
+		}
+	}
+	return false
+}
+
+func (n notCondition) eval(facts map[string]interface{}) bool {
+	return !n.c.eval(facts)
+}
+
+func (c comparison) eval(facts map[string]interface{}) bool {
+	v, exists := facts[c.fact]
+	switch c.op {
+	case "exists":
+		return exists
+	case "missing":
+		return !exists
+	}
+	if !exists {
+		return false
+	}
+
+	switch c.op {
+	case "":
+		switch x := v.(type) {
+		case bool:
+			return x
+		case string:
+			return x != "" && !otm_transform.Denies(x)
+		case float64:
+			return x != 0
+		}
+		return false
+	case "==":
+		return equal(v, c.value)
+	case "!=":
+		return !equal(v, c.value)
+	case "in":
+		for _, item := range c.value.([]interface{}) {
+			if equal(v, item) {
+				return true
+			}
+		}
+		return false
+	case "contains":
+		if list, isList := v.([]string); isList {
+			for _, item := range list {
+				if equal(item, c.value) {
+					return true
+				}
+			}
+			return false
+		}
+		return strings.Contains(strings.ToLower(fmt.Sprint(v)), strings.ToLower(fmt.Sprint(c.value)))
+	}
+
+	//ordering comparisons are numeric
+	a, aIsNumber := toNumber(v)
+	b, bIsNumber := toNumber(c.value)
+	if !aIsNumber || !bIsNumber {
+		return false
+	}
+	switch c.op {
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	case ">=":
+		return a >= b
+	}
+	return false
+}
+
+// equal compares values case-insensitively, numerically if both are numbers
+func equal(a, b interface{}) bool {
+	if x, isNumber := toNumber(a); isNumber {
+		if y, isNumber := toNumber(b); isNumber {
+			return x == y
+		}
+	}
+	return strings.EqualFold(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+func toNumber(v interface{}) (float64, bool) {
+	switch x := v.(type) {
+	case float64:
+		return x, true
+	case string:
+		f, err := strconv.ParseFloat(x, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// parseCondition parses a condition expression
+func parseCondition(expression string) (condition, error) {
+	tokens, err := tokenise(expression)
+	if err != nil {
+		return nil, err
+	}
+	p := &conditionParser{tokens: tokens}
+	c, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q in condition %q", p.tokens[p.pos].text, expression)
+	}
+	return c, nil
+}
+
+type token struct {
+	text   string
+	quoted bool
+}
+
+func tokenise(s string) ([]token, error) {
+	tokens := []token{}
+	runes := []rune(s)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '"' || r == '\'':
+			end := i + 1
+			for end < len(runes) && runes[end] != r {
+				end++
+			}
+			if end == len(runes) {
+				return nil, fmt.Errorf("unterminated string in condition %q", s)
+			}
+			tokens = append(tokens, token{text: string(runes[i+1 : end]), quoted: true})
+			i = end + 1
+		case strings.ContainsRune("()[],", r):
+			tokens = append(tokens, token{text: string(r)})
+			i++
+		case strings.ContainsRune("=!<>", r):
+			end := i + 1
+			if end < len(runes) && runes[end] == '=' {
+				end++
+			}
+			tokens = append(tokens, token{text: string(runes[i:end])})
+			i = end
+		default:
+			end := i
+			for end < len(runes) && !unicode.IsSpace(runes[end]) && !strings.ContainsRune(`()[],=!<>"'`, runes[end]) {
+				end++
+			}
+			tokens = append(tokens, token{text: string(runes[i:end])})
+			i = end
+		}
+	}
+	return tokens, nil
+}
+
+type conditionParser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *conditionParser) peek() (token, bool) {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos], true
+	}
+	return token{}, false
+}
+
+func (p *conditionParser) next() (token, error) {
+	t, ok := p.peek()
+	if !ok {
+		return t, fmt.Errorf("unexpected end of condition")
+	}
+	p.pos++
+	return t, nil
+}
+
+func (p *conditionParser) keyword(word string) bool {
+	if t, ok := p.peek(); ok && !t.quoted && t.text == word {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *conditionParser) or() (condition, error) {
+	terms := orCondition{}
+	for {
+		c, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, c)
+		if !p.keyword("or") {
+			break
+		}
+	}
+	if len(terms) == 1 {
+		return terms[0], nil
+	}
+	return terms, nil
+}
+
+func (p *conditionParser) and() (condition, error) {
+	terms := andCondition{}
+	for {
+		c, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, c)
+		if !p.keyword("and") {
+			break
+		}
+	}
+	if len(terms) == 1 {
+		return terms[0], nil
+	}
+	return terms, nil
+}
+
+func (p *conditionParser) unary() (condition, error) {
+	if p.keyword("not") || p.keyword("!") {
+		c, err := p.unary()
+		return notCondition{c}, err
+	}
+	if p.keyword("(") {
+		c, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if !p.keyword(")") {
+			return nil, fmt.Errorf("missing )")
+		}
+		return c, nil
+	}
+	return p.comparison()
+}
+
+func (p *conditionParser) comparison() (condition, error) {
+	fact, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if fact.quoted {
+		return nil, fmt.Errorf("expected a fact but found the string %q", fact.text)
+	}
+	c := comparison{fact: fact.text}
+
+	op, ok := p.peek()
+	if !ok || op.quoted {
+		return c, nil
+	}
+	switch op.text {
+	case "exists", "missing":
+		p.pos++
+		c.op = op.text
+	case "==", "!=", "<", "<=", ">", ">=", "contains":
+		p.pos++
+		c.op = op.text
+		c.value, err = p.value()
+	case "=":
+		p.pos++
+		c.op = "=="
+		c.value, err = p.value()
+	case "in":
+		p.pos++
+		c.op = "in"
+		c.value, err = p.list()
+	}
+	return c, err
+}
+
+func (p *conditionParser) value() (interface{}, error) {
+	t, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if !t.quoted {
+		if f, err := strconv.ParseFloat(t.text, 64); err == nil {
+			return f, nil
+		}
+		switch t.text {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		}
+	}
+	return t.text, nil
+}
+
+func (p *conditionParser) list() (interface{}, error) {
+	if !p.keyword("[") {
+		return nil, fmt.Errorf("expected [ after in")
+	}
+	items := []interface{}{}
+	for !p.keyword("]") {
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, v)
+		p.keyword(",")
+	}
+	return items, nil
+}
@@ -0,0 +1,123 @@
+package analysis
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConditions(t *testing.T) {
+	facts := map[string]interface{}{
+		"name":                       "orders",
+		"encrypted":                  false,
+		"crossesBoundary":            true,
+		"protocol":                   "HTTPS",
+		"authentication":             "none",
+		"attributes.owner":           "shop",
+		"zone.trustRating":           40.0,
+		"attributes.port":            "8443",
+		"destination.kind":           "datastore",
+		"tags":                       []string{"pii", "orders"},
+		"source.zone.internetFacing": true,
+	}
+	cases := []struct {
+		condition string
+		want      bool
+	}{
+		{"crossesBoundary", true},
+		{"encrypted", false},
+		{"authentication", false}, //a string that denies
+		{"protocol", true},
+		{"zone.trustRating", true},
+		{"unknown", false},
+		{"not encrypted", true},
+		{"! encrypted", true},
+		{"not not encrypted", false},
+		{"crossesBoundary and not encrypted", true},
+		{"crossesBoundary and encrypted", false},
+		{"encrypted or protocol", true},
+		{"encrypted or unknown", false},
+		{"encrypted or crossesBoundary and protocol", true}, //and binds tighter than or
+		{"(encrypted or crossesBoundary) and unknown", false},
+		{"attributes.owner exists", true},
+		{"attributes.owner missing", false},
+		{"attributes.ownerContact missing", true},
+		{`protocol == "https"`, true}, //case-insensitively
+		{`protocol = 'HTTPS'`, true},
+		{`protocol != "HTTP"`, true},
+		{`unknown != "HTTP"`, false}, //comparisons of missing facts fail
+		{"zone.trustRating < 50", true},
+		{"zone.trustRating <= 40", true},
+		{"zone.trustRating > 40", false},
+		{"zone.trustRating >= 40", true},
+		{"attributes.port == 8443", true}, //numerically
+		{"attributes.port > 1024", true},
+		{"protocol > 1", false},
+		{"encrypted == false", true},
+		{`destination.kind in ["datastore", "queue"]`, true},
+		{`destination.kind in ["queue" "actor"]`, false},
+		{"zone.trustRating in [10, 40]", true},
+		{`tags contains "PII"`, true},
+		{`tags contains "pi"`, false}, //lists contain items, not substrings
+		{`name contains "ORD"`, true},
+		{`source.zone.internetFacing and destination.kind == "datastore"`, true},
+	}
+	for _, c := range cases {
+		t.Run(c.condition, func(t *testing.T) {
+			cond, err := parseCondition(c.condition)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := cond.eval(facts); got != c.want {
+				t.Errorf("got %t, want %t", got, c.want)
+			}
+		})
+	}
+}
+
+func TestConditionErrors(t *testing.T) {
+	cases := []struct {
+		condition, err string
+	}{
+		{"", "unexpected end of condition"},
+		{"encrypted and", "unexpected end of condition"},
+		{"not", "unexpected end of condition"},
+		{"(encrypted or protocol", "missing )"},
+		{"encrypted)", `unexpected ")" in condition "encrypted)"`},
+		{"encrypted protocol", `unexpected "protocol" in condition "encrypted protocol"`},
+		{`protocol == "HTTPS`, `unterminated string in condition "protocol == \"HTTPS"`},
+		{`"HTTPS" == protocol`, `expected a fact but found the string "HTTPS"`},
+		{"protocol ==", "unexpected end of condition"},
+		{`destination.kind in "datastore"`, "expected [ after in"},
+		{`destination.kind in ["datastore"`, "unexpected end of condition"},
+	}
+	for _, c := range cases {
+		t.Run(c.condition, func(t *testing.T) {
+			_, err := parseCondition(c.condition)
+			if err == nil {
+				t.Fatalf("got no error, want %q", c.err)
+			}
+			if err.Error() != c.err {
+				t.Errorf("got error %q, want %q", err, c.err)
+			}
+		})
+	}
+}
+
+func TestTokenise(t *testing.T) {
+	tokens, err := tokenise(`a.b>=1 and c in ['x y',"z"]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	texts := []string{}
+	for _, tk := range tokens {
+		if tk.quoted {
+			texts = append(texts, "'"+tk.text+"'")
+		} else {
+			texts = append(texts, tk.text)
+		}
+	}
+	want := "a.b >= 1 and c in [ 'x y' , 'z' ]"
+	if got := strings.Join(texts, " "); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,148 @@
+package analysis
+
+import (
+	"strings"
+
+	otm_transform "github.com/0-trust/service/pkg/otm"
+	otm "github.com/adedayo/open-threat-model/pkg"
+)
+
+const (
+	internetFacingRating = 30 //trust zones rated below this are treated as internet-facing
+)
+
+var (
+	encryptedProtocols = map[string]bool{
+		"https": true, "tls": true, "mtls": true, "ssh": true, "sftp": true, "ftps": true, "wss": true, "grpcs": true,
+		"ldaps": true, "smtps": true, "imaps": true, "amqps": true, "mqtts": true, "ipsec": true, "wireguard": true, "dtls": true,
+	}
+)
+
+// element is an element of an OTM with the facts that rule conditions are evaluated against
+type element struct {
+	id    string
+	ids   []string //the element and the elements it relates, e.g. the ends of a data flow
+	facts map[string]interface{}
+}
+
+// elements returns the facts of the elements of a target: components, dataflows or trustZones
+func (ix *modelIndex) elements(target string) []element {
+	elements := []element{}
+	switch target {
+	case "components":
+		for _, c := range ix.model.Components {
+			facts := make(map[string]interface{})
+			ix.componentFacts(facts, "", c.ID)
+			elements = append(elements, element{id: c.ID, ids: []string{c.ID}, facts: facts})
+		}
+	case "dataflows":
+		for _, df := range ix.model.DataFlows {
+			elements = append(elements, element{id: df.ID, ids: []string{df.ID, df.Source, df.Destination}, facts: ix.flowFacts(df)})
+		}
+	case "trustZones":
+		for _, tz := range ix.model.TrustZones {
+			facts := make(map[string]interface{})
+			zoneFacts(facts, "", tz)
+			if tz.Parent != nil {
+				facts["parent.id"] = tz.Parent.GetID()
+			}
+			elements = append(elements, element{id: tz.ID, ids: []string{tz.ID}, facts: facts})
+		}
+	}
+	return elements
+}
+
+func (ix *modelIndex) flowFacts(df otm.DataFlow) map[string]interface{} {
+	facts := map[string]interface{}{
+		"id":            df.ID,
+		"bidirectional": df.Bidirectional,
+		"authenticated": otm_transform.Authenticated(df),
+		"encrypted":     isEncrypted(df),
+	}
+	if df.Name != "" {
+		facts["name"] = df.Name
+	}
+	if len(df.Tags) > 0 {
+		facts["tags"] = df.Tags
+	}
+	if len(df.Assets) > 0 {
+		facts["assets"] = df.Assets
+	}
+	facts["sensitive"] = len(ix.sensitiveAssets(df)) > 0
+	addAttributeFacts(facts, "", df.Attributes)
+	if p, exists := facts["attributes.protocol"]; exists {
+		facts["protocol"] = p
+	}
+
+	ix.componentFacts(facts, "source.", df.Source)
+	ix.componentFacts(facts, "destination.", df.Destination)
+	c := ix.crossing(df.Source, df.Destination)
+	facts["crossesBoundary"] = c.crossesBoundary
+	facts["entersHigherZone"] = c.entersHigherZone
+	facts["entersLowerZone"] = c.entersLowerZone
+	return facts
+}
+
+// componentFacts adds the facts of the element with the ID (normally a component) under a prefix, e.g. source.
+func (ix *modelIndex) componentFacts(facts map[string]interface{}, prefix, id string) {
+	facts[prefix+"id"] = id
+	if c, isComponent := ix.components[id]; isComponent {
+		facts[prefix+"name"] = c.Name
+		facts[prefix+"kind"] = otm_transform.ComponentKind(c.Type)
+		if c.Type != "" {
+			facts[prefix+"type"] = c.Type
+		}
+		if len(c.Tags) > 0 {
+			facts[prefix+"tags"] = c.Tags
+		}
+		if c.Parent != nil {
+			facts[prefix+"parent.id"] = c.Parent.GetID()
+		}
+		addAttributeFacts(facts, prefix, c.Attributes)
+	} else if tz, isZone := ix.zones[id]; isZone {
+		facts[prefix+"name"] = tz.Name
+		facts[prefix+"kind"] = "trustZone"
+	}
+	if tz, found := ix.zoneOf(id); found {
+		zoneFacts(facts, prefix+"zone.", tz)
+	} else {
+		//named for messages such as "from {source.zone.name}", since flows from outside the trust zones cross boundaries
+		facts[prefix+"zone.name"] = unzoned
+	}
+}
+
+func zoneFacts(facts map[string]interface{}, prefix string, tz otm.TrustZone) {
+	rating := otm_transform.TrustRating(tz)
+	facts[prefix+"id"] = tz.ID
+	facts[prefix+"name"] = tz.Name
+	facts[prefix+"trustRating"] = rating
+	if tz.Type != "" {
+		facts[prefix+"type"] = tz.Type
+	}
+	facts[prefix+"internetFacing"] = isInternetFacing(tz)
+	addAttributeFacts(facts, prefix, tz.Attributes)
+}
+
+// isInternetFacing checks whether a trust zone is exposed to the internet: it has a low trust rating or says it is public
+func isInternetFacing(tz otm.TrustZone) bool {
+	return otm_transform.TrustRating(tz) < internetFacingRating || mentionsInternet(tz.ID) || mentionsInternet(tz.Name) || mentionsInternet(tz.Type)
+}
+
+func mentionsInternet(s string) bool {
+	s = strings.ToLower(s)
+	return strings.Contains(s, "internet") || strings.Contains(s, "public")
+}
+
+func addAttributeFacts[V any](facts map[string]interface{}, prefix string, attrs map[string]V) {
+	for k := range attrs {
+		facts[prefix+"attributes."+k] = otm_transform.Attribute(attrs, k)
+	}
+}
+
+func isEncrypted(df otm.DataFlow) bool {
+	if otm_transform.Denied(df.Attributes, "encryption", "encrypted", "tls") {
+		return false
+	}
+	return otm_transform.Asserted(df.Attributes, "encryption", "encrypted", "tls") ||
+		encryptedProtocols[strings.ToLower(otm_transform.Attribute(df.Attributes, "protocol"))]
+}
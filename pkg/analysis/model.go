@@ -0,0 +1,116 @@
+package analysis
+
+import (
+	"strings"
+
+	otm_transform "github.com/0-trust/service/pkg/otm"
+	otm "github.com/adedayo/open-threat-model/pkg"
+)
+
+var (
+	//protocols that carry data in the clear
+	plaintextProtocols = map[string]bool{
+		"http": true, "ftp": true, "telnet": true, "smtp": true, "ldap": true, "tcp": true, "udp": true,
+		"mqtt": true, "amqp": true, "redis": true, "memcached": true, "snmp": true, "tftp": true, "dns": true,
+	}
+)
+
+const (
+	sensitiveConfidentiality = 70                //assets whose confidentiality is rated at least this are sensitive
+	unzoned                  = "(no trust zone)" //name of the untrusted zone of elements outside all trust zones
+)
+
+// modelIndex looks up the elements of an OTM by ID, and the trust zone that contains each element
+type modelIndex struct {
+	model      otm.OpenThreatModel
+	assets     map[string]otm.Asset
+	zones      map[string]otm.TrustZone
+	components map[string]otm.Component
+	boundaries *otm_transform.TrustBoundaries
+}
+
+func newModelIndex(model otm.OpenThreatModel) *modelIndex {
+	ix := &modelIndex{
+		model:      model,
+		assets:     make(map[string]otm.Asset),
+		zones:      make(map[string]otm.TrustZone),
+		components: make(map[string]otm.Component),
+		boundaries: otm_transform.NewTrustBoundaries(model),
+	}
+	for _, a := range model.Assets {
+		ix.assets[a.ID] = a
+	}
+	for _, tz := range model.TrustZones {
+		ix.zones[tz.ID] = tz
+	}
+	for _, c := range model.Components {
+		ix.components[c.ID] = c
+	}
+	return ix
+}
+
+// zoneOf returns the trust zone that an element is in: the zone itself, or the closest zone among its ancestors
+func (ix *modelIndex) zoneOf(id string) (otm.TrustZone, bool) {
+	return ix.boundaries.ZoneOf(id)
+}
+
+// crossing describes the trust zones at either end of a data flow, as otm_transform.AnalyseBoundaries does: elements
+// outside all trust zones are in an untrusted zone of their own
+type crossing struct {
+	from, to         otm.TrustZone
+	crossesBoundary  bool //the flow goes between two different zones
+	entersHigherZone bool //the flow goes from a less trusted zone to a more trusted one
+	entersLowerZone  bool //the flow goes from a more trusted zone to a less trusted one
+}
+
+func (ix *modelIndex) crossing(source, destination string) crossing {
+	bc, crosses := ix.boundaries.Crossing(otm.DataFlow{Source: source, Destination: destination})
+	c := crossing{
+		crossesBoundary:  crosses,
+		entersHigherZone: crosses && bc.Direction == otm_transform.Inbound,
+		entersLowerZone:  crosses && bc.Direction == otm_transform.Outbound,
+	}
+	c.from, c.to = ix.zoneOrUnzoned(source), ix.zoneOrUnzoned(destination)
+	return c
+}
+
+// zoneOrUnzoned returns the trust zone of an element, or the untrusted zone if it is outside all trust zones
+func (ix *modelIndex) zoneOrUnzoned(id string) otm.TrustZone {
+	if tz, found := ix.zoneOf(id); found {
+		return tz
+	}
+	return otm.TrustZone{Name: unzoned}
+}
+
+// inbound returns the flows into an element, with the element that data comes from: bidirectional flows count both ways
+func (ix *modelIndex) inbound(id string) (flows []otm.DataFlow, sources []string) {
+	for _, df := range ix.model.DataFlows {
+		if df.Destination == id {
+			flows, sources = append(flows, df), append(sources, df.Source)
+		} else if df.Bidirectional && df.Source == id {
+			flows, sources = append(flows, df), append(sources, df.Destination)
+		}
+	}
+	return
+}
+
+// sensitiveAssets returns the names of the assets that a data flow carries whose confidentiality is rated as sensitive
+func (ix *modelIndex) sensitiveAssets(df otm.DataFlow) []string {
+	names := []string{}
+	for _, id := range df.Assets {
+		if a, exists := ix.assets[id]; exists && a.Risk.Confidentiality >= sensitiveConfidentiality {
+			names = append(names, a.Name)
+		}
+	}
+	return names
+}
+
+func isUnencrypted(df otm.DataFlow) bool {
+	if otm_transform.Denied(df.Attributes, "encryption", "encrypted", "tls") {
+		return true
+	}
+	if otm_transform.Asserted(df.Attributes, "encryption", "encrypted", "tls") {
+		return false
+	}
+	return plaintextProtocols[strings.ToLower(otm_transform.Attribute(df.Attributes, "protocol"))]
+}
@@ -0,0 +1,373 @@
+package analysis
+
+import (
+	"math"
+	"sort"
+	"strings"
+
+	otm_transform "github.com/0-trust/service/pkg/otm"
+	otm "github.com/adedayo/open-threat-model/pkg"
+)
+
+const (
+	// DefaultAttackPaths is the number of most likely attack paths to find if not given
+	DefaultAttackPaths = 5
+
+	lateralLikelihood    = 0.9  //likelihood of moving between elements of the same trust zone
+	trustResistance      = 0.7  //share of attempts to enter a fully trusted (100) zone that fail
+	authenticatedFactor  = 0.5  //authentication of a flow halves the likelihood of abusing it
+	defaultRiskReduction = 50.0 //risk reduction of implemented mitigations that the model doesn't define
+	minimumLikelihood    = 0.01
+	hopTieBreak          = 1e-3 //weight of likelihood among shortest paths with the same number of hops
+	implementedState     = "implemented"
+
+	//virtual ends of the graph, connected to all entry points and targets to search them together
+	pathsSource = "\x00entry"
+	pathsSink   = "\x00target"
+)
+
+var (
+	entryPointTags = map[string]bool{"entry-point": true, "entrypoint": true, "internet-facing": true, "public": true}
+	highValueTags  = map[string]bool{"high-value": true, "crown-jewel": true, "crown-jewels": true, "critical-asset": true, "pii": true}
+)
+
+// AttackPathOptions chooses the ends of attack paths. Without entry points, the components tagged entry-point
+// (or with an entryPoint attribute) are used, or else the components in internet-facing zones. Without targets,
+// the components tagged high-value, crown-jewel or pii (or with a highValue attribute) are used, or else the data stores
+type AttackPathOptions struct {
+	EntryPoints []string
+	Targets     []string
+	K           int //the number of most likely paths, DefaultAttackPaths if 0
+}
+
+// AttackStep is a move of an attacker along a data flow. Bidirectional flows may be followed either way
+type AttackStep struct {
+	Flow       string  `json:"flow"`
+	FlowName   string  `json:"flowName,omitempty"`
+	From       string  `json:"from"`
+	To         string  `json:"to"`
+	Likelihood float64 `json:"likelihood"` //0-1
+}
+
+// AttackPath is a route along data flows from an entry point to a target
+type AttackPath struct {
+	EntryPoint string       `json:"entryPoint"`
+	Target     string       `json:"target"`
+	Nodes      []string     `json:"nodes"` //the elements on the path, from the entry point to the target
+	Steps      []AttackStep `json:"steps"`
+	Hops       int          `json:"hops"`
+	Likelihood float64      `json:"likelihood"` //the product of the likelihoods of the steps
+}
+
+// AttackPaths are the shortest path from each entry point to each target it reaches, and the most likely paths overall
+type AttackPaths struct {
+	EntryPoints []string     `json:"entryPoints"`
+	Targets     []string     `json:"targets"`
+	Shortest    []AttackPath `json:"shortest"`
+	MostLikely  []AttackPath `json:"mostLikely"`
+}
+
+// FindAttackPaths treats the data flows of a model as a directed graph and finds the attack paths from entry points
+// to high value targets: the shortest (fewest hops) for each pair, and the k most likely. Entering a more trusted zone
+// is less likely to succeed, as is following an authenticated flow or reaching a component with implemented mitigations
+func FindAttackPaths(model otm.OpenThreatModel, options AttackPathOptions) AttackPaths {
+	ix := newModelIndex(model)
+	paths := AttackPaths{
+		EntryPoints: options.EntryPoints,
+		Targets:     options.Targets,
+		Shortest:    []AttackPath{},
+		MostLikely:  []AttackPath{},
+	}
+	if len(paths.EntryPoints) == 0 {
+		paths.EntryPoints = ix.entryPoints()
+	}
+	if len(paths.Targets) == 0 {
+		paths.Targets = ix.highValueTargets()
+	}
+	k := options.K
+	if k <= 0 {
+		k = DefaultAttackPaths
+	}
+
+	g := newAttackGraph(ix)
+	hops := func(s AttackStep) float64 { return 1 - hopTieBreak*math.Log(s.Likelihood) }
+	for _, entry := range paths.EntryPoints {
+		for _, target := range paths.Targets {
+			if entry == target {
+				continue
+			}
+			if steps, _, found := g.cheapest(entry, target, hops, nil, nil); found {
+				paths.Shortest = append(paths.Shortest, newAttackPath(steps))
+			}
+		}
+	}
+	sort.SliceStable(paths.Shortest, func(i, j int) bool {
+		if paths.Shortest[i].Hops != paths.Shortest[j].Hops {
+			return paths.Shortest[i].Hops < paths.Shortest[j].Hops
+		}
+		return paths.Shortest[i].Likelihood > paths.Shortest[j].Likelihood
+	})
+
+	entries := make(map[string]bool)
+	for _, entry := range paths.EntryPoints {
+		entries[entry] = true
+		g.steps[pathsSource] = append(g.steps[pathsSource], AttackStep{From: pathsSource, To: entry, Likelihood: 1})
+	}
+	//an entry point that is itself a target gives a certain path without a step, which takes one of the k paths
+	empty := 0
+	for _, target := range paths.Targets {
+		if entries[target] {
+			empty++
+		}
+		g.steps[target] = append(g.steps[target], AttackStep{From: target, To: pathsSink, Likelihood: 1})
+	}
+	for _, steps := range g.mostLikely(k + empty) {
+		//strip the virtual ends, skipping the paths without a step
+		if len(steps) > 2 && len(paths.MostLikely) < k {
+			paths.MostLikely = append(paths.MostLikely, newAttackPath(steps[1:len(steps)-1]))
+		}
+	}
+	return paths
+}
+
+// Flows returns the IDs of the data flows on the most likely attack paths, e.g. to highlight them on a diagram
+func (ap AttackPaths) Flows() []string {
+	flows := []string{}
+	seen := make(map[string]bool)
+	for _, p := range ap.MostLikely {
+		for _, s := range p.Steps {
+			if !seen[s.Flow] {
+				seen[s.Flow] = true
+				flows = append(flows, s.Flow)
+			}
+		}
+	}
+	return flows
+}
+
+func newAttackPath(steps []AttackStep) AttackPath {
+	p := AttackPath{
+		EntryPoint: steps[0].From,
+		Target:     steps[len(steps)-1].To,
+		Nodes:      []string{steps[0].From},
+		Steps:      steps,
+		Hops:       len(steps),
+		Likelihood: 1,
+	}
+	for _, s := range steps {
+		p.Nodes = append(p.Nodes, s.To)
+		p.Likelihood *= s.Likelihood
+	}
+	p.Likelihood = math.Round(p.Likelihood*1e4) / 1e4
+	return p
+}
+
+func (ix *modelIndex) entryPoints() []string {
+	tagged, exposed := []string{}, []string{}
+	for _, c := range ix.model.Components {
+		if hasTag(c.Tags, entryPointTags) || otm_transform.Asserted(c.Attributes, "entryPoint", "entry-point") {
+			tagged = append(tagged, c.ID)
+		} else if tz, found := ix.zoneOf(c.ID); found && isInternetFacing(tz) {
+			exposed = append(exposed, c.ID)
+		}
+	}
+	if len(tagged) > 0 {
+		return tagged
+	}
+	return exposed
+}
+
+func (ix *modelIndex) highValueTargets() []string {
+	tagged, stores := []string{}, []string{}
+	for _, c := range ix.model.Components {
+		if hasTag(c.Tags, highValueTags) || otm_transform.Asserted(c.Attributes, "highValue", "high-value", "crownJewel") ||
+			strings.EqualFold(otm_transform.Attribute(c.Attributes, "assetValue"), "high") {
+			tagged = append(tagged, c.ID)
+		} else if kindOf(c) == "datastore" {
+			stores = append(stores, c.ID)
+		}
+	}
+	if len(tagged) > 0 {
+		return tagged
+	}
+	return stores
+}
+
+func hasTag(tags []string, wanted map[string]bool) bool {
+	for _, t := range tags {
+		if wanted[strings.ToLower(t)] {
+			return true
+		}
+	}
+	return false
+}
+
+// attackGraph is the directed graph of the data flows of a model
+type attackGraph struct {
+	ix             *modelIndex
+	steps          map[string][]AttackStep //the steps out of each element
+	riskReductions map[string]float64
+}
+
+func newAttackGraph(ix *modelIndex) *attackGraph {
+	g := &attackGraph{
+		ix:             ix,
+		steps:          make(map[string][]AttackStep),
+		riskReductions: make(map[string]float64),
+	}
+	for _, m := range ix.model.Mitigations {
+		g.riskReductions[m.ID] = m.RiskReduction
+	}
+	for _, df := range ix.model.DataFlows {
+		g.add(df, df.Source, df.Destination)
+		if df.Bidirectional {
+			g.add(df, df.Destination, df.Source)
+		}
+	}
+	return g
+}
+
+func (g *attackGraph) add(df otm.DataFlow, from, to string) {
+	g.steps[from] = append(g.steps[from], AttackStep{
+		Flow:       df.ID,
+		FlowName:   df.Name,
+		From:       from,
+		To:         to,
+		Likelihood: g.likelihood(df, from, to),
+	})
+}
+
+// likelihood estimates how likely an attacker in one element is to get into another along a data flow
+func (g *attackGraph) likelihood(df otm.DataFlow, from, to string) float64 {
+	fromZone, _ := g.ix.zoneOf(from)
+	toZone, _ := g.ix.zoneOf(to)
+	likelihood := lateralLikelihood
+	if fromZone.ID != toZone.ID {
+		likelihood = 1 - trustResistance*otm_transform.TrustRating(toZone)/100
+	}
+	if otm_transform.Authenticated(df) {
+		likelihood *= authenticatedFactor
+	}
+
+	//implemented mitigations of the flow and the element it reaches
+	threats := df.Threats
+	if c, isComponent := g.ix.components[to]; isComponent {
+		threats = append(threats[:len(threats):len(threats)], c.Threats...)
+	}
+	mitigated := make(map[string]bool)
+	for _, t := range threats {
+		for _, m := range t.Mitigations {
+			if !strings.EqualFold(m.State, implementedState) || mitigated[m.Mitigation] {
+				continue
+			}
+			mitigated[m.Mitigation] = true
+			reduction, defined := g.riskReductions[m.Mitigation]
+			if !defined || reduction <= 0 {
+				reduction = defaultRiskReduction
+			}
+			likelihood *= 1 - math.Min(reduction, 100)/100
+		}
+	}
+	return math.Max(likelihood, minimumLikelihood)
+}
+
+func stepKey(s AttackStep) string {
+	return s.From + "\x00" + s.Flow + "\x00" + s.To
+}
+
+func pathKey(steps []AttackStep) string {
+	keys := []string{}
+	for _, s := range steps {
+		keys = append(keys, stepKey(s))
+	}
+	return strings.Join(keys, "\x01")
+}
+
+// cheapest finds the path of least cost between two elements (Dijkstra), avoiding the excluded elements and steps
+func (g *attackGraph) cheapest(from, to string, cost func(AttackStep) float64,
+	excludedNodes, excludedSteps map[string]bool) (path []AttackStep, total float64, found bool) {
+	distances := map[string]float64{from: 0}
+	previous := make(map[string]AttackStep)
+	done := make(map[string]bool)
+	for {
+		node, best := "", math.Inf(1)
+		for n, d := range distances {
+			if !done[n] && (d < best || (d == best && n < node)) {
+				node, best = n, d
+			}
+		}
+		if math.IsInf(best, 1) {
+			return nil, 0, false
+		}
+		if node == to {
+			break
+		}
+		done[node] = true
+		for _, s := range g.steps[node] {
+			if done[s.To] || excludedNodes[s.To] || excludedSteps[stepKey(s)] {
+				continue
+			}
+			if d, seen := distances[s.To]; !seen || best+cost(s) < d {
+				distances[s.To] = best + cost(s)
+				previous[s.To] = s
+			}
+		}
+	}
+
+	for n := to; n != from; n = previous[n].From {
+		path = append([]AttackStep{previous[n]}, path...)
+	}
+	return path, distances[to], true
+}
+
+// mostLikely finds the k most likely paths between the virtual ends of the graph with Yen's k shortest paths algorithm,
+// the cost of a step being the negative log of its likelihood
+func (g *attackGraph) mostLikely(k int) [][]AttackStep {
+	cost := func(s AttackStep) float64 { return -math.Log(s.Likelihood) }
+	first, _, found := g.cheapest(pathsSource, pathsSink, cost, nil, nil)
+	if !found {
+		return nil
+	}
+
+	type candidate struct {
+		steps []AttackStep
+		cost  float64
+	}
+	paths := [][]AttackStep{first}
+	candidates := []candidate{}
+	seen := map[string]bool{pathKey(first): true}
+	for len(paths) < k {
+		last := paths[len(paths)-1]
+		for i := range last {
+			root, rootCost := last[:i], 0.0
+			excludedNodes := make(map[string]bool)
+			for _, s := range root {
+				excludedNodes[s.From] = true
+				rootCost += cost(s)
+			}
+			//branch off the root in a way that no path found so far has
+			excludedSteps := make(map[string]bool)
+			for _, p := range paths {
+				if len(p) > i && pathKey(p[:i]) == pathKey(root) {
+					excludedSteps[stepKey(p[i])] = true
+				}
+			}
+			spur, spurCost, found := g.cheapest(last[i].From, pathsSink, cost, excludedNodes, excludedSteps)
+			if !found {
+				continue
+			}
+			path := append(append([]AttackStep{}, root...), spur...)
+			if key := pathKey(path); !seen[key] {
+				seen[key] = true
+				candidates = append(candidates, candidate{path, rootCost + spurCost})
+			}
+		}
+		if len(candidates) == 0 {
+			break
+		}
+		sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].cost < candidates[j].cost })
+		paths = append(paths, candidates[0].steps)
+		candidates = candidates[1:]
+	}
+	return paths
+}
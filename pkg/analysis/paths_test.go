@@ -0,0 +1,182 @@
+package analysis
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	otm "github.com/adedayo/open-threat-model/pkg"
+)
+
+const pathsModel = `otmVersion: 0.1.0
+project:
+  name: shop
+  id: shop
+trustZones:
+  - id: internet
+    name: Internet
+    risk:
+      trustRating: 10
+  - id: dmz
+    name: DMZ
+    risk:
+      trustRating: 50
+  - id: internal
+    name: Internal
+    risk:
+      trustRating: 80
+components:
+  - id: web
+    name: Web
+    type: web-server
+    parent:
+      trustZone: internet
+  - id: admin
+    name: Admin
+    type: web-server
+    parent:
+      trustZone: internet
+  - id: app
+    name: App
+    type: service
+    parent:
+      trustZone: dmz
+  - id: cache
+    name: Cache
+    type: service
+    parent:
+      trustZone: dmz
+  - id: db
+    name: DB
+    type: database
+    parent:
+      trustZone: internal
+dataflows:
+  - id: web-app
+    name: call
+    source: web
+    destination: app
+  - id: app-db
+    name: query
+    source: app
+    destination: db
+  - id: web-cache
+    name: lookup
+    source: web
+    destination: cache
+  - id: cache-db
+    name: fill
+    source: cache
+    destination: db
+    attributes:
+      authentication: mTLS
+  - id: app-cache
+    name: store
+    source: app
+    destination: cache
+  - id: admin-db
+    name: manage
+    source: admin
+    destination: db
+`
+
+func parseTestModel(t *testing.T, model string) otm.OpenThreatModel {
+	t.Helper()
+	m, err := otm.Parse(strings.NewReader(model))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestFindAttackPaths(t *testing.T) {
+	model := parseTestModel(t, pathsModel)
+	type path struct {
+		nodes      string
+		likelihood float64
+	}
+	//entering the DMZ is 0.65 likely, the internal zone 0.44, moving within a zone 0.9, and authentication halves them
+	all := []path{
+		{"admin db", 0.44},
+		{"web app db", 0.286},
+		{"web cache db", 0.143},
+		{"web app cache db", 0.1287},
+	}
+	cases := []struct {
+		name       string
+		options    AttackPathOptions
+		entries    []string
+		targets    []string
+		mostLikely []path
+	}{
+		{
+			name:       "default ends",
+			entries:    []string{"web", "admin"},
+			targets:    []string{"db"},
+			mostLikely: all,
+		},
+		{
+			name:       "k paths",
+			options:    AttackPathOptions{EntryPoints: []string{"web", "admin"}, Targets: []string{"db"}, K: 3},
+			entries:    []string{"web", "admin"},
+			targets:    []string{"db"},
+			mostLikely: all[:3],
+		},
+		{
+			name:       "one entry point",
+			options:    AttackPathOptions{EntryPoints: []string{"web"}, Targets: []string{"db"}, K: 2},
+			entries:    []string{"web"},
+			targets:    []string{"db"},
+			mostLikely: all[1:3],
+		},
+		{
+			//a target that is an entry point is reached without a step, which isn't a path
+			name:       "entry point that is a target",
+			options:    AttackPathOptions{EntryPoints: []string{"web", "admin", "db"}, Targets: []string{"db"}, K: 3},
+			entries:    []string{"web", "admin", "db"},
+			targets:    []string{"db"},
+			mostLikely: all[:3],
+		},
+		{
+			name:       "unreachable",
+			options:    AttackPathOptions{EntryPoints: []string{"db"}, Targets: []string{"web"}},
+			entries:    []string{"db"},
+			targets:    []string{"web"},
+			mostLikely: []path{},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			paths := FindAttackPaths(model, c.options)
+			if !reflect.DeepEqual(paths.EntryPoints, c.entries) || !reflect.DeepEqual(paths.Targets, c.targets) {
+				t.Errorf("got entry points %v and targets %v, want %v and %v", paths.EntryPoints, paths.Targets, c.entries, c.targets)
+			}
+			got := []path{}
+			for _, p := range paths.MostLikely {
+				got = append(got, path{strings.Join(p.Nodes, " "), p.Likelihood})
+				if p.Hops != len(p.Steps) || p.EntryPoint != p.Nodes[0] || p.Target != p.Nodes[len(p.Nodes)-1] {
+					t.Errorf("path %v has inconsistent ends or hops", p.Nodes)
+				}
+			}
+			if !reflect.DeepEqual(got, c.mostLikely) {
+				t.Errorf("got most likely paths %v, want %v", got, c.mostLikely)
+			}
+		})
+	}
+}
+
+func TestShortestAttackPaths(t *testing.T) {
+	paths := FindAttackPaths(parseTestModel(t, pathsModel), AttackPathOptions{})
+	got := []string{}
+	for _, p := range paths.Shortest {
+		got = append(got, strings.Join(p.Nodes, " "))
+	}
+	//of the two-hop paths from web, the more likely one
+	want := []string{"admin db", "web app db"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got shortest paths %v, want %v", got, want)
+	}
+	if flows := paths.Flows(); !reflect.DeepEqual(flows, []string{"admin-db", "web-app", "app-db", "web-cache", "cache-db", "app-cache"}) {
+		t.Errorf("got flows %v on the most likely paths", flows)
+	}
+}
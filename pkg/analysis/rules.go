@@ -0,0 +1,342 @@
+package analysis
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	otm "github.com/adedayo/open-threat-model/pkg"
+	"gopkg.in/yaml.v3"
+)
+
+// Rule is a zero trust policy check: every element of the target section for which the deny condition holds is a finding.
+// Rules are written in YAML, e.g.
+//
+//	rules:
+//	  - id: zt-encrypted-crossings
+//	    name: Flows between trust zones are encrypted
+//	    severity: high
+//	    target: dataflows
+//	    deny: crossesBoundary and not encrypted
+//	    message: "{name} crosses from {source.zone.name} to {destination.zone.name} without encryption"
+//
+// or in a Rego-like syntax, where the comment preceding a rule is its name and the lines of the body must all hold:
+//
+//	# Flows between trust zones are encrypted
+//	deny[dataflows] "zt-encrypted-crossings" high {
+//	    crossesBoundary
+//	    not encrypted
+//	    message "{name} crosses from {source.zone.name} to {destination.zone.name} without encryption"
+//	}
+//
+// Messages may refer to the facts of the element in braces. See the facts of elements in facts.go
+type Rule struct {
+	ID          string `yaml:"id" json:"id"`
+	Name        string `yaml:"name" json:"name"`
+	Description string `yaml:"description,omitempty" json:"description,omitempty"`
+	Severity    string `yaml:"severity" json:"severity"`
+	Target      string `yaml:"target" json:"target"` //components, dataflows or trustZones
+	Deny        string `yaml:"deny" json:"deny"`
+	Message     string `yaml:"message,omitempty" json:"message,omitempty"`
+	Disabled    bool   `yaml:"disabled,omitempty" json:"disabled,omitempty"`
+	Source      string `yaml:"-" json:"source"` //file the rule was loaded from, or builtin
+	deny        condition
+}
+
+// Finding is an element of a model that violates a rule
+type Finding struct {
+	RuleID    string   `json:"ruleID"`
+	Rule      string   `json:"rule"`
+	Severity  string   `json:"severity"`
+	Target    string   `json:"target"`
+	ElementID string   `json:"elementID"`
+	IDs       []string `json:"ids"` //IDs of the offending element and the elements it relates, e.g. the ends of a data flow
+	Message   string   `json:"message"`
+}
+
+var (
+	// Severities of findings, from the least to the most severe
+	Severities = []string{"info", "low", "medium", "high", "critical"}
+
+	targets = map[string]string{
+		"component": "components", "components": "components",
+		"dataflow": "dataflows", "dataflows": "dataflows", "flow": "dataflows", "flows": "dataflows",
+		"trustzone": "trustZones", "trustzones": "trustZones", "zone": "trustZones", "zones": "trustZones",
+	}
+	messageFacts = regexp.MustCompile(`\{([A-Za-z0-9_.\-]+)\}`)
+	regoHeader   = regexp.MustCompile(`^deny\[(\w+)\]\s+"([^"]+)"\s+(\w+)\s*\{$`)
+
+	builtinRules = `
+rules:
+  - id: zt-encrypted-crossings
+    name: Flows between trust zones are encrypted
+    severity: high
+    target: dataflows
+    deny: crossesBoundary and not encrypted
+    message: "{name} crosses from {source.zone.name} to {destination.zone.name} without encryption"
+  - id: zt-authenticated-entry
+    name: Flows into more trusted zones are authenticated
+    severity: high
+    target: dataflows
+    deny: entersHigherZone and not authenticated
+    message: "{name} enters {destination.zone.name} from the less trusted {source.zone.name} without authentication"
+  - id: zt-authenticated-sensitive-data
+    name: Flows of sensitive data are authenticated
+    severity: high
+    target: dataflows
+    deny: sensitive and not authenticated
+    message: "{name} carries sensitive data to {destination.name} without authentication"
+  - id: zt-internet-to-datastore
+    name: No direct flow from internet-facing zones to data stores
+    severity: critical
+    target: dataflows
+    deny: >-
+      (source.zone.internetFacing and destination.kind == "datastore" and not destination.zone.internetFacing) or
+      (bidirectional and destination.zone.internetFacing and source.kind == "datastore" and not source.zone.internetFacing)
+    message: "{name} connects the data store {destination.name} directly to the internet-facing {source.zone.name}"
+  - id: zt-component-owner
+    name: Every component has an owner
+    severity: medium
+    target: components
+    deny: attributes.owner missing and attributes.ownerContact missing
+    message: "{name} has no owner attribute"
+  - id: zt-component-zone
+    name: Every component is in a trust zone
+    severity: low
+    target: components
+    deny: zone.id missing
+    message: "{name} is not in a trust zone"
+`
+)
+
+// BuiltinRules returns the built-in library of zero trust checks
+func BuiltinRules() []*Rule {
+	rules, err := parseYAMLRules([]byte(builtinRules), "builtin")
+	if err != nil {
+		panic(err)
+	}
+	return rules
+}
+
+// LoadRules returns the built-in rules together with the rules in a directory (or file): YAML (.yaml, .yml)
+// and Rego-like (.rules) files. A rule with the ID of a built-in rule replaces it. A missing directory has no rules
+func LoadRules(path string) ([]*Rule, error) {
+	rules := BuiltinRules()
+	if path == "" {
+		return rules, nil
+	}
+	index := make(map[string]int)
+	for i, r := range rules {
+		index[r.ID] = i
+	}
+
+	err := filepath.WalkDir(path, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) && file == path {
+				return fs.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		var loaded []*Rule
+		switch strings.ToLower(filepath.Ext(file)) {
+		case ".yaml", ".yml":
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return err
+			}
+			loaded, err = parseYAMLRules(data, file)
+			if err != nil {
+				return err
+			}
+		case ".rules":
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return err
+			}
+			loaded, err = parseRegoLikeRules(string(data), file)
+			if err != nil {
+				return err
+			}
+		}
+		for _, r := range loaded {
+			if i, exists := index[r.ID]; exists {
+				rules[i] = r
+			} else {
+				index[r.ID] = len(rules)
+				rules = append(rules, r)
+			}
+		}
+		return nil
+	})
+	return rules, err
+}
+
+func parseYAMLRules(data []byte, source string) ([]*Rule, error) {
+	var file struct {
+		Rules []*Rule `yaml:"rules"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		//also accept a plain list of rules
+		if e := yaml.Unmarshal(data, &file.Rules); e != nil {
+			return nil, fmt.Errorf("%s: %w", source, err)
+		}
+	}
+	for _, r := range file.Rules {
+		r.Source = source
+		if err := r.compile(); err != nil {
+			return nil, fmt.Errorf("%s: %w", source, err)
+		}
+	}
+	return file.Rules, nil
+}
+
+func parseRegoLikeRules(data, source string) ([]*Rule, error) {
+	rules := []*Rule{}
+	var rule *Rule
+	conditions := []string{}
+	comment := ""
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		switch {
+		case text == "":
+			if rule == nil {
+				comment = ""
+			}
+		case strings.HasPrefix(text, "#"):
+			if rule == nil && comment == "" {
+				comment = strings.TrimSpace(strings.TrimPrefix(text, "#"))
+			}
+		case strings.HasPrefix(text, "package ") || strings.HasPrefix(text, "import "):
+			//Rego preamble, ignored
+		case rule == nil:
+			m := regoHeader.FindStringSubmatch(text)
+			if m == nil {
+				return nil, fmt.Errorf(`%s:%d: expected a rule such as deny[dataflows] "rule-id" high {`, source, line)
+			}
+			rule = &Rule{ID: m[2], Name: comment, Severity: m[3], Target: m[1], Source: source}
+			conditions = []string{}
+		case text == "}":
+			rule.Deny = strings.Join(conditions, " and ")
+			if err := rule.compile(); err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", source, line, err)
+			}
+			rules = append(rules, rule)
+			rule, comment = nil, ""
+		case strings.HasPrefix(text, "message "):
+			msg, err := strconv.Unquote(strings.TrimSpace(strings.TrimPrefix(text, "message ")))
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: the message must be a quoted string", source, line)
+			}
+			rule.Message = msg
+		default:
+			conditions = append(conditions, "("+text+")")
+		}
+	}
+	if rule != nil {
+		return nil, fmt.Errorf("%s: rule %s is missing its closing }", source, rule.ID)
+	}
+	return rules, scanner.Err()
+}
+
+func (r *Rule) compile() error {
+	if r.ID == "" {
+		return errors.New("a rule is missing its id")
+	}
+	target, valid := targets[strings.ToLower(r.Target)]
+	if !valid {
+		return fmt.Errorf("rule %s has target %q, use components, dataflows or trustZones", r.ID, r.Target)
+	}
+	r.Target = target
+	r.Severity = strings.ToLower(r.Severity)
+	if !IsSeverity(r.Severity) {
+		return fmt.Errorf("rule %s has severity %q, use one of %s", r.ID, r.Severity, strings.Join(Severities, ", "))
+	}
+	if r.Name == "" {
+		r.Name = r.ID
+	}
+	deny, err := parseCondition(r.Deny)
+	if err != nil {
+		return fmt.Errorf("rule %s: %w", r.ID, err)
+	}
+	r.deny = deny
+	return nil
+}
+
+func severityRank(severity string) int {
+	for i, s := range Severities {
+		if s == severity {
+			return i
+		}
+	}
+	return -1
+}
+
+// IsSeverity checks whether a severity is one of Severities, in any case
+func IsSeverity(severity string) bool {
+	return severityRank(strings.ToLower(severity)) >= 0
+}
+
+// AtLeast checks whether a finding is at least as severe as the given severity
+func (f Finding) AtLeast(severity string) bool {
+	return severityRank(f.Severity) >= severityRank(strings.ToLower(severity))
+}
+
+// CheckRules evaluates a model against rules. Findings are ordered from the most severe
+func CheckRules(model otm.OpenThreatModel, rules []*Rule) []Finding {
+	ix := newModelIndex(model)
+	elements := make(map[string][]element)
+	findings := []Finding{}
+	for _, r := range rules {
+		if r.Disabled || r.deny == nil {
+			continue
+		}
+		if _, computed := elements[r.Target]; !computed {
+			elements[r.Target] = ix.elements(r.Target)
+		}
+		for _, e := range elements[r.Target] {
+			if r.deny.eval(e.facts) {
+				findings = append(findings, Finding{
+					RuleID:    r.ID,
+					Rule:      r.Name,
+					Severity:  r.Severity,
+					Target:    r.Target,
+					ElementID: e.id,
+					IDs:       e.ids,
+					Message:   r.message(e),
+				})
+			}
+		}
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		return severityRank(findings[i].Severity) > severityRank(findings[j].Severity)
+	})
+	return findings
+}
+
+func (r *Rule) message(e element) string {
+	msg := r.Message
+	if msg == "" {
+		msg = fmt.Sprintf("{name} violates %s", r.Name)
+	}
+	return messageFacts.ReplaceAllStringFunc(msg, func(ref string) string {
+		if v, exists := e.facts[strings.Trim(ref, "{}")]; exists {
+			return fmt.Sprint(v)
+		}
+		if ref == "{name}" {
+			return e.id
+		}
+		return ref
+	})
+}
@@ -0,0 +1,243 @@
+package analysis
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseRules(t *testing.T) {
+	cases := []struct {
+		name   string
+		yaml   bool
+		source string
+		want   []Rule
+	}{
+		{
+			name: "yaml",
+			yaml: true,
+			source: `
+rules:
+  - id: zt-mtls
+    severity: HIGH
+    target: flows
+    deny: crossesBoundary and protocol != "mTLS"
+    message: "{name} isn't mTLS"
+`,
+			want: []Rule{{ID: "zt-mtls", Name: "zt-mtls", Severity: "high", Target: "dataflows", Message: "{name} isn't mTLS"}},
+		},
+		{
+			name: "yaml list",
+			yaml: true,
+			source: `
+- id: zt-owner
+  name: Zones have owners
+  severity: low
+  target: zone
+  deny: attributes.owner missing
+`,
+			want: []Rule{{ID: "zt-owner", Name: "Zones have owners", Severity: "low", Target: "trustZones"}},
+		},
+		{
+			name: "rego-like",
+			source: `package zerotrust
+
+# Flows between trust zones are encrypted
+deny[dataflows] "zt-encrypted" critical {
+    crossesBoundary
+    not encrypted
+    message "{name} isn't encrypted"
+}
+
+# an unrelated comment
+
+deny[component] "zt-zone" info {
+    zone.id missing
+}
+`,
+			want: []Rule{
+				{ID: "zt-encrypted", Name: "Flows between trust zones are encrypted", Severity: "critical", Target: "dataflows",
+					Deny: "(crossesBoundary) and (not encrypted)", Message: "{name} isn't encrypted"},
+				{ID: "zt-zone", Name: "zt-zone", Severity: "info", Target: "components", Deny: "(zone.id missing)"},
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var rules []*Rule
+			var err error
+			if c.yaml {
+				rules, err = parseYAMLRules([]byte(c.source), "test")
+			} else {
+				rules, err = parseRegoLikeRules(c.source, "test")
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rules) != len(c.want) {
+				t.Fatalf("got %d rules, want %d", len(rules), len(c.want))
+			}
+			for i, want := range c.want {
+				got := rules[i]
+				if got.ID != want.ID || got.Name != want.Name || got.Severity != want.Severity || got.Target != want.Target ||
+					got.Message != want.Message || (want.Deny != "" && got.Deny != want.Deny) {
+					t.Errorf("got %+v, want %+v", *got, want)
+				}
+				if got.deny == nil || got.Source != "test" {
+					t.Errorf("rule %s isn't compiled from its source", got.ID)
+				}
+			}
+		})
+	}
+}
+
+func TestParseRulesErrors(t *testing.T) {
+	cases := []struct {
+		name   string
+		yaml   bool
+		source string
+		err    string
+	}{
+		{
+			name:   "missing id",
+			yaml:   true,
+			source: "rules:\n  - severity: high\n    target: dataflows\n    deny: encrypted\n",
+			err:    "test: a rule is missing its id",
+		},
+		{
+			name:   "unknown target",
+			yaml:   true,
+			source: "rules:\n  - id: r\n    severity: high\n    target: assets\n    deny: encrypted\n",
+			err:    `test: rule r has target "assets", use components, dataflows or trustZones`,
+		},
+		{
+			name:   "unknown severity",
+			yaml:   true,
+			source: "rules:\n  - id: r\n    severity: severe\n    target: dataflows\n    deny: encrypted\n",
+			err:    `test: rule r has severity "severe", use one of info, low, medium, high, critical`,
+		},
+		{
+			name:   "invalid condition",
+			yaml:   true,
+			source: "rules:\n  - id: r\n    severity: high\n    target: dataflows\n    deny: encrypted and\n",
+			err:    "test: rule r: unexpected end of condition",
+		},
+		{
+			name:   "invalid yaml",
+			yaml:   true,
+			source: "rules: [",
+			err:    "test: yaml:",
+		},
+		{
+			name:   "invalid rule header",
+			source: "deny dataflows r high {\n}\n",
+			err:    `test:1: expected a rule such as deny[dataflows] "rule-id" high {`,
+		},
+		{
+			name:   "invalid rule",
+			source: "deny[dataflows] \"r\" severe {\n  encrypted\n}\n",
+			err:    `test:3: rule r has severity "severe", use one of info, low, medium, high, critical`,
+		},
+		{
+			name:   "unquoted message",
+			source: "deny[dataflows] \"r\" high {\n  message unencrypted\n}\n",
+			err:    "test:2: the message must be a quoted string",
+		},
+		{
+			name:   "unclosed rule",
+			source: "deny[dataflows] \"r\" high {\n  encrypted\n",
+			err:    "test: rule r is missing its closing }",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var err error
+			if c.yaml {
+				_, err = parseYAMLRules([]byte(c.source), "test")
+			} else {
+				_, err = parseRegoLikeRules(c.source, "test")
+			}
+			if err == nil {
+				t.Fatalf("got no error, want %q", c.err)
+			}
+			if !strings.HasPrefix(err.Error(), c.err) {
+				t.Errorf("got error %q, want %q", err, c.err)
+			}
+		})
+	}
+}
+
+func TestLoadRules(t *testing.T) {
+	builtin := len(BuiltinRules())
+	dir := t.TempDir()
+	files := map[string]string{
+		"override.yaml": "rules:\n  - id: zt-component-owner\n    severity: info\n    target: components\n    deny: attributes.owner missing\n",
+		"extra.rules":   "deny[dataflows] \"zt-extra\" low {\n  not encrypted\n}\n",
+		"README.md":     "not rules",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rules, err := LoadRules(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != builtin+1 {
+		t.Fatalf("got %d rules, want the %d built-in rules and one more", len(rules), builtin)
+	}
+	for _, r := range rules {
+		if r.ID == "zt-component-owner" && r.Severity != "info" {
+			t.Errorf("the built-in rule %s isn't replaced", r.ID)
+		}
+	}
+	if last := rules[len(rules)-1]; last.ID != "zt-extra" {
+		t.Errorf("got last rule %s, want zt-extra", last.ID)
+	}
+
+	if rules, err := LoadRules(filepath.Join(dir, "missing")); err != nil || len(rules) != builtin {
+		t.Errorf("got %d rules and error %v for a missing directory, want the built-in rules", len(rules), err)
+	}
+}
+
+func TestFindingAtLeast(t *testing.T) {
+	cases := []struct {
+		severity, failOn string
+		want             bool
+	}{
+		{"high", "high", true},
+		{"critical", "HIGH", true},
+		{"medium", "high", false},
+		{"info", "info", true},
+	}
+	for _, c := range cases {
+		if got := (Finding{Severity: c.severity}).AtLeast(c.failOn); got != c.want {
+			t.Errorf("a %s finding at least %s: got %t, want %t", c.severity, c.failOn, got, c.want)
+		}
+	}
+	for _, s := range []string{"severe", ""} {
+		if IsSeverity(s) {
+			t.Errorf("%q is a severity", s)
+		}
+	}
+}
+
+func TestRuleMessage(t *testing.T) {
+	e := element{id: "web-db", facts: map[string]interface{}{"name": "query", "source.zone.name": "DMZ"}}
+	cases := []struct {
+		message, want string
+	}{
+		{"{name} leaves {source.zone.name}", "query leaves DMZ"},
+		{"{name} enters {destination.zone.name}", "query enters {destination.zone.name}"},
+		{"", "query violates Flows are encrypted"},
+	}
+	for _, c := range cases {
+		r := Rule{Name: "Flows are encrypted", Message: c.message}
+		if got := r.message(e); got != c.want {
+			t.Errorf("got %q, want %q", got, c.want)
+		}
+	}
+}
@@ -0,0 +1,317 @@
+package analysis
+
+import (
+	"fmt"
+	"strings"
+
+	otm_transform "github.com/0-trust/service/pkg/otm"
+	otm "github.com/adedayo/open-threat-model/pkg"
+)
+
+const (
+	// Generator marks the threats and mitigations proposed by GenerateThreats, in their generatedBy attribute
+	Generator = "zero-trust-stride"
+
+	Spoofing              = "spoofing"
+	Tampering             = "tampering"
+	Repudiation           = "repudiation"
+	InformationDisclosure = "information disclosure"
+	DenialOfService       = "denial of service"
+	ElevationOfPrivilege  = "elevation of privilege"
+)
+
+// Threat is a threat definition, in the form of the threats section of an OTM document
+type Threat struct {
+	ID          string            `yaml:"id" json:"id"`
+	Name        string            `yaml:"name" json:"name"`
+	Description string            `yaml:"description" json:"description"`
+	Categories  []string          `yaml:"categories" json:"categories"`
+	CWEs        []string          `yaml:"cwes,omitempty" json:"cwes,omitempty"`
+	Risk        ThreatRisk        `yaml:"risk" json:"risk"`
+	Attributes  map[string]string `yaml:"attributes,omitempty" json:"attributes,omitempty"`
+}
+
+type ThreatRisk struct {
+	Likelihood float64 `yaml:"likelihood" json:"likelihood"`
+	Impact     float64 `yaml:"impact" json:"impact"`
+}
+
+// Mitigation is a mitigation definition, in the form of the mitigations section of an OTM document
+type Mitigation struct {
+	ID            string            `yaml:"id" json:"id"`
+	Name          string            `yaml:"name" json:"name"`
+	Description   string            `yaml:"description" json:"description"`
+	RiskReduction float64           `yaml:"riskReduction" json:"riskReduction"`
+	Attributes    map[string]string `yaml:"attributes,omitempty" json:"attributes,omitempty"`
+}
+
+// ThreatProposal is a threat to an element (a component or data flow) of an OTM, with its suggested mitigations
+type ThreatProposal struct {
+	Section     string       `json:"section"` //components or dataflows
+	ElementID   string       `json:"elementID"`
+	Threat      Threat       `json:"threat"`
+	Mitigations []Mitigation `json:"mitigations"`
+}
+
+// strideRule proposes a threat for each element it applies to
+type strideRule struct {
+	id, name, category string
+	cwes               []string
+	likelihood, impact float64
+	mitigations        []string
+	//describes the threat to a flow or component, and whether the rule applies to it
+	flow      func(ix *modelIndex, df otm.DataFlow) (string, bool)
+	component func(ix *modelIndex, c otm.Component) (string, bool)
+}
+
+var (
+	strideMitigations = map[string]Mitigation{
+		"authenticate-source": {Name: "Authenticate the source",
+			Description: "Authenticate the sender of every request, e.g. with mutual TLS or signed tokens, before acting on it", RiskReduction: 80},
+		"authenticate-destination": {Name: "Authenticate the destination",
+			Description: "Verify the identity of the receiver before sending data, e.g. by validating its TLS certificate", RiskReduction: 70},
+		"integrity-protection": {Name: "Protect message integrity",
+			Description: "Protect data in transit against modification with an authenticated channel (TLS) or message signatures", RiskReduction: 70},
+		"encrypt-in-transit": {Name: "Encrypt data in transit",
+			Description: "Use an encrypted protocol, such as TLS, for the data flow", RiskReduction: 80},
+		"audit-logging": {Name: "Audit logging",
+			Description: "Record security-relevant actions with the authenticated identity of the actor, in tamper-evident logs", RiskReduction: 60},
+		"rate-limiting": {Name: "Rate limiting and resource quotas",
+			Description: "Limit the rate and size of requests from less trusted sources, and bound the resources each may consume", RiskReduction: 60},
+		"least-privilege": {Name: "Least privilege authorisation",
+			Description: "Authorise every request against the caller's privileges, and run the component with the least privileges it needs", RiskReduction: 70},
+		"input-validation": {Name: "Input validation",
+			Description: "Validate all input from less trusted sources against a strict schema before processing it", RiskReduction: 50},
+		"encrypt-at-rest": {Name: "Encrypt data at rest",
+			Description: "Encrypt stored data, and restrict access to the data store to the components that need it", RiskReduction: 60},
+	}
+
+	strideRules = []strideRule{
+		{
+			id: "spoofed-source", name: "Spoofed source", category: Spoofing,
+			cwes: []string{"CWE-290"}, likelihood: 60, impact: 70,
+			mitigations: []string{"authenticate-source"},
+			flow: func(ix *modelIndex, df otm.DataFlow) (string, bool) {
+				c := ix.crossing(df.Source, df.Destination)
+				return fmt.Sprintf("The data flow enters %s from the less trusted %s without authentication, so an attacker in %[2]s may impersonate %s",
+					c.to.Name, c.from.Name, name(ix, df.Source)), c.entersHigherZone && !otm_transform.Authenticated(df)
+			},
+		},
+		{
+			id: "spoofed-destination", name: "Spoofed destination", category: Spoofing,
+			cwes: []string{"CWE-295"}, likelihood: 40, impact: 60,
+			mitigations: []string{"authenticate-destination"},
+			flow: func(ix *modelIndex, df otm.DataFlow) (string, bool) {
+				c := ix.crossing(df.Source, df.Destination)
+				return fmt.Sprintf("The data flow leaves %s for the less trusted %s, where an attacker may impersonate %s to receive the data",
+					c.from.Name, c.to.Name, name(ix, df.Destination)), c.entersLowerZone
+			},
+		},
+		{
+			id: "tampered-flow", name: "Tampering with data in transit", category: Tampering,
+			cwes: []string{"CWE-345"}, likelihood: 50, impact: 60,
+			mitigations: []string{"integrity-protection"},
+			flow: func(ix *modelIndex, df otm.DataFlow) (string, bool) {
+				return "The data flow is not authenticated, so the data may be modified in transit without the receiver noticing",
+					!otm_transform.Authenticated(df)
+			},
+		},
+		{
+			id: "disclosed-in-transit", name: "Disclosure of data in transit", category: InformationDisclosure,
+			cwes: []string{"CWE-319"}, likelihood: 60, impact: 60,
+			mitigations: []string{"encrypt-in-transit"},
+			flow: func(ix *modelIndex, df otm.DataFlow) (string, bool) {
+				return "The data flow is not encrypted, so its data may be read by anyone on the network path", isUnencrypted(df)
+			},
+		},
+		{
+			id: "unauthenticated-endpoint", name: "Unauthenticated access to sensitive data", category: InformationDisclosure,
+			cwes: []string{"CWE-306"}, likelihood: 60, impact: 80,
+			mitigations: []string{"authenticate-source", "least-privilege"},
+			flow: func(ix *modelIndex, df otm.DataFlow) (string, bool) {
+				assets := ix.sensitiveAssets(df)
+				return fmt.Sprintf("%s does not require authentication, so anyone who can reach %s may read or submit its sensitive data (%s)",
+					df.Name, name(ix, df.Destination), strings.Join(assets, ", ")), len(assets) > 0 && otm_transform.Unauthenticated(df)
+			},
+		},
+		{
+			id: "repudiated-action", name: "Repudiation of actions", category: Repudiation,
+			cwes: []string{"CWE-778"}, likelihood: 40, impact: 40,
+			mitigations: []string{"audit-logging"},
+			component: func(ix *modelIndex, c otm.Component) (string, bool) {
+				sources := lessTrustedSources(ix, c.ID)
+				return fmt.Sprintf("%s acts on requests from less trusted sources (%s) that may later deny having made them",
+					c.Name, strings.Join(sources, ", ")), len(sources) > 0 && kindOf(c) == "process" && !otm_transform.Asserted(c.Attributes, "logging", "audit")
+			},
+		},
+		{
+			id: "exhausted-resources", name: "Denial of service", category: DenialOfService,
+			cwes: []string{"CWE-400"}, likelihood: 50, impact: 60,
+			mitigations: []string{"rate-limiting"},
+			component: func(ix *modelIndex, c otm.Component) (string, bool) {
+				sources := lessTrustedSources(ix, c.ID)
+				kind := kindOf(c)
+				return fmt.Sprintf("%s is reachable from less trusted sources (%s) that may exhaust its resources",
+					c.Name, strings.Join(sources, ", ")), len(sources) > 0 && (kind == "process" || kind == "network" || kind == "queue")
+			},
+		},
+		{
+			id: "elevated-privilege", name: "Elevation of privilege", category: ElevationOfPrivilege,
+			cwes: []string{"CWE-269", "CWE-20"}, likelihood: 40, impact: 80,
+			mitigations: []string{"least-privilege", "input-validation"},
+			component: func(ix *modelIndex, c otm.Component) (string, bool) {
+				sources := lessTrustedSources(ix, c.ID)
+				return fmt.Sprintf("Input from less trusted sources (%s) may be crafted to gain the privileges of %s",
+					strings.Join(sources, ", "), c.Name), len(sources) > 0 && kindOf(c) == "process"
+			},
+		},
+		{
+			id: "disclosed-at-rest", name: "Disclosure of stored data", category: InformationDisclosure,
+			cwes: []string{"CWE-311"}, likelihood: 40, impact: 80,
+			mitigations: []string{"encrypt-at-rest"},
+			component: func(ix *modelIndex, c otm.Component) (string, bool) {
+				flows, sources := ix.inbound(c.ID)
+				crossZone := false
+				for i := range flows {
+					crossZone = crossZone || ix.crossing(sources[i], c.ID).crossesBoundary
+				}
+				return fmt.Sprintf("%s is accessed from other trust zones, and its data may be exposed if it is not encrypted and access is not restricted", c.Name),
+					crossZone && kindOf(c) == "datastore" && !otm_transform.Asserted(c.Attributes, "encryption", "encrypted")
+			},
+		},
+	}
+)
+
+func kindOf(c otm.Component) string {
+	return otm_transform.ComponentKind(c.Type)
+}
+
+func name(ix *modelIndex, id string) string {
+	if n, found := ix.model.GetNameByID(id); found && n != "" {
+		return n
+	}
+	return id
+}
+
+// lessTrustedSources returns the names of the elements that send data to an element from less trusted zones
+func lessTrustedSources(ix *modelIndex, id string) []string {
+	sources := []string{}
+	_, from := ix.inbound(id)
+	for _, s := range from {
+		if ix.crossing(s, id).entersHigherZone {
+			sources = append(sources, name(ix, s))
+		}
+	}
+	return sources
+}
+
+// GenerateThreats proposes STRIDE threats, with suggested mitigations, for the components and data flows of an OTM.
+// Threat IDs are derived from the rule and the element, so threats that the model already has are not proposed again
+func GenerateThreats(model otm.OpenThreatModel) []ThreatProposal {
+	ix := newModelIndex(model)
+	existing := make(map[string]bool)
+	for _, t := range model.Threats {
+		existing[t.ID] = true
+	}
+
+	proposals := []ThreatProposal{}
+	propose := func(rule strideRule, section, elementID, elementName, description string) {
+		id := fmt.Sprintf("stride-%s-%s", rule.id, elementID)
+		if existing[id] {
+			return
+		}
+		p := ThreatProposal{
+			Section:   section,
+			ElementID: elementID,
+			Threat: Threat{
+				ID:          id,
+				Name:        fmt.Sprintf("%s: %s", rule.name, elementName),
+				Description: description,
+				Categories:  []string{rule.category},
+				CWEs:        rule.cwes,
+				Risk:        ThreatRisk{Likelihood: rule.likelihood, Impact: rule.impact},
+				Attributes:  map[string]string{"generatedBy": Generator, "rule": rule.id},
+			},
+		}
+		for _, m := range rule.mitigations {
+			mitigation := strideMitigations[m]
+			mitigation.ID = "stride-" + m
+			mitigation.Attributes = map[string]string{"generatedBy": Generator}
+			p.Mitigations = append(p.Mitigations, mitigation)
+		}
+		proposals = append(proposals, p)
+	}
+
+	for _, rule := range strideRules {
+		if rule.flow != nil {
+			for _, df := range model.DataFlows {
+				if description, applies := rule.flow(ix, df); applies {
+					propose(rule, "dataflows", df.ID, flowName(ix, df), description)
+				}
+			}
+		}
+		if rule.component != nil {
+			for _, c := range model.Components {
+				if description, applies := rule.component(ix, c); applies {
+					propose(rule, "components", c.ID, name(ix, c.ID), description)
+				}
+			}
+		}
+	}
+	return proposals
+}
+
+func flowName(ix *modelIndex, df otm.DataFlow) string {
+	if df.Name != "" {
+		return df.Name
+	}
+	return fmt.Sprintf("%s to %s", name(ix, df.Source), name(ix, df.Destination))
+}
+
+// AddThreats writes threat proposals into an OTM document: the threats and mitigations are added to their sections,
+// and the threats are attached to their elements with the state exposed and the mitigations recommended.
+// Returns the updated document and the number of threats added
+func AddThreats(threatModel string, proposals []ThreatProposal) (string, int, error) {
+	doc, err := otm_transform.ParseDocument(threatModel)
+	if err != nil {
+		return threatModel, 0, err
+	}
+
+	added := 0
+	for _, p := range proposals {
+		if doc.HasElement("threats", p.Threat.ID) || !doc.HasElement(p.Section, p.ElementID) {
+			continue
+		}
+		if err := doc.AddElement("threats", p.Threat); err != nil {
+			return threatModel, added, err
+		}
+
+		instance := threatInstance{Threat: p.Threat.ID, State: "exposed"}
+		for _, m := range p.Mitigations {
+			if !doc.HasElement("mitigations", m.ID) {
+				if err := doc.AddElement("mitigations", m); err != nil {
+					return threatModel, added, err
+				}
+			}
+			instance.Mitigations = append(instance.Mitigations, mitigationInstance{Mitigation: m.ID, State: "recommended"})
+		}
+		if err := doc.AppendField(p.Section, p.ElementID, "threats", instance); err != nil {
+			return threatModel, added, err
+		}
+		added++
+	}
+
+	tm, err := doc.String()
+	return tm, added, err
+}
+
+type threatInstance struct {
+	Threat      string               `yaml:"threat"`
+	State       string               `yaml:"state"`
+	Mitigations []mitigationInstance `yaml:"mitigations,omitempty"`
+}
+
+type mitigationInstance struct {
+	Mitigation string `yaml:"mitigation"`
+	State      string `yaml:"state"`
+}
@@ -2,12 +2,20 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"path"
+	"strconv"
 	"strings"
 
+	"github.com/0-trust/service/pkg/analysis"
+	"github.com/0-trust/service/pkg/ingest"
+	otm_transform "github.com/0-trust/service/pkg/otm"
 	"github.com/0-trust/service/pkg/projects"
+	otm "github.com/adedayo/open-threat-model/pkg"
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
@@ -17,6 +25,7 @@ var (
 	routes         = mux.NewRouter()
 	apiVersion     = "0.0.0"
 	pm             projects.ProjectManager
+	rulesPath      string //directory of user-defined zero trust rules
 	allowedOrigins = []string{
 		"localhost:18273",
 		"http://localhost:4200",
@@ -64,6 +73,25 @@ func addRoutes() {
 	routes.HandleFunc("/api/project/delete", deleteProject).Methods(http.MethodPost)
 	routes.HandleFunc("/api/project/create", createProject).Methods(http.MethodPost)
 	routes.HandleFunc("/api/project/updatemodel", updateThreatModel).Methods(http.MethodPost)
+	routes.HandleFunc("/api/project/{projectID}/revisions", getRevisions).Methods(http.MethodGet)
+	routes.HandleFunc("/api/project/{projectID}/revisions/{revisionID}", getRevision).Methods(http.MethodGet)
+	routes.HandleFunc("/api/project/rollback", rollbackModel).Methods(http.MethodPost)
+	routes.HandleFunc("/api/project/{projectID}/diff", getDiff).Methods(http.MethodGet)
+	routes.HandleFunc("/api/project/{projectID}/diagram.drawio", getDrawIO).Methods(http.MethodGet)
+	routes.HandleFunc("/api/project/{projectID}/diagram.svg", getSVG).Methods(http.MethodGet)
+	routes.HandleFunc("/api/project/{projectID}/diagram.png", getPNG).Methods(http.MethodGet)
+	routes.HandleFunc("/api/project/{projectID}/export/{format}", exportModel).Methods(http.MethodGet)
+	routes.HandleFunc("/api/project/{projectID}/threats/generate", generateThreats).Methods(http.MethodPost)
+	routes.HandleFunc("/api/project/{projectID}/findings", getFindings).Methods(http.MethodGet)
+	routes.HandleFunc("/api/project/{projectID}/boundaries", getBoundaries).Methods(http.MethodGet)
+	routes.HandleFunc("/api/project/{projectID}/attack-paths", getAttackPaths).Methods(http.MethodGet)
+	routes.HandleFunc("/api/project/{projectID}/import/flows", importFlows).Methods(http.MethodPost)
+	routes.HandleFunc("/api/project/{projectID}/import/kubernetes", importKubernetes).Methods(http.MethodPost)
+	routes.HandleFunc("/api/project/{projectID}/import/terraform", importTerraform).Methods(http.MethodPost)
+	routes.HandleFunc("/api/project/{projectID}/import/compose", importCompose).Methods(http.MethodPost)
+	routes.HandleFunc("/api/project/{projectID}/import/openapi", importOpenAPI).Methods(http.MethodPost)
+	routes.HandleFunc("/api/project/{projectID}/drift", detectDrift).Methods(http.MethodPost)
+	routes.HandleFunc("/api/rules", getRules).Methods(http.MethodGet)
 	routes.HandleFunc("/api/message", getMessageWebSocket).Methods(http.MethodGet)
 
 }
@@ -90,23 +118,77 @@ func updateThreatModel(w http.ResponseWriter, r *http.Request) {
 
 	m, err := updateTM(model)
 
+	if errors.Is(err, projects.ErrMergeConflict) {
+		//return the conflicts for the client to resolve
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(m)
+		return
+	}
+
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	broadcastModel(*m, nil)
 	json.NewEncoder(w).Encode(m)
 }
 
+// createProject creates a project, whose threat model may be inferred from an attached source (see ingest.Importers):
+// either a source {kind, name, content} in the JSON project description, or the file of a multipart form whose
+// project field is the description and whose source field is the kind of source
 func createProject(w http.ResponseWriter, r *http.Request) {
-	var projDesc projects.ProjectDescription
-	if err := json.NewDecoder(r.Body).Decode(&projDesc); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+	var projDesc struct {
+		projects.ProjectDescription
+		Source *struct {
+			Kind    string `json:"kind"`
+			Name    string `json:"name"`
+			Content string `json:"content"`
+		} `json:"source,omitempty"`
+	}
+	var draft *ingest.Model
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := json.Unmarshal([]byte(r.FormValue("project")), &projDesc); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if kind := r.FormValue("source"); kind != "" {
+			in, name, err := uploadedFile(r, kind)
+			if err == nil {
+				defer in.Close()
+				draft, err = ingest.Import(kind, in, name)
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+	} else {
+		if err := json.NewDecoder(r.Body).Decode(&projDesc); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if source := projDesc.Source; source != nil {
+			name := source.Name
+			if name == "" {
+				name = source.Kind
+			}
+			var err error
+			if draft, err = ingest.Import(source.Kind, strings.NewReader(source.Content), name); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
 	}
 
 	// log.Printf("Got Proj Desc: %#v\n", projDesc)
-	proj, err := pm.CreateProject(projDesc)
+	var proj *projects.Project
+	var err error
+	if draft == nil {
+		proj, err = pm.CreateProject(projDesc.ProjectDescription)
+	} else {
+		proj, _, err = ingest.CreateProject(pm, projDesc.ProjectDescription, draft, projDesc.Owner)
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -149,6 +231,517 @@ func getModel(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(m)
 }
 
+func getRevisions(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	revs, err := pm.ListRevisions(vars["projectID"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(revs)
+}
+
+func getRevision(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	rev, err := pm.GetRevision(vars["projectID"], vars["revisionID"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(rev)
+}
+
+func rollbackModel(w http.ResponseWriter, r *http.Request) {
+	var rb struct {
+		ProjectID, RevisionID, Author string
+	}
+	if err := json.NewDecoder(r.Body).Decode(&rb); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	m, err := pm.RollbackModel(rb.ProjectID, rb.RevisionID, rb.Author)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	broadcastModel(*m, nil)
+	json.NewEncoder(w).Encode(m)
+}
+
+// getDiff compares two revisions of a project's threat model. The "to" revision defaults to the current model
+// and the "from" revision to the parent of the "to" revision
+func getDiff(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	projID := vars["projectID"]
+	query := r.URL.Query()
+
+	to, err := getRevisionOrHead(projID, query.Get("to"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	from := &projects.Revision{Model: &projects.Model{}}
+	if fromID := query.Get("from"); fromID != "" || to.Parent != "" {
+		if fromID == "" {
+			fromID = to.Parent
+		}
+		from, err = pm.GetRevision(projID, fromID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+	}
+
+	diff, err := diffRevisions(from, to)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	json.NewEncoder(w).Encode(diff)
+}
+
+// getDrawIO exports the current threat model of a project as a draw.io diagram
+func getDrawIO(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	model, err := getThreatModel(vars["projectID"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	mxFile, err := otm_transform.OtmToMXFile(model)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.Header().Set("Content-Disposition", `attachment; filename="diagram.drawio"`)
+	w.Write([]byte(mxFile))
+}
+
+// getSVG renders the current threat model of a project as an SVG diagram, highlighting the flows of highlightedFlows
+func getSVG(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	model, err := getThreatModel(vars["projectID"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	flows, err := highlightedFlows(r, model)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	svg, err := otm_transform.OtmToSVGHighlighting(model, flows)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Write([]byte(svg))
+}
+
+// exportModel exports the current threat model of a project to one of the otm_transform.ExportFormats or BundleFormats
+func exportModel(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	format, text := otm_transform.ExportFormats[vars["format"]]
+	bundle, binary := otm_transform.BundleFormats[vars["format"]]
+	if !text && !binary {
+		http.Error(w, fmt.Sprintf("unsupported export format %s", vars["format"]), http.StatusNotFound)
+		return
+	}
+	model, err := getThreatModel(vars["projectID"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	contentType, extension := format.ContentType, format.Extension
+	var out []byte
+	if binary {
+		contentType, extension = bundle.ContentType, bundle.Extension
+		out, err = otm_transform.ExportBundle(model, bundle.Name)
+	} else {
+		var exported string
+		exported, err = otm_transform.Export(model, format.Name)
+		out = []byte(exported)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="model.%s"`, extension))
+	w.Write(out)
+}
+
+// generateThreats proposes STRIDE threats for the current threat model of a project and, unless the dryRun
+// query parameter is true, saves them into the model as a new revision
+func generateThreats(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	projID := vars["projectID"]
+	head, err := pm.GetModel(projID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	model, err := parseThreatModel(head.ThreatModel)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result := struct {
+		Proposals []analysis.ThreatProposal `json:"proposals"`
+		Added     int                       `json:"added"`
+		Model     *projects.Message         `json:"model,omitempty"`
+	}{Proposals: analysis.GenerateThreats(model)}
+
+	if r.URL.Query().Get("dryRun") != "true" && len(result.Proposals) > 0 {
+		tm, added, err := analysis.AddThreats(head.ThreatModel, result.Proposals)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		result.Added = added
+		if added > 0 { //otherwise every proposal is already in the model, and there is no revision to save
+			m, err := pm.UpdateModel(projID, &projects.Message{
+				ProjectID:    projID,
+				ThreatModel:  tm,
+				VisualModel:  head.VisualModel,
+				BaseRevision: head.Revision,
+				Author:       analysis.Generator,
+				Comment:      fmt.Sprintf("Generated %d STRIDE threats", added),
+			})
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			broadcastModel(*m, nil)
+			result.Model = m
+		}
+	}
+	json.NewEncoder(w).Encode(result)
+}
+
+// importFlows infers a draft model from uploaded network flows, Zeek conn.log or packet capture (see ingest.ReadFlows)
+// and adds it to a project's model
+func importFlows(w http.ResponseWriter, r *http.Request) {
+	in, name, err := uploadedFile(r, "flows.csv")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer in.Close()
+	zones, err := uploadedZones(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	zf, err := ingest.ReadFlows(in, zones)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	importDraft(w, mux.Vars(r)["projectID"], zf.DraftModel(name))
+}
+
+// importKubernetes adds a draft model inferred from uploaded Kubernetes manifests to a project's model. The manifests
+// are the request body, or the files of a multipart form, which may have several
+func importKubernetes(w http.ResponseWriter, r *http.Request) {
+	km := ingest.NewKubernetesManifests()
+	name := "manifests"
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := r.ParseMultipartForm(32 << 20); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		files := r.MultipartForm.File["file"]
+		if len(files) == 1 {
+			name = files[0].Filename
+		}
+		for _, header := range files {
+			file, err := header.Open()
+			if err != nil {
+				http.Error(w, fmt.Sprintf("%s: %s", header.Filename, err.Error()), http.StatusBadRequest)
+				return
+			}
+			km.ReadFile(header.Filename, file)
+			file.Close()
+		}
+		if len(files) > 0 && len(km.Warnings) == len(files) {
+			http.Error(w, strings.Join(km.Warnings, "\n"), http.StatusBadRequest)
+			return
+		}
+	} else if err := km.Read(r.Body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	importDraft(w, mux.Vars(r)["projectID"], km.DraftModel(name), km.Warnings...)
+}
+
+// importTerraform adds a draft model inferred from uploaded Terraform state or plan JSON (see
+// ingest.ReadTerraformJSON) to a project's model
+func importTerraform(w http.ResponseWriter, r *http.Request) {
+	in, name, err := uploadedFile(r, "terraform.json")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer in.Close()
+	tf, err := ingest.ReadTerraformJSON(in)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	importDraft(w, mux.Vars(r)["projectID"], tf.DraftModel(name))
+}
+
+// importCompose adds a draft model inferred from an uploaded Compose file (see ingest.ReadComposeFile) to a project's model
+func importCompose(w http.ResponseWriter, r *http.Request) {
+	in, name, err := uploadedFile(r, "docker-compose.yml")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer in.Close()
+	cf, err := ingest.ReadComposeFile(in)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	importDraft(w, mux.Vars(r)["projectID"], cf.DraftModel(name))
+}
+
+// importOpenAPI adds a draft model inferred from uploaded OpenAPI 3 specifications to a project's model. The
+// specifications are the request body, or the files of a multipart form, which may have several. They are the APIs of
+// the component of the component query parameter, or of new components, called from the component of the clients
+// parameter, or the internet
+func importOpenAPI(w http.ResponseWriter, r *http.Request) {
+	specs := ingest.NewOpenAPISpecs()
+	specs.Clients = r.URL.Query().Get("clients")
+	component := r.URL.Query().Get("component")
+	name := "openapi"
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := r.ParseMultipartForm(32 << 20); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		files := r.MultipartForm.File["file"]
+		if len(files) == 0 {
+			http.Error(w, "no OpenAPI specification uploaded", http.StatusBadRequest)
+			return
+		}
+		name = files[0].Filename
+		for _, header := range files {
+			file, err := header.Open()
+			if err == nil {
+				err = specs.Read(file, component)
+				file.Close()
+			}
+			if err != nil {
+				http.Error(w, fmt.Sprintf("%s: %s", header.Filename, err.Error()), http.StatusBadRequest)
+				return
+			}
+		}
+	} else if err := specs.Read(r.Body, component); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	importDraft(w, mux.Vars(r)["projectID"], specs.DraftModel(name))
+}
+
+// detectDrift compares the current threat model of a project with the traffic of an uploaded flow log
+func detectDrift(w http.ResponseWriter, r *http.Request) {
+	model, err := getThreatModel(mux.Vars(r)["projectID"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	in, _, err := uploadedFile(r, "flows.csv")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer in.Close()
+	zones, err := uploadedZones(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	observed, err := ingest.ReadFlows(in, zones)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	json.NewEncoder(w).Encode(analysis.DetectDrift(model, observed))
+}
+
+// uploadedFile is the file of a multipart form upload, or else the request body, with its name
+func uploadedFile(r *http.Request, defaultName string) (io.ReadCloser, string, error) {
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			return nil, "", err
+		}
+		return file, header.Filename, nil
+	}
+	return r.Body, defaultName, nil
+}
+
+// uploadedZones is the CIDR to zone table (see ingest.ReadZoneMap) in the zones file of a multipart form upload, if any
+func uploadedZones(r *http.Request) (*ingest.ZoneMap, error) {
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		return nil, nil
+	}
+	file, _, err := r.FormFile("zones")
+	if errors.Is(err, http.ErrMissingFile) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ingest.ReadZoneMap(file)
+}
+
+// importDraft adds a draft model to a project's model, replying with the number of elements added and the saved model,
+// and any warnings about the input
+func importDraft(w http.ResponseWriter, projectID string, draft *ingest.Model, warnings ...string) {
+	saved, added, err := ingest.ImportIntoProject(pm, projectID, draft, "import")
+	if errors.Is(err, projects.ErrMergeConflict) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if added > 0 {
+		broadcastModel(*saved, nil)
+	}
+	json.NewEncoder(w).Encode(struct {
+		Added    int               `json:"added"`
+		Model    *projects.Message `json:"model"`
+		Warnings []string          `json:"warnings,omitempty"`
+	}{added, saved, warnings})
+}
+
+// getRules lists the zero trust checks: the built-in rules and those in the rules directory
+func getRules(w http.ResponseWriter, _ *http.Request) {
+	rules, err := analysis.LoadRules(rulesPath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(rules)
+}
+
+// getFindings checks the current threat model of a project against the zero trust rules
+func getFindings(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	model, err := getThreatModel(vars["projectID"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	rules, err := analysis.LoadRules(rulesPath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(analysis.CheckRules(model, rules))
+}
+
+// getBoundaries returns the trust boundary crossings of the current threat model of a project, riskiest first
+func getBoundaries(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	model, err := getThreatModel(vars["projectID"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(otm_transform.AnalyseBoundaries(model))
+}
+
+// getAttackPaths returns the attack paths of the current threat model of a project. The query parameters from and to
+// are comma-separated IDs of entry points and targets (chosen from the model if missing), and k the number of most likely paths
+func getAttackPaths(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	model, err := getThreatModel(vars["projectID"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	options, err := attackPathOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	json.NewEncoder(w).Encode(analysis.FindAttackPaths(model, options))
+}
+
+func attackPathOptions(r *http.Request) (options analysis.AttackPathOptions, err error) {
+	query := r.URL.Query()
+	split := func(ids string) (list []string) {
+		for _, id := range strings.Split(ids, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				list = append(list, id)
+			}
+		}
+		return
+	}
+	options.EntryPoints, options.Targets = split(query.Get("from")), split(query.Get("to"))
+	if k := query.Get("k"); k != "" {
+		if options.K, err = strconv.Atoi(k); err != nil {
+			return options, fmt.Errorf("k must be a number of paths: %w", err)
+		}
+	}
+	return
+}
+
+// highlightedFlows are the flows to highlight on a diagram: those of the most likely attack paths if the attackPaths
+// query parameter is true (with the options of getAttackPaths), and any listed in the highlight parameter
+func highlightedFlows(r *http.Request, model otm.OpenThreatModel) ([]string, error) {
+	flows := []string{}
+	if r.URL.Query().Get("attackPaths") == "true" {
+		options, err := attackPathOptions(r)
+		if err != nil {
+			return nil, err
+		}
+		flows = analysis.FindAttackPaths(model, options).Flows()
+	}
+	for _, id := range strings.Split(r.URL.Query().Get("highlight"), ",") {
+		if id != "" {
+			flows = append(flows, id)
+		}
+	}
+	return flows, nil
+}
+
+// getPNG renders the current threat model of a project as a PNG diagram, highlighting the flows of highlightedFlows
+func getPNG(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	model, err := getThreatModel(vars["projectID"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	flows, err := highlightedFlows(r, model)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	png, err := otm_transform.OtmToPNGHighlighting(model, flows)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(png)
+}
+
 func deleteProject(w http.ResponseWriter, r *http.Request) {
 	var id struct {
 		ProjectID string
@@ -201,5 +794,6 @@ func ServeAPI(config Config) {
 	corsOptions = append(corsOptions, handlers.AllowedOrigins(allowedOrigins))
 	apiVersion = config.AppVersion
 	pm, _ = projects.NewDBProjectManager(config.DataPath)
+	rulesPath = path.Join(config.DataPath, "rules")
 	log.Fatal(http.ListenAndServe(hostPort, handlers.CORS(corsOptions...)(routes)))
 }
@@ -0,0 +1,325 @@
+package api
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/0-trust/service/pkg/collab"
+	"github.com/0-trust/service/pkg/projects"
+	"github.com/gorilla/websocket"
+)
+
+var (
+	collabSessions  = make(map[string]*collabSession) //projectID -> collaborative editing session
+	collabLock      sync.Mutex
+	collabSaveDelay = 5 * time.Second //save the session this long after the last edit
+)
+
+type collabSession struct {
+	*collab.Session
+	sockets   map[string]*websocket.Conn //participantID -> socket
+	saveTimer *time.Timer
+	lock      sync.Mutex //serialises edits with their broadcast, so that participants receive them in version order
+	saveLock  sync.Mutex
+}
+
+func participantID(ws *websocket.Conn) string {
+	return ws.RemoteAddr().String()
+}
+
+func getSession(projectID string) *collabSession {
+	collabLock.Lock()
+	defer collabLock.Unlock()
+	return collabSessions[projectID]
+}
+
+func getOrStartSession(projectID string) (*collabSession, error) {
+	collabLock.Lock()
+	defer collabLock.Unlock()
+	if cs, exists := collabSessions[projectID]; exists {
+		return cs, nil
+	}
+
+	m, err := pm.GetModel(projectID)
+	if err != nil {
+		return nil, err
+	}
+	s, err := collab.NewSession(projectID, m.Revision, m.ThreatModel, m.VisualModel)
+	if err != nil {
+		return nil, err
+	}
+	cs := &collabSession{
+		Session: s,
+		sockets: make(map[string]*websocket.Conn),
+	}
+	collabSessions[projectID] = cs
+	return cs, nil
+}
+
+// inSession checks whether the socket takes part in the collaborative session of a project
+func inSession(projectID string, ws *websocket.Conn) bool {
+	if cs := getSession(projectID); cs != nil {
+		cs.lock.Lock()
+		defer cs.lock.Unlock()
+		return cs.sockets[participantID(ws)] == ws
+	}
+	return false
+}
+
+func joinSession(msg projects.Message, ws *websocket.Conn) {
+	cs, err := getOrStartSession(msg.ProjectID)
+	if err != nil {
+		writeJSON(ws, projects.Message{
+			Type:      "session_state",
+			ProjectID: msg.ProjectID,
+			Workspace: msg.Workspace,
+			HasError:  true,
+			Error:     err.Error(),
+		})
+		return
+	}
+
+	presence := collab.Presence{
+		ParticipantID: participantID(ws),
+		User:          msg.Author,
+	}
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+	cs.sockets[presence.ParticipantID] = ws
+	cs.UpdatePresence(presence)
+	cs.sendState(ws)
+	cs.broadcast(projects.Message{
+		Type:      "presence",
+		ProjectID: msg.ProjectID,
+		Presence:  []collab.Presence{presence},
+	}, ws)
+}
+
+func editModel(msg projects.Message, ws *websocket.Conn) {
+	cs := getSession(msg.ProjectID)
+	if cs == nil {
+		writeJSON(ws, projects.Message{
+			Type:      "edit_ack",
+			ProjectID: msg.ProjectID,
+			HasError:  true,
+			Error:     "there is no collaborative session on the project, send join_session first",
+		})
+		return
+	}
+
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+	applied, rejected := cs.Apply(msg.Operations, msg.Author)
+	version := cs.Version()
+	writeJSON(ws, projects.Message{
+		Type:       "edit_ack",
+		ProjectID:  msg.ProjectID,
+		Version:    version,
+		Operations: applied,
+		Rejected:   rejected,
+	})
+
+	if len(applied) > 0 {
+		cs.broadcast(projects.Message{
+			Type:       "edit",
+			ProjectID:  msg.ProjectID,
+			Version:    version,
+			Operations: applied,
+		}, ws)
+		cs.scheduleSave()
+	}
+}
+
+func updatePresence(msg projects.Message, ws *websocket.Conn) {
+	cs := getSession(msg.ProjectID)
+	if cs == nil || len(msg.Presence) == 0 {
+		return
+	}
+
+	presence := msg.Presence[0]
+	presence.ParticipantID = participantID(ws)
+	if presence.User == "" {
+		presence.User = msg.Author
+	}
+
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+	if cs.sockets[presence.ParticipantID] != ws {
+		return
+	}
+	cs.UpdatePresence(presence)
+	cs.broadcast(projects.Message{
+		Type:      "presence",
+		ProjectID: msg.ProjectID,
+		Presence:  []collab.Presence{presence},
+	}, ws)
+}
+
+func leaveSession(msg projects.Message, ws *websocket.Conn) {
+	if cs := getSession(msg.ProjectID); cs != nil {
+		cs.leave(ws)
+	}
+}
+
+// leaveSessions removes a (closed) socket from every collaborative session
+func leaveSessions(ws *websocket.Conn) {
+	collabLock.Lock()
+	sessions := []*collabSession{}
+	for _, cs := range collabSessions {
+		sessions = append(sessions, cs)
+	}
+	collabLock.Unlock()
+
+	for _, cs := range sessions {
+		cs.leave(ws)
+	}
+}
+
+// leave removes a participant, saving and ending the session when the last participant leaves
+func (cs *collabSession) leave(ws *websocket.Conn) {
+	id := participantID(ws)
+	cs.lock.Lock()
+	if cs.sockets[id] != ws {
+		cs.lock.Unlock()
+		return
+	}
+	delete(cs.sockets, id)
+	remaining := cs.Leave(id)
+	cs.broadcast(projects.Message{
+		Type:      "presence",
+		ProjectID: cs.ProjectID,
+		Presence:  []collab.Presence{{ParticipantID: id, Left: true}},
+	}, nil)
+	cs.lock.Unlock()
+
+	if remaining == 0 {
+		collabLock.Lock()
+		if collabSessions[cs.ProjectID] == cs {
+			delete(collabSessions, cs.ProjectID)
+		}
+		collabLock.Unlock()
+		cs.save()
+	}
+}
+
+// refreshSession brings a collaborative session up to date with a model saved outside it
+func refreshSession(msg projects.Message) {
+	cs := getSession(msg.ProjectID)
+	if cs == nil || msg.Revision == cs.BaseRevision() {
+		return
+	}
+	if cs.IsDirty() {
+		//saving merges the session with the model that was saved, and resets the session to the merge
+		cs.save()
+		return
+	}
+
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+	if err := cs.Reset(msg.Revision, msg.ThreatModel, msg.VisualModel); err != nil {
+		log.Printf("Error refreshing the collaborative session of project %s: %v", msg.ProjectID, err)
+		return
+	}
+	cs.sendState(nil)
+}
+
+func (cs *collabSession) scheduleSave() {
+	if cs.saveTimer == nil {
+		cs.saveTimer = time.AfterFunc(collabSaveDelay, cs.save)
+	} else {
+		cs.saveTimer.Reset(collabSaveDelay)
+	}
+}
+
+// save saves the session document as a new revision of the project's model, merging it with any changes saved outside the session
+func (cs *collabSession) save() {
+	cs.saveLock.Lock()
+	defer cs.saveLock.Unlock()
+	if !cs.IsDirty() {
+		return
+	}
+
+	tm, vm, version, err := cs.Snapshot()
+	if err != nil {
+		log.Printf("Error saving the collaborative session of project %s: %v", cs.ProjectID, err)
+		return
+	}
+
+	m, err := pm.UpdateModel(cs.ProjectID, &projects.Message{
+		ProjectID:    cs.ProjectID,
+		ThreatModel:  tm,
+		VisualModel:  vm,
+		BaseRevision: cs.BaseRevision(),
+		Author:       strings.Join(cs.Authors(), ", "),
+		Comment:      "Collaborative edit",
+	})
+
+	cs.lock.Lock()
+	if err != nil {
+		log.Printf("Error saving the collaborative session of project %s: %v", cs.ProjectID, err)
+		cs.broadcast(projects.Message{
+			Type:      "session_error",
+			ProjectID: cs.ProjectID,
+			Conflicts: m.Conflicts,
+			HasError:  true,
+			Error:     err.Error(),
+		}, nil)
+		//the edits can't be saved on the session's base revision, so continue from the current model rather than
+		//retrying them on a stale revision with every later save
+		if head, err := pm.GetModel(cs.ProjectID); err != nil {
+			log.Printf("Error reloading the model of the collaborative session of project %s: %v", cs.ProjectID, err)
+		} else if err := cs.Reset(head.Revision, head.ThreatModel, head.VisualModel); err != nil {
+			log.Printf("Error resetting the collaborative session of project %s: %v", cs.ProjectID, err)
+		} else {
+			cs.sendState(nil)
+		}
+		cs.lock.Unlock()
+		return
+	}
+	cs.Saved(m.Revision, version)
+	if m.Merged {
+		if err := cs.Reset(m.Revision, m.ThreatModel, m.VisualModel); err == nil {
+			cs.sendState(nil)
+		}
+	}
+	cs.lock.Unlock()
+
+	broadcastModel(*m, nil)
+}
+
+// sendState sends the session document to a participant, or all participants if ws is nil. Must hold cs.lock
+func (cs *collabSession) sendState(ws *websocket.Conn) {
+	tm, vm, version, err := cs.Snapshot()
+	msg := projects.Message{
+		Type:        "session_state",
+		ProjectID:   cs.ProjectID,
+		ThreatModel: tm,
+		VisualModel: vm,
+		Revision:    cs.BaseRevision(),
+		Version:     version,
+		Presence:    cs.Participants(),
+	}
+	if err != nil {
+		msg.HasError = true
+		msg.Error = err.Error()
+	}
+
+	if ws != nil {
+		writeJSON(ws, msg)
+		return
+	}
+	cs.broadcast(msg, nil)
+}
+
+// broadcast sends a message to every participant other than the sender (if any). Must hold cs.lock
+func (cs *collabSession) broadcast(msg projects.Message, sender *websocket.Conn) {
+	for _, ws := range cs.sockets {
+		if ws != sender {
+			if err := writeJSON(ws, msg); err != nil {
+				log.Printf("Error sending %s to %s: %v", msg.Type, ws.RemoteAddr(), err)
+			}
+		}
+	}
+}
@@ -3,7 +3,9 @@ package api
 import (
 	"strings"
 
+	otm_transform "github.com/0-trust/service/pkg/otm"
 	"github.com/0-trust/service/pkg/projects"
+	otm "github.com/adedayo/open-threat-model/pkg"
 )
 
 func updateTM(msg projects.Message) (*projects.Message, error) {
@@ -32,3 +34,44 @@ func validateAndUpdateTM(msg *projects.Message) (*projects.Message, error) {
 
 	return msg, err
 }
+
+// parseThreatModel parses an OTM document, treating an empty document (e.g. of a newly created project) as an empty model
+func parseThreatModel(threatModel string) (otm.OpenThreatModel, error) {
+	if strings.TrimSpace(threatModel) == "" {
+		return otm.OpenThreatModel{}, nil
+	}
+	return otm.Parse(strings.NewReader(threatModel))
+}
+
+// getThreatModel parses the current threat model of a project
+func getThreatModel(projectID string) (otm.OpenThreatModel, error) {
+	m, err := pm.GetModel(projectID)
+	if err != nil {
+		return otm.OpenThreatModel{}, err
+	}
+	return parseThreatModel(m.ThreatModel)
+}
+
+// getRevisionOrHead returns the specified revision of a project, or its current revision if revisionID is empty
+func getRevisionOrHead(projectID, revisionID string) (*projects.Revision, error) {
+	if revisionID == "" {
+		m, err := pm.GetModel(projectID)
+		if err != nil {
+			return nil, err
+		}
+		revisionID = m.Revision
+	}
+	return pm.GetRevision(projectID, revisionID)
+}
+
+func diffRevisions(from, to *projects.Revision) (otm_transform.ModelDiff, error) {
+	fromModel, err := parseThreatModel(from.Model.ThreatModel)
+	if err != nil {
+		return otm_transform.ModelDiff{}, err
+	}
+	toModel, err := parseThreatModel(to.Model.ThreatModel)
+	if err != nil {
+		return otm_transform.ModelDiff{}, err
+	}
+	return otm_transform.DiffModels(fromModel, toModel), nil
+}
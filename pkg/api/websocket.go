@@ -15,12 +15,11 @@ import (
 var (
 	longLivedSockets = make(map[string]map[string]*websocket.Conn) //projectID -> remoteAddres -> listening socket, they get removed when remote closes
 	longSocLock      sync.RWMutex
+	socketWriteLocks sync.Map //*websocket.Conn -> *sync.Mutex, websocket connections support only one concurrent writer
 )
 
 func addLongLivedSocket(ctx context.Context, msg projects.Message, ws *websocket.Conn) {
 	longSocLock.Lock()
-	defer longSocLock.Unlock()
-
 	conns := make(map[string]*websocket.Conn)
 	if cc, exists := longLivedSockets[msg.ProjectID]; exists {
 		conns = cc
@@ -28,6 +27,7 @@ func addLongLivedSocket(ctx context.Context, msg projects.Message, ws *websocket
 	remoteAdd := ws.RemoteAddr().String()
 	conns[remoteAdd] = ws
 	longLivedSockets[msg.ProjectID] = conns
+	longSocLock.Unlock()
 
 	go cleanClose(ws)
 
@@ -43,6 +43,7 @@ func readLoop(_ context.Context, ws *websocket.Conn) {
 		if err := ws.ReadJSON(&msg); err == nil {
 			processMessage(msg, ws)
 		} else {
+			removeSocket(ws)
 			ws.Close()
 			break
 		}
@@ -58,6 +59,16 @@ func processMessage(msg projects.Message, ws *websocket.Conn) {
 		processModel(msg, ws)
 	case "get_model":
 		getModelOverWS(msg, ws)
+	case "generate_visual_model":
+		generateVisualModel(msg, ws)
+	case "join_session":
+		joinSession(msg, ws)
+	case "edit":
+		editModel(msg, ws)
+	case "presence":
+		updatePresence(msg, ws)
+	case "leave_session":
+		leaveSession(msg, ws)
 	default:
 		log.Printf("Unhandles message type: %s", msg.Type)
 	}
@@ -66,36 +77,53 @@ func processMessage(msg projects.Message, ws *websocket.Conn) {
 func getModelOverWS(msg projects.Message, ws *websocket.Conn) {
 	m, _ := pm.GetModel(msg.ProjectID)
 	m.Type = "update_ui"
-	ws.WriteJSON(m)
+	writeJSON(ws, m)
 }
 
+// processModel transforms the threat model to the requested text format (graphviz by default), the reply type is the
+// format. Binary formats such as OPA bundles are rejected, since the reply is JSON text
 func processModel(msg projects.Message, ws *websocket.Conn) {
-	if model, err := otm.Parse(strings.NewReader(msg.ThreatModel)); err == nil {
-		if g, err := otm_transform.OtmToGraphviz(model); err == nil {
-			ws.WriteJSON(projects.Message{
-				Type:        "graphviz",
-				ProjectID:   msg.ProjectID,
-				Workspace:   msg.Workspace,
-				VisualModel: g,
-			})
-		} else {
-			ws.WriteJSON(projects.Message{
-				Type:      "graphviz",
-				ProjectID: msg.ProjectID,
-				Workspace: msg.Workspace,
-				HasError:  true,
-				Error:     err.Error(),
-			})
+	format := msg.Format
+	if format == "" {
+		format = "graphviz"
+	}
+	reply := projects.Message{
+		Type:      format,
+		ProjectID: msg.ProjectID,
+		Workspace: msg.Workspace,
+		Format:    format,
+	}
+	model, err := otm.Parse(strings.NewReader(msg.ThreatModel))
+	if err == nil {
+		reply.VisualModel, err = otm_transform.Export(model, format)
+	}
+	if err != nil {
+		reply.HasError = true
+		reply.Error = err.Error()
+	}
+	writeJSON(ws, reply)
+}
+
+// generateVisualModel lays out the threat model as an mxGraph visual model
+func generateVisualModel(msg projects.Message, ws *websocket.Conn) {
+	reply := projects.Message{
+		Type:      "visual_model",
+		ProjectID: msg.ProjectID,
+		Workspace: msg.Workspace,
+	}
+	model, err := otm.Parse(strings.NewReader(msg.ThreatModel))
+	if err == nil {
+		var g *otm_transform.MxGraphModel
+		if g, err = otm_transform.OtmToMxGraph(model); err == nil {
+			reply.ThreatModel = msg.ThreatModel
+			reply.VisualModel = g.String()
 		}
-	} else {
-		ws.WriteJSON(projects.Message{
-			Type:      "graphviz",
-			ProjectID: msg.ProjectID,
-			Workspace: msg.Workspace,
-			HasError:  true,
-			Error:     err.Error(),
-		})
 	}
+	if err != nil {
+		reply.HasError = true
+		reply.Error = err.Error()
+	}
+	writeJSON(ws, reply)
 }
 
 func updateModel(msg projects.Message, ws *websocket.Conn) {
@@ -106,7 +134,32 @@ func updateModel(msg projects.Message, ws *websocket.Conn) {
 		m.HasError = true
 	}
 
-	ws.WriteJSON(m)
+	writeJSON(ws, m)
+
+	if err == nil {
+		broadcastModel(*m, ws)
+	}
+}
+
+// broadcastModel sends a saved model to every socket listening on its project, other than the sender's (if any).
+// Participants of a collaborative session on the project receive it through the session instead
+func broadcastModel(msg projects.Message, sender *websocket.Conn) {
+	refreshSession(msg)
+	msg.Type = "update_ui"
+	for _, ws := range GetListeningSocketsByProjectID(msg.ProjectID) {
+		if ws != sender && !inSession(msg.ProjectID, ws) {
+			if err := writeJSON(ws, msg); err != nil {
+				log.Printf("Error broadcasting model of project %s to %s: %v", msg.ProjectID, ws.RemoteAddr(), err)
+			}
+		}
+	}
+}
+
+func writeJSON(ws *websocket.Conn, v interface{}) error {
+	lock, _ := socketWriteLocks.LoadOrStore(ws, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+	return ws.WriteJSON(v)
 }
 
 func cleanClose(ws *websocket.Conn) {
@@ -116,14 +169,23 @@ func cleanClose(ws *websocket.Conn) {
 func socketCloseHandler(ws *websocket.Conn) func(code int, text string) error {
 	return func(c int, t string) error {
 		// log.Printf("Closing socket. Code: %d, Text: %s", c, t)
-		longSocLock.Lock()
-		defer longSocLock.Unlock()
-		for projID, socks := range longLivedSockets {
+		removeSocket(ws)
+		return nil
+	}
+}
+
+// removeSocket stops the socket from listening to any project
+func removeSocket(ws *websocket.Conn) {
+	leaveSessions(ws)
+	longSocLock.Lock()
+	defer longSocLock.Unlock()
+	for projID, socks := range longLivedSockets {
+		if socks[ws.RemoteAddr().String()] == ws {
 			delete(socks, ws.RemoteAddr().String())
 			longLivedSockets[projID] = socks
 		}
-		return nil
 	}
+	socketWriteLocks.Delete(ws)
 }
 
 type webSocketDiagnosticConsumer struct {
@@ -0,0 +1,195 @@
+package collab
+
+import (
+	"fmt"
+	"sort"
+
+	otm_transform "github.com/0-trust/service/pkg/otm"
+)
+
+const (
+	//operations on the visual (mxGraph) model
+	AddCell    = "add_cell"
+	MoveCell   = "move_cell"
+	UpdateCell = "update_cell"
+	DeleteCell = "delete_cell"
+	//operations on the threat (OTM) model
+	SetField      = "set_field"
+	AddElement    = "add_element"
+	DeleteElement = "delete_element"
+)
+
+// Operation is a fine-grained edit of a project's visual or threat model.
+// Operations on different cells, elements or fields commute. Concurrent operations on the same cell attribute or
+// element field are applied in the order the server receives them, so the last one wins
+type Operation struct {
+	Op string `json:"op"`
+	//visual model operations
+	Cell     *otm_transform.MxCell     `json:"cell,omitempty"`   //add_cell: the new cell
+	CellID   string                    `json:"cellID,omitempty"` //cell to move, update or delete
+	Parent   string                    `json:"parent,omitempty"` //move_cell: the new parent, if it changes
+	Geometry *otm_transform.MxGeometry `json:"geometry,omitempty"`
+	//update_cell: value, style, source or target of the cell, or its user-defined attributes. An empty value removes an attribute
+	Attributes map[string]string `json:"attributes,omitempty"`
+	//threat model operations
+	Section   string      `json:"section,omitempty"`   //e.g. components, dataflows or project
+	ElementID string      `json:"elementID,omitempty"` //empty when setting a field of the project section
+	Field     string      `json:"field,omitempty"`     //field to set, may be a path such as attributes.protocol
+	Value     interface{} `json:"value,omitempty"`     //set_field: the value, nil removes the field. add_element: the element
+	//assigned by the server when the operation is applied
+	Version int    `json:"version"`
+	Author  string `json:"author,omitempty"`
+}
+
+// Rejection is an operation that could not be applied, e.g. because a concurrent operation deleted its target
+type Rejection struct {
+	Operation Operation `json:"operation"`
+	Reason    string    `json:"reason"`
+}
+
+func applyToVisual(model *otm_transform.MxGraphModel, op Operation) error {
+	switch op.Op {
+	case AddCell:
+		if op.Cell == nil || op.Cell.ID == "" {
+			return fmt.Errorf("%s requires a cell with an ID", op.Op)
+		}
+		if model.Cell(op.Cell.ID) != nil {
+			return fmt.Errorf("cell %s already exists", op.Cell.ID)
+		}
+		for _, ref := range []string{op.Cell.Parent, op.Cell.Source, op.Cell.Target} {
+			if ref != "" && model.Cell(ref) == nil {
+				return fmt.Errorf("cell %s no longer exists", ref)
+			}
+		}
+		for _, attrs := range []map[string]string{op.Cell.Attributes, op.Cell.Extra} {
+			if err := checkAttributeNames(attrs); err != nil {
+				return err
+			}
+		}
+		model.Cells = append(model.Cells, op.Cell.Copy())
+	case MoveCell:
+		cell := model.Cell(op.CellID)
+		if cell == nil {
+			return fmt.Errorf("cell %s no longer exists", op.CellID)
+		}
+		if op.Parent != "" {
+			if model.Cell(op.Parent) == nil {
+				return fmt.Errorf("cell %s no longer exists", op.Parent)
+			}
+			if isDescendant(model, op.Parent, cell.ID) {
+				return fmt.Errorf("cannot move cell %s into its own descendant %s", cell.ID, op.Parent)
+			}
+			cell.Parent = op.Parent
+		}
+		if op.Geometry != nil {
+			geometry := *op.Geometry
+			cell.Geometry = &geometry
+		}
+	case UpdateCell:
+		cell := model.Cell(op.CellID)
+		if cell == nil {
+			return fmt.Errorf("cell %s no longer exists", op.CellID)
+		}
+		//check every reference and name before changing the cell, so that an operation is applied entirely or not at all
+		for _, k := range []string{"source", "target"} {
+			if v := op.Attributes[k]; v != "" && model.Cell(v) == nil {
+				return fmt.Errorf("cell %s no longer exists", v)
+			}
+		}
+		if err := checkAttributeNames(op.Attributes); err != nil {
+			return err
+		}
+		keys := []string{}
+		for k := range op.Attributes {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys) //in the same order everywhere, e.g. if both value and label are set
+		for _, k := range keys {
+			v := op.Attributes[k]
+			switch k {
+			case "value", "label":
+				cell.Value = v
+			case "style":
+				cell.Style = v
+			case "source":
+				cell.Source = v
+			case "target":
+				cell.Target = v
+			default:
+				if v == "" {
+					delete(cell.Attributes, k)
+				} else {
+					if cell.Attributes == nil {
+						cell.Attributes = make(map[string]string)
+					}
+					cell.Attributes[k] = v
+				}
+			}
+		}
+	case DeleteCell:
+		if model.Cell(op.CellID) == nil {
+			return fmt.Errorf("cell %s no longer exists", op.CellID)
+		}
+		//delete the cell, its descendants and any edges connected to them
+		deleted := map[string]bool{op.CellID: true}
+		for changed := true; changed; {
+			changed = false
+			for _, c := range model.Cells {
+				if !deleted[c.ID] && (deleted[c.Parent] || deleted[c.Source] || deleted[c.Target]) {
+					deleted[c.ID] = true
+					changed = true
+				}
+			}
+		}
+		cells := []*otm_transform.MxCell{}
+		for _, c := range model.Cells {
+			if !deleted[c.ID] {
+				cells = append(cells, c)
+			}
+		}
+		model.Cells = cells
+	default:
+		return fmt.Errorf("unknown operation %s", op.Op)
+	}
+	return nil
+}
+
+// checkAttributeNames checks that attributes can be written as XML attributes of a cell
+func checkAttributeNames(attrs map[string]string) error {
+	for k := range attrs {
+		if !otm_transform.ValidAttributeName(k) {
+			return fmt.Errorf("%q is not a valid attribute name", k)
+		}
+	}
+	return nil
+}
+
+// isDescendant checks whether the cell with id is, or is contained in, the cell with ancestorID
+func isDescendant(model *otm_transform.MxGraphModel, id, ancestorID string) bool {
+	seen := make(map[string]bool)
+	for c := model.Cell(id); c != nil && !seen[c.ID]; c = model.Cell(c.Parent) {
+		if c.ID == ancestorID {
+			return true
+		}
+		seen[c.ID] = true
+	}
+	return false
+}
+
+func applyToThreatModel(doc *otm_transform.Document, op Operation) error {
+	if op.Section == "" {
+		return fmt.Errorf("%s requires a section", op.Op)
+	}
+	switch op.Op {
+	case SetField:
+		if op.Field == "" {
+			return fmt.Errorf("%s requires a field", op.Op)
+		}
+		return doc.SetField(op.Section, op.ElementID, op.Field, op.Value)
+	case AddElement:
+		return doc.AddElement(op.Section, op.Value)
+	case DeleteElement:
+		return doc.DeleteElement(op.Section, op.ElementID)
+	}
+	return fmt.Errorf("unknown operation %s", op.Op)
+}
@@ -0,0 +1,185 @@
+package collab
+
+import (
+	"sort"
+	"sync"
+
+	otm_transform "github.com/0-trust/service/pkg/otm"
+)
+
+// Session is the shared, in-memory state of a project being edited collaboratively.
+// The server applies the participants' operations in the order it receives them and numbers them with increasing
+// versions, so every participant that applies the broadcast operations in version order converges on the same document
+type Session struct {
+	ProjectID    string
+	baseRevision string //revision the document was loaded from, or last saved as
+	version      int
+	savedVersion int
+	visual       *otm_transform.MxGraphModel
+	threat       *otm_transform.Document
+	participants map[string]*Presence
+	authors      map[string]struct{} //authors of the operations since the last save
+	lock         sync.Mutex
+}
+
+// Presence is a participant's cursor and selection, shared with the other participants
+type Presence struct {
+	ParticipantID string   `json:"participantID"` //assigned by the server
+	User          string   `json:"user"`
+	Cursor        *Point   `json:"cursor,omitempty"`    //pointer position in the diagram
+	Selection     []string `json:"selection,omitempty"` //IDs of the selected cells or OTM elements
+	Left          bool     `json:"left,omitempty"`      //set when the participant leaves the session
+}
+
+type Point struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+// NewSession starts a collaborative session on a project's model at the given revision
+func NewSession(projectID, revision, threatModel, visualModel string) (*Session, error) {
+	s := &Session{
+		ProjectID:    projectID,
+		participants: make(map[string]*Presence),
+		authors:      make(map[string]struct{}),
+	}
+	return s, s.load(revision, threatModel, visualModel)
+}
+
+func (s *Session) load(revision, threatModel, visualModel string) error {
+	visual, err := otm_transform.ParseMxGraph(visualModel)
+	if err != nil {
+		return err
+	}
+	threat, err := otm_transform.ParseDocument(threatModel)
+	if err != nil {
+		return err
+	}
+	s.visual, s.threat, s.baseRevision = visual, threat, revision
+	return nil
+}
+
+// Apply applies operations in order, returning those applied (numbered with their versions) and those rejected
+func (s *Session) Apply(ops []Operation, author string) (applied []Operation, rejected []Rejection) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	applied, rejected = []Operation{}, []Rejection{}
+	for _, op := range ops {
+		var err error
+		switch op.Op {
+		case AddCell, MoveCell, UpdateCell, DeleteCell:
+			err = applyToVisual(s.visual, op)
+		default:
+			err = applyToThreatModel(s.threat, op)
+		}
+		if err != nil {
+			rejected = append(rejected, Rejection{Operation: op, Reason: err.Error()})
+			continue
+		}
+		s.version++
+		op.Version = s.version
+		op.Author = author
+		applied = append(applied, op)
+	}
+	if len(applied) > 0 {
+		s.authors[author] = struct{}{}
+	}
+	return
+}
+
+// Snapshot returns the current document and its version
+func (s *Session) Snapshot() (threatModel, visualModel string, version int, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	threatModel, err = s.threat.String()
+	return threatModel, s.visual.String(), s.version, err
+}
+
+// Version returns the version of the last operation applied
+func (s *Session) Version() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.version
+}
+
+// BaseRevision returns the revision the document was loaded from, or last saved as
+func (s *Session) BaseRevision() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.baseRevision
+}
+
+// IsDirty checks whether there are operations that have not been saved
+func (s *Session) IsDirty() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.version > s.savedVersion
+}
+
+// Authors returns the authors of the operations since the last save
+func (s *Session) Authors() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	authors := []string{}
+	for a := range s.authors {
+		authors = append(authors, a)
+	}
+	sort.Strings(authors)
+	return authors
+}
+
+// Saved records that the document, up to version, has been saved as revision
+func (s *Session) Saved(revision string, version int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.baseRevision = revision
+	if version > s.savedVersion {
+		s.savedVersion = version
+	}
+	if s.savedVersion == s.version {
+		s.authors = make(map[string]struct{})
+	}
+}
+
+// Reset replaces the document with a saved revision of the model, e.g. after it was merged with changes made outside the session
+func (s *Session) Reset(revision, threatModel, visualModel string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := s.load(revision, threatModel, visualModel); err != nil {
+		return err
+	}
+	s.version++
+	s.savedVersion = s.version
+	s.authors = make(map[string]struct{})
+	return nil
+}
+
+// UpdatePresence records a participant's presence, adding the participant if they have just joined
+func (s *Session) UpdatePresence(p Presence) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.participants[p.ParticipantID] = &p
+}
+
+// Leave removes a participant, returning the number of participants remaining
+func (s *Session) Leave(participantID string) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.participants, participantID)
+	return len(s.participants)
+}
+
+// Participants returns the presence of every participant
+func (s *Session) Participants() []Presence {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	out := []Presence{}
+	for _, p := range s.participants {
+		out = append(out, *p)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].ParticipantID < out[j].ParticipantID
+	})
+	return out
+}
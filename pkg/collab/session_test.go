@@ -0,0 +1,219 @@
+package collab
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	otm_transform "github.com/0-trust/service/pkg/otm"
+)
+
+const sessionModel = `otmVersion: 0.1.0
+project:
+  name: shop
+  id: shop
+components:
+  - id: web
+    name: Web
+    type: web-server
+  - id: db
+    name: DB
+    type: database
+dataflows:
+  - id: web-db
+    name: query
+    source: web
+    destination: db
+`
+
+const sessionDiagram = `<root><mxCell id="0"/><mxCell id="1" parent="0"/>` +
+	`<object id="web" label="Web" otm="component"><mxCell vertex="1" parent="1"><mxGeometry x="10" y="10" width="120" height="60" as="geometry"/></mxCell></object>` +
+	`<object id="db" label="DB" otm="component"><mxCell vertex="1" parent="1"><mxGeometry x="200" y="10" width="120" height="60" as="geometry"/></mxCell></object>` +
+	`<mxCell id="web-db" value="query" edge="1" parent="1" source="web" target="db"/>` +
+	`</root>`
+
+func newTestSession(t *testing.T) *Session {
+	t.Helper()
+	s, err := NewSession("shop", "r1", sessionModel, sessionDiagram)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestApply(t *testing.T) {
+	s := newTestSession(t)
+	ops := []Operation{
+		{Op: AddCell, Cell: &otm_transform.MxCell{ID: "zone", Value: "DMZ", Vertex: true, Parent: "1",
+			Attributes: map[string]string{"otm": "trustZone"}, Geometry: &otm_transform.MxGeometry{Width: 400, Height: 200}}},
+		{Op: MoveCell, CellID: "web", Parent: "zone", Geometry: &otm_transform.MxGeometry{X: 20, Y: 20, Width: 120, Height: 60}},
+		{Op: UpdateCell, CellID: "db", Attributes: map[string]string{"label": "Orders", "owner": "shop"}},
+		{Op: UpdateCell, CellID: "web", Attributes: map[string]string{"otm": ""}},
+		{Op: SetField, Section: "dataflows", ElementID: "web-db", Field: "attributes.protocol", Value: "postgres"},
+		{Op: AddElement, Section: "components", Value: map[string]string{"id": "cache", "name": "Cache", "type": "cache"}},
+		{Op: DeleteCell, CellID: "db"},
+	}
+	applied, rejected := s.Apply(ops, "alice")
+	if len(rejected) > 0 {
+		t.Fatalf("rejected %+v", rejected)
+	}
+	for i, op := range applied {
+		if op.Version != i+1 || op.Author != "alice" {
+			t.Errorf("operation %d: got version %d by %q, want version %d by alice", i, op.Version, op.Author, i+1)
+		}
+	}
+	if s.Version() != len(ops) || !s.IsDirty() {
+		t.Errorf("got version %d, dirty %v, want version %d and dirty", s.Version(), s.IsDirty(), len(ops))
+	}
+
+	web := s.visual.Cell("web")
+	if web.Parent != "zone" || web.Geometry.X != 20 || len(web.Attributes) != 0 {
+		t.Errorf("web wasn't moved and updated: %+v", web)
+	}
+	//deleting db deletes the edge connected to it
+	if s.visual.Cell("db") != nil || s.visual.Cell("web-db") != nil {
+		t.Errorf("db and its edge weren't deleted: %s", s.visual.String())
+	}
+
+	tm, _, _, err := s.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"protocol: postgres", "id: cache"} {
+		if !strings.Contains(tm, want) {
+			t.Errorf("the threat model doesn't contain %s:\n%s", want, tm)
+		}
+	}
+
+	s.Saved("r2", s.Version())
+	if s.IsDirty() || s.BaseRevision() != "r2" || len(s.Authors()) != 0 {
+		t.Errorf("got dirty %v, base revision %s, authors %v after saving", s.IsDirty(), s.BaseRevision(), s.Authors())
+	}
+}
+
+func TestApplyRejects(t *testing.T) {
+	cases := []struct {
+		name string
+		op   Operation
+	}{
+		{"add a cell without an ID", Operation{Op: AddCell, Cell: &otm_transform.MxCell{Value: "A"}}},
+		{"add an existing cell", Operation{Op: AddCell, Cell: &otm_transform.MxCell{ID: "web"}}},
+		{"add an edge to a missing cell", Operation{Op: AddCell, Cell: &otm_transform.MxCell{ID: "e", Edge: true, Source: "web", Target: "cache"}}},
+		{"move a missing cell", Operation{Op: MoveCell, CellID: "cache", Parent: "1"}},
+		{"move a cell into itself", Operation{Op: MoveCell, CellID: "1", Parent: "web"}},
+		{"update a missing cell", Operation{Op: UpdateCell, CellID: "cache", Attributes: map[string]string{"label": "Cache"}}},
+		{"connect a missing cell", Operation{Op: UpdateCell, CellID: "web-db", Attributes: map[string]string{"label": "select", "source": "web", "target": "cache"}}},
+		{"set an attribute with an invalid name", Operation{Op: UpdateCell, CellID: "web", Attributes: map[string]string{"label": "Web app", "bad name": "x"}}},
+		{"add a cell with an invalid attribute name", Operation{Op: AddCell, Cell: &otm_transform.MxCell{ID: "cache", Attributes: map[string]string{"a\"b": "x"}}}},
+		{"delete a missing cell", Operation{Op: DeleteCell, CellID: "cache"}},
+		{"set a field of a missing element", Operation{Op: SetField, Section: "components", ElementID: "cache", Field: "name", Value: "Cache"}},
+		{"add an element without an ID", Operation{Op: AddElement, Section: "components", Value: map[string]string{"name": "Cache"}}},
+		{"delete a missing element", Operation{Op: DeleteElement, Section: "components", ElementID: "cache"}},
+		{"unknown operation", Operation{Op: "rename_cell", Section: "components", CellID: "web"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := newTestSession(t)
+			before, _, _, _ := s.Snapshot()
+			visualBefore := s.visual.String()
+
+			applied, rejected := s.Apply([]Operation{c.op}, "alice")
+			if len(applied) != 0 || len(rejected) != 1 || rejected[0].Reason == "" {
+				t.Fatalf("got applied %+v, rejected %+v, want a rejection with a reason", applied, rejected)
+			}
+			//a rejected operation changes nothing, not even the part of it that could be applied
+			after, visualAfter, version, _ := s.Snapshot()
+			if after != before || visualAfter != visualBefore || version != 0 || s.IsDirty() {
+				t.Errorf("the rejected operation changed the session to version %d:\n%s\n%s", version, after, visualAfter)
+			}
+		})
+	}
+}
+
+func TestAddCellCopiesTheCell(t *testing.T) {
+	s := newTestSession(t)
+	cell := &otm_transform.MxCell{ID: "cache", Vertex: true, Parent: "1",
+		Attributes: map[string]string{"otm": "component"}, Geometry: &otm_transform.MxGeometry{X: 10}}
+	if _, rejected := s.Apply([]Operation{{Op: AddCell, Cell: cell}}, "alice"); len(rejected) > 0 {
+		t.Fatalf("rejected %+v", rejected)
+	}
+	cell.Attributes["otm"] = "trustZone"
+	cell.Geometry.X = 500
+
+	added := s.visual.Cell("cache")
+	if added == cell || added.Attributes["otm"] != "component" || added.Geometry.X != 10 {
+		t.Errorf("the session shares the cell of the operation: %+v %+v", added, added.Geometry)
+	}
+}
+
+func TestConcurrentOperations(t *testing.T) {
+	t.Run("the last update of an attribute wins", func(t *testing.T) {
+		s := newTestSession(t)
+		s.Apply([]Operation{{Op: UpdateCell, CellID: "web", Attributes: map[string]string{"label": "Web app"}}}, "alice")
+		s.Apply([]Operation{{Op: UpdateCell, CellID: "web", Attributes: map[string]string{"label": "Website"}}}, "bob")
+		if got := s.visual.Cell("web").Value; got != "Website" {
+			t.Errorf("got label %s, want Website", got)
+		}
+		if got := s.Authors(); len(got) != 2 {
+			t.Errorf("got authors %v, want alice and bob", got)
+		}
+	})
+
+	t.Run("an update of a deleted cell is rejected", func(t *testing.T) {
+		s := newTestSession(t)
+		s.Apply([]Operation{{Op: DeleteCell, CellID: "db"}}, "alice")
+		_, rejected := s.Apply([]Operation{
+			{Op: UpdateCell, CellID: "db", Attributes: map[string]string{"label": "Orders"}},
+			{Op: UpdateCell, CellID: "web", Attributes: map[string]string{"label": "Web app"}},
+		}, "bob")
+		if len(rejected) != 1 || rejected[0].Operation.CellID != "db" {
+			t.Errorf("got rejected %+v, want the update of db", rejected)
+		}
+		if s.Version() != 2 {
+			t.Errorf("got version %d, want 2", s.Version())
+		}
+	})
+
+	t.Run("operations are numbered in the order they are applied", func(t *testing.T) {
+		s := newTestSession(t)
+		var wg sync.WaitGroup
+		versions := make(chan int, 20)
+		for i := 0; i < cap(versions); i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				applied, _ := s.Apply([]Operation{{Op: UpdateCell, CellID: "web", Attributes: map[string]string{"n": fmt.Sprint(i)}}}, "alice")
+				for _, op := range applied {
+					versions <- op.Version
+				}
+			}(i)
+		}
+		wg.Wait()
+		close(versions)
+
+		seen := make(map[int]bool)
+		for v := range versions {
+			if seen[v] || v < 1 || v > cap(versions) {
+				t.Errorf("version %d is out of range or given twice", v)
+			}
+			seen[v] = true
+		}
+		if len(seen) != cap(versions) || s.Version() != cap(versions) {
+			t.Errorf("got %d versions, session version %d, want %d", len(seen), s.Version(), cap(versions))
+		}
+	})
+}
+
+func TestReset(t *testing.T) {
+	s := newTestSession(t)
+	s.Apply([]Operation{{Op: UpdateCell, CellID: "web", Attributes: map[string]string{"label": "Web app"}}}, "alice")
+	if err := s.Reset("r2", sessionModel, sessionDiagram); err != nil {
+		t.Fatal(err)
+	}
+	//the version keeps increasing, so participants can tell the reset state from the state before it
+	if s.IsDirty() || s.BaseRevision() != "r2" || s.Version() != 2 || s.visual.Cell("web").Value != "Web" {
+		t.Errorf("got dirty %v, base revision %s, version %d, label %s after the reset",
+			s.IsDirty(), s.BaseRevision(), s.Version(), s.visual.Cell("web").Value)
+	}
+}
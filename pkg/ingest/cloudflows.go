@@ -0,0 +1,347 @@
+package ingest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	vpcFlowLogUnset = "-"
+	vpcFlowLogSkip  = "REJECT"
+	gcpDestReporter = "DEST"
+	nsgDenied       = "D"
+	nsgFlowBegins   = "B"
+)
+
+var (
+	//the fields of the default (version 2) format of AWS VPC Flow Logs, used if a log has no header
+	vpcFlowLogV2Fields = strings.Fields("version account-id interface-id srcaddr dstaddr srcport dstport protocol " +
+		"packets bytes start end action log-status")
+	vpcFlowLogRecord = regexp.MustCompile(`^\d+ \S+ eni-`)
+	nsgTransports    = map[string]string{"T": "tcp", "U": "udp"}
+)
+
+// ReadVPCFlowLogs reads AWS VPC Flow Logs of versions 2 to 5, in the default format or in a custom format named by a
+// header line of field names, as exported to S3. Accepted traffic is aggregated into flows between hosts, using the
+// packet addresses if the log has them. The host of the network interface that logged a record is put in the zone of
+// its subnet (or VPC), if the log has them: the destination of ingress and the source of egress traffic, or else the
+// end with a private address. Records of traffic that responds to a connection (see isResponse) count towards its bytes
+func ReadVPCFlowLogs(in io.Reader) (ZoneFlows, error) {
+	conns := newConnections()
+	fields := make(map[string]int)
+	for i, name := range vpcFlowLogV2Fields {
+		fields[name] = i
+	}
+	scanner := bufio.NewScanner(in)
+	for line := 1; scanner.Scan(); line++ {
+		values := strings.Fields(scanner.Text())
+		if len(values) == 0 {
+			continue
+		}
+		if header := vpcFlowLogHeader(values); header != nil {
+			fields = header
+			continue
+		}
+		field := func(name string) string {
+			if i, exists := fields[name]; exists && i < len(values) && values[i] != vpcFlowLogUnset {
+				return values[i]
+			}
+			return ""
+		}
+		if field("action") == vpcFlowLogSkip || field("log-status") != "" && field("log-status") != "OK" {
+			continue
+		}
+		source, target := field("pkt-srcaddr"), field("pkt-dstaddr")
+		if source == "" || target == "" {
+			source, target = field("srcaddr"), field("dstaddr")
+		}
+		if source == "" || target == "" {
+			return ZoneFlows{}, fmt.Errorf("line %d: a record needs a source and a destination address", line)
+		}
+
+		zone := field("subnet-id")
+		if zone == "" {
+			zone = field("vpc-id")
+		}
+		switch direction := field("flow-direction"); {
+		case direction == "ingress":
+			conns.zone(target, zone)
+		case direction == "egress":
+			conns.zone(source, zone)
+		case isPrivate(source) && !isPrivate(target):
+			conns.zone(source, zone)
+		case isPrivate(target) && !isPrivate(source):
+			conns.zone(target, zone)
+		}
+
+		transport := ipProtocolName(field("protocol"))
+		start, _ := strconv.ParseInt(field("start"), 10, 64)
+		end, _ := strconv.ParseInt(field("end"), 10, 64)
+		flowBytes, _ := strconv.ParseInt(field("bytes"), 10, 64)
+		first, last := time.Unix(start, 0).UTC(), time.Unix(end, 0).UTC()
+		if start == 0 {
+			first, last = time.Time{}, time.Time{}
+		}
+		sourcePort, targetPort := field("srcport"), field("dstport")
+		if isResponse(transport, sourcePort, targetPort) {
+			conns.add(target, source, transport, sourcePort, "", 0, flowBytes, first, last)
+		} else {
+			conns.add(source, target, transport, targetPort, "", 1, flowBytes, first, last)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return ZoneFlows{}, err
+	}
+	return conns.zoneFlows(), nil
+}
+
+// vpcFlowLogHeader maps the field names of a header line to their positions, or is nil if the line isn't a header.
+// The names may be written as in the format of a custom flow log, e.g. ${srcaddr}
+func vpcFlowLogHeader(values []string) map[string]int {
+	header := make(map[string]int)
+	for i, name := range values {
+		header[strings.Trim(name, "${}")] = i
+	}
+	_, hasSource := header["srcaddr"]
+	_, hasPacketSource := header["pkt-srcaddr"]
+	if !hasSource && !hasPacketSource {
+		return nil
+	}
+	return header
+}
+
+func isVPCFlowLog(head []byte) bool {
+	first, _, _ := bytes.Cut(bytes.TrimSpace(head), []byte("\n"))
+	return vpcFlowLogRecord.Match(first) ||
+		bytes.Contains(first, []byte("srcaddr")) && bytes.Contains(first, []byte("dstaddr")) && !bytes.Contains(first, []byte(","))
+}
+
+// gcpFlowEntry is a GCP VPC flow log record, as a Cloud Logging entry or its payload, e.g. exported to BigQuery
+type gcpFlowEntry struct {
+	JSONPayload *gcpFlow `json:"jsonPayload"`
+	gcpFlow
+}
+
+type gcpFlow struct {
+	Connection *struct {
+		SrcIP    string      `json:"src_ip"`
+		DestIP   string      `json:"dest_ip"`
+		SrcPort  json.Number `json:"src_port"`
+		DestPort json.Number `json:"dest_port"`
+		Protocol json.Number `json:"protocol"`
+	} `json:"connection"`
+	BytesSent    json.Number  `json:"bytes_sent"`
+	StartTime    string       `json:"start_time"`
+	EndTime      string       `json:"end_time"`
+	Reporter     string       `json:"reporter"` //SRC or DEST
+	SrcInstance  *gcpInstance `json:"src_instance"`
+	DestInstance *gcpInstance `json:"dest_instance"`
+	SrcVPC       *gcpVPC      `json:"src_vpc"`
+	DestVPC      *gcpVPC      `json:"dest_vpc"`
+}
+
+type gcpInstance struct {
+	VMName string `json:"vm_name"`
+}
+
+type gcpVPC struct {
+	VPCName        string `json:"vpc_name"`
+	SubnetworkName string `json:"subnetwork_name"`
+}
+
+// ReadGCPFlowLogs reads GCP VPC flow logs exported as JSON: a JSON array or a stream of Cloud Logging entries, or of
+// their payloads. Hosts are named by their VM instance if they are one, or else by address, and put in the zone of
+// their subnetwork (or VPC). Traffic that both ends report is counted from the report of the source, and records of
+// traffic that responds to a connection (see isResponse) count towards its bytes
+func ReadGCPFlowLogs(in io.Reader) (ZoneFlows, error) {
+	conns := newConnections()
+	dec := json.NewDecoder(in)
+	for {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return ZoneFlows{}, err
+		}
+		entries := []gcpFlowEntry{}
+		if bytes.HasPrefix(bytes.TrimSpace(raw), []byte("[")) {
+			if err := json.Unmarshal(raw, &entries); err != nil {
+				return ZoneFlows{}, err
+			}
+		} else {
+			var entry gcpFlowEntry
+			if err := json.Unmarshal(raw, &entry); err != nil {
+				return ZoneFlows{}, err
+			}
+			entries = append(entries, entry)
+		}
+
+		for _, entry := range entries {
+			flow := entry.gcpFlow
+			if entry.JSONPayload != nil {
+				flow = *entry.JSONPayload
+			}
+			c := flow.Connection
+			if c == nil || c.SrcIP == "" || c.DestIP == "" {
+				continue
+			}
+			count := float32(1)
+			flowBytes, _ := flow.BytesSent.Int64()
+			if flow.Reporter == gcpDestReporter && flow.SrcInstance != nil {
+				//the source instance reports the traffic too
+				count, flowBytes = 0, 0
+			}
+			source, target := gcpHost(c.SrcIP, flow.SrcInstance), gcpHost(c.DestIP, flow.DestInstance)
+			conns.zone(source, flow.SrcVPC.zone())
+			conns.zone(target, flow.DestVPC.zone())
+
+			first, _ := time.Parse(time.RFC3339Nano, flow.StartTime)
+			last, _ := time.Parse(time.RFC3339Nano, flow.EndTime)
+			transport := ipProtocolName(c.Protocol.String())
+			sourcePort, targetPort := c.SrcPort.String(), c.DestPort.String()
+			if isResponse(transport, sourcePort, targetPort) {
+				conns.add(target, source, transport, sourcePort, "", 0, flowBytes, first, last)
+			} else {
+				conns.add(source, target, transport, targetPort, "", count, flowBytes, first, last)
+			}
+		}
+	}
+	return conns.zoneFlows(), nil
+}
+
+func gcpHost(ip string, instance *gcpInstance) string {
+	if instance != nil && instance.VMName != "" {
+		return instance.VMName
+	}
+	return ip
+}
+
+func (vpc *gcpVPC) zone() string {
+	if vpc == nil {
+		return ""
+	}
+	if vpc.SubnetworkName != "" {
+		return vpc.SubnetworkName
+	}
+	return vpc.VPCName
+}
+
+func isGCPFlowLog(head []byte) bool {
+	head = bytes.TrimSpace(head)
+	return (bytes.HasPrefix(head, []byte("{")) || bytes.HasPrefix(head, []byte("["))) &&
+		bytes.Contains(head, []byte(`"connection"`)) && bytes.Contains(head, []byte(`"src_ip"`))
+}
+
+// nsgFlowLog is an Azure NSG flow log, as written to a storage account
+type nsgFlowLog struct {
+	Records []struct {
+		ResourceID string `json:"resourceId"`
+		Properties struct {
+			Version int `json:"Version"`
+			Flows   []struct {
+				Rule  string `json:"rule"`
+				Flows []struct {
+					FlowTuples []string `json:"flowTuples"`
+				} `json:"flows"`
+			} `json:"flows"`
+		} `json:"properties"`
+	} `json:"records"`
+}
+
+// ReadNSGFlowLogs reads Azure network security group flow logs of versions 1 and 2, in the JSON of the storage account
+// they are written to, or a stream of such files. Allowed flows are aggregated into flows between hosts, from the host
+// that initiated them, and the host on the side of the security group is put in its zone, named after the group. The
+// flows of version 2 logs are counted when they begin, with the bytes of their updates and ends
+func ReadNSGFlowLogs(in io.Reader) (ZoneFlows, error) {
+	conns := newConnections()
+	dec := json.NewDecoder(in)
+	for {
+		var log nsgFlowLog
+		if err := dec.Decode(&log); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return ZoneFlows{}, err
+		}
+		for _, record := range log.Records {
+			nsg := strings.ToLower(record.ResourceID[strings.LastIndex(record.ResourceID, "/")+1:])
+			for _, rule := range record.Properties.Flows {
+				for _, flows := range rule.Flows {
+					for _, tuple := range flows.FlowTuples {
+						if err := addNSGFlowTuple(conns, nsg, tuple); err != nil {
+							return ZoneFlows{}, fmt.Errorf("%s: %w", record.ResourceID, err)
+						}
+					}
+				}
+			}
+		}
+	}
+	return conns.zoneFlows(), nil
+}
+
+// addNSGFlowTuple adds the connection of a flow tuple: time,source,destination,source port,destination port,
+// protocol,direction,decision and, in version 2, state,packets and bytes from the source, packets and bytes to it
+func addNSGFlowTuple(conns *connections, nsg, tuple string) error {
+	values := strings.Split(tuple, ",")
+	if len(values) < 8 {
+		return fmt.Errorf("invalid flow tuple %q", tuple)
+	}
+	if values[7] == nsgDenied {
+		return nil
+	}
+	source, target := values[1], values[2]
+	if values[6] == "I" {
+		conns.zone(target, nsg)
+	} else {
+		conns.zone(source, nsg)
+	}
+	ts, err := strconv.ParseInt(values[0], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid time in flow tuple %q", tuple)
+	}
+	seen := time.Unix(ts, 0).UTC()
+	transport := nsgTransports[values[5]]
+	if transport == "" {
+		transport = strings.ToLower(values[5])
+	}
+
+	count, flowBytes := float32(1), int64(0)
+	if len(values) >= 13 {
+		if values[8] != nsgFlowBegins {
+			count = 0
+		}
+		sent, _ := strconv.ParseInt(values[10], 10, 64)
+		received, _ := strconv.ParseInt(values[12], 10, 64)
+		flowBytes = sent + received
+	}
+	conns.add(source, target, transport, values[4], "", count, flowBytes, seen, seen)
+	return nil
+}
+
+func isNSGFlowLog(head []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(head), []byte("{")) && bytes.Contains(head, []byte(`"flowTuples"`))
+}
+
+// ipProtocolName names an IANA protocol number, e.g. 6 is tcp, or returns it as it is
+func ipProtocolName(number string) string {
+	if n, err := strconv.Atoi(number); err == nil && n >= 0 && n < 256 {
+		if name, known := ipProtocols[byte(n)]; known {
+			return name
+		}
+	}
+	return number
+}
+
+// isPrivate checks whether an address is private, e.g. in 10.0.0.0/8, rather than public
+func isPrivate(address string) bool {
+	ip := net.ParseIP(address)
+	return ip != nil && (ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast())
+}
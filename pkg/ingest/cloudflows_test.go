@@ -0,0 +1,190 @@
+package ingest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// describeZoneFlows describes observed flows by their hosts and zones, label, port, weight and bytes
+func describeZoneFlows(zf ZoneFlows) []string {
+	flows := []string{}
+	for _, f := range zf.Flows() {
+		flows = append(flows, fmt.Sprintf("%s@%s>%s@%s %s %s w%v b%d", f.Source, f.SourceZone, f.Target, f.TargetZone,
+			f.Label, f.Port, f.Weight, f.Bytes))
+	}
+	return flows
+}
+
+func TestReadVPCFlowLogs(t *testing.T) {
+	cases := []struct {
+		name, log string
+		flows     []string
+	}{
+		{
+			//the reply counts towards the bytes of the connection, and rejected and empty records are skipped
+			name: "version 2",
+			log: `2 123456789012 eni-1 10.0.1.5 203.0.113.9 49152 443 6 10 5000 1700000000 1700000060 ACCEPT OK
+2 123456789012 eni-1 203.0.113.9 10.0.1.5 443 49152 6 8 3000 1700000000 1700000060 ACCEPT OK
+2 123456789012 eni-1 198.51.100.7 10.0.1.5 50000 22 6 1 60 1700000000 1700000060 REJECT OK
+2 123456789012 eni-1 - - - - - - - 1700000000 1700000060 - NODATA
+`,
+			flows: []string{"10.0.1.5@>203.0.113.9@ https 443 w1 b8000"},
+		},
+		{
+			//through a NAT gateway, whose interface logged the record, from the address of the private subnet
+			name: "version 3",
+			log: `version vpc-id subnet-id instance-id interface-id account-id type srcaddr dstaddr srcport dstport pkt-srcaddr pkt-dstaddr protocol bytes packets start end action tcp-flags log-status
+3 vpc-1 subnet-nat - eni-nat 123456789012 IPv4 10.0.0.220 203.0.113.9 49153 443 10.0.1.5 203.0.113.9 6 1000 5 1700000000 1700000060 ACCEPT 2 OK
+`,
+			flows: []string{"10.0.1.5@subnet-nat>203.0.113.9@ https 443 w1 b1000"},
+		},
+		{
+			//between private addresses, without a flow direction, neither end is put in the subnet
+			name: "version 4",
+			log: `version account-id interface-id srcaddr dstaddr srcport dstport protocol packets bytes start end action log-status vpc-id subnet-id instance-id region az-id sublocation-type sublocation-id
+4 123456789012 eni-2 10.0.3.4 10.0.0.2 53000 53 17 1 80 1700000000 1700000060 ACCEPT OK vpc-1 subnet-app i-2 eu-west-1 euw1-az1 - -
+`,
+			flows: []string{"10.0.3.4@>10.0.0.2@ dns 53/UDP w1 b80"},
+		},
+		{
+			name: "version 5",
+			log: `${version} ${srcaddr} ${dstaddr} ${srcport} ${dstport} ${protocol} ${bytes} ${start} ${end} ${action} ${log-status} ${vpc-id} ${subnet-id} ${flow-direction} ${pkt-src-aws-service}
+5 10.0.1.5 10.0.2.9 40000 5432 6 700 1700000000 1700000060 ACCEPT OK vpc-1 subnet-db ingress -
+5 10.0.1.5 10.0.2.9 40000 5432 6 300 1700000060 1700000120 ACCEPT OK vpc-1 - ingress -
+`,
+			flows: []string{"10.0.1.5@>10.0.2.9@subnet-db postgres 5432 w2 b1000"},
+		},
+		{
+			name: "custom format",
+			log: `dstaddr srcaddr protocol dstport srcport bytes
+10.0.0.9 10.0.1.5 6 8080 40000 300
+`,
+			flows: []string{"10.0.1.5@>10.0.0.9@ tcp/8080 8080 w1 b300"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			zf, err := ReadVPCFlowLogs(strings.NewReader(c.log))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := describeZoneFlows(zf); !reflect.DeepEqual(got, c.flows) {
+				t.Errorf("got flows %v, want %v", got, c.flows)
+			}
+		})
+	}
+
+	if _, err := ReadVPCFlowLogs(strings.NewReader("2 123456789012 eni-1 - - - - - - - 1700000000 1700000060 ACCEPT OK\n")); err == nil {
+		t.Error("got no error for an accepted record without addresses")
+	}
+}
+
+const gcpFlowLogs = `{"jsonPayload": {"connection": {"src_ip": "10.0.1.5", "src_port": 40000, "dest_ip": "10.0.2.9", "dest_port": 5432, "protocol": 6},
+  "bytes_sent": "1000", "reporter": "SRC", "start_time": "2023-11-14T22:13:20Z", "end_time": "2023-11-14T22:14:20Z",
+  "src_instance": {"vm_name": "web-1"}, "dest_instance": {"vm_name": "db-1"},
+  "src_vpc": {"vpc_name": "shop", "subnetwork_name": "frontend"}, "dest_vpc": {"vpc_name": "shop", "subnetwork_name": "backend"}}}
+{"jsonPayload": {"connection": {"src_ip": "10.0.1.5", "src_port": 40000, "dest_ip": "10.0.2.9", "dest_port": 5432, "protocol": 6},
+  "bytes_sent": "1000", "reporter": "DEST", "start_time": "2023-11-14T22:13:20Z", "end_time": "2023-11-14T22:14:20Z",
+  "src_instance": {"vm_name": "web-1"}, "dest_instance": {"vm_name": "db-1"},
+  "src_vpc": {"vpc_name": "shop", "subnetwork_name": "frontend"}, "dest_vpc": {"vpc_name": "shop", "subnetwork_name": "backend"}}}
+{"jsonPayload": {"connection": {"src_ip": "198.51.100.7", "src_port": 50000, "dest_ip": "10.0.1.5", "dest_port": 443, "protocol": 6},
+  "bytes_sent": "200", "reporter": "DEST", "dest_instance": {"vm_name": "web-1"}, "dest_vpc": {"vpc_name": "shop"}}}
+[{"connection": {"src_ip": "10.0.2.9", "src_port": 5432, "dest_ip": "10.0.1.5", "dest_port": 40000, "protocol": 6},
+  "bytes_sent": "4000", "reporter": "SRC", "src_instance": {"vm_name": "db-1"}, "dest_instance": {"vm_name": "web-1"},
+  "src_vpc": {"vpc_name": "shop", "subnetwork_name": "backend"}, "dest_vpc": {"vpc_name": "shop", "subnetwork_name": "frontend"}}]
+`
+
+func TestReadGCPFlowLogs(t *testing.T) {
+	zf, err := ReadGCPFlowLogs(strings.NewReader(gcpFlowLogs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	//the traffic between web-1 and db-1 that both report is counted once, with the bytes of the reply
+	want := []string{
+		"198.51.100.7@>web-1@frontend https 443 w1 b200",
+		"web-1@frontend>db-1@backend postgres 5432 w1 b5000",
+	}
+	if got := describeZoneFlows(zf); !reflect.DeepEqual(got, want) {
+		t.Errorf("got flows %v, want %v", got, want)
+	}
+}
+
+const nsgFlowLogs = `{"records": [{"time": "2023-11-14T22:13:20Z", "resourceId": "/SUBSCRIPTIONS/1/RESOURCEGROUPS/SHOP/PROVIDERS/MICROSOFT.NETWORK/NETWORKSECURITYGROUPS/WEB-NSG",
+  "properties": {"Version": 1, "flows": [{"rule": "UserRule_AllowDB", "flows": [{"mac": "000D3A000001", "flowTuples": [
+    "1700000000,10.0.1.5,10.0.2.9,40000,5432,T,O,A",
+    "1700000001,10.0.1.5,10.0.2.9,40001,5432,T,O,A",
+    "1700000002,10.0.1.5,10.0.2.10,40002,22,T,O,D"
+  ]}]}]}}]}
+{"records": [{"time": "2023-11-14T22:14:20Z", "resourceId": "/SUBSCRIPTIONS/1/RESOURCEGROUPS/SHOP/PROVIDERS/MICROSOFT.NETWORK/NETWORKSECURITYGROUPS/APP-NSG",
+  "properties": {"Version": 2, "flows": [{"rule": "UserRule_AllowHTTPS", "flows": [{"mac": "000D3A000002", "flowTuples": [
+    "1700000000,198.51.100.7,10.0.1.6,50000,443,T,I,A,B,,,,",
+    "1700000060,198.51.100.7,10.0.1.6,50000,443,T,I,A,C,10,1000,8,4000",
+    "1700000120,198.51.100.7,10.0.1.6,50000,443,T,I,A,E,2,100,1,50",
+    "1700000130,198.51.100.7,10.0.1.6,50001,53,U,I,A,B,1,60,1,90"
+  ]}]}]}}]}
+`
+
+func TestReadNSGFlowLogs(t *testing.T) {
+	zf, err := ReadNSGFlowLogs(strings.NewReader(nsgFlowLogs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	//version 1 tuples are connections, version 2 flows are counted when they begin, with the bytes of all their tuples
+	want := []string{
+		"198.51.100.7@>10.0.1.6@app-nsg https 443 w1 b5150",
+		"198.51.100.7@>10.0.1.6@app-nsg dns 53/UDP w1 b150",
+		"10.0.1.5@web-nsg>10.0.2.9@ postgres 5432 w2 b0",
+	}
+	if got := describeZoneFlows(zf); !reflect.DeepEqual(got, want) {
+		t.Errorf("got flows %v, want %v", got, want)
+	}
+
+	invalid := strings.Replace(nsgFlowLogs, "1700000000,10.0.1.5,10.0.2.9,40000,5432,T,O,A", "1700000000,10.0.1.5", 1)
+	if _, err := ReadNSGFlowLogs(strings.NewReader(invalid)); err == nil {
+		t.Error("got no error for an invalid flow tuple")
+	}
+}
+
+func TestReadFlows(t *testing.T) {
+	capture := pcapFile(binary.LittleEndian, pcapMagicMicroseconds, linkEther,
+		ethernet(false, etherTypeIPv4, ipv4("10.0.0.1", "10.0.0.2", ipProtocolTCP, tcp(50000, 443, tcpFlagSYN))))
+	cases := []struct {
+		name  string
+		in    []byte
+		first string //the first flow read
+	}{
+		{"pcap", capture, "10.0.0.1@>10.0.0.2@ https 443 w1 b40"},
+		{"Zeek", []byte("#separator \\x09\n#fields\tid.orig_h\tid.resp_h\tid.resp_p\tproto\n10.0.0.1\t10.0.0.2\t22\ttcp\n"),
+			"10.0.0.1@>10.0.0.2@ ssh 22 w1 b0"},
+		{"Zeek JSON", []byte(`{"id.orig_h":"10.0.0.1","id.resp_h":"10.0.0.2","id.resp_p":22,"proto":"tcp"}` + "\n"),
+			"10.0.0.1@>10.0.0.2@ ssh 22 w1 b0"},
+		{"NSG", []byte(nsgFlowLogs), "198.51.100.7@>10.0.1.6@app-nsg https 443 w1 b5150"},
+		{"GCP", []byte(gcpFlowLogs), "198.51.100.7@>web-1@frontend https 443 w1 b200"},
+		{"VPC", []byte("2 123456789012 eni-1 10.0.1.5 203.0.113.9 49152 443 6 10 5000 1700000000 1700000060 ACCEPT OK\n"),
+			"10.0.1.5@>203.0.113.9@ https 443 w1 b5000"},
+		{"VPC with a header", []byte("srcaddr dstaddr dstport protocol\n10.0.1.5 10.0.0.9 8080 6\n"),
+			"10.0.1.5@>10.0.0.9@ tcp/8080 8080 w1 b0"},
+		{"CSV", []byte("src_zone,dst_zone,protocol,src,dst,count\ndmz,internal,https,web01.example.com,db01,3\n"),
+			"web01@dmz>db01@internal https  w3 b0"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			zf, err := ReadFlows(bytes.NewReader(c.in), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := describeZoneFlows(zf); len(got) == 0 || got[0] != c.first {
+				t.Errorf("got flows %v, want %s first", got, c.first)
+			}
+		})
+	}
+
+	pcapNG := []byte{0x0a, 0x0d, 0x0d, 0x0a, 0x1c, 0, 0, 0}
+	if _, err := ReadFlows(bytes.NewReader(pcapNG), nil); err == nil || !strings.Contains(err.Error(), "pcapng") {
+		t.Errorf("got %v, want an error about pcapng captures", err)
+	}
+}
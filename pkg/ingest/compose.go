@@ -0,0 +1,301 @@
+package ingest
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	otm_transform "github.com/0-trust/service/pkg/otm"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	composeDefaultNetwork = "default"
+	hostNetworkName       = "host"
+)
+
+var (
+	localHostIPs = map[string]bool{"127.0.0.1": true, "::1": true, "localhost": true}
+)
+
+// ComposeFile is a Compose file (docker-compose.yml) describing the services of an application and their networks
+type ComposeFile struct {
+	services []composeService //in the order of the file
+	networks map[string]composeNetwork
+}
+
+type composeService struct {
+	name          string
+	Image         string      `yaml:"image"`
+	ContainerName string      `yaml:"container_name"`
+	Ports         []yaml.Node `yaml:"ports"`  //short "[host_ip:][published:]target[/protocol]" or long syntax
+	Expose        []string    `yaml:"expose"` //ports exposed to the other services only
+	DependsOn     yaml.Node   `yaml:"depends_on"`
+	Links         []string    `yaml:"links"`
+	Networks      yaml.Node   `yaml:"networks"`
+	NetworkMode   string      `yaml:"network_mode"`
+}
+
+type composeNetwork struct {
+	Internal bool        `yaml:"internal"`
+	External interface{} `yaml:"external"`
+	Driver   string      `yaml:"driver"`
+}
+
+// composePort is a port of a service, published on the host unless it is only exposed to the other services
+type composePort struct {
+	Target    string `yaml:"target"`
+	Published string `yaml:"published"`
+	HostIP    string `yaml:"host_ip"`
+	Protocol  string `yaml:"protocol"`
+	exposed   bool
+}
+
+// ReadComposeFile reads a Compose file
+func ReadComposeFile(in io.Reader) (*ComposeFile, error) {
+	var file struct {
+		Services yaml.Node                 `yaml:"services"`
+		Networks map[string]composeNetwork `yaml:"networks"`
+	}
+	if err := yaml.NewDecoder(in).Decode(&file); err != nil {
+		return nil, err
+	}
+	if file.Services.Kind != yaml.MappingNode {
+		return nil, errors.New("no services found in the Compose file")
+	}
+	cf := &ComposeFile{networks: file.Networks}
+	for i := 0; i+1 < len(file.Services.Content); i += 2 {
+		s := composeService{name: file.Services.Content[i].Value}
+		if err := file.Services.Content[i+1].Decode(&s); err != nil {
+			return nil, fmt.Errorf("service %s: %w", s.name, err)
+		}
+		cf.services = append(cf.services, s)
+	}
+	return cf, nil
+}
+
+// DraftModel infers a draft threat model from the Compose file: networks become trust zones and services become
+// components, in the zone of their first network. Data flows are inferred from depends_on and links, from each
+// service (other than datastores and queues) to the services on a shared network that listen on ports, and from the
+// internet to the services that publish ports on the host (other than on localhost), which are marked internet facing
+func (cf *ComposeFile) DraftModel(source string) *Model {
+	m := NewModel(source)
+	zones := make(map[string]string)
+	zone := func(network string) string {
+		if _, exists := zones[network]; !exists {
+			zones[network] = m.NewID(network)
+			tz := m.AddTrustZone(zones[network], network)
+			n := cf.networks[network]
+			if n.Internal {
+				tz.Attributes["internal"] = "true"
+			}
+			if n.External != nil && n.External != false {
+				tz.Attributes["external"] = "true"
+			}
+			if n.Driver != "" {
+				tz.Attributes["driver"] = n.Driver
+			}
+		}
+		return zones[network]
+	}
+
+	components := make(map[string]*Component)
+	networks := make(map[string][]string)
+	members := make(map[string][]string) //services by network
+	for _, s := range cf.services {
+		networks[s.name] = s.networkNames()
+	}
+	for _, s := range cf.services {
+		//services sharing the network of another service, or the host's
+		if target := strings.TrimPrefix(s.NetworkMode, "service:"); target != s.NetworkMode {
+			networks[s.name] = networks[target]
+		} else if s.NetworkMode == hostNetworkName {
+			networks[s.name] = []string{hostNetworkName}
+		}
+		for _, n := range networks[s.name] {
+			members[n] = append(members[n], s.name)
+		}
+	}
+
+	for _, s := range cf.services {
+		componentType := imageType(s.Image)
+		if componentType == "" {
+			componentType = "container"
+		}
+		name := s.name
+		if s.ContainerName != "" {
+			name = s.ContainerName
+		}
+		c := m.AddComponent(m.NewID(s.name), name, componentType, "")
+		if len(networks[s.name]) > 0 {
+			c.Parent = &Parent{TrustZone: zone(networks[s.name][0])}
+			c.Attributes["networks"] = strings.Join(networks[s.name], ",")
+		}
+		if s.Image != "" {
+			c.Attributes["image"] = s.Image
+		}
+		if s.NetworkMode != "" {
+			c.Attributes["networkMode"] = s.NetworkMode
+		}
+		if ports := s.listening(); len(ports) > 0 {
+			c.Attributes["ports"] = strings.Join(ports, ",")
+		}
+		components[s.name] = c
+	}
+
+	for _, s := range cf.services {
+		c := components[s.name]
+		published, exposed := []string{}, []string{}
+		for _, p := range s.ports() {
+			if p.exposed {
+				continue
+			}
+			published = append(published, p.String())
+			if !localHostIPs[strings.Trim(p.HostIP, "[]")] {
+				exposed = append(exposed, portSpec(p.Target, p.Protocol))
+			}
+		}
+		if len(published) > 0 {
+			c.Attributes["publishedPorts"] = strings.Join(published, ",")
+		}
+		if len(exposed) > 0 || s.NetworkMode == hostNetworkName {
+			c.Attributes["internetFacing"] = "true"
+			m.flow(m.external(""), c, "ports", exposed)
+		}
+
+		for _, dependency := range append(s.dependencies(), s.Links...) {
+			dependency, _, _ = strings.Cut(dependency, ":")
+			if d, exists := components[dependency]; exists && d != c {
+				m.flow(c, d, "depends_on", listeningPorts(cf, dependency))
+			}
+		}
+	}
+	modes := make(map[string]string)
+	for _, s := range cf.services {
+		modes[s.name] = s.NetworkMode
+	}
+	for _, network := range sortedKeys(members) {
+		for _, client := range members[network] {
+			//datastores and queues don't connect to other services
+			if kind := otm_transform.ComponentKind(components[client].Type); kind == "datastore" || kind == "queue" {
+				continue
+			}
+			for _, server := range members[network] {
+				ports := listeningPorts(cf, server)
+				if client != server && len(ports) > 0 && modes[client] != "service:"+server {
+					m.flow(components[client], components[server], "network/"+network, ports)
+				}
+			}
+		}
+	}
+	return m
+}
+
+func listeningPorts(cf *ComposeFile, service string) []string {
+	for _, s := range cf.services {
+		if s.name == service {
+			return s.listening()
+		}
+	}
+	return nil
+}
+
+// networkNames are the networks of a service, or the default network
+func (s composeService) networkNames() []string {
+	if s.NetworkMode != "" {
+		return nil
+	}
+	names := nodeNames(s.Networks)
+	if len(names) == 0 {
+		names = []string{composeDefaultNetwork}
+	}
+	return names
+}
+
+func (s composeService) dependencies() []string {
+	return nodeNames(s.DependsOn)
+}
+
+// nodeNames are the items of a list, or the keys of a map, such as the short and long syntax of depends_on
+func nodeNames(n yaml.Node) []string {
+	names := []string{}
+	switch n.Kind {
+	case yaml.SequenceNode:
+		for _, item := range n.Content {
+			names = append(names, item.Value)
+		}
+	case yaml.MappingNode:
+		for i := 0; i < len(n.Content); i += 2 {
+			names = append(names, n.Content[i].Value)
+		}
+	}
+	return names
+}
+
+// ports are the published and exposed ports of a service
+func (s composeService) ports() []composePort {
+	ports := []composePort{}
+	for _, n := range s.Ports {
+		var p composePort
+		if n.Kind == yaml.MappingNode {
+			if n.Decode(&p) != nil {
+				continue
+			}
+		} else {
+			p = parseComposePort(n.Value)
+		}
+		if p.Target != "" {
+			ports = append(ports, p)
+		}
+	}
+	for _, e := range s.Expose {
+		target, protocol, _ := strings.Cut(e, "/")
+		ports = append(ports, composePort{Target: target, Protocol: protocol, exposed: true})
+	}
+	return ports
+}
+
+// listening are the container ports of a service, formatted like the port attributes of data flows
+func (s composeService) listening() []string {
+	ports := []string{}
+	for _, p := range s.ports() {
+		if port := portSpec(p.Target, p.Protocol); !contains(ports, port) {
+			ports = append(ports, port)
+		}
+	}
+	return ports
+}
+
+// String formats a port in the short syntax, without the protocol
+func (p composePort) String() string {
+	port := p.Target
+	if p.Published != "" {
+		port = p.Published + ":" + port
+	}
+	if p.HostIP != "" {
+		port = p.HostIP + ":" + port
+	}
+	return port
+}
+
+// parseComposePort parses the short syntax of a port: [host_ip:][published:]target[/protocol], where the host IP may be
+// an IPv6 address in brackets
+func parseComposePort(spec string) composePort {
+	var p composePort
+	spec, p.Protocol, _ = strings.Cut(strings.TrimSpace(spec), "/")
+	if strings.HasPrefix(spec, "[") {
+		if end := strings.Index(spec, "]"); end > 0 {
+			p.HostIP, spec = spec[:end+1], strings.TrimPrefix(spec[end+1:], ":")
+		}
+	}
+	parts := strings.Split(spec, ":")
+	p.Target = parts[len(parts)-1]
+	if len(parts) > 1 {
+		p.Published = parts[len(parts)-2]
+	}
+	if len(parts) > 2 {
+		p.HostIP = strings.Join(parts[:len(parts)-2], ":")
+	}
+	return p
+}
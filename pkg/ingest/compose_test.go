@@ -0,0 +1,113 @@
+package ingest
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const shopCompose = `services:
+  proxy:
+    image: nginx:1.25
+    ports:
+      - "443:8443"
+      - "127.0.0.1:9000:9000"
+    networks: [front]
+    depends_on: [web]
+  web:
+    image: example/web
+    expose: ["8080"]
+    networks: [front, back]
+    depends_on:
+      db:
+        condition: service_healthy
+  db:
+    image: postgres:15
+    networks: [back]
+    ports:
+      - target: 5432
+        published: "5432"
+        host_ip: 127.0.0.1
+  sidecar:
+    image: envoyproxy/envoy
+    network_mode: service:web
+networks:
+  front: {}
+  back:
+    internal: true
+`
+
+func TestComposeDraftModel(t *testing.T) {
+	cf, err := ReadComposeFile(strings.NewReader(shopCompose))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := cf.DraftModel("docker-compose.yml")
+
+	zones, components, flows := draftElements(m)
+	if want := []string{"front", "back", "internet"}; !reflect.DeepEqual(zones, want) {
+		t.Errorf("got zones %v, want %v", zones, want)
+	}
+	wantComponents := []string{
+		"proxy:container@front",
+		"web:container@front",
+		"db:database@back",
+		"sidecar:container@front", //in the networks of web
+		"internet-clients:client@internet",
+	}
+	if !reflect.DeepEqual(components, wantComponents) {
+		t.Errorf("got components %v, want %v", components, wantComponents)
+	}
+	wantFlows := []string{
+		"internet-clients>proxy 8443", //not the port published on localhost
+		"proxy>web 8080",
+		"web>db 5432",
+		"sidecar>db 5432", //the database doesn't connect to the other services
+		"web>proxy 8443,9000",
+		"sidecar>proxy 8443,9000",
+	}
+	if !reflect.DeepEqual(flows, wantFlows) {
+		t.Errorf("got flows %v, want %v", flows, wantFlows)
+	}
+
+	if got := m.TrustZones[1].Attributes["internal"]; got != "true" {
+		t.Errorf("the back network should be internal: %v", m.TrustZones[1].Attributes)
+	}
+	for i, want := range map[int]map[string]string{
+		0: {"internetFacing": "true", "publishedPorts": "443:8443,127.0.0.1:9000:9000"},
+		2: {"internetFacing": "", "publishedPorts": "127.0.0.1:5432:5432"},
+	} {
+		for k, v := range want {
+			if got := m.Components[i].Attributes[k]; got != v {
+				t.Errorf("got %s attribute %s %q, want %q", m.Components[i].ID, k, got, v)
+			}
+		}
+	}
+}
+
+func TestReadComposeFileWithoutServices(t *testing.T) {
+	if _, err := ReadComposeFile(strings.NewReader("version: \"3\"\nnetworks: {}\n")); err == nil {
+		t.Error("got no error for a Compose file without services")
+	}
+}
+
+func TestParseComposePort(t *testing.T) {
+	cases := []struct {
+		spec string
+		want composePort
+	}{
+		{"80", composePort{Target: "80"}},
+		{"8080:80", composePort{Target: "80", Published: "8080"}},
+		{"9000-9001:9000-9001", composePort{Target: "9000-9001", Published: "9000-9001"}},
+		{" 53:53/udp ", composePort{Target: "53", Published: "53", Protocol: "udp"}},
+		{"127.0.0.1:8080:80/tcp", composePort{Target: "80", Published: "8080", HostIP: "127.0.0.1", Protocol: "tcp"}},
+		{"127.0.0.1::80", composePort{Target: "80", HostIP: "127.0.0.1"}},
+		{"[::1]:8080:80", composePort{Target: "80", Published: "8080", HostIP: "[::1]"}},
+		{"::1:8080:80", composePort{Target: "80", Published: "8080", HostIP: "::1"}},
+	}
+	for _, c := range cases {
+		if got := parseComposePort(c.spec); got != c.want {
+			t.Errorf("%q: got %+v, want %+v", c.spec, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,387 @@
+package ingest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	otm_transform "github.com/0-trust/service/pkg/otm"
+)
+
+const (
+	hostComponentType = "host"
+)
+
+var (
+	//column names of flow CSV files with a header, normalised by flowColumn, for each field of OutFlow
+	flowColumns = map[string][]string{
+		"sourceZone": {"sourcezone", "srczone", "fromzone", "zone"},
+		"targetZone": {"targetzone", "destinationzone", "dstzone", "destzone", "tozone"},
+		"label":      {"label", "protocol", "service", "port", "application"},
+		"source":     {"source", "sourcehost", "src", "srchost", "sourceip", "srcip", "from"},
+		"target":     {"target", "targethost", "destination", "destinationhost", "dst", "dsthost", "destinationip", "dstip", "to"},
+		"weight":     {"weight", "count", "connections", "flows", "bytes", "packets"},
+	}
+	//columns of flow CSV files without a header
+	defaultFlowColumns = map[string]int{"sourceZone": 0, "targetZone": 1, "label": 2, "source": 3, "target": 4, "weight": 5}
+	//application protocols of connections to well-known ports, formatted like the port attributes of data flows
+	portServices = map[string]string{
+		"21": "ftp", "22": "ssh", "23": "telnet", "25": "smtp", "53": "dns", "53/UDP": "dns", "80": "http",
+		"88": "kerberos", "123/UDP": "ntp", "161/UDP": "snmp", "389": "ldap", "443": "https", "445": "smb",
+		"465": "smtps", "514/UDP": "syslog", "636": "ldaps", "993": "imaps", "1433": "mssql", "1883": "mqtt",
+		"2049": "nfs", "3306": "mysql", "3389": "rdp", "5432": "postgres", "5671": "amqps", "5672": "amqp",
+		"6379": "redis", "8883": "mqtts", "9092": "kafka", "9200": "elasticsearch", "11211": "memcached", "27017": "mongodb",
+	}
+)
+
+// OutFlow is a flow observed from a source host in one zone to a target host in another (or the same) zone
+type OutFlow struct {
+	Source, Target, Label  string
+	SourceZone, TargetZone string
+	Weight                 float32
+	//of flows aggregated from connections, e.g. of Zeek logs or packet captures
+	Protocol, Port      string //e.g. https and 443, or udp and 514/UDP if the application protocol isn't known
+	Bytes               int64
+	FirstSeen, LastSeen time.Time
+}
+
+// ZoneFlows are observed flows, by the zone they come from
+type ZoneFlows struct {
+	ZoneToFlows map[string][]OutFlow
+}
+
+// ReadFlows reads observed flows from a flow CSV (see ReadFlowsCSV), a Zeek conn.log (see ReadZeekConnLog), a
+// libpcap capture (see ReadPcap), or AWS, GCP or Azure NSG flow logs (see ReadVPCFlowLogs, ReadGCPFlowLogs and
+// ReadNSGFlowLogs), depending on the content. The hosts that weren't observed in a zone are assigned the zones of
+// their networks, if zones are given
+func ReadFlows(in io.Reader, zones *ZoneMap) (ZoneFlows, error) {
+	buffered := bufio.NewReader(in)
+	head, _ := buffered.Peek(4096)
+	var zf ZoneFlows
+	var err error
+	switch {
+	case isPcap(head):
+		zf, err = ReadPcap(buffered)
+	case isPcapNG(head):
+		err = errors.New("pcapng captures are not supported, convert them to pcap, e.g. with editcap -F pcap")
+	case isZeekConnLog(head):
+		zf, err = ReadZeekConnLog(buffered)
+	case isNSGFlowLog(head):
+		zf, err = ReadNSGFlowLogs(buffered)
+	case isGCPFlowLog(head):
+		zf, err = ReadGCPFlowLogs(buffered)
+	case isVPCFlowLog(head):
+		zf, err = ReadVPCFlowLogs(buffered)
+	default:
+		zf, err = ReadFlowsCSV(buffered)
+	}
+	if err != nil {
+		return zf, err
+	}
+	return zones.assign(zf), nil
+}
+
+func isZeekConnLog(head []byte) bool {
+	head = bytes.TrimSpace(head)
+	return bytes.HasPrefix(head, []byte("#separator")) || bytes.HasPrefix(head, []byte("#fields")) ||
+		bytes.HasPrefix(head, []byte("{")) && bytes.Contains(head, []byte(`"id.orig_h"`))
+}
+
+// ReadFlowsCSV reads observed flows from CSV, e.g. exported from firewall or flow logs. The columns are the source zone,
+// target zone, label (e.g. protocol or port), source host, target host and weight (e.g. connection count), unless the
+// first row is a header naming them, e.g. src_zone,dst_zone,protocol,src,dst,count. Host names are shortened to
+// their first label, so that web01.example.com and web01 are the same host
+func ReadFlowsCSV(in io.Reader) (ZoneFlows, error) {
+	zf := ZoneFlows{
+		ZoneToFlows: make(map[string][]OutFlow),
+	}
+	reader := csv.NewReader(in)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	reader.Comment = '#'
+
+	columns := defaultFlowColumns
+	for line := 1; ; line++ {
+		rec, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return zf, err
+		}
+		if line == 1 {
+			if header, isHeader := flowHeader(rec); isHeader {
+				columns = header
+				continue
+			}
+		}
+
+		field := func(name string) string {
+			if i, exists := columns[name]; exists && i < len(rec) {
+				return strings.TrimSpace(rec[i])
+			}
+			return ""
+		}
+		flow := OutFlow{
+			Source:     BasicHostName(field("source")),
+			Target:     BasicHostName(field("target")),
+			SourceZone: field("sourceZone"),
+			TargetZone: field("targetZone"),
+			Label:      field("label"),
+			Weight:     1,
+		}
+		if flow.Source == "" || flow.Target == "" {
+			return zf, fmt.Errorf("line %d: a flow needs a source and a target host", line)
+		}
+		if w := field("weight"); w != "" {
+			weight, err := strconv.ParseFloat(w, 32)
+			if err != nil {
+				return zf, fmt.Errorf("line %d: the weight %q is not a number", line, w)
+			}
+			flow.Weight = float32(weight)
+		}
+		zf.ZoneToFlows[flow.SourceZone] = append(zf.ZoneToFlows[flow.SourceZone], flow)
+	}
+	return zf, nil
+}
+
+// flowHeader maps the columns of a header row to the fields of OutFlow, if the row is a header
+func flowHeader(rec []string) (map[string]int, bool) {
+	columns := make(map[string]int)
+	for i, name := range rec {
+		name = flowColumn(name)
+		for field, aliases := range flowColumns {
+			for _, alias := range aliases {
+				if _, mapped := columns[field]; !mapped && name == alias {
+					columns[field] = i
+				}
+			}
+		}
+	}
+	_, hasSource := columns["source"]
+	_, hasTarget := columns["target"]
+	return columns, hasSource && hasTarget
+}
+
+func flowColumn(name string) string {
+	return strings.NewReplacer("_", "", "-", "", " ", "", ".", "").Replace(strings.ToLower(strings.TrimSpace(name)))
+}
+
+// BasicHostName shortens a host name to its first label, e.g. web01.example.com to web01. IP addresses are kept whole
+func BasicHostName(in string) string {
+	if net.ParseIP(in) != nil {
+		return in
+	}
+	return strings.Split(in, ".")[0]
+}
+
+// DraftModel infers a draft threat model from the observed flows: zones become trust zones, hosts become components
+// in the zone they were seen in, and the flows between two hosts with the same label become a data flow, whose
+// weight and observations attributes are the total weight and number of the flows. Flows aggregated from connections
+// also have their protocols, ports, bytes and the times they were first and last seen
+func (zf ZoneFlows) DraftModel(source string) *Model {
+	m := NewModel(source)
+	zoneIDs := make(map[string]string)
+	hostIDs := make(map[string]string)
+	addZone := func(zone string) string {
+		if zone == "" {
+			return ""
+		}
+		if _, exists := zoneIDs[zone]; !exists {
+			zoneIDs[zone] = m.NewID(zone)
+			m.AddTrustZone(zoneIDs[zone], zone)
+		}
+		return zoneIDs[zone]
+	}
+	addHost := func(host, zone string) string {
+		if _, exists := hostIDs[host]; !exists {
+			hostIDs[host] = m.NewID(host)
+			m.AddComponent(hostIDs[host], host, hostComponentType, addZone(zone))
+		}
+		return hostIDs[host]
+	}
+
+	type aggregate struct {
+		source, target, label string
+		weight                float64
+		observations          int
+		protocol, ports       string
+		bytes                 int64
+		first, last           time.Time
+	}
+	aggregates := make(map[[3]string]*aggregate)
+	order := [][3]string{}
+	for _, of := range zf.Flows() {
+		source, target := addHost(of.Source, of.SourceZone), addHost(of.Target, of.TargetZone)
+		key := [3]string{source, target, of.Label}
+		a, exists := aggregates[key]
+		if !exists {
+			a = &aggregate{source: source, target: target, label: of.Label}
+			aggregates[key] = a
+			order = append(order, key)
+		}
+		a.weight += float64(of.Weight)
+		a.observations++
+		if of.Protocol != "" {
+			a.protocol = appendUnique(a.protocol, of.Protocol)
+		}
+		if of.Port != "" {
+			a.ports = appendUnique(a.ports, of.Port)
+		}
+		a.bytes += of.Bytes
+		if !of.FirstSeen.IsZero() && (a.first.IsZero() || of.FirstSeen.Before(a.first)) {
+			a.first = of.FirstSeen
+		}
+		if of.LastSeen.After(a.last) {
+			a.last = of.LastSeen
+		}
+	}
+
+	for _, key := range order {
+		a := aggregates[key]
+		name := a.label
+		if name == "" {
+			name = fmt.Sprintf("%s to %s", a.source, a.target)
+		}
+		df := m.AddDataFlow(m.NewID(strings.Join([]string{a.source, a.target, a.label}, "-")), name, a.source, a.target)
+		df.Attributes["weight"] = strconv.FormatFloat(a.weight, 'f', -1, 64)
+		df.Attributes["observations"] = strconv.Itoa(a.observations)
+		if a.protocol != "" {
+			df.Attributes["protocol"] = a.protocol
+		}
+		if a.ports != "" {
+			df.Attributes["ports"] = a.ports
+		}
+		if a.bytes > 0 {
+			df.Attributes["bytes"] = strconv.FormatInt(a.bytes, 10)
+		}
+		if !a.first.IsZero() {
+			df.Attributes["firstSeen"] = a.first.UTC().Format(time.RFC3339)
+			df.Attributes["lastSeen"] = a.last.UTC().Format(time.RFC3339)
+		}
+	}
+	return m
+}
+
+// Flows returns the observed flows, ordered by the zone they come from
+func (zf ZoneFlows) Flows() []OutFlow {
+	zones := []string{}
+	for zone := range zf.ZoneToFlows {
+		zones = append(zones, zone)
+	}
+	sort.Strings(zones)
+	flows := []OutFlow{}
+	for _, zone := range zones {
+		flows = append(flows, zf.ZoneToFlows[zone]...)
+	}
+	return flows
+}
+
+// GenerateDotGraph renders the draft model of the flows as a Graphviz DOT graph
+func (zf ZoneFlows) GenerateDotGraph() (string, error) {
+	doc, err := zf.DraftModel("").Document(otm_transform.ProjectInfo{Name: "Observed flows", ID: "observed-flows"})
+	if err != nil {
+		return "", err
+	}
+	model, err := doc.Model()
+	if err != nil {
+		return "", err
+	}
+	return otm_transform.OtmToGraphviz(model)
+}
+
+// connections aggregates the connections between hosts into flows, by the source, target, protocol and port
+type connections struct {
+	flows map[[4]string]*OutFlow
+	order [][4]string
+	zones map[string]string //of hosts, if known
+}
+
+func newConnections() *connections {
+	return &connections{flows: make(map[[4]string]*OutFlow), zones: make(map[string]string)}
+}
+
+// zone sets the zone of a host, unless it is already known
+func (cs *connections) zone(host, zone string) {
+	if _, known := cs.zones[host]; !known && zone != "" {
+		cs.zones[host] = zone
+	}
+}
+
+// add adds connections over a transport protocol (tcp, udp or icmp) to a port of the target, or the traffic of
+// connections already counted if count is 0. The application protocol (service) is named from the port if it isn't known
+func (cs *connections) add(source, target, transport, port, service string, count float32, bytes int64, first, last time.Time) {
+	transport = strings.ToLower(transport)
+	if transport == "icmp" || transport == "icmp6" || transport == "ipv6-icmp" {
+		port = ""
+	}
+	spec := ""
+	if port != "" {
+		spec = portSpec(port, transport)
+	}
+	if service == "" {
+		service = portServices[spec]
+	}
+	of := OutFlow{Source: source, Target: target, Protocol: service, Port: spec}
+	switch {
+	case service != "":
+		of.Label = service
+	case port != "":
+		of.Protocol, of.Label = transport, transport+"/"+port
+	default:
+		of.Protocol, of.Label = transport, transport
+	}
+
+	key := [4]string{source, target, of.Protocol, of.Port}
+	f, exists := cs.flows[key]
+	if !exists {
+		f = &of
+		f.FirstSeen, f.LastSeen = first, last
+		cs.flows[key] = f
+		cs.order = append(cs.order, key)
+	}
+	f.Weight += count
+	f.Bytes += bytes
+	if !first.IsZero() && (f.FirstSeen.IsZero() || first.Before(f.FirstSeen)) {
+		f.FirstSeen = first
+	}
+	if last.After(f.LastSeen) {
+		f.LastSeen = last
+	}
+}
+
+// isResponse guesses whether traffic from a source port to a target port is the response of a connection made the other
+// way: the source port is of a well-known service and the target port isn't, or else the source port is the lower
+func isResponse(transport, sourcePort, targetPort string) bool {
+	switch {
+	case portServices[portSpec(targetPort, transport)] != "":
+		return false
+	case portServices[portSpec(sourcePort, transport)] != "":
+		return true
+	}
+	source, err := strconv.Atoi(sourcePort)
+	if err != nil {
+		return false
+	}
+	target, err := strconv.Atoi(targetPort)
+	return err == nil && source < target
+}
+
+// zoneFlows are the flows of the connections, in the zones of their hosts if they are known
+func (cs *connections) zoneFlows() ZoneFlows {
+	zf := ZoneFlows{ZoneToFlows: make(map[string][]OutFlow)}
+	for _, key := range cs.order {
+		of := *cs.flows[key]
+		of.SourceZone, of.TargetZone = cs.zones[of.Source], cs.zones[of.Target]
+		zf.ZoneToFlows[of.SourceZone] = append(zf.ZoneToFlows[of.SourceZone], of)
+	}
+	return zf
+}
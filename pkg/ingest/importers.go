@@ -0,0 +1,60 @@
+package ingest
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Importer infers a draft model from a source, such as an uploaded file of the given name
+type Importer func(in io.Reader, source string) (*Model, error)
+
+var (
+	// Importers are the kinds of sources that draft models can be inferred from, by name
+	Importers = map[string]Importer{
+		"flows": func(in io.Reader, source string) (*Model, error) {
+			zf, err := ReadFlows(in, nil)
+			if err != nil {
+				return nil, err
+			}
+			return zf.DraftModel(source), nil
+		},
+		"kubernetes": func(in io.Reader, source string) (*Model, error) {
+			km := NewKubernetesManifests()
+			if err := km.Read(in); err != nil {
+				return nil, err
+			}
+			return km.DraftModel(source), nil
+		},
+		"terraform": func(in io.Reader, source string) (*Model, error) {
+			tf, err := ReadTerraformJSON(in)
+			if err != nil {
+				return nil, err
+			}
+			return tf.DraftModel(source), nil
+		},
+		"compose": func(in io.Reader, source string) (*Model, error) {
+			cf, err := ReadComposeFile(in)
+			if err != nil {
+				return nil, err
+			}
+			return cf.DraftModel(source), nil
+		},
+		"openapi": func(in io.Reader, source string) (*Model, error) {
+			specs := NewOpenAPISpecs()
+			if err := specs.Read(in, ""); err != nil {
+				return nil, err
+			}
+			return specs.DraftModel(source), nil
+		},
+	}
+)
+
+// Import infers a draft model from a source of one of the kinds of Importers
+func Import(kind string, in io.Reader, source string) (*Model, error) {
+	importer, supported := Importers[kind]
+	if !supported {
+		return nil, fmt.Errorf("unsupported source %s, use one of %s", kind, strings.Join(sortedKeys(Importers), ", "))
+	}
+	return importer(in, source)
+}
@@ -0,0 +1,737 @@
+package ingest
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	namespaceNameLabel = "kubernetes.io/metadata.name"
+	defaultNamespace   = "default"
+)
+
+var (
+	//kinds of Kubernetes objects that run pods
+	workloadKinds = map[string]bool{"Deployment": true, "StatefulSet": true, "DaemonSet": true, "ReplicaSet": true,
+		"ReplicationController": true, "Job": true, "CronJob": true, "Pod": true}
+	//component types of workloads by the images they run
+	imageTypes = []struct {
+		componentType string
+		images        []string
+	}{
+		{"database", []string{"postgres", "mysql", "mariadb", "mongo", "cassandra", "cockroach", "mssql", "couchdb", "neo4j", "elasticsearch", "opensearch", "minio"}},
+		{"cache", []string{"redis", "memcached", "valkey"}},
+		{"queue", []string{"kafka", "rabbitmq", "nats", "activemq", "pulsar", "zookeeper"}},
+	}
+	//service account annotations binding workloads to cloud identities
+	cloudIdentityAnnotations = []string{"eks.amazonaws.com/role-arn", "iam.gke.io/gcp-service-account", "azure.workload.identity/client-id"}
+	hostTokens               = regexp.MustCompile(`[a-z0-9]([a-z0-9.\-]*[a-z0-9])?`)
+)
+
+type k8sMeta struct {
+	Name        string            `yaml:"name"`
+	Namespace   string            `yaml:"namespace"`
+	Labels      map[string]string `yaml:"labels"`
+	Annotations map[string]string `yaml:"annotations"`
+}
+
+type k8sObject struct {
+	Kind     string      `yaml:"kind"`
+	Metadata k8sMeta     `yaml:"metadata"`
+	Items    []yaml.Node `yaml:"items"`
+	Spec     yaml.Node   `yaml:"spec"`
+	//service accounts
+	AutomountServiceAccountToken *bool `yaml:"automountServiceAccountToken"`
+}
+
+type k8sLabelSelector struct {
+	MatchLabels      map[string]string `yaml:"matchLabels"`
+	MatchExpressions []struct {
+		Key      string   `yaml:"key"`
+		Operator string   `yaml:"operator"`
+		Values   []string `yaml:"values"`
+	} `yaml:"matchExpressions"`
+}
+
+type k8sPodTemplate struct {
+	Metadata k8sMeta    `yaml:"metadata"`
+	Spec     k8sPodSpec `yaml:"spec"`
+}
+
+type k8sPodSpec struct {
+	ServiceAccountName string         `yaml:"serviceAccountName"`
+	Containers         []k8sContainer `yaml:"containers"`
+	InitContainers     []k8sContainer `yaml:"initContainers"`
+}
+
+type k8sContainer struct {
+	Name  string `yaml:"name"`
+	Image string `yaml:"image"`
+	Ports []struct {
+		Name          string `yaml:"name"`
+		ContainerPort int    `yaml:"containerPort"`
+		Protocol      string `yaml:"protocol"`
+	} `yaml:"ports"`
+	Env []struct {
+		Name  string `yaml:"name"`
+		Value string `yaml:"value"`
+	} `yaml:"env"`
+	Args    []string `yaml:"args"`
+	Command []string `yaml:"command"`
+}
+
+type k8sWorkloadSpec struct {
+	Template    k8sPodTemplate `yaml:"template"`
+	JobTemplate struct {
+		Spec struct {
+			Template k8sPodTemplate `yaml:"template"`
+		} `yaml:"spec"`
+	} `yaml:"jobTemplate"`
+}
+
+type k8sServiceSpec struct {
+	Type     string            `yaml:"type"`
+	Selector map[string]string `yaml:"selector"`
+	Ports    []struct {
+		Name       string `yaml:"name"`
+		Port       int    `yaml:"port"`
+		TargetPort string `yaml:"targetPort"` //number or name of a container port
+		Protocol   string `yaml:"protocol"`
+	} `yaml:"ports"`
+}
+
+type k8sIngressBackend struct {
+	Service struct {
+		Name string `yaml:"name"`
+		Port struct {
+			Number int    `yaml:"number"`
+			Name   string `yaml:"name"`
+		} `yaml:"port"`
+	} `yaml:"service"`
+	//networking.k8s.io/v1beta1
+	ServiceName string `yaml:"serviceName"`
+	ServicePort string `yaml:"servicePort"`
+}
+
+type k8sIngressSpec struct {
+	DefaultBackend *k8sIngressBackend `yaml:"defaultBackend"`
+	Backend        *k8sIngressBackend `yaml:"backend"`
+	TLS            []struct {
+		Hosts []string `yaml:"hosts"`
+	} `yaml:"tls"`
+	Rules []struct {
+		Host string `yaml:"host"`
+		HTTP struct {
+			Paths []struct {
+				Backend k8sIngressBackend `yaml:"backend"`
+			} `yaml:"paths"`
+		} `yaml:"http"`
+	} `yaml:"rules"`
+}
+
+type k8sPolicyPeer struct {
+	PodSelector       *k8sLabelSelector `yaml:"podSelector"`
+	NamespaceSelector *k8sLabelSelector `yaml:"namespaceSelector"`
+	IPBlock           *struct {
+		CIDR string `yaml:"cidr"`
+	} `yaml:"ipBlock"`
+}
+
+type k8sPolicyPort struct {
+	Protocol string `yaml:"protocol"`
+	Port     string `yaml:"port"`
+	EndPort  int    `yaml:"endPort"`
+}
+
+type k8sNetworkPolicySpec struct {
+	PodSelector k8sLabelSelector `yaml:"podSelector"`
+	Ingress     []struct {
+		From  []k8sPolicyPeer `yaml:"from"`
+		Ports []k8sPolicyPort `yaml:"ports"`
+	} `yaml:"ingress"`
+	Egress []struct {
+		To    []k8sPolicyPeer `yaml:"to"`
+		Ports []k8sPolicyPort `yaml:"ports"`
+	} `yaml:"egress"`
+}
+
+type k8sWorkload struct {
+	meta   k8sMeta
+	kind   string
+	labels map[string]string //pod labels
+	pod    k8sPodSpec
+}
+
+type k8sService struct {
+	meta k8sMeta
+	spec k8sServiceSpec
+}
+
+type k8sIngress struct {
+	meta k8sMeta
+	spec k8sIngressSpec
+}
+
+type k8sNetworkPolicy struct {
+	meta k8sMeta
+	spec k8sNetworkPolicySpec
+}
+
+type k8sServiceAccount struct {
+	meta      k8sMeta
+	automount *bool
+}
+
+// KubernetesManifests are the Kubernetes objects of a system, from which a draft threat model is inferred: Namespaces,
+// workloads (Deployments, StatefulSets, DaemonSets, Jobs, Pods etc.), Services, Ingresses, NetworkPolicies and
+// ServiceAccounts. Other kinds of objects are ignored
+type KubernetesManifests struct {
+	namespaces      map[string]map[string]string //namespace labels, by name
+	workloads       []k8sWorkload
+	services        []k8sService
+	ingresses       []k8sIngress
+	policies        []k8sNetworkPolicy
+	serviceAccounts map[[2]string]k8sServiceAccount
+	//Warnings are about the files skipped because they aren't Kubernetes manifests, e.g. Helm templates
+	Warnings []string
+}
+
+// NewKubernetesManifests creates an empty set of Kubernetes manifests
+func NewKubernetesManifests() *KubernetesManifests {
+	return &KubernetesManifests{
+		namespaces:      make(map[string]map[string]string),
+		serviceAccounts: make(map[[2]string]k8sServiceAccount),
+	}
+}
+
+// ReadKubernetesManifests reads the Kubernetes manifests of YAML or JSON files, or of the directories (recursively).
+// Files that aren't Kubernetes manifests, e.g. Helm templates or values, are skipped with a warning, unless none are
+func ReadKubernetesManifests(paths ...string) (*KubernetesManifests, error) {
+	km := NewKubernetesManifests()
+	files := 0
+	for _, path := range paths {
+		err := filepath.WalkDir(path, func(file string, d os.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				if file != path && strings.HasPrefix(d.Name(), ".") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			switch strings.ToLower(filepath.Ext(file)) {
+			case ".yaml", ".yml", ".json":
+			default:
+				if file != path {
+					return nil
+				}
+			}
+			in, err := os.Open(file)
+			if err != nil {
+				return err
+			}
+			defer in.Close()
+			files++
+			km.ReadFile(file, in)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if files > 0 && len(km.Warnings) == files {
+		return nil, fmt.Errorf("no Kubernetes manifests in %s:\n%s", strings.Join(paths, ", "), strings.Join(km.Warnings, "\n"))
+	}
+	return km, nil
+}
+
+// ReadFile reads the Kubernetes manifests of a file, or skips it with a warning if it isn't a stream of manifests
+func (km *KubernetesManifests) ReadFile(name string, in io.Reader) {
+	if err := km.Read(in); err != nil {
+		km.Warnings = append(km.Warnings, fmt.Sprintf("skipped %s: %v", name, err))
+	}
+}
+
+// Read reads the objects of a stream of Kubernetes manifests, separated by ---. If the stream isn't a stream of
+// manifests, none of its objects are read
+func (km *KubernetesManifests) Read(in io.Reader) (err error) {
+	saved := *km
+	saved.namespaces = make(map[string]map[string]string)
+	for ns, labels := range km.namespaces {
+		saved.namespaces[ns] = labels
+	}
+	saved.serviceAccounts = make(map[[2]string]k8sServiceAccount)
+	for key, sa := range km.serviceAccounts {
+		saved.serviceAccounts[key] = sa
+	}
+	defer func() {
+		if err != nil {
+			*km = saved
+		}
+	}()
+
+	dec := yaml.NewDecoder(in)
+	for {
+		var doc yaml.Node
+		err := dec.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := km.add(&doc); err != nil {
+			return err
+		}
+	}
+}
+
+func (km *KubernetesManifests) add(doc *yaml.Node) error {
+	var obj k8sObject
+	if err := doc.Decode(&obj); err != nil {
+		return err
+	}
+	if obj.Metadata.Namespace == "" && obj.Kind != "Namespace" {
+		obj.Metadata.Namespace = defaultNamespace
+	}
+	switch {
+	case strings.HasSuffix(obj.Kind, "List"):
+		for i := range obj.Items {
+			if err := km.add(&obj.Items[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	case obj.Kind == "Namespace":
+		labels := map[string]string{namespaceNameLabel: obj.Metadata.Name}
+		for k, v := range obj.Metadata.Labels {
+			labels[k] = v
+		}
+		km.namespaces[obj.Metadata.Name] = labels
+		return nil
+	case obj.Kind == "ServiceAccount":
+		km.serviceAccounts[[2]string{obj.Metadata.Namespace, obj.Metadata.Name}] = k8sServiceAccount{obj.Metadata, obj.AutomountServiceAccountToken}
+		return nil
+	case obj.Kind == "":
+		return nil
+	}
+
+	switch {
+	case obj.Kind == "Pod":
+		var spec k8sPodSpec
+		if err := obj.Spec.Decode(&spec); err != nil {
+			return fmt.Errorf("%s %s: %w", obj.Kind, obj.Metadata.Name, err)
+		}
+		km.workloads = append(km.workloads, k8sWorkload{meta: obj.Metadata, kind: obj.Kind, labels: obj.Metadata.Labels, pod: spec})
+	case workloadKinds[obj.Kind]:
+		var spec k8sWorkloadSpec
+		if err := obj.Spec.Decode(&spec); err != nil {
+			return fmt.Errorf("%s %s: %w", obj.Kind, obj.Metadata.Name, err)
+		}
+		template := spec.Template
+		if obj.Kind == "CronJob" {
+			template = spec.JobTemplate.Spec.Template
+		}
+		km.workloads = append(km.workloads, k8sWorkload{meta: obj.Metadata, kind: obj.Kind, labels: template.Metadata.Labels, pod: template.Spec})
+	case obj.Kind == "Service":
+		var spec k8sServiceSpec
+		if err := obj.Spec.Decode(&spec); err != nil {
+			return fmt.Errorf("%s %s: %w", obj.Kind, obj.Metadata.Name, err)
+		}
+		km.services = append(km.services, k8sService{obj.Metadata, spec})
+	case obj.Kind == "Ingress":
+		var spec k8sIngressSpec
+		if err := obj.Spec.Decode(&spec); err != nil {
+			return fmt.Errorf("%s %s: %w", obj.Kind, obj.Metadata.Name, err)
+		}
+		km.ingresses = append(km.ingresses, k8sIngress{obj.Metadata, spec})
+	case obj.Kind == "NetworkPolicy":
+		var spec k8sNetworkPolicySpec
+		if err := obj.Spec.Decode(&spec); err != nil {
+			return fmt.Errorf("%s %s: %w", obj.Kind, obj.Metadata.Name, err)
+		}
+		km.policies = append(km.policies, k8sNetworkPolicy{obj.Metadata, spec})
+	default:
+		return nil
+	}
+	km.addNamespace(obj.Metadata.Namespace)
+	return nil
+}
+
+func (km *KubernetesManifests) addNamespace(ns string) {
+	if _, exists := km.namespaces[ns]; !exists {
+		km.namespaces[ns] = map[string]string{namespaceNameLabel: ns}
+	}
+}
+
+// k8sDraft is a draft model being inferred from Kubernetes manifests
+type k8sDraft struct {
+	*Model
+	km         *KubernetesManifests
+	zones      map[string]string //trust zone IDs of namespaces
+	components []*Component      //components of the workloads, in the order of km.workloads
+}
+
+// DraftModel infers a draft threat model from the manifests: namespaces become trust zones (with a namespace attribute),
+// workloads become components in the trust zone of their namespace (with their pod labels, container ports and
+// service account as attributes), and Ingresses become components fronting the workloads they route to. Data flows are
+// inferred from the internet to Ingresses and LoadBalancer or NodePort Services, from workloads to the Services that
+// their container environment or arguments name, and from the rules of NetworkPolicies
+func (km *KubernetesManifests) DraftModel(source string) *Model {
+	d := &k8sDraft{
+		Model: NewModel(source),
+		km:    km,
+		zones: make(map[string]string),
+	}
+	for _, ns := range sortedKeys(km.namespaces) {
+		d.zones[ns] = d.NewID(ns)
+		tz := d.AddTrustZone(d.zones[ns], ns)
+		tz.Attributes["namespace"] = ns
+	}
+	for _, w := range km.workloads {
+		d.components = append(d.components, d.addWorkload(w))
+	}
+
+	for _, svc := range km.services {
+		if svc.spec.Type == "LoadBalancer" || svc.spec.Type == "NodePort" {
+			for _, i := range km.selected(svc.meta.Namespace, svc.spec.Selector) {
+				d.flow(d.external(""), d.components[i], "service/"+svc.meta.Name, km.targetPorts(svc, i, ""))
+			}
+		}
+	}
+	for _, ing := range km.ingresses {
+		d.addIngress(ing)
+	}
+	for i, w := range km.workloads {
+		for _, svc := range km.referencedServices(w) {
+			for _, j := range km.selected(svc.meta.Namespace, svc.spec.Selector) {
+				if j != i {
+					d.flow(d.components[i], d.components[j], "service/"+svc.meta.Name, km.targetPorts(svc, j, ""))
+				}
+			}
+		}
+	}
+	for _, np := range km.policies {
+		d.addNetworkPolicy(np)
+	}
+	return d.Model
+}
+
+func (d *k8sDraft) addWorkload(w k8sWorkload) *Component {
+	c := d.AddComponent(d.NewID(w.meta.Name), w.meta.Name, workloadType(w), d.zones[w.meta.Namespace])
+	c.Attributes["kind"] = w.kind
+	if len(w.labels) > 0 {
+		c.Attributes["labels"] = joinLabels(w.labels)
+	}
+	images, ports := []string{}, []string{}
+	for _, ct := range append(w.pod.InitContainers, w.pod.Containers...) {
+		images = append(images, ct.Image)
+		for _, p := range ct.Ports {
+			ports = append(ports, portSpec(strconv.Itoa(p.ContainerPort), p.Protocol))
+		}
+	}
+	if len(images) > 0 {
+		c.Attributes["images"] = strings.Join(images, ",")
+	}
+	if len(ports) > 0 {
+		c.Attributes["ports"] = strings.Join(ports, ",")
+	}
+
+	account := w.pod.ServiceAccountName
+	if account == "" {
+		account = "default"
+	}
+	c.Attributes["serviceAccount"] = account
+	if sa, exists := d.km.serviceAccounts[[2]string{w.meta.Namespace, account}]; exists {
+		for _, a := range cloudIdentityAnnotations {
+			if identity := sa.meta.Annotations[a]; identity != "" {
+				c.Attributes["cloudIdentity"] = identity
+			}
+		}
+		if sa.automount != nil {
+			c.Attributes["automountServiceAccountToken"] = strconv.FormatBool(*sa.automount)
+		}
+	}
+	return c
+}
+
+func (d *k8sDraft) addIngress(ing k8sIngress) {
+	c := d.AddComponent(d.NewID(ing.meta.Name), ing.meta.Name, "ingress", d.zones[ing.meta.Namespace])
+	c.Attributes["kind"] = "Ingress"
+	hosts := []string{}
+	for _, r := range ing.spec.Rules {
+		if r.Host != "" {
+			hosts = append(hosts, r.Host)
+		}
+	}
+	if len(hosts) > 0 {
+		c.Attributes["hosts"] = strings.Join(hosts, ",")
+	}
+
+	internet := d.flow(d.external(""), c, "ingress/"+ing.meta.Name, []string{"80"})
+	if len(ing.spec.TLS) > 0 {
+		internet.Attributes["protocol"] = "https"
+		internet.Attributes["ports"] = "443,80"
+	} else {
+		internet.Attributes["protocol"] = "http"
+	}
+
+	backends := []*k8sIngressBackend{ing.spec.DefaultBackend, ing.spec.Backend}
+	for i := range ing.spec.Rules {
+		for j := range ing.spec.Rules[i].HTTP.Paths {
+			backends = append(backends, &ing.spec.Rules[i].HTTP.Paths[j].Backend)
+		}
+	}
+	for _, b := range backends {
+		if b == nil {
+			continue
+		}
+		name, port := b.Service.Name, b.ServicePort
+		if name == "" {
+			name = b.ServiceName
+		}
+		if b.Service.Port.Number != 0 {
+			port = strconv.Itoa(b.Service.Port.Number)
+		} else if b.Service.Port.Name != "" {
+			port = b.Service.Port.Name
+		}
+		for _, svc := range d.km.services {
+			if svc.meta.Name == name && svc.meta.Namespace == ing.meta.Namespace {
+				for _, i := range d.km.selected(svc.meta.Namespace, svc.spec.Selector) {
+					d.flow(c, d.components[i], "ingress/"+ing.meta.Name, d.km.targetPorts(svc, i, port))
+				}
+			}
+		}
+	}
+}
+
+func (d *k8sDraft) addNetworkPolicy(np k8sNetworkPolicy) {
+	via := "networkpolicy/" + np.meta.Name
+	selected := d.km.matching(np.meta.Namespace, &np.spec.PodSelector, nil)
+	for _, rule := range np.spec.Ingress {
+		ports := policyPorts(rule.Ports)
+		for _, peer := range rule.From {
+			for _, source := range d.peerComponents(np.meta.Namespace, peer) {
+				for _, i := range selected {
+					if source != d.components[i] {
+						d.flow(source, d.components[i], via, ports)
+					}
+				}
+			}
+		}
+	}
+	for _, rule := range np.spec.Egress {
+		ports := policyPorts(rule.Ports)
+		for _, peer := range rule.To {
+			for _, destination := range d.peerComponents(np.meta.Namespace, peer) {
+				for _, i := range selected {
+					if destination != d.components[i] {
+						d.flow(d.components[i], destination, via, ports)
+					}
+				}
+			}
+		}
+	}
+}
+
+// peerComponents are the components that a peer of a NetworkPolicy rule selects
+func (d *k8sDraft) peerComponents(namespace string, peer k8sPolicyPeer) []*Component {
+	if peer.IPBlock != nil {
+		return []*Component{d.external(peer.IPBlock.CIDR)}
+	}
+	components := []*Component{}
+	for _, i := range d.km.matching(namespace, peer.PodSelector, peer.NamespaceSelector) {
+		components = append(components, d.components[i])
+	}
+	return components
+}
+
+// selected returns the indices of the workloads of a namespace that a Service selector selects
+func (km *KubernetesManifests) selected(namespace string, selector map[string]string) []int {
+	if len(selector) == 0 {
+		return nil //a Service without a selector has manually managed endpoints
+	}
+	return km.matching(namespace, &k8sLabelSelector{MatchLabels: selector}, nil)
+}
+
+// matching returns the indices of the workloads that a pod and namespace selector select, as in NetworkPolicy peers:
+// with no namespace selector, only the workloads of the given namespace are selected, and a nil pod selector
+// selects all the pods of the selected namespaces
+func (km *KubernetesManifests) matching(namespace string, pods, namespaces *k8sLabelSelector) []int {
+	matches := []int{}
+	for i, w := range km.workloads {
+		if namespaces == nil {
+			if w.meta.Namespace != namespace {
+				continue
+			}
+		} else if !namespaces.matches(km.namespaces[w.meta.Namespace]) {
+			continue
+		}
+		if pods == nil || pods.matches(w.labels) {
+			matches = append(matches, i)
+		}
+	}
+	return matches
+}
+
+// referencedServices are the services that a workload names in the environment, command or arguments of its
+// containers, e.g. DATABASE_URL=postgres://db:5432/app or --upstream=orders.shop.svc.cluster.local
+func (km *KubernetesManifests) referencedServices(w k8sWorkload) []k8sService {
+	hosts := make(map[string]bool)
+	for _, ct := range append(w.pod.InitContainers, w.pod.Containers...) {
+		values := append(append([]string{}, ct.Args...), ct.Command...)
+		for _, e := range ct.Env {
+			values = append(values, e.Value)
+		}
+		for _, v := range values {
+			for _, token := range hostTokens.FindAllString(strings.ToLower(v), -1) {
+				hosts[token] = true
+			}
+		}
+	}
+
+	services := []k8sService{}
+	for _, svc := range km.services {
+		ns := svc.meta.Namespace
+		if (ns == w.meta.Namespace && hosts[svc.meta.Name]) || hosts[svc.meta.Name+"."+ns] ||
+			hosts[svc.meta.Name+"."+ns+".svc"] || hosts[svc.meta.Name+"."+ns+".svc.cluster.local"] {
+			services = append(services, svc)
+		}
+	}
+	return services
+}
+
+// targetPorts are the container ports of a workload that a Service forwards to, for all the ports of the
+// Service or the given one (by number or name)
+func (km *KubernetesManifests) targetPorts(svc k8sService, workload int, port string) []string {
+	ports := []string{}
+	for _, p := range svc.spec.Ports {
+		if port != "" && port != strconv.Itoa(p.Port) && port != p.Name {
+			continue
+		}
+		target := p.TargetPort
+		if target == "" {
+			target = strconv.Itoa(p.Port)
+		}
+		if _, err := strconv.Atoi(target); err != nil {
+			//a named port of the pod's containers
+			for _, ct := range km.workloads[workload].pod.Containers {
+				for _, cp := range ct.Ports {
+					if cp.Name == target {
+						target = strconv.Itoa(cp.ContainerPort)
+					}
+				}
+			}
+		}
+		ports = append(ports, portSpec(target, p.Protocol))
+	}
+	return ports
+}
+
+func (s *k8sLabelSelector) matches(labels map[string]string) bool {
+	for k, v := range s.MatchLabels {
+		if value, exists := labels[k]; !exists || value != v {
+			return false
+		}
+	}
+	for _, e := range s.MatchExpressions {
+		value, exists := labels[e.Key]
+		in := false
+		for _, v := range e.Values {
+			in = in || (exists && v == value)
+		}
+		switch e.Operator {
+		case "In":
+			if !in {
+				return false
+			}
+		case "NotIn":
+			if in {
+				return false
+			}
+		case "Exists":
+			if !exists {
+				return false
+			}
+		case "DoesNotExist":
+			if exists {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func workloadType(w k8sWorkload) string {
+	for _, ct := range w.pod.Containers {
+		if t := imageType(ct.Image); t != "" {
+			return t
+		}
+	}
+	return strings.ToLower(w.kind)
+}
+
+// imageType is the component type of a container image, if its name is that of a well-known datastore or queue
+func imageType(image string) string {
+	image = strings.ToLower(image)
+	if i := strings.LastIndex(image, "/"); i >= 0 {
+		image = image[i+1:]
+	}
+	for _, t := range imageTypes {
+		for _, name := range t.images {
+			if strings.HasPrefix(image, name) {
+				return t.componentType
+			}
+		}
+	}
+	return ""
+}
+
+func policyPorts(ports []k8sPolicyPort) []string {
+	specs := []string{}
+	for _, p := range ports {
+		port := p.Port
+		if p.EndPort != 0 {
+			port = fmt.Sprintf("%s-%d", port, p.EndPort)
+		}
+		if port != "" {
+			specs = append(specs, portSpec(port, p.Protocol))
+		}
+	}
+	return specs
+}
+
+// portSpec formats a port like the port attributes of data flows: 443, or 53/UDP if the protocol isn't TCP
+func portSpec(port, protocol string) string {
+	if protocol == "" || strings.EqualFold(protocol, "TCP") {
+		return port
+	}
+	return port + "/" + strings.ToUpper(protocol)
+}
+
+func joinLabels(labels map[string]string) string {
+	pairs := []string{}
+	for _, k := range sortedKeys(labels) {
+		pairs = append(pairs, k+"="+labels[k])
+	}
+	return strings.Join(pairs, ",")
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,209 @@
+package ingest
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const shopManifests = `apiVersion: v1
+kind: Namespace
+metadata:
+  name: shop
+  labels:
+    team: shop
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: shop
+spec:
+  template:
+    metadata:
+      labels:
+        app: web
+    spec:
+      serviceAccountName: web
+      containers:
+        - name: web
+          image: example/web:1.0
+          ports:
+            - name: http
+              containerPort: 8080
+          env:
+            - name: DATABASE_URL
+              value: postgres://db:5432/shop
+---
+apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: db
+  namespace: shop
+spec:
+  template:
+    metadata:
+      labels:
+        app: db
+    spec:
+      containers:
+        - name: postgres
+          image: docker.io/library/postgres:15
+          ports:
+            - containerPort: 5432
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+  namespace: shop
+spec:
+  selector:
+    app: web
+  ports:
+    - port: 80
+      targetPort: http
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: db
+  namespace: shop
+spec:
+  selector:
+    app: db
+  ports:
+    - port: 5432
+---
+apiVersion: networking.k8s.io/v1
+kind: Ingress
+metadata:
+  name: shop
+  namespace: shop
+spec:
+  tls:
+    - hosts: [shop.example.com]
+  rules:
+    - host: shop.example.com
+      http:
+        paths:
+          - path: /
+            backend:
+              service:
+                name: web
+                port:
+                  number: 80
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: scraper
+  namespace: monitoring
+spec:
+  template:
+    metadata:
+      labels:
+        app: scraper
+    spec:
+      containers:
+        - name: scraper
+          image: prom/prometheus
+---
+apiVersion: networking.k8s.io/v1
+kind: NetworkPolicy
+metadata:
+  name: db
+  namespace: shop
+spec:
+  podSelector:
+    matchLabels:
+      app: db
+  ingress:
+    - from:
+        - namespaceSelector:
+            matchLabels:
+              kubernetes.io/metadata.name: monitoring
+      ports:
+        - port: "9187"
+  egress:
+    - to:
+        - ipBlock:
+            cidr: 10.20.0.0/16
+`
+
+// draftElements describes the elements of a draft model: the IDs of its trust zones, the IDs, types and zones of
+// its components, and the sources, destinations and ports of its data flows
+func draftElements(m *Model) (zones, components, flows []string) {
+	zones, components, flows = []string{}, []string{}, []string{}
+	for _, tz := range m.TrustZones {
+		zones = append(zones, tz.ID)
+	}
+	for _, c := range m.Components {
+		zone := ""
+		if c.Parent != nil {
+			zone = c.Parent.TrustZone
+		}
+		components = append(components, c.ID+":"+c.Type+"@"+zone)
+	}
+	for _, df := range m.DataFlows {
+		flows = append(flows, df.Source+">"+df.Destination+" "+df.Attributes["ports"])
+	}
+	return
+}
+
+func TestKubernetesDraftModel(t *testing.T) {
+	km := NewKubernetesManifests()
+	if err := km.Read(strings.NewReader(shopManifests)); err != nil {
+		t.Fatal(err)
+	}
+	m := km.DraftModel("shop.yaml")
+
+	zones, components, flows := draftElements(m)
+	if want := []string{"monitoring", "shop", "internet", "external-networks"}; !reflect.DeepEqual(zones, want) {
+		t.Errorf("got zones %v, want %v", zones, want)
+	}
+	wantComponents := []string{
+		"web:deployment@shop",
+		"db:database@shop",
+		"scraper:deployment@monitoring",
+		"shop-2:ingress@shop",
+		"internet-clients:client@internet",
+		"10.20.0.0-16:network@external-networks",
+	}
+	if !reflect.DeepEqual(components, wantComponents) {
+		t.Errorf("got components %v, want %v", components, wantComponents)
+	}
+	wantFlows := []string{
+		"internet-clients>shop-2 443,80", //the Ingress has TLS
+		"shop-2>web 8080",                //the named target port of the Service
+		"web>db 5432",                    //DATABASE_URL names the db Service
+		"scraper>db 9187",
+		"db>10.20.0.0-16 ",
+	}
+	if !reflect.DeepEqual(flows, wantFlows) {
+		t.Errorf("got flows %v, want %v", flows, wantFlows)
+	}
+
+	web := m.Components[0]
+	for k, want := range map[string]string{"kind": "Deployment", "labels": "app=web", "ports": "8080",
+		"serviceAccount": "web", "inferredFrom": "shop.yaml"} {
+		if got := web.Attributes[k]; got != want {
+			t.Errorf("got web attribute %s %q, want %q", k, got, want)
+		}
+	}
+	if via := m.DataFlows[3].Attributes["via"]; via != "networkpolicy/db" {
+		t.Errorf("got scraper to db via %q, want networkpolicy/db", via)
+	}
+}
+
+func TestKubernetesReadSkipsOtherFiles(t *testing.T) {
+	km := NewKubernetesManifests()
+	km.ReadFile("deployment.yaml", strings.NewReader("kind: Deployment\nmetadata:\n  name: {{ include \"web.name\" . }}\n"))
+	km.ReadFile("shop.yaml", strings.NewReader(shopManifests))
+	if len(km.Warnings) != 1 || !strings.Contains(km.Warnings[0], "deployment.yaml") {
+		t.Errorf("got warnings %v, want one about deployment.yaml", km.Warnings)
+	}
+	if got := len(km.DraftModel("").Components); got != 6 {
+		t.Errorf("got %d components, want 6", got)
+	}
+}
@@ -0,0 +1,348 @@
+package ingest
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	otm_transform "github.com/0-trust/service/pkg/otm"
+)
+
+const (
+	// ObservedTag marks the elements of a draft model that were inferred from an external source
+	ObservedTag = "observed"
+
+	//trust ratings of inferred zones, which should be reviewed
+	draftTrustRating    = 50.0
+	untrustedZoneRating = 10.0
+	draftAssetRisk      = 50.0
+
+	anywhere         = "0.0.0.0/0"
+	internetZoneName = "Internet"
+	externalZoneName = "External networks"
+)
+
+var (
+	idUnsafe = regexp.MustCompile(`[^A-Za-z0-9_.\-]+`)
+)
+
+// Model is a draft threat model inferred from an external source, such as network flow logs.
+// It is added to an OTM document with AddTo, leaving the elements the document already has untouched
+type Model struct {
+	Source     string //where the model was inferred from, e.g. a file name
+	Assets     []*Asset
+	TrustZones []*TrustZone
+	Components []*Component
+	DataFlows  []*DataFlow
+
+	ids     map[string]bool
+	flows   map[[2]string]*DataFlow //data flows by their source and destination, see flow
+	outside map[string]*Component   //components outside the modelled system by CIDR, see external
+}
+
+// Asset is a data asset of a draft model, in the form of the assets section of an OTM document
+type Asset struct {
+	ID          string            `yaml:"id"`
+	Name        string            `yaml:"name"`
+	Description string            `yaml:"description,omitempty"`
+	Risk        AssetRisk         `yaml:"risk"`
+	Attributes  map[string]string `yaml:"attributes,omitempty"`
+}
+
+// AssetRisk rates the impact (0-100) of the loss of confidentiality, integrity and availability of an asset
+type AssetRisk struct {
+	Confidentiality float64 `yaml:"confidentiality"`
+	Integrity       float64 `yaml:"integrity"`
+	Availability    float64 `yaml:"availability"`
+}
+
+// TrustZone is a trust zone of a draft model, in the form of the trustZones section of an OTM document
+type TrustZone struct {
+	ID         string            `yaml:"id"`
+	Name       string            `yaml:"name"`
+	Type       string            `yaml:"type,omitempty"`
+	Risk       TrustZoneRisk     `yaml:"risk"`
+	Parent     *Parent           `yaml:"parent,omitempty"`
+	Tags       []string          `yaml:"tags,omitempty"`
+	Attributes map[string]string `yaml:"attributes,omitempty"`
+}
+
+type TrustZoneRisk struct {
+	TrustRating float64 `yaml:"trustRating"`
+}
+
+// Parent is the trust zone or component that contains an element
+type Parent struct {
+	TrustZone string `yaml:"trustZone,omitempty"`
+	Component string `yaml:"component,omitempty"`
+}
+
+// Component is a component of a draft model, in the form of the components section of an OTM document
+type Component struct {
+	ID         string            `yaml:"id"`
+	Name       string            `yaml:"name"`
+	Type       string            `yaml:"type,omitempty"`
+	Parent     *Parent           `yaml:"parent,omitempty"`
+	Assets     *ComponentAssets  `yaml:"assets,omitempty"`
+	Tags       []string          `yaml:"tags,omitempty"`
+	Attributes map[string]string `yaml:"attributes,omitempty"`
+}
+
+// ComponentAssets are the IDs of the data assets that a component processes and stores
+type ComponentAssets struct {
+	Processed []string `yaml:"processed,omitempty"`
+	Stored    []string `yaml:"stored,omitempty"`
+}
+
+// DataFlow is a data flow of a draft model, in the form of the dataflows section of an OTM document
+type DataFlow struct {
+	ID            string            `yaml:"id"`
+	Name          string            `yaml:"name"`
+	Source        string            `yaml:"source"`
+	Destination   string            `yaml:"destination"`
+	Bidirectional bool              `yaml:"bidirectional,omitempty"`
+	Assets        []string          `yaml:"assets,omitempty"`
+	Tags          []string          `yaml:"tags,omitempty"`
+	Attributes    map[string]string `yaml:"attributes,omitempty"`
+}
+
+// NewModel creates an empty draft model of a source
+func NewModel(source string) *Model {
+	return &Model{Source: source}
+}
+
+// NewID returns an ID derived from a name that no element of the model has yet
+func (m *Model) NewID(name string) string {
+	if m.ids == nil {
+		m.ids = make(map[string]bool)
+	}
+	base := strings.Trim(idUnsafe.ReplaceAllString(strings.ToLower(name), "-"), "-")
+	if base == "" {
+		base = "element"
+	}
+	id := base
+	for i := 2; m.ids[id]; i++ {
+		id = fmt.Sprintf("%s-%d", base, i)
+	}
+	m.ids[id] = true
+	return id
+}
+
+// useID reserves an ID given elsewhere, e.g. the ID of a component of a project, so that NewID doesn't return it
+func (m *Model) useID(id string) string {
+	if m.ids == nil {
+		m.ids = make(map[string]bool)
+	}
+	m.ids[id] = true
+	return id
+}
+
+// AddAsset adds a data asset, with a neutral risk rating
+func (m *Model) AddAsset(id, name string) *Asset {
+	a := &Asset{
+		ID:         id,
+		Name:       name,
+		Risk:       AssetRisk{Confidentiality: draftAssetRisk, Integrity: draftAssetRisk, Availability: draftAssetRisk},
+		Attributes: m.attributes(),
+	}
+	m.Assets = append(m.Assets, a)
+	return a
+}
+
+// AddTrustZone adds a trust zone, rated as untrusted if its name suggests it is public, or else with a neutral rating
+func (m *Model) AddTrustZone(id, name string) *TrustZone {
+	rating := draftTrustRating
+	if lower := strings.ToLower(name); strings.Contains(lower, "internet") || strings.Contains(lower, "public") ||
+		strings.Contains(lower, "external") {
+		rating = untrustedZoneRating
+	}
+	tz := &TrustZone{
+		ID:         id,
+		Name:       name,
+		Risk:       TrustZoneRisk{TrustRating: rating},
+		Tags:       []string{ObservedTag},
+		Attributes: m.attributes(),
+	}
+	m.TrustZones = append(m.TrustZones, tz)
+	return tz
+}
+
+// AddComponent adds a component, in a trust zone unless the zone ID is empty
+func (m *Model) AddComponent(id, name, componentType, zoneID string) *Component {
+	c := &Component{
+		ID:         id,
+		Name:       name,
+		Type:       componentType,
+		Tags:       []string{ObservedTag},
+		Attributes: m.attributes(),
+	}
+	if zoneID != "" {
+		c.Parent = &Parent{TrustZone: zoneID}
+	}
+	m.Components = append(m.Components, c)
+	return c
+}
+
+// AddDataFlow adds a data flow
+func (m *Model) AddDataFlow(id, name, source, destination string) *DataFlow {
+	df := &DataFlow{
+		ID:          id,
+		Name:        name,
+		Source:      source,
+		Destination: destination,
+		Tags:        []string{ObservedTag},
+		Attributes:  m.attributes(),
+	}
+	m.DataFlows = append(m.DataFlows, df)
+	return df
+}
+
+// flow adds a data flow between two components, or adds to the ports and origin (via) of the flow between them
+func (m *Model) flow(source, destination *Component, via string, ports []string) *DataFlow {
+	if m.flows == nil {
+		m.flows = make(map[[2]string]*DataFlow)
+	}
+	key := [2]string{source.ID, destination.ID}
+	df, exists := m.flows[key]
+	if !exists {
+		df = m.AddDataFlow(m.NewID(source.ID+"-"+destination.ID), fmt.Sprintf("%s to %s", source.Name, destination.Name), source.ID, destination.ID)
+		m.flows[key] = df
+	}
+	df.Attributes["via"] = appendUnique(df.Attributes["via"], via)
+	for _, p := range ports {
+		df.Attributes["ports"] = appendUnique(df.Attributes["ports"], p)
+	}
+	return df
+}
+
+// external is the component of the addresses of a CIDR outside the modelled system, in an untrusted zone of
+// external networks, or of the internet if the CIDR is empty or 0.0.0.0/0
+func (m *Model) external(cidr string) *Component {
+	if cidr == anywhere || cidr == "::/0" {
+		cidr = ""
+	}
+	if m.outside == nil {
+		m.outside = make(map[string]*Component)
+	}
+	if c, exists := m.outside[cidr]; exists {
+		return c
+	}
+	var c *Component
+	if cidr == "" {
+		c = m.AddComponent(m.NewID("internet-clients"), "Internet clients", "client", m.externalZone(internetZoneName))
+	} else {
+		c = m.AddComponent(m.NewID(cidr), cidr, "network", m.externalZone(externalZoneName))
+		c.Attributes["cidr"] = cidr
+	}
+	m.outside[cidr] = c
+	return c
+}
+
+func (m *Model) externalZone(name string) string {
+	for _, tz := range m.TrustZones {
+		if tz.Name == name {
+			return tz.ID
+		}
+	}
+	tz := m.AddTrustZone(m.NewID(name), name)
+	tz.Risk.TrustRating = untrustedZoneRating
+	return tz.ID
+}
+
+func (m *Model) attributes() map[string]string {
+	attrs := make(map[string]string)
+	if m.Source != "" {
+		attrs["inferredFrom"] = m.Source
+	}
+	return attrs
+}
+
+// AddTo adds the elements of the model that an OTM document doesn't have yet, returning the number added
+func (m *Model) AddTo(doc *otm_transform.Document) (added int, err error) {
+	add := func(section, id string, element interface{}) error {
+		if doc.HasElement(section, id) {
+			return nil
+		}
+		if err := doc.AddElement(section, element); err != nil {
+			return err
+		}
+		added++
+		return nil
+	}
+	for _, a := range m.Assets {
+		if err = add("assets", a.ID, a); err != nil {
+			return
+		}
+	}
+	for _, tz := range m.TrustZones {
+		if err = add("trustZones", tz.ID, tz); err != nil {
+			return
+		}
+	}
+	for _, c := range m.Components {
+		if doc.HasElement("components", c.ID) && c.Assets != nil {
+			//the component is mapped to the draft's assets, e.g. the component of an API specification
+			var n int
+			if n, err = addComponentAssets(doc, c); err != nil {
+				return
+			}
+			added += n
+		}
+		if err = add("components", c.ID, c); err != nil {
+			return
+		}
+	}
+	for _, df := range m.DataFlows {
+		if err = add("dataflows", df.ID, df); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// addComponentAssets adds the processed and stored assets of a draft component to the component of a document,
+// returning the number of assets added
+func addComponentAssets(doc *otm_transform.Document, c *Component) (added int, err error) {
+	fields := map[string][]string{"assets.processed": c.Assets.Processed, "assets.stored": c.Assets.Stored}
+	for _, field := range sortedKeys(fields) {
+		var assets []string
+		if _, err = doc.Field("components", c.ID, field, &assets); err != nil {
+			return
+		}
+		n := len(assets)
+		for _, a := range fields[field] {
+			if !contains(assets, a) {
+				assets = append(assets, a)
+			}
+		}
+		if len(assets) > n {
+			if err = doc.SetField("components", c.ID, field, assets); err != nil {
+				return
+			}
+			added += len(assets) - n
+		}
+	}
+	return
+}
+
+// Document creates an OTM document of the model
+func (m *Model) Document(project otm_transform.ProjectInfo) (*otm_transform.Document, error) {
+	doc, err := otm_transform.NewDocument(project)
+	if err != nil {
+		return nil, err
+	}
+	_, err = m.AddTo(doc)
+	return doc, err
+}
+
+func appendUnique(list, item string) string {
+	if list == "" {
+		return item
+	}
+	for _, i := range strings.Split(list, ",") {
+		if i == item {
+			return list
+		}
+	}
+	return list + "," + item
+}
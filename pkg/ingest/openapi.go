@@ -0,0 +1,496 @@
+package ingest
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	apiZoneName    = "APIs"
+	maxSchemaDepth = 8 //of nested properties, followed when inferring the fields of a schema
+)
+
+var (
+	httpMethods       = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+	serverVariable    = regexp.MustCompile(`\{([^}]*)\}`)
+	fieldNameUnsafe   = regexp.MustCompile(`[^a-z0-9]+`)
+	defaultServerPort = map[string]string{"https": "443", "http": "80", "wss": "443", "ws": "80"}
+
+	//the sensitivity of data fields, from the most sensitive, by the keywords of their (normalised) names. A name matches
+	//a keyword it ends with, or a keyword of six or more letters that it contains
+	fieldSensitivities = []struct {
+		sensitivity     string
+		confidentiality float64
+		keywords        []string
+	}{
+		{"credential", 100, []string{"password", "passwd", "passphrase", "secret", "token", "apikey", "privatekey",
+			"credential", "pin", "otp", "sessionid", "cookie"}},
+		{"payment", 90, []string{"cardnumber", "creditcard", "cvv", "cvc", "pan", "iban", "accountnumber",
+			"routingnumber", "bankaccount", "sortcode"}},
+		{"health", 90, []string{"diagnosis", "medical", "prescription", "allergies", "allergy"}},
+		{"personal", 70, []string{"email", "phone", "phonenumber", "mobile", "ssn", "socialsecurity", "nationalid",
+			"passport", "taxid", "dateofbirth", "dob", "birthdate", "firstname", "lastname", "fullname", "surname",
+			"address", "postcode", "postalcode", "zipcode", "gender"}},
+	}
+	//names of fields that match sensitive keywords but aren't sensitive, such as the tokens of paginated results
+	insensitiveFields = []string{"page", "cursor"}
+)
+
+const internalSensitivity, internalConfidentiality = "internal", 30.0
+
+// OpenAPISpecs are OpenAPI 3 specifications of the APIs of components
+type OpenAPISpecs struct {
+	Clients string //ID of the component that calls the APIs, the internet clients if empty
+	specs   []openAPISpec
+}
+
+type openAPISpec struct {
+	component string //ID of the component that implements the API
+	OpenAPI   string `yaml:"openapi"`
+	Swagger   string `yaml:"swagger"`
+	Info      struct {
+		Title   string `yaml:"title"`
+		Version string `yaml:"version"`
+	} `yaml:"info"`
+	Servers    []openAPIServer        `yaml:"servers"`
+	Security   *[]map[string][]string `yaml:"security"` //nil if not set, empty if the API doesn't require authentication
+	Paths      yaml.Node              `yaml:"paths"`
+	Components struct {
+		Schemas         map[string]*openAPISchema        `yaml:"schemas"`
+		SecuritySchemes map[string]openAPISecurityScheme `yaml:"securitySchemes"`
+		RequestBodies   map[string]*openAPIBody          `yaml:"requestBodies"`
+		Responses       map[string]*openAPIBody          `yaml:"responses"`
+	} `yaml:"components"`
+}
+
+type openAPIServer struct {
+	URL       string `yaml:"url"`
+	Variables map[string]struct {
+		Default string `yaml:"default"`
+	} `yaml:"variables"`
+}
+
+type openAPIPathItem struct {
+	Servers []openAPIServer `yaml:"servers"`
+}
+
+type openAPIOperation struct {
+	OperationID string                  `yaml:"operationId"`
+	Summary     string                  `yaml:"summary"`
+	Tags        []string                `yaml:"tags"`
+	Deprecated  bool                    `yaml:"deprecated"`
+	Security    *[]map[string][]string  `yaml:"security"`
+	Servers     []openAPIServer         `yaml:"servers"`
+	RequestBody *openAPIBody            `yaml:"requestBody"`
+	Responses   map[string]*openAPIBody `yaml:"responses"`
+}
+
+// openAPIBody is a request body or a response, or a reference to one
+type openAPIBody struct {
+	Ref     string `yaml:"$ref"`
+	Content map[string]struct {
+		Schema *openAPISchema `yaml:"schema"`
+	} `yaml:"content"`
+}
+
+type openAPISchema struct {
+	Ref        string                    `yaml:"$ref"`
+	Format     string                    `yaml:"format"`
+	Properties map[string]*openAPISchema `yaml:"properties"`
+	Items      *openAPISchema            `yaml:"items"`
+	AllOf      []*openAPISchema          `yaml:"allOf"`
+	OneOf      []*openAPISchema          `yaml:"oneOf"`
+	AnyOf      []*openAPISchema          `yaml:"anyOf"`
+}
+
+type openAPISecurityScheme struct {
+	Type   string `yaml:"type"`
+	Scheme string `yaml:"scheme"` //of http schemes, e.g. bearer
+}
+
+// schemaField is a property of a schema, by its dotted path from the schema
+type schemaField struct {
+	path, format string
+}
+
+// NewOpenAPISpecs creates an empty set of OpenAPI specifications
+func NewOpenAPISpecs() *OpenAPISpecs {
+	return &OpenAPISpecs{}
+}
+
+// Read reads an OpenAPI 3 specification, in YAML or JSON, of the API of a component. If the component ID is empty, the
+// API is the interface of a new component named after the title of the specification
+func (s *OpenAPISpecs) Read(in io.Reader, component string) error {
+	var spec openAPISpec
+	if err := yaml.NewDecoder(in).Decode(&spec); err != nil {
+		return err
+	}
+	if spec.Swagger != "" {
+		return fmt.Errorf("unsupported Swagger %s specification, convert it to OpenAPI 3", spec.Swagger)
+	}
+	if !strings.HasPrefix(spec.OpenAPI, "3") {
+		return errors.New("not an OpenAPI 3 specification")
+	}
+	if spec.Paths.Kind != yaml.MappingNode {
+		return errors.New("no paths found in the OpenAPI specification")
+	}
+	spec.component = component
+	s.specs = append(s.specs, spec)
+	return nil
+}
+
+// DraftModel infers a draft threat model from the specifications: each API is the interface of a component, which is
+// added to an APIs trust zone unless it was given, and each operation becomes a data flow into the component from its
+// clients, carrying the authentication that the operation requires (none if it can be called anonymously), and the
+// protocol and ports of the servers. The schemas of request bodies and successful responses become data assets, rated
+// by the sensitivity that the names of their fields suggest, which the flows carry and the component processes
+func (s *OpenAPISpecs) DraftModel(source string) *Model {
+	m := NewModel(source)
+	var clients *Component
+	if s.Clients != "" {
+		clients = m.AddComponent(m.useID(s.Clients), s.Clients, "", "")
+	}
+	components := make(map[string]*Component)
+	assets := make(map[string]*Asset) //by component and schema
+	for _, spec := range s.specs {
+		if clients == nil {
+			clients = m.external("")
+		}
+		c, exists := components[spec.component]
+		if !exists || spec.component == "" {
+			if spec.component != "" {
+				c = m.AddComponent(m.useID(spec.component), spec.component, "api", "")
+			} else {
+				title := spec.Info.Title
+				if title == "" {
+					title = "API"
+				}
+				c = m.AddComponent(m.NewID(title), title, "api", m.apiZone())
+			}
+			components[spec.component] = c
+			c.Assets = &ComponentAssets{}
+		}
+		c.Attributes["openapi"] = appendUnique(c.Attributes["openapi"], strings.TrimSpace(spec.Info.Title+" "+spec.Info.Version))
+		for _, server := range spec.Servers {
+			c.Attributes["servers"] = appendUnique(c.Attributes["servers"], server.URL)
+		}
+
+		//the asset of a named schema, or else of the inline schema of a request or response with the name and ID
+		asset := func(schema *openAPISchema, name, id string) *Asset {
+			if schema == nil {
+				return nil
+			}
+			if ref := refName(schema.Ref, "schemas"); ref != "" {
+				name, id = ref, ref
+			} else if schema.Items != nil && len(schema.Properties) == 0 {
+				//an array of a named schema
+				if ref := refName(schema.Items.Ref, "schemas"); ref != "" {
+					name, id = ref, ref
+				}
+			}
+			key := c.ID + "/" + id
+			if a, exists := assets[key]; exists {
+				return a
+			}
+			fields := spec.fields(schema, "", map[string]bool{}, 0)
+			if len(fields) == 0 {
+				return nil
+			}
+			a := m.AddAsset(m.NewID(c.ID+"-"+id), name)
+			a.Description = fmt.Sprintf("%s data exchanged with %s", name, c.Name)
+			sensitivity, confidentiality, sensitive, names := internalSensitivity, internalConfidentiality, []string{}, []string{}
+			for _, f := range fields {
+				names = append(names, f.path)
+				parts := strings.Split(f.path, ".")
+				if fs, score := fieldSensitivity(parts[len(parts)-1], f.format); score > internalConfidentiality {
+					sensitive = append(sensitive, f.path)
+					if score > confidentiality {
+						sensitivity, confidentiality = fs, score
+					}
+				}
+			}
+			a.Risk.Confidentiality = confidentiality
+			a.Attributes["sensitivity"] = sensitivity
+			a.Attributes["fields"] = strings.Join(names, ",")
+			if len(sensitive) > 0 {
+				a.Attributes["sensitiveFields"] = strings.Join(sensitive, ",")
+			}
+			assets[key] = a
+			return a
+		}
+
+		for i := 0; i+1 < len(spec.Paths.Content); i += 2 {
+			path, item := spec.Paths.Content[i].Value, spec.Paths.Content[i+1]
+			var pathItem openAPIPathItem
+			item.Decode(&pathItem)
+			for _, method := range httpMethods {
+				node := mappingNode(item, method)
+				if node == nil {
+					continue
+				}
+				var op openAPIOperation
+				if err := node.Decode(&op); err != nil {
+					continue
+				}
+				name := fmt.Sprintf("%s %s", strings.ToUpper(method), path)
+				id := op.OperationID
+				if id == "" {
+					id = method + "-" + path
+				}
+				df := m.AddDataFlow(m.NewID(c.ID+"-"+id), name, clients.ID, c.ID)
+				df.Attributes["method"] = strings.ToUpper(method)
+				df.Attributes["path"] = path
+				if op.OperationID != "" {
+					df.Attributes["operationId"] = op.OperationID
+				}
+				if op.Summary != "" {
+					df.Attributes["summary"] = op.Summary
+				}
+				if len(op.Tags) > 0 {
+					df.Attributes["apiTags"] = strings.Join(op.Tags, ",")
+				}
+				if op.Deprecated {
+					df.Attributes["deprecated"] = "true"
+				}
+
+				security := op.Security
+				if security == nil {
+					security = spec.Security
+				}
+				auth, schemes, scopes := spec.authentication(security)
+				df.Attributes["authentication"] = auth
+				if len(schemes) > 0 {
+					df.Attributes["securitySchemes"] = strings.Join(schemes, ",")
+				}
+				if len(scopes) > 0 {
+					df.Attributes["scopes"] = strings.Join(scopes, ",")
+				}
+
+				servers := op.Servers
+				if len(servers) == 0 {
+					servers = pathItem.Servers
+				}
+				if len(servers) == 0 {
+					servers = spec.Servers
+				}
+				for _, server := range servers {
+					if protocol, port := server.endpoint(); protocol != "" {
+						df.Attributes["protocol"] = appendUnique(df.Attributes["protocol"], protocol)
+						df.Attributes["ports"] = appendUnique(df.Attributes["ports"], port)
+					}
+				}
+
+				if a := asset(spec.schema(op.RequestBody), name+" request", id+"-request"); a != nil {
+					df.Assets = append(df.Assets, a.ID)
+				}
+				for _, status := range sortedKeys(op.Responses) {
+					if !strings.HasPrefix(status, "2") {
+						continue
+					}
+					if a := asset(spec.schema(op.Responses[status]), name+" response", id+"-response"); a != nil && !contains(df.Assets, a.ID) {
+						df.Assets = append(df.Assets, a.ID)
+					}
+				}
+				for _, a := range df.Assets {
+					if !contains(c.Assets.Processed, a) {
+						c.Assets.Processed = append(c.Assets.Processed, a)
+					}
+				}
+			}
+		}
+	}
+	return m
+}
+
+func (m *Model) apiZone() string {
+	for _, tz := range m.TrustZones {
+		if tz.Name == apiZoneName {
+			return tz.ID
+		}
+	}
+	return m.AddTrustZone(m.NewID(apiZoneName), apiZoneName).ID
+}
+
+// authentication describes the security requirements of an operation: the kinds of its security schemes, e.g. oauth2
+// or bearer, or none if it doesn't require any, with the names of the schemes and their scopes
+func (spec openAPISpec) authentication(security *[]map[string][]string) (auth string, schemes, scopes []string) {
+	kinds := []string{}
+	anonymous := security == nil || len(*security) == 0
+	if security != nil {
+		for _, requirement := range *security {
+			//an empty requirement makes authentication optional
+			anonymous = anonymous || len(requirement) == 0
+			for _, name := range sortedKeys(requirement) {
+				if !contains(schemes, name) {
+					schemes = append(schemes, name)
+				}
+				for _, scope := range requirement[name] {
+					if !contains(scopes, scope) {
+						scopes = append(scopes, scope)
+					}
+				}
+				kind := name
+				if scheme, defined := spec.Components.SecuritySchemes[name]; defined {
+					switch {
+					case strings.EqualFold(scheme.Type, "http") && scheme.Scheme != "":
+						kind = strings.ToLower(scheme.Scheme)
+					case scheme.Type != "":
+						kind = scheme.Type
+					}
+				}
+				if !contains(kinds, kind) {
+					kinds = append(kinds, kind)
+				}
+			}
+		}
+	}
+	if anonymous {
+		return "none", schemes, scopes
+	}
+	return strings.Join(kinds, ","), schemes, scopes
+}
+
+// endpoint is the protocol and port of a server, with its variables set to their defaults. Both are empty if the URL
+// is relative to where the specification is served
+func (server openAPIServer) endpoint() (protocol, port string) {
+	address := serverVariable.ReplaceAllStringFunc(server.URL, func(v string) string {
+		return server.Variables[strings.Trim(v, "{}")].Default
+	})
+	u, err := url.Parse(address)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return "", ""
+	}
+	protocol, port = strings.ToLower(u.Scheme), u.Port()
+	if port == "" {
+		port = defaultServerPort[protocol]
+	}
+	return
+}
+
+// schema is the schema of a request body or response, of its JSON content if it has several media types
+func (spec openAPISpec) schema(body *openAPIBody) *openAPISchema {
+	if body == nil {
+		return nil
+	}
+	if ref := refName(body.Ref, "requestBodies"); ref != "" {
+		body = spec.Components.RequestBodies[ref]
+	} else if ref := refName(body.Ref, "responses"); ref != "" {
+		body = spec.Components.Responses[ref]
+	}
+	if body == nil {
+		return nil
+	}
+	mediaTypes := sortedKeys(body.Content)
+	sort.SliceStable(mediaTypes, func(i, j int) bool {
+		return strings.Contains(mediaTypes[i], "json") && !strings.Contains(mediaTypes[j], "json")
+	})
+	for _, mediaType := range mediaTypes {
+		if schema := body.Content[mediaType].Schema; schema != nil {
+			return schema
+		}
+	}
+	return nil
+}
+
+// refName is the name of a local reference to a component of a kind, e.g. Pet of #/components/schemas/Pet
+func refName(ref, kind string) string {
+	prefix := "#/components/" + kind + "/"
+	if !strings.HasPrefix(ref, prefix) {
+		return ""
+	}
+	return strings.TrimPrefix(ref, prefix)
+}
+
+// fields are the properties of a schema, and of the schemas it is composed of, references or contains, by their
+// dotted paths. Arrays are transparent, and the schemas being expanded aren't followed again, to stop at cycles
+func (spec openAPISpec) fields(schema *openAPISchema, prefix string, expanding map[string]bool, depth int) []schemaField {
+	if schema == nil || depth > maxSchemaDepth {
+		return nil
+	}
+	if ref := refName(schema.Ref, "schemas"); ref != "" {
+		if expanding[ref] {
+			return nil
+		}
+		expanding[ref] = true
+		defer delete(expanding, ref)
+		return spec.fields(spec.Components.Schemas[ref], prefix, expanding, depth)
+	}
+	fields := []schemaField{}
+	for _, composed := range [][]*openAPISchema{schema.AllOf, schema.OneOf, schema.AnyOf, {schema.Items}} {
+		for _, s := range composed {
+			for _, f := range spec.fields(s, prefix, expanding, depth) {
+				if !containsField(fields, f.path) {
+					fields = append(fields, f)
+				}
+			}
+		}
+	}
+	for _, name := range sortedKeys(schema.Properties) {
+		property := schema.Properties[name]
+		path := prefix + name
+		if !containsField(fields, path) {
+			format := ""
+			if property != nil {
+				format = property.Format
+			}
+			fields = append(fields, schemaField{path: path, format: format})
+		}
+		for _, f := range spec.fields(property, path+".", expanding, depth+1) {
+			if !containsField(fields, f.path) {
+				fields = append(fields, f)
+			}
+		}
+	}
+	return fields
+}
+
+func containsField(fields []schemaField, path string) bool {
+	for _, f := range fields {
+		if f.path == path {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldSensitivity infers the sensitivity of a data field from its name and format, with the rating of the impact of
+// its disclosure (0-100)
+func fieldSensitivity(name, format string) (string, float64) {
+	if strings.EqualFold(format, "password") {
+		return fieldSensitivities[0].sensitivity, fieldSensitivities[0].confidentiality
+	}
+	name = fieldNameUnsafe.ReplaceAllString(strings.ToLower(name), "")
+	for _, insensitive := range insensitiveFields {
+		if strings.Contains(name, insensitive) {
+			return internalSensitivity, internalConfidentiality
+		}
+	}
+	for _, fs := range fieldSensitivities {
+		for _, kw := range fs.keywords {
+			if strings.HasSuffix(name, kw) || len(kw) >= 6 && strings.Contains(name, kw) {
+				return fs.sensitivity, fs.confidentiality
+			}
+		}
+	}
+	return internalSensitivity, internalConfidentiality
+}
+
+// mappingNode is the value of a key of a YAML mapping, or nil
+func mappingNode(n *yaml.Node, key string) *yaml.Node {
+	if n == nil || n.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return n.Content[i+1]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package ingest
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const ordersAPI = `openapi: 3.0.3
+info:
+  title: Orders API
+  version: "1.2"
+servers:
+  - url: https://api.example.com/v1
+  - url: http://{host}:8080
+    variables:
+      host:
+        default: localhost
+security:
+  - bearerAuth: []
+paths:
+  /orders:
+    get:
+      operationId: listOrders
+      security: []
+      responses:
+        "200":
+          description: the orders
+          content:
+            application/json:
+              schema:
+                type: array
+                items:
+                  $ref: "#/components/schemas/Order"
+    post:
+      operationId: createOrder
+      requestBody:
+        content:
+          application/json:
+            schema:
+              $ref: "#/components/schemas/Order"
+      responses:
+        "201":
+          description: the order
+          content:
+            application/json:
+              schema:
+                $ref: "#/components/schemas/Order"
+        "400":
+          description: an invalid order
+          content:
+            application/json:
+              schema:
+                properties:
+                  message:
+                    type: string
+  /login:
+    post:
+      operationId: login
+      security: []
+      requestBody:
+        content:
+          application/json:
+            schema:
+              properties:
+                username:
+                  type: string
+                secret:
+                  type: string
+                  format: password
+      responses:
+        "200":
+          description: a session
+          content:
+            application/json:
+              schema:
+                properties:
+                  token:
+                    type: string
+components:
+  securitySchemes:
+    bearerAuth:
+      type: http
+      scheme: bearer
+  schemas:
+    Order:
+      properties:
+        id:
+          type: string
+        email:
+          type: string
+        card:
+          properties:
+            cardNumber:
+              type: string
+        items:
+          type: array
+          items:
+            $ref: "#/components/schemas/OrderItem"
+    OrderItem:
+      properties:
+        sku:
+          type: string
+        order:
+          $ref: "#/components/schemas/Order"
+`
+
+func TestOpenAPIDraftModel(t *testing.T) {
+	specs := NewOpenAPISpecs()
+	if err := specs.Read(strings.NewReader(ordersAPI), ""); err != nil {
+		t.Fatal(err)
+	}
+	m := specs.DraftModel("orders.yaml")
+
+	zones, components, flows := draftElements(m)
+	if want := []string{"internet", "apis"}; !reflect.DeepEqual(zones, want) {
+		t.Errorf("got zones %v, want %v", zones, want)
+	}
+	if want := []string{"internet-clients:client@internet", "orders-api:api@apis"}; !reflect.DeepEqual(components, want) {
+		t.Errorf("got components %v, want %v", components, want)
+	}
+	if want := []string{
+		"internet-clients>orders-api 443,8080",
+		"internet-clients>orders-api 443,8080",
+		"internet-clients>orders-api 443,8080",
+	}; !reflect.DeepEqual(flows, want) {
+		t.Errorf("got flows %v, want %v", flows, want)
+	}
+
+	wantFlows := []struct {
+		id, name, authentication string
+		assets                   []string
+	}{
+		{"orders-api-listorders", "GET /orders", "none", []string{"orders-api-order"}},
+		{"orders-api-createorder", "POST /orders", "bearer", []string{"orders-api-order"}}, //not the error response
+		{"orders-api-login", "POST /login", "none", []string{"orders-api-login-request", "orders-api-login-response"}},
+	}
+	for i, want := range wantFlows {
+		df := m.DataFlows[i]
+		if df.ID != want.id || df.Name != want.name || df.Attributes["authentication"] != want.authentication ||
+			!reflect.DeepEqual(df.Assets, want.assets) {
+			t.Errorf("got flow %s %q, authentication %s, assets %v, want %s %q, authentication %s, assets %v", df.ID,
+				df.Name, df.Attributes["authentication"], df.Assets, want.id, want.name, want.authentication, want.assets)
+		}
+	}
+
+	wantAssets := []struct {
+		id, sensitivity, fields string
+	}{
+		//the cycle back to Order through its items isn't followed
+		{"orders-api-order", "payment", "card,card.cardNumber,email,id,items,items.order,items.sku"},
+		{"orders-api-login-request", "credential", "secret,username"},
+		{"orders-api-login-response", "credential", "token"},
+	}
+	if len(m.Assets) != len(wantAssets) {
+		t.Fatalf("got %d assets, want %d", len(m.Assets), len(wantAssets))
+	}
+	for i, want := range wantAssets {
+		a := m.Assets[i]
+		if a.ID != want.id || a.Attributes["sensitivity"] != want.sensitivity || a.Attributes["fields"] != want.fields {
+			t.Errorf("got asset %s, sensitivity %s, fields %s, want %s, %s, %s", a.ID, a.Attributes["sensitivity"],
+				a.Attributes["fields"], want.id, want.sensitivity, want.fields)
+		}
+	}
+	if api := m.Components[1]; !reflect.DeepEqual(api.Assets.Processed, []string{"orders-api-order", "orders-api-login-request", "orders-api-login-response"}) {
+		t.Errorf("got processed assets %v", api.Assets.Processed)
+	}
+}
+
+func TestOpenAPIDraftModelOfComponent(t *testing.T) {
+	specs := NewOpenAPISpecs()
+	specs.Clients = "web"
+	if err := specs.Read(strings.NewReader(ordersAPI), "orders"); err != nil {
+		t.Fatal(err)
+	}
+	zones, components, flows := draftElements(specs.DraftModel(""))
+	if len(zones) != 0 || !reflect.DeepEqual(components, []string{"web:@", "orders:api@"}) || len(flows) != 3 || flows[0] != "web>orders 443,8080" {
+		t.Errorf("got zones %v, components %v, flows %v, want the flows of the clients to the component", zones, components, flows)
+	}
+}
+
+func TestOpenAPIReadRejects(t *testing.T) {
+	cases := []struct {
+		name, spec string
+	}{
+		{"Swagger", "swagger: \"2.0\"\npaths: {}\n"},
+		{"not OpenAPI", "asyncapi: 2.6.0\n"},
+		{"no paths", "openapi: 3.1.0\ninfo:\n  title: Empty\n"},
+	}
+	for _, c := range cases {
+		if err := NewOpenAPISpecs().Read(strings.NewReader(c.spec), ""); err == nil {
+			t.Errorf("%s: got no error", c.name)
+		}
+	}
+}
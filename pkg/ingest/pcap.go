@@ -0,0 +1,275 @@
+package ingest
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	pcapMagicMicroseconds = 0xa1b2c3d4
+	pcapMagicNanoseconds  = 0xa1b23c4d
+	pcapNGMagic           = 0x0a0d0d0a
+	maxPcapPacket         = 1 << 18
+
+	//link layer header types
+	linkNull   = 0
+	linkEther  = 1
+	linkRaw    = 101
+	linkRawAlt = 12
+	linkLoop   = 108
+	linkSLL    = 113
+	linkSLL2   = 276
+
+	etherTypeIPv4 = 0x0800
+	etherTypeIPv6 = 0x86dd
+	etherTypeVLAN = 0x8100
+	etherTypeQinQ = 0x88a8
+
+	ipProtocolTCP    = 6
+	ipProtocolUDP    = 17
+	ipProtocolICMP   = 1
+	ipProtocolICMPv6 = 58
+
+	tcpFlagSYN = 0x02
+	tcpFlagACK = 0x10
+)
+
+var (
+	ipProtocols = map[byte]string{ipProtocolTCP: "tcp", ipProtocolUDP: "udp", ipProtocolICMP: "icmp", ipProtocolICMPv6: "icmp6"}
+	//IPv6 extension headers that are followed by another header
+	ipv6ExtensionHeaders = map[byte]bool{0: true, 43: true, 60: true}
+)
+
+// pcapPacket is the IP packet of a captured frame, reduced to what identifies its connection
+type pcapPacket struct {
+	time             time.Time
+	protocol         byte
+	source, target   string
+	sourcePort       uint16
+	targetPort       uint16
+	length           int64 //of the IP packet
+	syn, ack, ported bool
+}
+
+// pcapConnection is a connection being tracked in a capture, from the host that originated it
+type pcapConnection struct {
+	originator, responder string
+	protocol              string
+	port                  uint16
+	bytes                 int64
+	first, last           time.Time
+}
+
+func isPcap(head []byte) bool {
+	if len(head) < 4 {
+		return false
+	}
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		if magic := order.Uint32(head); magic == pcapMagicMicroseconds || magic == pcapMagicNanoseconds {
+			return true
+		}
+	}
+	return false
+}
+
+func isPcapNG(head []byte) bool {
+	return len(head) >= 4 && binary.BigEndian.Uint32(head) == pcapNGMagic
+}
+
+// ReadPcap reads the TCP, UDP and ICMP packets of a libpcap capture, tracks their connections, and aggregates them into
+// flows from the originating to the responding host, by protocol and port. The originator of a TCP connection is the
+// host that sent the SYN, and otherwise the host that didn't use the lower (well-known) port, or else the first
+// sender. The label of a flow is the service of a well-known port, or the transport protocol and port
+func ReadPcap(in io.Reader) (ZoneFlows, error) {
+	header := make([]byte, 24)
+	if _, err := io.ReadFull(in, header); err != nil {
+		return ZoneFlows{}, fmt.Errorf("reading the capture header: %w", err)
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if !isPcap(header) {
+		return ZoneFlows{}, errors.New("not a libpcap capture")
+	}
+	if m := binary.LittleEndian.Uint32(header); m != pcapMagicMicroseconds && m != pcapMagicNanoseconds {
+		order = binary.BigEndian
+	}
+	nanoseconds := order.Uint32(header) == pcapMagicNanoseconds
+	linkType := order.Uint32(header[20:]) & 0x0fffffff
+
+	connections := make(map[string]*pcapConnection)
+	keys := []string{}
+	record := make([]byte, 16)
+	for n := 1; ; n++ {
+		if _, err := io.ReadFull(in, record); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				break //the end of a complete or truncated capture
+			}
+			return ZoneFlows{}, err
+		}
+		captured := order.Uint32(record[8:])
+		if captured > maxPcapPacket {
+			return ZoneFlows{}, fmt.Errorf("packet %d: invalid length %d", n, captured)
+		}
+		frame := make([]byte, captured)
+		if _, err := io.ReadFull(in, frame); err != nil {
+			break //a truncated capture
+		}
+		fraction := time.Duration(order.Uint32(record[4:]))
+		if !nanoseconds {
+			fraction *= time.Microsecond
+		}
+		p, ok := parseFrame(linkType, frame, order)
+		if !ok {
+			continue
+		}
+		p.time = time.Unix(int64(order.Uint32(record)), int64(fraction)).UTC()
+
+		key := connectionKey(p)
+		c, exists := connections[key]
+		if !exists {
+			c = &pcapConnection{protocol: ipProtocols[p.protocol], first: p.time}
+			c.originator, c.responder, c.port = p.source, p.target, p.targetPort
+			if p.originatesFromTarget() {
+				c.originator, c.responder, c.port = p.target, p.source, p.sourcePort
+			}
+			connections[key] = c
+			keys = append(keys, key)
+		}
+		c.bytes += p.length
+		c.last = p.time
+	}
+
+	conns := newConnections()
+	for _, key := range keys {
+		c := connections[key]
+		port := ""
+		if c.protocol == "tcp" || c.protocol == "udp" {
+			port = strconv.Itoa(int(c.port))
+		}
+		conns.add(c.originator, c.responder, c.protocol, port, "", 1, c.bytes, c.first, c.last)
+	}
+	return conns.zoneFlows(), nil
+}
+
+// originatesFromTarget guesses whether the first packet seen of a connection was sent by the host that responds
+func (p pcapPacket) originatesFromTarget() bool {
+	switch {
+	case p.protocol == ipProtocolTCP && p.syn:
+		return p.ack //SYN-ACK
+	case !p.ported:
+		return false
+	}
+	return isResponse(ipProtocols[p.protocol], strconv.Itoa(int(p.sourcePort)), strconv.Itoa(int(p.targetPort)))
+}
+
+// connectionKey identifies the connection of a packet, whichever way it goes
+func connectionKey(p pcapPacket) string {
+	a := net.JoinHostPort(p.source, strconv.Itoa(int(p.sourcePort)))
+	b := net.JoinHostPort(p.target, strconv.Itoa(int(p.targetPort)))
+	if b < a {
+		a, b = b, a
+	}
+	return fmt.Sprintf("%d %s %s", p.protocol, a, b)
+}
+
+// parseFrame finds the IP packet of a frame of a link type
+func parseFrame(linkType uint32, frame []byte, order binary.ByteOrder) (pcapPacket, bool) {
+	var etherType uint16
+	switch linkType {
+	case linkEther:
+		if len(frame) < 14 {
+			return pcapPacket{}, false
+		}
+		etherType, frame = binary.BigEndian.Uint16(frame[12:]), frame[14:]
+		for (etherType == etherTypeVLAN || etherType == etherTypeQinQ) && len(frame) >= 4 {
+			etherType, frame = binary.BigEndian.Uint16(frame[2:]), frame[4:]
+		}
+	case linkSLL:
+		if len(frame) < 16 {
+			return pcapPacket{}, false
+		}
+		etherType, frame = binary.BigEndian.Uint16(frame[14:]), frame[16:]
+	case linkSLL2:
+		if len(frame) < 20 {
+			return pcapPacket{}, false
+		}
+		etherType, frame = binary.BigEndian.Uint16(frame), frame[20:]
+	case linkNull, linkLoop:
+		//the address family, in the byte order of the capturing host for null, and big endian for loop
+		if len(frame) < 4 {
+			return pcapPacket{}, false
+		}
+		family := order.Uint32(frame)
+		if linkType == linkLoop {
+			family = binary.BigEndian.Uint32(frame)
+		}
+		etherType, frame = etherTypeIPv6, frame[4:]
+		if family == 2 {
+			etherType = etherTypeIPv4
+		}
+	case linkRaw, linkRawAlt:
+		if len(frame) == 0 {
+			return pcapPacket{}, false
+		}
+		etherType = etherTypeIPv4
+		if frame[0]>>4 == 6 {
+			etherType = etherTypeIPv6
+		}
+	default:
+		return pcapPacket{}, false
+	}
+
+	var p pcapPacket
+	var payload []byte
+	switch etherType {
+	case etherTypeIPv4:
+		if len(frame) < 20 || frame[0]>>4 != 4 {
+			return p, false
+		}
+		headerLength := int(frame[0]&0x0f) * 4
+		if headerLength < 20 || len(frame) < headerLength {
+			return p, false
+		}
+		p.protocol = frame[9]
+		p.source, p.target = net.IP(frame[12:16]).String(), net.IP(frame[16:20]).String()
+		p.length = int64(binary.BigEndian.Uint16(frame[2:]))
+		if binary.BigEndian.Uint16(frame[6:])&0x1fff != 0 {
+			//a later fragment, without the transport header
+			return p, false
+		}
+		payload = frame[headerLength:]
+	case etherTypeIPv6:
+		if len(frame) < 40 || frame[0]>>4 != 6 {
+			return p, false
+		}
+		p.protocol = frame[6]
+		p.source, p.target = net.IP(frame[8:24]).String(), net.IP(frame[24:40]).String()
+		p.length = 40 + int64(binary.BigEndian.Uint16(frame[4:]))
+		payload = frame[40:]
+		for ipv6ExtensionHeaders[p.protocol] && len(payload) >= 8 {
+			next, length := payload[0], (int(payload[1])+1)*8
+			if len(payload) < length {
+				return p, false
+			}
+			p.protocol, payload = next, payload[length:]
+		}
+	default:
+		return p, false
+	}
+
+	if _, known := ipProtocols[p.protocol]; !known {
+		return p, false
+	}
+	if (p.protocol == ipProtocolTCP || p.protocol == ipProtocolUDP) && len(payload) >= 4 {
+		p.sourcePort, p.targetPort = binary.BigEndian.Uint16(payload), binary.BigEndian.Uint16(payload[2:])
+		p.ported = true
+		if p.protocol == ipProtocolTCP && len(payload) >= 14 {
+			p.syn, p.ack = payload[13]&tcpFlagSYN != 0, payload[13]&tcpFlagACK != 0
+		}
+	}
+	return p, true
+}
@@ -0,0 +1,205 @@
+package ingest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const captureTime = 1700000000
+
+// pcapFile is a libpcap capture of frames of a link type, a second apart, with 500 microseconds or nanoseconds
+func pcapFile(order binary.ByteOrder, magic, linkType uint32, frames ...[]byte) []byte {
+	var b bytes.Buffer
+	for _, v := range []interface{}{magic, uint16(2), uint16(4), int32(0), uint32(0), uint32(65535), linkType} {
+		binary.Write(&b, order, v)
+	}
+	for i, frame := range frames {
+		for _, v := range []uint32{uint32(captureTime + i), 500, uint32(len(frame)), uint32(len(frame))} {
+			binary.Write(&b, order, v)
+		}
+		b.Write(frame)
+	}
+	return b.Bytes()
+}
+
+func ethernet(vlan bool, etherType uint16, packet []byte) []byte {
+	frame := make([]byte, 12) //the MAC addresses
+	if vlan {
+		frame = append(frame, etherTypeVLAN>>8, etherTypeVLAN&0xff, 0, 100)
+	}
+	frame = append(frame, byte(etherType>>8), byte(etherType))
+	return append(frame, packet...)
+}
+
+func linuxSLL(etherType uint16, packet []byte) []byte {
+	frame := make([]byte, 16)
+	binary.BigEndian.PutUint16(frame[14:], etherType)
+	return append(frame, packet...)
+}
+
+func ipv4(source, target string, protocol byte, payload []byte) []byte {
+	header := make([]byte, 20)
+	header[0] = 0x45
+	binary.BigEndian.PutUint16(header[2:], uint16(20+len(payload)))
+	header[9] = protocol
+	copy(header[12:], net.ParseIP(source).To4())
+	copy(header[16:], net.ParseIP(target).To4())
+	return append(header, payload...)
+}
+
+// ipv6 is an IPv6 packet of a protocol, with extension headers of the given types before the transport header
+func ipv6(source, target string, protocol byte, payload []byte, extensions ...byte) []byte {
+	for i := len(extensions) - 1; i >= 0; i-- {
+		extension := make([]byte, 8)
+		extension[0] = protocol
+		payload, protocol = append(extension, payload...), extensions[i]
+	}
+	header := make([]byte, 40)
+	header[0] = 0x60
+	binary.BigEndian.PutUint16(header[4:], uint16(len(payload)))
+	header[6] = protocol
+	copy(header[8:], net.ParseIP(source))
+	copy(header[24:], net.ParseIP(target))
+	return append(header, payload...)
+}
+
+func tcp(sourcePort, targetPort uint16, flags byte) []byte {
+	segment := make([]byte, 20)
+	binary.BigEndian.PutUint16(segment, sourcePort)
+	binary.BigEndian.PutUint16(segment[2:], targetPort)
+	segment[12] = 5 << 4
+	segment[13] = flags
+	return segment
+}
+
+func udp(sourcePort, targetPort uint16) []byte {
+	datagram := make([]byte, 8)
+	binary.BigEndian.PutUint16(datagram, sourcePort)
+	binary.BigEndian.PutUint16(datagram[2:], targetPort)
+	return datagram
+}
+
+// describeFlows describes observed flows by their hosts, label, port and bytes
+func describeFlows(zf ZoneFlows) []string {
+	flows := []string{}
+	for _, f := range zf.Flows() {
+		flows = append(flows, fmt.Sprintf("%s>%s %s %s %d", f.Source, f.Target, f.Label, f.Port, f.Bytes))
+	}
+	return flows
+}
+
+func TestReadPcap(t *testing.T) {
+	handshake := [][]byte{
+		ipv4("10.0.0.1", "10.0.0.2", ipProtocolTCP, tcp(50000, 443, tcpFlagSYN)),
+		ipv4("10.0.0.2", "10.0.0.1", ipProtocolTCP, tcp(443, 50000, tcpFlagSYN|tcpFlagACK)),
+		ipv4("10.0.0.1", "10.0.0.2", ipProtocolTCP, tcp(50000, 443, tcpFlagACK)),
+	}
+	etherFrames := func(packets ...[]byte) [][]byte {
+		frames := [][]byte{}
+		for _, p := range packets {
+			frames = append(frames, ethernet(false, etherTypeIPv4, p))
+		}
+		return frames
+	}
+	loopback := func(order binary.ByteOrder, family uint32, packet []byte) []byte {
+		frame := make([]byte, 4)
+		order.PutUint32(frame, family)
+		return append(frame, packet...)
+	}
+
+	cases := []struct {
+		name      string
+		capture   []byte
+		flows     []string
+		firstSeen time.Time
+	}{
+		{
+			name:      "little endian, microseconds",
+			capture:   pcapFile(binary.LittleEndian, pcapMagicMicroseconds, linkEther, etherFrames(handshake...)...),
+			flows:     []string{"10.0.0.1>10.0.0.2 https 443 120"},
+			firstSeen: time.Unix(captureTime, 500*int64(time.Microsecond)).UTC(),
+		},
+		{
+			//the capture starts with the SYN-ACK, sent by the responder
+			name:      "big endian, nanoseconds",
+			capture:   pcapFile(binary.BigEndian, pcapMagicNanoseconds, linkEther, etherFrames(handshake[1:]...)...),
+			flows:     []string{"10.0.0.1>10.0.0.2 https 443 80"},
+			firstSeen: time.Unix(captureTime, 500).UTC(),
+		},
+		{
+			name: "VLAN",
+			capture: pcapFile(binary.LittleEndian, pcapMagicMicroseconds, linkEther,
+				ethernet(true, etherTypeIPv4, ipv4("10.0.0.1", "10.0.0.53", ipProtocolUDP, udp(53000, 53)))),
+			flows:     []string{"10.0.0.1>10.0.0.53 dns 53/UDP 28"},
+			firstSeen: time.Unix(captureTime, 500*int64(time.Microsecond)).UTC(),
+		},
+		{
+			//the capture starts with a reply from the well-known port
+			name: "Linux cooked capture",
+			capture: pcapFile(binary.LittleEndian, pcapMagicMicroseconds, linkSLL,
+				linuxSLL(etherTypeIPv4, ipv4("10.0.0.5", "10.0.0.1", ipProtocolTCP, tcp(5432, 40000, tcpFlagACK))),
+				linuxSLL(etherTypeIPv4, ipv4("10.0.0.1", "10.0.0.5", ipProtocolTCP, tcp(40000, 5432, tcpFlagACK)))),
+			flows:     []string{"10.0.0.1>10.0.0.5 postgres 5432 80"},
+			firstSeen: time.Unix(captureTime, 500*int64(time.Microsecond)).UTC(),
+		},
+		{
+			name: "loopback",
+			capture: pcapFile(binary.BigEndian, pcapMagicMicroseconds, linkNull,
+				loopback(binary.BigEndian, 2, ipv4("127.0.0.1", "127.0.0.2", ipProtocolICMP, make([]byte, 8))),
+				loopback(binary.BigEndian, 30, ipv6("::1", "::1", ipProtocolUDP, udp(40000, 8125)))),
+			flows:     []string{"127.0.0.1>127.0.0.2 icmp  28", "::1>::1 udp/8125 8125/UDP 48"},
+			firstSeen: time.Unix(captureTime, 500*int64(time.Microsecond)).UTC(),
+		},
+		{
+			//hop-by-hop and destination options before the TCP header
+			name: "IPv6 extension headers",
+			capture: pcapFile(binary.LittleEndian, pcapMagicMicroseconds, linkEther,
+				ethernet(false, etherTypeIPv6, ipv6("fd00::1", "fd00::2", ipProtocolTCP, tcp(50000, 22, tcpFlagSYN), 0, 60))),
+			flows:     []string{"fd00::1>fd00::2 ssh 22 76"},
+			firstSeen: time.Unix(captureTime, 500*int64(time.Microsecond)).UTC(),
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			zf, err := ReadPcap(bytes.NewReader(c.capture))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := describeFlows(zf); !reflect.DeepEqual(got, c.flows) {
+				t.Errorf("got flows %v, want %v", got, c.flows)
+			}
+			if got := zf.Flows()[0].FirstSeen; !got.Equal(c.firstSeen) {
+				t.Errorf("got first seen %v, want %v", got, c.firstSeen)
+			}
+		})
+	}
+}
+
+func TestReadPcapTruncated(t *testing.T) {
+	capture := pcapFile(binary.LittleEndian, pcapMagicMicroseconds, linkEther,
+		ethernet(false, etherTypeIPv4, ipv4("10.0.0.1", "10.0.0.2", ipProtocolTCP, tcp(50000, 443, tcpFlagSYN))),
+		ethernet(false, etherTypeIPv4, ipv4("10.0.0.1", "10.0.0.3", ipProtocolTCP, tcp(50000, 22, tcpFlagSYN))))
+	want := []string{"10.0.0.1>10.0.0.2 https 443 40"}
+
+	//cut in the second frame, and in the header of its record
+	for _, cut := range []int{10, 54 + 8} {
+		zf, err := ReadPcap(bytes.NewReader(capture[:len(capture)-cut]))
+		if err != nil {
+			t.Fatalf("cut %d bytes: %v", cut, err)
+		}
+		if got := describeFlows(zf); !reflect.DeepEqual(got, want) {
+			t.Errorf("cut %d bytes: got flows %v, want %v", cut, got, want)
+		}
+	}
+
+	for _, invalid := range [][]byte{capture[:20], []byte("src,dst\nweb,db\n" + string(make([]byte, 20)))} {
+		if _, err := ReadPcap(bytes.NewReader(invalid)); err == nil {
+			t.Errorf("got no error for %q", invalid)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package ingest
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	otm_transform "github.com/0-trust/service/pkg/otm"
+	"github.com/0-trust/service/pkg/projects"
+	"github.com/dgraph-io/badger/v3"
+)
+
+// ImportIntoProject adds the elements of a draft model that a project's threat model doesn't have yet, and saves the
+// result as a new revision. Cells for the imported elements are added to the visual model, if the project has one, and
+// the rest of the diagram is kept as it is. It returns the saved model and the number of elements added; nothing is
+// saved if none were added
+func ImportIntoProject(pm projects.ProjectManager, projectID string, m *Model, author string) (*projects.Message, int, error) {
+	proj, err := pm.GetProject(projectID)
+	if err != nil {
+		return nil, 0, fmt.Errorf("project %s: %w", projectID, err)
+	}
+	head, err := pm.GetModel(projectID)
+	if err != nil && !errors.Is(err, badger.ErrKeyNotFound) {
+		return nil, 0, err
+	}
+
+	var doc *otm_transform.Document
+	if strings.TrimSpace(head.ThreatModel) == "" {
+		doc, err = otm_transform.NewDocument(otm_transform.ProjectInfo{
+			Name:         proj.Name,
+			ID:           projectID,
+			Description:  proj.Description,
+			Owner:        proj.Owner,
+			OwnerContact: proj.OwnerContact,
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+	} else if doc, err = otm_transform.ParseDocument(head.ThreatModel); err != nil {
+		return nil, 0, err
+	}
+
+	added, err := m.AddTo(doc)
+	if err != nil || added == 0 {
+		return head, added, err
+	}
+	tm, err := doc.String()
+	if err != nil {
+		return nil, 0, err
+	}
+	model, err := doc.Model()
+	if err != nil {
+		return nil, 0, err
+	}
+	visual, err := otm_transform.AddToMxGraph(head.VisualModel, model)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	comment := fmt.Sprintf("Imported %d elements", added)
+	if m.Source != "" {
+		comment += " from " + m.Source
+	}
+	saved, err := pm.UpdateModel(projectID, &projects.Message{
+		ProjectID:    projectID,
+		ThreatModel:  tm,
+		VisualModel:  visual,
+		BaseRevision: head.Revision,
+		Author:       author,
+		Comment:      comment,
+	})
+	return saved, added, err
+}
+
+// CreateProject creates a project whose threat model is a draft model. If the model can't be saved, the project is
+// deleted again rather than left without it
+func CreateProject(pm projects.ProjectManager, description projects.ProjectDescription, m *Model, author string) (*projects.Project, *projects.Message, error) {
+	proj, err := pm.CreateProject(description)
+	if err != nil {
+		return nil, nil, err
+	}
+	saved, _, err := ImportIntoProject(pm, proj.ID, m, author)
+	if err != nil {
+		if e := pm.DeleteProject(proj.ID); e != nil {
+			return nil, nil, fmt.Errorf("%w (and deleting the project %s failed: %v)", err, proj.ID, e)
+		}
+		return nil, nil, err
+	}
+	return proj, saved, nil
+}
@@ -0,0 +1,778 @@
+package ingest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	cloudServicesZoneName = "Cloud services"
+)
+
+var (
+	//resource types that become trust zones: networks and their subnets
+	tfNetworkTypes = map[string]bool{"aws_vpc": true, "google_compute_network": true, "azurerm_virtual_network": true}
+	tfSubnetTypes  = map[string]bool{"aws_subnet": true, "google_compute_subnetwork": true, "azurerm_subnet": true}
+	//resource types that group subnets or attach resources to them, through which components are placed in subnets
+	tfSubnetGroupTypes = map[string]bool{"aws_db_subnet_group": true, "aws_elasticache_subnet_group": true,
+		"aws_redshift_subnet_group": true, "aws_network_interface": true, "azurerm_network_interface": true}
+	tfSecurityGroupTypes = map[string]bool{"aws_security_group": true}
+	tfNSGTypes           = map[string]bool{"azurerm_network_security_group": true}
+	tfNICTypes           = map[string]bool{"azurerm_network_interface": true}
+	//Azure service tags of the internet, in the address prefixes of network security rules
+	azureInternetPrefixes = map[string]bool{"*": true, "internet": true, anywhere: true, "::/0": true}
+	//component types of the resource types that become components
+	tfComponentTypes = map[string]string{
+		"aws_instance": "vm", "aws_autoscaling_group": "vm", "aws_db_instance": "database", "aws_rds_cluster": "database",
+		"aws_dynamodb_table": "database", "aws_redshift_cluster": "database", "aws_elasticache_cluster": "cache",
+		"aws_elasticache_replication_group": "cache", "aws_s3_bucket": "storage bucket", "aws_efs_file_system": "file storage",
+		"aws_lb": "load-balancer", "aws_alb": "load-balancer", "aws_elb": "load-balancer", "aws_lambda_function": "function",
+		"aws_ecs_service": "container service", "aws_eks_cluster": "kubernetes cluster", "aws_sqs_queue": "queue",
+		"aws_sns_topic": "topic", "aws_msk_cluster": "kafka", "aws_api_gateway_rest_api": "api gateway",
+		"aws_apigatewayv2_api": "api gateway", "aws_cloudfront_distribution": "cdn", "aws_opensearch_domain": "database",
+		"google_compute_instance": "vm", "google_sql_database_instance": "database", "google_storage_bucket": "storage bucket",
+		"google_cloudfunctions_function": "function", "google_cloudfunctions2_function": "function",
+		"google_cloud_run_service": "container service", "google_cloud_run_v2_service": "container service",
+		"google_container_cluster": "kubernetes cluster", "google_pubsub_topic": "topic", "google_redis_instance": "cache",
+		"google_compute_forwarding_rule": "load-balancer", "google_compute_global_forwarding_rule": "load-balancer",
+		"azurerm_linux_virtual_machine": "vm", "azurerm_windows_virtual_machine": "vm", "azurerm_virtual_machine": "vm",
+		"azurerm_mssql_server": "database", "azurerm_postgresql_flexible_server": "database",
+		"azurerm_mysql_flexible_server": "database", "azurerm_cosmosdb_account": "database",
+		"azurerm_storage_account": "storage", "azurerm_linux_function_app": "function", "azurerm_windows_function_app": "function",
+		"azurerm_lb": "load-balancer", "azurerm_application_gateway": "gateway", "azurerm_kubernetes_cluster": "kubernetes cluster",
+		"azurerm_redis_cache": "cache", "azurerm_servicebus_namespace": "bus",
+	}
+	//attributes of resources that refer to their subnets, networks and security groups
+	tfSubnetAttributes = []string{"subnet_id", "subnet_ids", "subnets", "subnetwork", "subnet_mapping", "vpc_config",
+		"vpc_zone_identifier", "network_configuration", "network_interface", "network_interface_ids", "ip_configuration",
+		"db_subnet_group_name", "subnet_group_name", "cluster_subnet_group_name", "delegated_subnet_id",
+		"default_node_pool", "gateway_ip_configuration", "frontend_ip_configuration", "vpc_access"}
+	tfNetworkAttributes       = []string{"vpc_id", "network", "virtual_network_name", "network_interface"}
+	tfSecurityGroupAttributes = []string{"vpc_security_group_ids", "security_groups", "security_group_ids",
+		"vpc_config", "network_configuration"}
+	tfIndex = regexp.MustCompile(`\[[^\]]*\]$`)
+)
+
+// TerraformJSON is the JSON representation of Terraform state or of a plan, as output by terraform show -json
+type TerraformJSON struct {
+	Values        *tfValues `json:"values"`
+	PlannedValues *tfValues `json:"planned_values"`
+	Configuration struct {
+		RootModule tfConfigModule `json:"root_module"`
+	} `json:"configuration"`
+}
+
+type tfValues struct {
+	RootModule tfModule `json:"root_module"`
+}
+
+type tfModule struct {
+	Resources    []tfResource `json:"resources"`
+	ChildModules []tfModule   `json:"child_modules"`
+}
+
+type tfResource struct {
+	Address string                 `json:"address"`
+	Mode    string                 `json:"mode"`
+	Type    string                 `json:"type"`
+	Name    string                 `json:"name"`
+	Values  map[string]interface{} `json:"values"`
+}
+
+type tfConfigModule struct {
+	Resources []struct {
+		Address     string                 `json:"address"`
+		Expressions map[string]interface{} `json:"expressions"`
+	} `json:"resources"`
+	ModuleCalls map[string]struct {
+		Module tfConfigModule `json:"module"`
+	} `json:"module_calls"`
+}
+
+// tfRule is a rule of a security group or firewall allowing traffic to (ingress) or from (egress) its members
+type tfRule struct {
+	address  string
+	ingress  bool
+	members  []*Component
+	peers    []*Component
+	protocol string
+	ports    string
+}
+
+// ReadTerraformJSON reads Terraform state or a plan in the JSON output of terraform show -json
+func ReadTerraformJSON(in io.Reader) (*TerraformJSON, error) {
+	var tf TerraformJSON
+	if err := json.NewDecoder(in).Decode(&tf); err != nil {
+		return nil, err
+	}
+	if tf.Values == nil && tf.PlannedValues == nil {
+		return nil, errors.New("neither state values nor planned values found, expected the output of terraform show -json")
+	}
+	return &tf, nil
+}
+
+// tfDraft is a draft model being inferred from Terraform resources
+type tfDraft struct {
+	*Model
+	resources  []tfResource
+	byAddress  map[string][]int               //resources by address without index, e.g. aws_subnet.private for aws_subnet.private[0]
+	byID       map[string]int                 //resources by their id, arn, name and self_link values
+	references map[string]map[string][]string //resources that the configuration refers to, by address and attribute
+	zones      map[int]string                 //trust zone IDs of network and subnet resources
+	components map[int]*Component             //components of resources
+	networkOf  map[int]int                    //network resources of subnet resources
+	placement  map[*Component][]int           //network and subnet resources of components
+}
+
+// DraftModel infers a draft threat model from the resources, planned or in state: networks (VPCs and virtual networks)
+// and their subnets become nested trust zones, and instances, databases, load balancers, functions, queues, buckets
+// and other services become components in the subnets they are placed in, or else in a zone of cloud services.
+// Data flows are inferred from the rules of AWS security groups, Google Cloud firewalls and Azure network security
+// groups, between the members of the groups or the tagged instances, and the CIDRs they allow (the internet for
+// 0.0.0.0/0). The default egress rule allowing all traffic anywhere is ignored. IDs are derived from Terraform resource addresses, so that importing a
+// changed configuration into a project only adds the new elements
+func (tf *TerraformJSON) DraftModel(source string) *Model {
+	d := &tfDraft{
+		Model:      NewModel(source),
+		byAddress:  make(map[string][]int),
+		byID:       make(map[string]int),
+		references: make(map[string]map[string][]string),
+		zones:      make(map[int]string),
+		components: make(map[int]*Component),
+		networkOf:  make(map[int]int),
+		placement:  make(map[*Component][]int),
+	}
+	values := tf.PlannedValues
+	if values == nil {
+		values = tf.Values
+	}
+	d.addResources(values.RootModule)
+	d.addReferences(tf.Configuration.RootModule, "")
+
+	for i, r := range d.resources {
+		if tfNetworkTypes[r.Type] {
+			d.zones[i] = d.NewID(r.Address)
+			tz := d.AddTrustZone(d.zones[i], resourceName(r))
+			tz.Type = "network"
+			d.annotate(tz.Attributes, r)
+		}
+	}
+	for i, r := range d.resources {
+		if tfSubnetTypes[r.Type] {
+			d.zones[i] = d.NewID(r.Address)
+			tz := d.AddTrustZone(d.zones[i], resourceName(r))
+			tz.Type = "subnet"
+			d.annotate(tz.Attributes, r)
+			if public, _ := r.Values["map_public_ip_on_launch"].(bool); public {
+				tz.Type = "public subnet"
+				tz.Risk.TrustRating = untrustedZoneRating
+			}
+			for _, n := range d.links(i, tfNetworkAttributes, tfNetworkTypes) {
+				d.networkOf[i] = n
+				tz.Parent = &Parent{TrustZone: d.zones[n]}
+				break
+			}
+		}
+	}
+	for i, r := range d.resources {
+		if componentType, exists := tfComponentTypes[r.Type]; exists && r.Mode != "data" {
+			d.addComponent(i, componentType)
+		}
+	}
+
+	rules := []tfRule{}
+	for i, r := range d.resources {
+		switch {
+		case tfSecurityGroupTypes[r.Type]:
+			rules = append(rules, d.securityGroupRules(i)...)
+		case r.Type == "aws_security_group_rule", r.Type == "aws_vpc_security_group_ingress_rule",
+			r.Type == "aws_vpc_security_group_egress_rule":
+			rules = append(rules, d.securityGroupRule(i)...)
+		case r.Type == "google_compute_firewall":
+			rules = append(rules, d.firewallRules(i)...)
+		case tfNSGTypes[r.Type]:
+			blocks, _ := r.Values["security_rule"].([]interface{})
+			for _, b := range blocks {
+				block, _ := b.(map[string]interface{})
+				rules = append(rules, d.nsgRules(r.Address, i, block)...)
+			}
+		case r.Type == "azurerm_network_security_rule":
+			for _, group := range d.links(i, []string{"network_security_group_name"}, tfNSGTypes) {
+				rules = append(rules, d.nsgRules(r.Address, group, r.Values)...)
+			}
+		}
+	}
+	for _, rule := range rules {
+		var ports []string
+		if rule.ports != "" {
+			ports = []string{rule.ports}
+		}
+		for _, member := range rule.members {
+			for _, peer := range rule.peers {
+				source, destination := peer, member
+				if !rule.ingress {
+					source, destination = member, peer
+				}
+				if source != destination {
+					df := d.flow(source, destination, rule.address, ports)
+					if rule.protocol != "" {
+						df.Attributes["protocol"] = rule.protocol
+					}
+				}
+			}
+		}
+	}
+	return d.Model
+}
+
+func (d *tfDraft) addResources(m tfModule) {
+	for _, r := range m.Resources {
+		i := len(d.resources)
+		d.resources = append(d.resources, r)
+		base := tfIndex.ReplaceAllString(r.Address, "")
+		d.byAddress[base] = append(d.byAddress[base], i)
+		for _, k := range []string{"id", "arn", "name", "self_link"} {
+			if id, isString := r.Values[k].(string); isString && id != "" {
+				if _, exists := d.byID[id]; !exists || k != "name" {
+					d.byID[id] = i
+				}
+			}
+		}
+	}
+	for _, child := range m.ChildModules {
+		d.addResources(child)
+	}
+}
+
+// addReferences collects the resources that the expressions of the configuration refer to, by attribute, which
+// link resources whose IDs are not known until they are applied
+func (d *tfDraft) addReferences(m tfConfigModule, prefix string) {
+	for _, r := range m.Resources {
+		refs := make(map[string][]string)
+		for attr, expr := range r.Expressions {
+			refs[attr] = expressionReferences(expr, prefix)
+		}
+		d.references[prefix+r.Address] = refs
+	}
+	for _, name := range sortedKeys(m.ModuleCalls) {
+		d.addReferences(m.ModuleCalls[name].Module, prefix+"module."+name+".")
+	}
+}
+
+func expressionReferences(expr interface{}, prefix string) []string {
+	refs := []string{}
+	switch e := expr.(type) {
+	case map[string]interface{}:
+		for k, v := range e {
+			if list, isList := v.([]interface{}); k == "references" && isList {
+				for _, ref := range list {
+					if s, isString := ref.(string); isString {
+						refs = append(refs, prefix+s)
+					}
+				}
+			} else {
+				refs = append(refs, expressionReferences(v, prefix)...)
+			}
+		}
+	case []interface{}:
+		for _, v := range e {
+			refs = append(refs, expressionReferences(v, prefix)...)
+		}
+	}
+	return refs
+}
+
+// links returns the resources of the given types that a resource refers to in some attributes, by their IDs in the
+// values of the attributes, or by the references of the configuration
+func (d *tfDraft) links(i int, attributes []string, types map[string]bool) []int {
+	linked := []int{}
+	seen := make(map[int]bool)
+	add := func(j int) {
+		if !seen[j] && types[d.resources[j].Type] {
+			seen[j] = true
+			linked = append(linked, j)
+		}
+	}
+	r := d.resources[i]
+	refs := d.references[tfIndex.ReplaceAllString(r.Address, "")]
+	for _, attr := range attributes {
+		for _, s := range stringValues(r.Values[attr]) {
+			if j, exists := d.byID[s]; exists {
+				add(j)
+			}
+		}
+		for _, ref := range refs[attr] {
+			parts := strings.Split(ref, ".")
+			for n := len(parts); n > 1; n-- {
+				if resources, exists := d.byAddress[tfIndex.ReplaceAllString(strings.Join(parts[:n], "."), "")]; exists {
+					for _, j := range resources {
+						add(j)
+					}
+					break
+				}
+			}
+		}
+	}
+	return linked
+}
+
+func stringValues(v interface{}) []string {
+	switch value := v.(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		values := []string{}
+		for _, e := range value {
+			values = append(values, stringValues(e)...)
+		}
+		return values
+	case map[string]interface{}:
+		values := []string{}
+		for _, k := range sortedKeys(value) {
+			values = append(values, stringValues(value[k])...)
+		}
+		return values
+	}
+	return nil
+}
+
+func (d *tfDraft) addComponent(i int, componentType string) {
+	r := d.resources[i]
+	subnets := []int{}
+	for _, s := range d.links(i, tfSubnetAttributes, mergeTypes(tfSubnetTypes, tfSubnetGroupTypes)) {
+		if tfSubnetGroupTypes[d.resources[s].Type] {
+			subnets = append(subnets, d.links(s, tfSubnetAttributes, tfSubnetTypes)...)
+		} else {
+			subnets = append(subnets, s)
+		}
+	}
+	zone := ""
+	placement := subnets
+	if len(subnets) > 0 {
+		zone = d.zones[subnets[0]]
+	} else if networks := d.links(i, tfNetworkAttributes, tfNetworkTypes); len(networks) > 0 {
+		zone, placement = d.zones[networks[0]], networks
+	} else {
+		zone = d.cloudServicesZone()
+	}
+	for _, s := range subnets {
+		if n, exists := d.networkOf[s]; exists {
+			placement = append(placement, n)
+		}
+	}
+
+	c := d.AddComponent(d.NewID(r.Address), resourceName(r), componentType, zone)
+	d.annotate(c.Attributes, r)
+	for attr, value := range map[string]string{
+		"publicIP":           fmt.Sprint(r.Values["public_ip"]),
+		"publiclyAccessible": fmt.Sprint(r.Values["publicly_accessible"]),
+		"encrypted":          fmt.Sprint(r.Values["storage_encrypted"]),
+	} {
+		if value != "" && value != "<nil>" && value != "false" {
+			c.Attributes[attr] = value
+		}
+	}
+	if internal, isBool := r.Values["internal"].(bool); isBool && !internal {
+		c.Attributes["internetFacing"] = "true"
+	}
+	d.components[i] = c
+	d.placement[c] = placement
+}
+
+func (d *tfDraft) cloudServicesZone() string {
+	for _, tz := range d.TrustZones {
+		if tz.Name == cloudServicesZoneName {
+			return tz.ID
+		}
+	}
+	return d.AddTrustZone(d.NewID(cloudServicesZoneName), cloudServicesZoneName).ID
+}
+
+func (d *tfDraft) annotate(attributes map[string]string, r tfResource) {
+	attributes["terraformAddress"] = r.Address
+	attributes["resourceType"] = r.Type
+	for _, k := range []string{"cidr_block", "ip_cidr_range"} {
+		if cidr, isString := r.Values[k].(string); isString && cidr != "" {
+			attributes["cidr"] = cidr
+		}
+	}
+}
+
+// members are the components whose security group attributes refer to a security group
+func (d *tfDraft) members(group int) []*Component {
+	members := []*Component{}
+	for _, i := range d.sortedComponents() {
+		for _, g := range d.links(i, tfSecurityGroupAttributes, tfSecurityGroupTypes) {
+			if g == group {
+				members = append(members, d.components[i])
+			}
+		}
+	}
+	return members
+}
+
+// cidrPeers are the components that the CIDRs of a rule allow: those in networks or subnets with the same CIDRs, or
+// else the components of external networks
+func (d *tfDraft) cidrPeers(cidrs []string) []*Component {
+	peers := []*Component{}
+	for _, cidr := range cidrs {
+		matched := false
+		for _, i := range d.sortedComponents() {
+			for _, z := range d.placement[d.components[i]] {
+				if d.resources[z].Values["cidr_block"] == cidr || d.resources[z].Values["ip_cidr_range"] == cidr {
+					peers = append(peers, d.components[i])
+					matched = true
+					break
+				}
+			}
+		}
+		if !matched {
+			peers = append(peers, d.external(cidr))
+		}
+	}
+	return peers
+}
+
+func (d *tfDraft) sortedComponents() []int {
+	indices := []int{}
+	for i := range d.components {
+		indices = append(indices, i)
+	}
+	sort.Ints(indices)
+	return indices
+}
+
+// securityGroupRules are the inline ingress and egress rules of an AWS security group
+func (d *tfDraft) securityGroupRules(group int) []tfRule {
+	r := d.resources[group]
+	members := d.members(group)
+	rules := []tfRule{}
+	for _, direction := range []string{"ingress", "egress"} {
+		blocks, _ := r.Values[direction].([]interface{})
+		for _, b := range blocks {
+			block, _ := b.(map[string]interface{})
+			sources := []int{}
+			for _, s := range stringValues(block["security_groups"]) {
+				if g, exists := d.byID[s]; exists {
+					sources = append(sources, g)
+				}
+			}
+			rule, keep := d.awsRule(r.Address, direction == "ingress", members, sources, block)
+			if keep {
+				rules = append(rules, rule)
+			}
+		}
+	}
+	return rules
+}
+
+// securityGroupRule is a rule of an AWS security group defined as a resource of its own
+func (d *tfDraft) securityGroupRule(i int) []tfRule {
+	r := d.resources[i]
+	ingress := r.Values["type"] == "ingress" || r.Type == "aws_vpc_security_group_ingress_rule"
+	sources := d.links(i, []string{"source_security_group_id", "referenced_security_group_id"}, tfSecurityGroupTypes)
+	for _, group := range d.links(i, []string{"security_group_id"}, tfSecurityGroupTypes) {
+		if rule, keep := d.awsRule(r.Address, ingress, d.members(group), sources, r.Values); keep {
+			return []tfRule{rule}
+		}
+	}
+	return nil
+}
+
+// awsRule is a rule allowing traffic between the members of a security group and the members of other (source)
+// security groups or CIDRs
+func (d *tfDraft) awsRule(address string, ingress bool, members []*Component, sources []int, values map[string]interface{}) (tfRule, bool) {
+	rule := tfRule{address: address, ingress: ingress, members: members}
+	protocol := strings.ToLower(fmt.Sprint(firstValue(values, "protocol", "ip_protocol")))
+	from, to := intValue(values["from_port"]), intValue(values["to_port"])
+	rule.protocol, rule.ports = ruleProtocol(protocol, from, to)
+
+	cidrs := append(stringValues(values["cidr_blocks"]), stringValues(values["ipv6_cidr_blocks"])...)
+	cidrs = append(cidrs, stringValues(values["cidr_ipv4"])...)
+	cidrs = append(cidrs, stringValues(values["cidr_ipv6"])...)
+	if !ingress && rule.ports == "" && rule.protocol == "" && len(cidrs) > 0 && (cidrs[0] == anywhere || cidrs[0] == "::/0") {
+		return rule, false //the default egress rule
+	}
+	rule.peers = d.cidrPeers(cidrs)
+	for _, g := range sources {
+		rule.peers = append(rule.peers, d.members(g)...)
+	}
+	if self, _ := values["self"].(bool); self {
+		rule.peers = append(rule.peers, members...)
+	}
+	return rule, true
+}
+
+// firewallRules are the rules of a Google Cloud firewall, between the instances in its network with its target and
+// source tags, or all the instances of the network if it has no target tags
+func (d *tfDraft) firewallRules(i int) []tfRule {
+	r := d.resources[i]
+	if r.Values["disabled"] == true {
+		return nil
+	}
+	network := d.links(i, []string{"network"}, tfNetworkTypes)
+	tagged := func(tags []string) []*Component {
+		components := []*Component{}
+		for _, j := range d.sortedComponents() {
+			c := d.components[j]
+			inNetwork := len(network) == 0
+			for _, z := range d.placement[c] {
+				inNetwork = inNetwork || z == network[0]
+			}
+			if !inNetwork {
+				continue
+			}
+			if len(tags) == 0 {
+				components = append(components, c)
+				continue
+			}
+			for _, t := range stringValues(d.resources[j].Values["tags"]) {
+				if contains(tags, t) {
+					components = append(components, c)
+					break
+				}
+			}
+		}
+		return components
+	}
+
+	ingress := !strings.EqualFold(fmt.Sprint(r.Values["direction"]), "EGRESS")
+	rule := tfRule{address: r.Address, ingress: ingress, members: tagged(stringValues(r.Values["target_tags"]))}
+	if ingress {
+		rule.peers = d.cidrPeers(stringValues(r.Values["source_ranges"]))
+		if sourceTags := stringValues(r.Values["source_tags"]); len(sourceTags) > 0 {
+			rule.peers = append(rule.peers, tagged(sourceTags)...)
+		}
+	} else {
+		rule.peers = d.cidrPeers(stringValues(r.Values["destination_ranges"]))
+	}
+
+	rules := []tfRule{}
+	allows, _ := r.Values["allow"].([]interface{})
+	for _, a := range allows {
+		allow, _ := a.(map[string]interface{})
+		protocol := strings.ToLower(fmt.Sprint(allow["protocol"]))
+		ports := stringValues(allow["ports"])
+		if len(ports) == 0 {
+			ports = []string{""}
+		}
+		for _, p := range ports {
+			rule := rule
+			rule.protocol, rule.ports = ruleProtocol(protocol, -1, -1)
+			if p != "" {
+				rule.ports = portSpec(p, rule.protocol)
+				if rule.protocol == "tcp" || rule.protocol == "udp" {
+					rule.protocol = ""
+				}
+			}
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// nsgRules are the flows that a rule of an Azure network security group allows, between the components of the subnets
+// and network interfaces the group is associated with and the address prefixes of the rule: CIDRs, the internet (*
+// or Internet) or the components in the same virtual networks (VirtualNetwork). Rules are taken to allow what they
+// say regardless of their priority, Deny rules are ignored, and so are other service tags and application security
+// groups, which don't identify components of the model
+func (d *tfDraft) nsgRules(address string, group int, values map[string]interface{}) []tfRule {
+	if !strings.EqualFold(fmt.Sprint(values["access"]), "Allow") {
+		return nil
+	}
+	ingress := !strings.EqualFold(fmt.Sprint(values["direction"]), "Outbound")
+	members := d.nsgMembers(group)
+	prefixes := append(stringValues(values["source_address_prefix"]), stringValues(values["source_address_prefixes"])...)
+	if !ingress {
+		prefixes = append(stringValues(values["destination_address_prefix"]), stringValues(values["destination_address_prefixes"])...)
+	}
+	ports := append(stringValues(values["destination_port_range"]), stringValues(values["destination_port_ranges"])...)
+	if len(ports) == 0 || contains(ports, "*") {
+		ports = []string{""}
+	}
+	protocol := strings.ToLower(fmt.Sprint(values["protocol"]))
+	if protocol == "*" {
+		protocol = "all"
+	}
+
+	rule := tfRule{address: address, ingress: ingress, members: members}
+	cidrs := []string{}
+	for _, prefix := range prefixes {
+		switch {
+		case azureInternetPrefixes[strings.ToLower(prefix)]:
+			cidrs = append(cidrs, anywhere)
+		case strings.EqualFold(prefix, "VirtualNetwork"):
+			rule.peers = append(rule.peers, d.networkPeers(members)...)
+		case strings.Contains(prefix, "/") || net.ParseIP(prefix) != nil:
+			cidrs = append(cidrs, prefix)
+		}
+	}
+	if !ingress && ports[0] == "" && protocol == "all" && len(cidrs) == 1 && cidrs[0] == anywhere && len(rule.peers) == 0 {
+		return nil //allowing all traffic anywhere, like the default outbound rules
+	}
+	rule.peers = append(d.cidrPeers(cidrs), rule.peers...)
+
+	rules := []tfRule{}
+	for _, p := range ports {
+		rule := rule
+		rule.protocol, rule.ports = ruleProtocol(protocol, -1, -1)
+		if p != "" {
+			rule.ports = portSpec(p, rule.protocol)
+			if rule.protocol == "tcp" || rule.protocol == "udp" {
+				rule.protocol = ""
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// nsgMembers are the components in the subnets, or with the network interfaces, that an Azure network security group
+// is associated with
+func (d *tfDraft) nsgMembers(group int) []*Component {
+	subnets, nics := make(map[int]bool), make(map[int]bool)
+	for j, r := range d.resources {
+		switch r.Type {
+		case "azurerm_subnet_network_security_group_association", "azurerm_network_interface_security_group_association":
+		case "azurerm_subnet":
+			//older providers associate the group with the subnet
+			for _, g := range d.links(j, []string{"network_security_group_id"}, tfNSGTypes) {
+				if g == group {
+					subnets[j] = true
+				}
+			}
+			continue
+		default:
+			continue
+		}
+		for _, g := range d.links(j, []string{"network_security_group_id"}, tfNSGTypes) {
+			if g != group {
+				continue
+			}
+			for _, s := range d.links(j, []string{"subnet_id"}, tfSubnetTypes) {
+				subnets[s] = true
+			}
+			for _, n := range d.links(j, []string{"network_interface_id"}, tfNICTypes) {
+				nics[n] = true
+			}
+		}
+	}
+
+	members := []*Component{}
+	for _, i := range d.sortedComponents() {
+		c := d.components[i]
+		member := false
+		for _, z := range d.placement[c] {
+			member = member || subnets[z]
+		}
+		for _, n := range d.links(i, tfSubnetAttributes, tfNICTypes) {
+			member = member || nics[n]
+		}
+		if member {
+			members = append(members, c)
+		}
+	}
+	return members
+}
+
+// networkPeers are the components in the networks of some components
+func (d *tfDraft) networkPeers(components []*Component) []*Component {
+	networks := make(map[int]bool)
+	for _, c := range components {
+		for _, z := range d.placement[c] {
+			if tfNetworkTypes[d.resources[z].Type] {
+				networks[z] = true
+			}
+		}
+	}
+	peers := []*Component{}
+	for _, i := range d.sortedComponents() {
+		for _, z := range d.placement[d.components[i]] {
+			if networks[z] {
+				peers = append(peers, d.components[i])
+				break
+			}
+		}
+	}
+	return peers
+}
+
+// ruleProtocol formats the protocol and port range of a rule like the attributes of data flows: no protocol for TCP
+// and UDP, whose ports carry it (e.g. 443 or 53/UDP), and no ports for all of them
+func ruleProtocol(protocol string, from, to int) (string, string) {
+	switch protocol {
+	case "-1", "all", "<nil>", "":
+		return "", ""
+	case "6", "tcp", "17", "udp":
+		if protocol == "6" {
+			protocol = "tcp"
+		} else if protocol == "17" {
+			protocol = "udp"
+		}
+		if from < 0 || (from <= 0 && to >= 65535) {
+			return protocol, ""
+		}
+		port := strconv.Itoa(from)
+		if to > from {
+			port = fmt.Sprintf("%d-%d", from, to)
+		}
+		return "", portSpec(port, protocol)
+	}
+	return protocol, ""
+}
+
+func resourceName(r tfResource) string {
+	if tags, isMap := r.Values["tags"].(map[string]interface{}); isMap {
+		if name, isString := tags["Name"].(string); isString && name != "" {
+			return name
+		}
+	}
+	return r.Address
+}
+
+func firstValue(values map[string]interface{}, keys ...string) interface{} {
+	for _, k := range keys {
+		if v, exists := values[k]; exists && v != nil {
+			return v
+		}
+	}
+	return nil
+}
+
+func intValue(v interface{}) int {
+	switch n := v.(type) {
+	case float64:
+		return int(n)
+	case string:
+		if i, err := strconv.Atoi(n); err == nil {
+			return i
+		}
+	}
+	return -1
+}
+
+func mergeTypes(types ...map[string]bool) map[string]bool {
+	merged := make(map[string]bool)
+	for _, t := range types {
+		for k := range t {
+			merged[k] = true
+		}
+	}
+	return merged
+}
+
+func contains(list []string, item string) bool {
+	for _, i := range list {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
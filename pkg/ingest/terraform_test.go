@@ -0,0 +1,175 @@
+package ingest
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const awsState = `{
+  "format_version": "1.0",
+  "values": {
+    "root_module": {
+      "resources": [
+        {"address": "aws_vpc.main", "mode": "managed", "type": "aws_vpc", "name": "main",
+          "values": {"id": "vpc-1", "cidr_block": "10.0.0.0/16", "tags": {"Name": "main"}}},
+        {"address": "aws_subnet.public[0]", "mode": "managed", "type": "aws_subnet", "name": "public",
+          "values": {"id": "subnet-1", "vpc_id": "vpc-1", "cidr_block": "10.0.1.0/24", "map_public_ip_on_launch": true}},
+        {"address": "aws_subnet.private", "mode": "managed", "type": "aws_subnet", "name": "private",
+          "values": {"id": "subnet-2", "vpc_id": "vpc-1", "cidr_block": "10.0.2.0/24"}},
+        {"address": "aws_security_group.web", "mode": "managed", "type": "aws_security_group", "name": "web",
+          "values": {"id": "sg-web", "vpc_id": "vpc-1",
+            "ingress": [{"from_port": 443, "to_port": 443, "protocol": "tcp", "cidr_blocks": ["0.0.0.0/0"]}],
+            "egress": [{"from_port": 0, "to_port": 0, "protocol": "-1", "cidr_blocks": ["0.0.0.0/0"]}]}},
+        {"address": "aws_security_group.db", "mode": "managed", "type": "aws_security_group", "name": "db",
+          "values": {"id": "sg-db", "vpc_id": "vpc-1",
+            "ingress": [{"from_port": 5432, "to_port": 5432, "protocol": "tcp", "security_groups": ["sg-web"]}]}},
+        %s{"address": "aws_instance.web[0]", "mode": "managed", "type": "aws_instance", "name": "web",
+          "values": {"id": "i-1", "subnet_id": "subnet-1", "vpc_security_group_ids": ["sg-web"], "public_ip": "203.0.113.10"}},
+        {"address": "aws_db_subnet_group.db", "mode": "managed", "type": "aws_db_subnet_group", "name": "db",
+          "values": {"id": "db-subnets", "name": "db-subnets", "subnet_ids": ["subnet-2"]}},
+        {"address": "aws_s3_bucket.logs", "mode": "managed", "type": "aws_s3_bucket", "name": "logs",
+          "values": {"id": "logs", "bucket": "logs"}}
+      ],
+      "child_modules": [
+        {"resources": [
+          {"address": "module.data.aws_db_instance.main", "mode": "managed", "type": "aws_db_instance", "name": "main",
+            "values": {"id": "db-1", "db_subnet_group_name": "db-subnets", "vpc_security_group_ids": ["sg-db"],
+              "storage_encrypted": true}}
+        ]}
+      ]
+    }
+  }
+}`
+
+const azureState = `{
+  "values": {
+    "root_module": {
+      "resources": [
+        {"address": "azurerm_virtual_network.main", "type": "azurerm_virtual_network",
+          "values": {"id": "/vnet", "name": "main"}},
+        {"address": "azurerm_subnet.app", "type": "azurerm_subnet",
+          "values": {"id": "/subnet-app", "name": "app", "virtual_network_name": "main"}},
+        {"address": "azurerm_network_security_group.app", "type": "azurerm_network_security_group",
+          "values": {"id": "/nsg", "name": "app-nsg", "security_rule": [
+            {"access": "Allow", "direction": "Inbound", "protocol": "Tcp", "source_address_prefix": "Internet",
+              "destination_port_range": "443"},
+            {"access": "Allow", "direction": "Inbound", "protocol": "*", "source_address_prefix": "VirtualNetwork",
+              "destination_port_ranges": ["22", "3389"]},
+            {"access": "Deny", "direction": "Inbound", "protocol": "*", "source_address_prefix": "*",
+              "destination_port_range": "*"}
+          ]}},
+        {"address": "azurerm_subnet_network_security_group_association.app",
+          "type": "azurerm_subnet_network_security_group_association",
+          "values": {"id": "/association", "subnet_id": "/subnet-app", "network_security_group_id": "/nsg"}},
+        {"address": "azurerm_network_interface.vm", "type": "azurerm_network_interface",
+          "values": {"id": "/nic-vm", "ip_configuration": [{"subnet_id": "/subnet-app"}]}},
+        {"address": "azurerm_network_interface.jump", "type": "azurerm_network_interface",
+          "values": {"id": "/nic-jump", "ip_configuration": [{"subnet_id": "/subnet-app"}]}},
+        {"address": "azurerm_linux_virtual_machine.vm", "type": "azurerm_linux_virtual_machine",
+          "values": {"id": "/vm", "network_interface_ids": ["/nic-vm"]}},
+        {"address": "azurerm_linux_virtual_machine.jump", "type": "azurerm_linux_virtual_machine",
+          "values": {"id": "/jump", "network_interface_ids": ["/nic-jump"]}}
+      ]
+    }
+  }
+}`
+
+func readTerraform(t *testing.T, state string) *Model {
+	t.Helper()
+	tf, err := ReadTerraformJSON(strings.NewReader(state))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tf.DraftModel("terraform.json")
+}
+
+func TestTerraformDraftModel(t *testing.T) {
+	cases := []struct {
+		name                     string
+		state                    string
+		zones, components, flows []string
+	}{
+		{
+			name:  "AWS",
+			state: strings.Replace(awsState, "%s", "", 1),
+			zones: []string{"aws_vpc.main", "aws_subnet.public-0", "aws_subnet.private", "cloud-services", "internet"},
+			components: []string{
+				"aws_instance.web-0:vm@aws_subnet.public-0",
+				"aws_s3_bucket.logs:storage bucket@cloud-services",
+				"module.data.aws_db_instance.main:database@aws_subnet.private", //placed through its DB subnet group
+				"internet-clients:client@internet",
+			},
+			flows: []string{
+				"internet-clients>aws_instance.web-0 443",
+				"aws_instance.web-0>module.data.aws_db_instance.main 5432", //the default egress rule is ignored
+			},
+		},
+		{
+			name:       "Azure",
+			state:      azureState,
+			zones:      []string{"azurerm_virtual_network.main", "azurerm_subnet.app", "internet"},
+			components: []string{"azurerm_linux_virtual_machine.vm:vm@azurerm_subnet.app", "azurerm_linux_virtual_machine.jump:vm@azurerm_subnet.app", "internet-clients:client@internet"},
+			flows: []string{
+				"internet-clients>azurerm_linux_virtual_machine.vm 443",
+				"internet-clients>azurerm_linux_virtual_machine.jump 443",
+				"azurerm_linux_virtual_machine.jump>azurerm_linux_virtual_machine.vm 22,3389", //VirtualNetwork
+				"azurerm_linux_virtual_machine.vm>azurerm_linux_virtual_machine.jump 22,3389",
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			zones, components, flows := draftElements(readTerraform(t, c.state))
+			if !reflect.DeepEqual(zones, c.zones) {
+				t.Errorf("got zones %v, want %v", zones, c.zones)
+			}
+			if !reflect.DeepEqual(components, c.components) {
+				t.Errorf("got components %v, want %v", components, c.components)
+			}
+			if !reflect.DeepEqual(flows, c.flows) {
+				t.Errorf("got flows %v, want %v", flows, c.flows)
+			}
+		})
+	}
+
+	m := readTerraform(t, strings.Replace(awsState, "%s", "", 1))
+	if public := m.TrustZones[1]; public.Type != "public subnet" || public.Risk.TrustRating != untrustedZoneRating ||
+		public.Parent == nil || public.Parent.TrustZone != "aws_vpc.main" {
+		t.Errorf("the public subnet should be an untrusted zone in the VPC: %+v", public)
+	}
+	db := m.Components[2]
+	for k, want := range map[string]string{"terraformAddress": "module.data.aws_db_instance.main", "encrypted": "true"} {
+		if got := db.Attributes[k]; got != want {
+			t.Errorf("got database attribute %s %q, want %q", k, got, want)
+		}
+	}
+}
+
+func TestTerraformIDs(t *testing.T) {
+	//the IDs are those of the resource addresses, whatever else the configuration has
+	added := `{"address": "aws_instance.web", "mode": "managed", "type": "aws_instance", "name": "web",
+          "values": {"id": "i-0", "subnet_id": "subnet-1"}},
+        `
+	before := readTerraform(t, strings.Replace(awsState, "%s", "", 1))
+	after := readTerraform(t, strings.Replace(awsState, "%s", added, 1))
+
+	ids := make(map[string]bool)
+	for _, c := range after.Components {
+		ids[c.ID] = true
+	}
+	for _, c := range before.Components {
+		if !ids[c.ID] {
+			t.Errorf("component %s has another ID after adding a resource: %v", c.ID, ids)
+		}
+	}
+	if !ids["aws_instance.web"] {
+		t.Errorf("the added instance should have the ID of its address: %v", ids)
+	}
+}
+
+func TestReadTerraformJSONRejectsOtherJSON(t *testing.T) {
+	if _, err := ReadTerraformJSON(strings.NewReader(`{"resources": []}`)); err == nil {
+		t.Error("got no error for JSON without state or planned values")
+	}
+}
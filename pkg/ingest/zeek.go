@@ -0,0 +1,136 @@
+package ingest
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	zeekUnsetField = "-"
+	maxZeekLine    = 1 << 20
+)
+
+var (
+	//Zeek service names that are named differently in the protocol attributes of data flows
+	zeekServices = map[string]string{"ssl": "tls", "krb": "kerberos", "krb_tcp": "kerberos", "postgresql": "postgres"}
+)
+
+// ReadZeekConnLog reads the connections of a Zeek conn.log, in Zeek's tab separated format or as JSON lines, and
+// aggregates them into flows from the originating to the responding host, by protocol and port. The label of a flow is
+// the service that Zeek detected, or else the service of a well-known port, or the transport protocol and port
+func ReadZeekConnLog(in io.Reader) (ZoneFlows, error) {
+	conns := newConnections()
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), maxZeekLine)
+	separator, unset := "\t", zeekUnsetField
+	var columns map[string]int
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+
+		var field func(name string) string
+		if strings.HasPrefix(text, "{") {
+			record := make(map[string]interface{})
+			dec := json.NewDecoder(strings.NewReader(text))
+			dec.UseNumber()
+			if err := dec.Decode(&record); err != nil {
+				return ZoneFlows{}, fmt.Errorf("line %d: %w", line, err)
+			}
+			field = func(name string) string {
+				if v, exists := record[name]; exists && v != nil {
+					return fmt.Sprint(v)
+				}
+				return ""
+			}
+		} else if strings.HasPrefix(text, "#") {
+			directive, value, _ := strings.Cut(text, " ")
+			if directive != "#separator" {
+				directive, value, _ = strings.Cut(text, separator)
+			}
+			switch directive {
+			case "#separator":
+				if s, err := strconv.Unquote(`"` + value + `"`); err == nil && s != "" {
+					separator = s
+				}
+			case "#unset_field":
+				unset = value
+			case "#fields":
+				columns = make(map[string]int)
+				for i, name := range strings.Split(value, separator) {
+					columns[name] = i
+				}
+			}
+			continue
+		} else {
+			if columns == nil {
+				return ZoneFlows{}, fmt.Errorf("line %d: the log has no #fields header", line)
+			}
+			values := strings.Split(text, separator)
+			field = func(name string) string {
+				if i, exists := columns[name]; exists && i < len(values) && values[i] != unset {
+					return values[i]
+				}
+				return ""
+			}
+		}
+
+		source, target := field("id.orig_h"), field("id.resp_h")
+		if source == "" || target == "" {
+			return ZoneFlows{}, fmt.Errorf("line %d: a connection needs an originating and a responding host", line)
+		}
+		first, err := zeekTime(field("ts"))
+		if err != nil {
+			return ZoneFlows{}, fmt.Errorf("line %d: %w", line, err)
+		}
+		last := first
+		if d, err := strconv.ParseFloat(field("duration"), 64); err == nil {
+			last = first.Add(time.Duration(d * float64(time.Second)))
+		}
+		origBytes, _ := strconv.ParseInt(field("orig_bytes"), 10, 64)
+		respBytes, _ := strconv.ParseInt(field("resp_bytes"), 10, 64)
+		conns.add(source, target, field("proto"), field("id.resp_p"), zeekService(field("service")), 1, origBytes+respBytes, first, last)
+	}
+	if err := scanner.Err(); err != nil {
+		return ZoneFlows{}, err
+	}
+	return conns.zoneFlows(), nil
+}
+
+// zeekTime parses a Zeek timestamp: seconds since the epoch, or an ISO 8601 time in JSON logs
+func zeekTime(ts string) (time.Time, error) {
+	if ts == "" {
+		return time.Time{}, nil
+	}
+	if seconds, err := strconv.ParseFloat(ts, 64); err == nil {
+		whole, fraction := math.Modf(seconds)
+		return time.Unix(int64(whole), int64(fraction*1e9)).UTC(), nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, ts)
+	if err != nil {
+		return t, fmt.Errorf("the timestamp %q is neither epoch seconds nor ISO 8601", ts)
+	}
+	return t, nil
+}
+
+// zeekService names the service that Zeek detected on a connection, e.g. ssl,http, like the protocols of data flows
+func zeekService(service string) string {
+	services := strings.Split(strings.ToLower(service), ",")
+	switch {
+	case service == "" || service == zeekUnsetField:
+		return ""
+	case contains(services, "ssl") && contains(services, "http"):
+		return "https"
+	}
+	if s, renamed := zeekServices[services[0]]; renamed {
+		return s
+	}
+	return services[0]
+}
@@ -0,0 +1,92 @@
+package ingest
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadZeekConnLog(t *testing.T) {
+	cases := []struct {
+		name, log string
+		flows     []string
+	}{
+		{
+			name: "tab separated",
+			log: `#separator \x09
+#set_separator	,
+#empty_field	(empty)
+#unset_field	-
+#path	conn
+#fields	ts	uid	id.orig_h	id.orig_p	id.resp_h	id.resp_p	proto	service	duration	orig_bytes	resp_bytes
+#types	time	string	addr	port	addr	port	enum	string	interval	count	count
+1700000000.250000	C1	10.0.0.1	50000	10.0.0.2	443	tcp	ssl,http	1.5	100	2000
+1700000010.000000	C2	10.0.0.1	50001	10.0.0.2	443	tcp	-	-	-	-
+1700000020.000000	C3	10.0.0.1	53000	10.0.0.53	53	udp	dns	0.01	40	80
+1700000030.000000	C4	10.0.0.1	-	10.0.0.2	-	icmp	-	-	-	-
+`,
+			//the connection without a detected service is of the service of its port
+			flows: []string{"10.0.0.1>10.0.0.2 https 443 2100", "10.0.0.1>10.0.0.53 dns 53/UDP 120", "10.0.0.1>10.0.0.2 icmp  0"},
+		},
+		{
+			name: "other separator and unset field",
+			log: `#separator ,
+#unset_field,NULL
+#fields,ts,id.orig_h,id.orig_p,id.resp_h,id.resp_p,proto,service,orig_bytes,resp_bytes
+1700000000.0,10.0.0.1,50000,10.0.0.9,8080,tcp,NULL,5,NULL
+1700000001.0,10.0.0.1,50002,10.0.0.5,5432,tcp,postgresql,10,20
+`,
+			flows: []string{"10.0.0.1>10.0.0.9 tcp/8080 8080 5", "10.0.0.1>10.0.0.5 postgres 5432 30"},
+		},
+		{
+			name: "JSON",
+			log: `{"ts":"2023-11-14T22:13:20.25Z","uid":"C1","id.orig_h":"10.0.0.1","id.orig_p":50000,"id.resp_h":"10.0.0.2","id.resp_p":443,"proto":"tcp","service":"ssl","duration":1.5,"orig_bytes":100,"resp_bytes":2000}
+
+{"ts":1700000010.0,"uid":"C2","id.orig_h":"10.0.0.1","id.orig_p":50001,"id.resp_h":"10.0.0.2","id.resp_p":443,"proto":"tcp","service":"ssl"}
+`,
+			flows: []string{"10.0.0.1>10.0.0.2 tls 443 2100"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			zf, err := ReadZeekConnLog(strings.NewReader(c.log))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := describeFlows(zf); !reflect.DeepEqual(got, c.flows) {
+				t.Errorf("got flows %v, want %v", got, c.flows)
+			}
+		})
+	}
+}
+
+func TestReadZeekConnLogTimes(t *testing.T) {
+	zf, err := ReadZeekConnLog(strings.NewReader(`{"ts":"2023-11-14T22:13:20.25Z","id.orig_h":"10.0.0.1","id.resp_h":"10.0.0.2","id.resp_p":443,"proto":"tcp","duration":1.5}
+{"ts":1700000010.5,"id.orig_h":"10.0.0.1","id.resp_h":"10.0.0.2","id.resp_p":443,"proto":"tcp","duration":2}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := zf.Flows()[0]
+	first, last := time.Unix(1700000000, 250000000).UTC(), time.Unix(1700000012, 500000000).UTC()
+	if !f.FirstSeen.Equal(first) || !f.LastSeen.Equal(last) || f.Weight != 2 {
+		t.Errorf("got %v to %v, weight %v, want %v to %v, weight 2", f.FirstSeen, f.LastSeen, f.Weight, first, last)
+	}
+}
+
+func TestReadZeekConnLogRejects(t *testing.T) {
+	cases := []struct {
+		name, log string
+	}{
+		{"no fields", "1700000000.0\tC1\t10.0.0.1\t50000\t10.0.0.2\t443\ttcp\n"},
+		{"no responding host", "#fields\tts\tid.orig_h\tid.resp_h\n1700000000.0\t10.0.0.1\t-\n"},
+		{"invalid time", "#fields\tts\tid.orig_h\tid.resp_h\nyesterday\t10.0.0.1\t10.0.0.2\n"},
+		{"invalid JSON", `{"id.orig_h": "10.0.0.1"` + "\n"},
+	}
+	for _, c := range cases {
+		if _, err := ReadZeekConnLog(strings.NewReader(c.log)); err == nil {
+			t.Errorf("%s: got no error", c.name)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package ingest
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+// ZoneMap maps the addresses of hosts to the zones of the networks they are in, by CIDR
+type ZoneMap struct {
+	networks []zoneNetwork
+}
+
+type zoneNetwork struct {
+	network *net.IPNet
+	zone    string
+}
+
+// ReadZoneMap reads a table of networks and their zones from CSV, with the columns CIDR and zone, e.g.
+// 10.0.1.0/24,dmz. A single address is a network of its own, and the first row may be a header
+func ReadZoneMap(in io.Reader) (*ZoneMap, error) {
+	zm := &ZoneMap{}
+	reader := csv.NewReader(in)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	reader.Comment = '#'
+	for line := 1; ; line++ {
+		rec, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(rec) < 2 {
+			return nil, fmt.Errorf("line %d: expected a CIDR and a zone", line)
+		}
+		cidr, zone := strings.TrimSpace(rec[0]), strings.TrimSpace(rec[1])
+		if ip := net.ParseIP(cidr); ip != nil {
+			bits := 128
+			if ip.To4() != nil {
+				bits = 32
+			}
+			cidr = fmt.Sprintf("%s/%d", cidr, bits)
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			if line == 1 {
+				continue //a header
+			}
+			return nil, fmt.Errorf("line %d: %q is not a CIDR", line, rec[0])
+		}
+		zm.networks = append(zm.networks, zoneNetwork{network: network, zone: zone})
+	}
+	return zm, nil
+}
+
+// Zone returns the zone of the most specific network that contains the address of a host, or empty if none does
+func (zm *ZoneMap) Zone(host string) string {
+	ip := net.ParseIP(host)
+	if zm == nil || ip == nil {
+		return ""
+	}
+	zone, longest := "", -1
+	for _, n := range zm.networks {
+		if ones, _ := n.network.Mask.Size(); n.network.Contains(ip) && ones > longest {
+			zone, longest = n.zone, ones
+		}
+	}
+	return zone
+}
+
+// assign sets the zones of the hosts of flows that weren't observed in a zone
+func (zm *ZoneMap) assign(zf ZoneFlows) ZoneFlows {
+	if zm == nil {
+		return zf
+	}
+	zoned := ZoneFlows{ZoneToFlows: make(map[string][]OutFlow)}
+	for _, of := range zf.Flows() {
+		if of.SourceZone == "" {
+			of.SourceZone = zm.Zone(of.Source)
+		}
+		if of.TargetZone == "" {
+			of.TargetZone = zm.Zone(of.Target)
+		}
+		zoned.ZoneToFlows[of.SourceZone] = append(zoned.ZoneToFlows[of.SourceZone], of)
+	}
+	return zoned
+}
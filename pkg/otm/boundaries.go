@@ -0,0 +1,175 @@
+package otm_transform
+
+import (
+	"math"
+	"sort"
+
+	otm "github.com/adedayo/open-threat-model/pkg"
+)
+
+const (
+	crossingBaseScore     = 10.0 //score of crossing a single boundary between zones of equal trust
+	extraBoundaryScore    = 5.0  //added for every further boundary crossed
+	outboundWeight        = 0.6  //weight of the trust differential of flows to less trusted zones (exfiltration)
+	bidirectionalWeight   = 1.25 //weight of the trust differential of bidirectional flows, which are inbound either way
+	unauthenticatedScore  = 10.0 //added for inbound flows that declare they need no authentication, e.g. public API operations
+	crossingHighlight     = "#b85450"
+	boundaryScoreAttr     = "boundaryScore" //attribute of highlighted flows in mxGraph diagrams
+	Inbound, Outbound     = "inbound", "outbound"
+	Lateral               = "lateral"
+	maxBoundaryCrossScore = 100.0
+)
+
+// BoundaryCrossing is a data flow that crosses one or more trust boundaries, scored by the risk of the crossing
+type BoundaryCrossing struct {
+	FlowID           string  `json:"flowID"`
+	FlowName         string  `json:"flowName"`
+	Source           string  `json:"source"`
+	Destination      string  `json:"destination"`
+	SourceZone       string  `json:"sourceZone"` //closest trust zone of the source, empty if it isn't in a zone
+	DestinationZone  string  `json:"destinationZone"`
+	SourceTrust      float64 `json:"sourceTrust"` //trust rating of the source zone, 0 outside trust zones
+	DestinationTrust float64 `json:"destinationTrust"`
+	//the trust zones that the flow leaves, innermost first, followed by those it enters, outermost first
+	Boundaries      []string `json:"boundaries"`
+	Direction       string   `json:"direction"` //inbound (to a more trusted zone), outbound or lateral
+	Bidirectional   bool     `json:"bidirectional"`
+	Differential    float64  `json:"differential"`              //destination trust - source trust
+	Unauthenticated bool     `json:"unauthenticated,omitempty"` //the flow declares it needs no authentication
+	Score           float64  `json:"score"`                     //0-100
+}
+
+// AnalyseBoundaries finds the data flows that cross trust boundaries, ranked from the riskiest crossing. A crossing is
+// scored by the trust differential, weighted by the direction of the flow, plus a base score for the number of
+// boundaries crossed, and more for inbound flows that need no authentication. Elements outside all trust zones are
+// treated as untrusted
+func AnalyseBoundaries(model otm.OpenThreatModel) []BoundaryCrossing {
+	boundaries := NewTrustBoundaries(model)
+	crossings := []BoundaryCrossing{}
+	for _, df := range model.DataFlows {
+		if c, crosses := boundaries.Crossing(df); crosses {
+			crossings = append(crossings, c)
+		}
+	}
+
+	sort.SliceStable(crossings, func(i, j int) bool {
+		if crossings[i].Score != crossings[j].Score {
+			return crossings[i].Score > crossings[j].Score
+		}
+		return crossings[i].FlowID < crossings[j].FlowID
+	})
+	return crossings
+}
+
+// TrustBoundaries locates the elements of a model in its trust zones, following the parents of components and trust
+// zones, to find the trust boundaries between them
+type TrustBoundaries struct {
+	zones   map[string]otm.TrustZone
+	parents map[string]string
+}
+
+// NewTrustBoundaries indexes the trust zones of a model and the parents of its elements
+func NewTrustBoundaries(model otm.OpenThreatModel) *TrustBoundaries {
+	b := &TrustBoundaries{zones: make(map[string]otm.TrustZone), parents: make(map[string]string)}
+	for _, tz := range model.TrustZones {
+		b.zones[tz.ID] = tz
+		if tz.Parent != nil {
+			b.parents[tz.ID] = tz.Parent.GetID()
+		}
+	}
+	for _, c := range model.Components {
+		if c.Parent != nil {
+			b.parents[c.ID] = c.Parent.GetID()
+		}
+	}
+	return b
+}
+
+// zonesOf returns the trust zones that contain an element (or are the element), innermost first
+func (b *TrustBoundaries) zonesOf(id string) []string {
+	chain := []string{}
+	seen := make(map[string]bool)
+	for ; id != "" && !seen[id]; id = b.parents[id] {
+		seen[id] = true
+		if _, isZone := b.zones[id]; isZone {
+			chain = append(chain, id)
+		}
+	}
+	return chain
+}
+
+// ZoneOf returns the trust zone that an element is in: the zone itself, or the closest zone among its ancestors
+func (b *TrustBoundaries) ZoneOf(id string) (otm.TrustZone, bool) {
+	if chain := b.zonesOf(id); len(chain) > 0 {
+		return b.zones[chain[0]], true
+	}
+	return otm.TrustZone{}, false
+}
+
+// Crossing scores the trust boundaries that a data flow crosses, if it crosses any: if its ends are in different
+// trust zones, or only one of them is in a trust zone
+func (b *TrustBoundaries) Crossing(df otm.DataFlow) (BoundaryCrossing, bool) {
+	from, to := b.zonesOf(df.Source), b.zonesOf(df.Destination)
+	c := BoundaryCrossing{
+		FlowID:        df.ID,
+		FlowName:      df.Name,
+		Source:        df.Source,
+		Destination:   df.Destination,
+		Bidirectional: df.Bidirectional,
+	}
+	if len(from) > 0 {
+		c.SourceZone, c.SourceTrust = from[0], TrustRating(b.zones[from[0]])
+	}
+	if len(to) > 0 {
+		c.DestinationZone, c.DestinationTrust = to[0], TrustRating(b.zones[to[0]])
+	}
+	//strip the zones that contain both ends
+	for len(from) > 0 && len(to) > 0 && from[len(from)-1] == to[len(to)-1] {
+		from, to = from[:len(from)-1], to[:len(to)-1]
+	}
+	if len(from)+len(to) == 0 {
+		return c, false
+	}
+
+	c.Boundaries = append([]string{}, from...)
+	for i := len(to) - 1; i >= 0; i-- {
+		c.Boundaries = append(c.Boundaries, to[i])
+	}
+	c.Differential = c.DestinationTrust - c.SourceTrust
+	c.Direction, c.Score = scoreCrossing(c.Differential, len(c.Boundaries), df.Bidirectional)
+	c.Unauthenticated = Unauthenticated(df)
+	if c.Unauthenticated && c.Direction == Inbound {
+		c.Score = math.Min(c.Score+unauthenticatedScore, maxBoundaryCrossScore)
+	}
+	return c, true
+}
+
+func scoreCrossing(differential float64, boundaries int, bidirectional bool) (direction string, score float64) {
+	direction = Lateral
+	weighted := 0.0
+	switch {
+	case differential > 0:
+		direction, weighted = Inbound, differential
+	case differential < 0:
+		direction, weighted = Outbound, -differential*outboundWeight
+	}
+	if bidirectional {
+		weighted = math.Abs(differential) * bidirectionalWeight
+	}
+	score = crossingBaseScore + extraBoundaryScore*float64(boundaries-1) + weighted
+	return direction, math.Round(math.Min(score, maxBoundaryCrossScore)*10) / 10
+}
+
+// crossingsByFlow indexes the boundary crossings of a model by flow ID
+func crossingsByFlow(model otm.OpenThreatModel) map[string]BoundaryCrossing {
+	crossings := make(map[string]BoundaryCrossing)
+	for _, c := range AnalyseBoundaries(model) {
+		crossings[c.FlowID] = c
+	}
+	return crossings
+}
+
+// crossingWidth is the line width that highlights a crossing, growing with its score
+func crossingWidth(score float64) float64 {
+	return math.Round((1.5+score/25)*10) / 10
+}
@@ -0,0 +1,165 @@
+package otm_transform
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const boundaryModel = `otmVersion: 0.1.0
+project:
+  name: shop
+  id: shop
+trustZones:
+  - id: dmz
+    name: DMZ
+    risk:
+      trustRating: 40
+  - id: internal
+    name: Internal
+    risk:
+      trustRating: 80
+  - id: pci
+    name: PCI
+    risk:
+      trustRating: 95
+    parent:
+      trustZone: internal
+components:
+  - id: user
+    name: User
+    type: browser
+  - id: web
+    name: Web
+    type: web-server
+    parent:
+      trustZone: dmz
+  - id: api
+    name: API
+    type: service
+    parent:
+      trustZone: internal
+  - id: sidecar
+    name: Sidecar
+    type: proxy
+    parent:
+      component: api
+  - id: db
+    name: DB
+    type: database
+    parent:
+      trustZone: internal
+  - id: vault
+    name: Vault
+    type: database
+    parent:
+      trustZone: pci
+dataflows:
+  - id: user-web
+    name: browse
+    source: user
+    destination: web
+  - id: web-api
+    name: call
+    source: web
+    destination: api
+  - id: api-vault
+    name: read card
+    source: api
+    destination: vault
+  - id: api-db
+    name: query
+    source: api
+    destination: db
+  - id: db-user
+    name: export
+    source: db
+    destination: user
+  - id: sidecar-web
+    name: sync
+    source: sidecar
+    destination: web
+    bidirectional: true
+`
+
+func TestAnalyseBoundaries(t *testing.T) {
+	crossings := AnalyseBoundaries(parseTestModel(t, boundaryModel))
+
+	want := []struct {
+		flow, sourceZone, destinationZone string
+		boundaries                        []string
+		direction                         string
+		differential, score               float64
+	}{
+		//1.25 x 40 for either way, 5 for the second boundary
+		{"sidecar-web", "internal", "dmz", []string{"internal", "dmz"}, Outbound, -40, 65},
+		//0.6 x 80 for data leaving to an untrusted element outside the trust zones
+		{"db-user", "internal", "", []string{"internal"}, Outbound, -80, 58},
+		{"web-api", "dmz", "internal", []string{"dmz", "internal"}, Inbound, 40, 55},
+		{"user-web", "", "dmz", []string{"dmz"}, Inbound, 40, 50},
+		//the flow stays in the internal zone that contains the PCI zone
+		{"api-vault", "internal", "pci", []string{"pci"}, Inbound, 15, 25},
+	}
+	if len(crossings) != len(want) {
+		t.Fatalf("got %d crossings, want %d: %+v", len(crossings), len(want), crossings)
+	}
+	for i, w := range want {
+		c := crossings[i]
+		if c.FlowID != w.flow || c.SourceZone != w.sourceZone || c.DestinationZone != w.destinationZone ||
+			!reflect.DeepEqual(c.Boundaries, w.boundaries) || c.Direction != w.direction ||
+			c.Differential != w.differential || c.Score != w.score {
+			t.Errorf("crossing %d: got %+v, want %+v", i+1, c, w)
+		}
+	}
+}
+
+func TestScoreCrossing(t *testing.T) {
+	cases := []struct {
+		differential  float64
+		boundaries    int
+		bidirectional bool
+		direction     string
+		score         float64
+	}{
+		{0, 1, false, Lateral, 10},
+		{0, 3, false, Lateral, 20},
+		{90, 1, false, Inbound, 100},
+		{95, 2, false, Inbound, 100},
+		{-50, 1, false, Outbound, 40},
+		{-50, 1, true, Outbound, 72.5},
+	}
+	for _, c := range cases {
+		direction, score := scoreCrossing(c.differential, c.boundaries, c.bidirectional)
+		if direction != c.direction || score != c.score {
+			t.Errorf("differential %v over %d boundaries (bidirectional %t): got %s %v, want %s %v",
+				c.differential, c.boundaries, c.bidirectional, direction, score, c.direction, c.score)
+		}
+	}
+}
+
+func TestUnauthenticatedCrossings(t *testing.T) {
+	cases := []struct {
+		attributes      string
+		unauthenticated bool
+		score           float64
+	}{
+		{"", false, 50},
+		{"authentication: none", true, 60},
+		{"auth: anonymous", true, 60},
+		{"authenticated: false", true, 60},
+		{"authentication: mTLS", false, 50},
+	}
+	for _, c := range cases {
+		flow := "    destination: web\n"
+		if c.attributes != "" {
+			flow += "    attributes:\n      " + c.attributes + "\n"
+		}
+		model := parseTestModel(t, strings.Replace(boundaryModel, "    destination: web\n", flow, 1))
+		for _, crossing := range AnalyseBoundaries(model) {
+			if crossing.FlowID == "user-web" && (crossing.Unauthenticated != c.unauthenticated || crossing.Score != c.score) {
+				t.Errorf("%q: got unauthenticated %t and score %v, want %t and %v",
+					c.attributes, crossing.Unauthenticated, crossing.Score, c.unauthenticated, c.score)
+			}
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package otm_transform
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	otm "github.com/adedayo/open-threat-model/pkg"
+)
+
+// ModelDiff is the structural difference between two threat models, keyed by the IDs of their elements
+type ModelDiff struct {
+	TrustZones  ElementDiff `json:"trustZones"`
+	Components  ElementDiff `json:"components"`
+	DataFlows   ElementDiff `json:"dataflows"`
+	Threats     ElementDiff `json:"threats"`
+	Mitigations ElementDiff `json:"mitigations"`
+}
+
+type ElementDiff struct {
+	Added   []Element       `json:"added"`
+	Removed []Element       `json:"removed"`
+	Changed []ElementChange `json:"changed"`
+}
+
+type Element struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type ElementChange struct {
+	Element
+	Moved  bool          `json:"moved"` //the parent (trust zone or component) of the element changed
+	Fields []FieldChange `json:"fields"`
+}
+
+type FieldChange struct {
+	Field string `json:"field"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+}
+
+// DiffModels compares two threat models and reports the added, removed and changed elements of the second relative to the first
+func DiffModels(from, to otm.OpenThreatModel) ModelDiff {
+	return ModelDiff{
+		TrustZones:  diffElements(trustZoneFields(from), trustZoneFields(to)),
+		Components:  diffElements(componentFields(from), componentFields(to)),
+		DataFlows:   diffElements(dataFlowFields(from), dataFlowFields(to)),
+		Threats:     diffElements(threatFields(from), threatFields(to)),
+		Mitigations: diffElements(mitigationFields(from), mitigationFields(to)),
+	}
+}
+
+func (d ModelDiff) IsEmpty() bool {
+	for _, ed := range d.sections() {
+		if !ed.diff.IsEmpty() {
+			return false
+		}
+	}
+	return true
+}
+
+func (ed ElementDiff) IsEmpty() bool {
+	return len(ed.Added) == 0 && len(ed.Removed) == 0 && len(ed.Changed) == 0
+}
+
+// String renders the difference in a human-readable form
+func (d ModelDiff) String() string {
+	if d.IsEmpty() {
+		return "No differences\n"
+	}
+	var b strings.Builder
+	for _, s := range d.sections() {
+		if s.diff.IsEmpty() {
+			continue
+		}
+		fmt.Fprintf(&b, "%s:\n", s.title)
+		for _, e := range s.diff.Added {
+			fmt.Fprintf(&b, "  + %s\n", e)
+		}
+		for _, e := range s.diff.Removed {
+			fmt.Fprintf(&b, "  - %s\n", e)
+		}
+		for _, c := range s.diff.Changed {
+			fmt.Fprintf(&b, "  ~ %s\n", c.Element)
+			for _, f := range c.Fields {
+				fmt.Fprintf(&b, "      %s: %q -> %q\n", f.Field, f.From, f.To)
+			}
+		}
+	}
+	return b.String()
+}
+
+func (e Element) String() string {
+	if e.Name == "" || e.Name == e.ID {
+		return e.ID
+	}
+	return fmt.Sprintf("%s (%s)", e.ID, e.Name)
+}
+
+type diffSection struct {
+	title string
+	diff  ElementDiff
+}
+
+func (d ModelDiff) sections() []diffSection {
+	return []diffSection{
+		{"Trust zones", d.TrustZones},
+		{"Components", d.Components},
+		{"Data flows", d.DataFlows},
+		{"Threats", d.Threats},
+		{"Mitigations", d.Mitigations},
+	}
+}
+
+// elementFields is a flattened, order-independent view of an element: ID -> field -> value
+type elementFields map[string]map[string]string
+
+func diffElements(from, to elementFields) ElementDiff {
+	diff := ElementDiff{
+		Added:   []Element{},
+		Removed: []Element{},
+		Changed: []ElementChange{},
+	}
+
+	for _, id := range sortedKeys(to) {
+		if _, exists := from[id]; !exists {
+			diff.Added = append(diff.Added, Element{ID: id, Name: to[id]["name"]})
+		}
+	}
+
+	for _, id := range sortedKeys(from) {
+		before := from[id]
+		after, exists := to[id]
+		if !exists {
+			diff.Removed = append(diff.Removed, Element{ID: id, Name: before["name"]})
+			continue
+		}
+
+		fields := []string{}
+		for f := range before {
+			fields = append(fields, f)
+		}
+		for f := range after {
+			if _, seen := before[f]; !seen {
+				fields = append(fields, f)
+			}
+		}
+		sort.Strings(fields)
+
+		change := ElementChange{
+			Element: Element{ID: id, Name: after["name"]},
+			Fields:  []FieldChange{},
+		}
+		for _, f := range fields {
+			if before[f] != after[f] {
+				change.Fields = append(change.Fields, FieldChange{Field: f, From: before[f], To: after[f]})
+				if f == "parent" {
+					change.Moved = true
+				}
+			}
+		}
+		if len(change.Fields) > 0 {
+			diff.Changed = append(diff.Changed, change)
+		}
+	}
+
+	return diff
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func trustZoneFields(model otm.OpenThreatModel) elementFields {
+	out := make(elementFields)
+	for _, tz := range model.TrustZones {
+		fields := map[string]string{
+			"name":        tz.Name,
+			"type":        tz.Type,
+			"description": tz.Description,
+			"trustRating": fmt.Sprint(TrustRating(tz)),
+			"parent":      parentRef(tz.Parent),
+		}
+		addAttributes(fields, tz.Attributes)
+		out[tz.ID] = fields
+	}
+	return out
+}
+
+func componentFields(model otm.OpenThreatModel) elementFields {
+	out := make(elementFields)
+	for _, comp := range model.Components {
+		fields := map[string]string{
+			"name":        comp.Name,
+			"type":        comp.Type,
+			"description": comp.Description,
+			"parent":      parentRef(comp.Parent),
+			"tags":        joinSorted(comp.Tags),
+			"threats":     threatInstances(comp.Threats),
+		}
+		addAttributes(fields, comp.Attributes)
+		out[comp.ID] = fields
+	}
+	return out
+}
+
+func dataFlowFields(model otm.OpenThreatModel) elementFields {
+	out := make(elementFields)
+	for _, df := range model.DataFlows {
+		fields := map[string]string{
+			"name":          df.Name,
+			"description":   df.Description,
+			"source":        df.Source,
+			"destination":   df.Destination,
+			"bidirectional": fmt.Sprint(df.Bidirectional),
+			"assets":        joinSorted(df.Assets),
+			"tags":          joinSorted(df.Tags),
+			"threats":       threatInstances(df.Threats),
+		}
+		addAttributes(fields, df.Attributes)
+		out[df.ID] = fields
+	}
+	return out
+}
+
+func threatFields(model otm.OpenThreatModel) elementFields {
+	out := make(elementFields)
+	for _, t := range model.Threats {
+		fields := map[string]string{
+			"name":            t.Name,
+			"description":     t.Description,
+			"categories":      joinSorted(t.Categories),
+			"cwes":            joinSorted(t.CWEs),
+			"risk.likelihood": fmt.Sprint(t.Risk.Likelihood),
+			"risk.impact":     fmt.Sprint(t.Risk.Impact),
+			"tags":            joinSorted(t.Tags),
+		}
+		addAttributes(fields, t.Attributes)
+		out[t.ID] = fields
+	}
+	return out
+}
+
+func mitigationFields(model otm.OpenThreatModel) elementFields {
+	out := make(elementFields)
+	for _, m := range model.Mitigations {
+		fields := map[string]string{
+			"name":          m.Name,
+			"description":   m.Description,
+			"riskReduction": fmt.Sprint(m.RiskReduction),
+		}
+		addAttributes(fields, m.Attributes)
+		out[m.ID] = fields
+	}
+	return out
+}
+
+func threatInstances(threats []otm.ThreatInstance) string {
+	ts := []string{}
+	for _, t := range threats {
+		ms := []string{}
+		for _, m := range t.Mitigations {
+			ms = append(ms, fmt.Sprintf("%s:%s", m.Mitigation, m.State))
+		}
+		ts = append(ts, fmt.Sprintf("%s:%s[%s]", t.Threat, t.State, joinSorted(ms)))
+	}
+	return joinSorted(ts)
+}
+
+func addAttributes[V any](fields map[string]string, attrs map[string]V) {
+	for k, v := range attrs {
+		fields["attributes."+k] = fmt.Sprint(v)
+	}
+}
+
+func joinSorted(items []string) string {
+	sorted := append([]string{}, items...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, ", ")
+}
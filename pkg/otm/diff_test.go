@@ -0,0 +1,174 @@
+package otm_transform
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	otm "github.com/adedayo/open-threat-model/pkg"
+)
+
+const diffBase = `otmVersion: 0.1.0
+project:
+  name: shop
+  id: shop
+trustZones:
+  - id: internet
+    name: Internet
+    risk:
+      trustRating: 10
+  - id: internal
+    name: Internal
+    risk:
+      trustRating: 80
+components:
+  - id: web
+    name: Web
+    type: web-server
+    parent:
+      trustZone: internet
+    attributes:
+      owner: team-a
+  - id: db
+    name: DB
+    type: database
+    parent:
+      trustZone: internal
+  - id: cache
+    name: Cache
+    type: redis
+    parent:
+      trustZone: internal
+dataflows:
+  - id: web-db
+    name: query
+    source: web
+    destination: db
+`
+
+func parseTestModel(t *testing.T, model string) otm.OpenThreatModel {
+	t.Helper()
+	m, err := otm.Parse(strings.NewReader(model))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestDiffModels(t *testing.T) {
+	edit := func(replacements ...string) string {
+		return strings.NewReplacer(replacements...).Replace(diffBase)
+	}
+	cases := []struct {
+		name          string
+		to            string
+		section       func(ModelDiff) ElementDiff
+		added         []string
+		removed       []string
+		changed       []string
+		fields, moved []string
+	}{
+		{
+			name:    "unchanged",
+			to:      diffBase,
+			section: func(d ModelDiff) ElementDiff { return d.Components },
+		},
+		{
+			name:    "component added and removed",
+			to:      edit("id: cache\n    name: Cache", "id: queue\n    name: Queue"),
+			section: func(d ModelDiff) ElementDiff { return d.Components },
+			added:   []string{"queue"},
+			removed: []string{"cache"},
+		},
+		{
+			name:    "component renamed and moved",
+			to:      edit("name: Web\n    type: web-server\n    parent:\n      trustZone: internet", "name: Web app\n    type: web-server\n    parent:\n      trustZone: internal"),
+			section: func(d ModelDiff) ElementDiff { return d.Components },
+			changed: []string{"web"},
+			fields:  []string{"name", "parent"},
+			moved:   []string{"web"},
+		},
+		{
+			name:    "attribute changed",
+			to:      edit("owner: team-a", "owner: team-b"),
+			section: func(d ModelDiff) ElementDiff { return d.Components },
+			changed: []string{"web"},
+			fields:  []string{"attributes.owner"},
+		},
+		{
+			name:    "trust rating changed",
+			to:      edit("trustRating: 10", "trustRating: 20"),
+			section: func(d ModelDiff) ElementDiff { return d.TrustZones },
+			changed: []string{"internet"},
+			fields:  []string{"trustRating"},
+		},
+		{
+			name:    "flow redirected",
+			to:      edit("destination: db", "destination: cache"),
+			section: func(d ModelDiff) ElementDiff { return d.DataFlows },
+			changed: []string{"web-db"},
+			fields:  []string{"destination"},
+		},
+	}
+	ids := func(elements []Element) []string {
+		out := []string{}
+		for _, e := range elements {
+			out = append(out, e.ID)
+		}
+		return out
+	}
+	orEmpty := func(s []string) []string {
+		if s == nil {
+			return []string{}
+		}
+		return s
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			diff := DiffModels(parseTestModel(t, diffBase), parseTestModel(t, c.to))
+			ed := c.section(diff)
+			if got := ids(ed.Added); !reflect.DeepEqual(got, orEmpty(c.added)) {
+				t.Errorf("got added %v, want %v", got, c.added)
+			}
+			if got := ids(ed.Removed); !reflect.DeepEqual(got, orEmpty(c.removed)) {
+				t.Errorf("got removed %v, want %v", got, c.removed)
+			}
+			changed, fields, moved := []string{}, []string{}, []string{}
+			for _, ch := range ed.Changed {
+				changed = append(changed, ch.ID)
+				for _, f := range ch.Fields {
+					fields = append(fields, f.Field)
+				}
+				if ch.Moved {
+					moved = append(moved, ch.ID)
+				}
+			}
+			if !reflect.DeepEqual(changed, orEmpty(c.changed)) || !reflect.DeepEqual(fields, orEmpty(c.fields)) ||
+				!reflect.DeepEqual(moved, orEmpty(c.moved)) {
+				t.Errorf("got changed %v with fields %v and moved %v, want %v with %v and %v",
+					changed, fields, moved, c.changed, c.fields, c.moved)
+			}
+			if empty := c.to == diffBase; diff.IsEmpty() != empty {
+				t.Errorf("got an empty diff %t, want %t", diff.IsEmpty(), empty)
+			}
+		})
+	}
+}
+
+func TestModelDiffString(t *testing.T) {
+	to := strings.NewReplacer("name: query", "name: select", "id: cache\n    name: Cache", "id: queue\n    name: Queue").Replace(diffBase)
+	got := DiffModels(parseTestModel(t, diffBase), parseTestModel(t, to)).String()
+	want := `Components:
+  + queue (Queue)
+  - cache (Cache)
+Data flows:
+  ~ web-db (select)
+      name: "query" -> "select"
+`
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	if got := (ModelDiff{}).String(); got != "No differences\n" {
+		t.Errorf("got %q for no differences", got)
+	}
+}
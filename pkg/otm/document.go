@@ -0,0 +1,232 @@
+package otm_transform
+
+import (
+	"fmt"
+	"strings"
+
+	otm "github.com/adedayo/open-threat-model/pkg"
+	"gopkg.in/yaml.v3"
+)
+
+// Document is an editable OTM YAML document. Edits preserve the order, comments and formatting of untouched content
+type Document struct {
+	root *yaml.Node
+}
+
+const (
+	OTMVersion = "0.1.0" //version of the OTM specification of new documents
+)
+
+// ProjectInfo is the project section of a new OTM document
+type ProjectInfo struct {
+	Name         string `yaml:"name"`
+	ID           string `yaml:"id"`
+	Description  string `yaml:"description,omitempty"`
+	Owner        string `yaml:"owner,omitempty"`
+	OwnerContact string `yaml:"ownerContact,omitempty"`
+}
+
+// NewDocument creates an OTM document with just the project section
+func NewDocument(project ProjectInfo) (*Document, error) {
+	root := &yaml.Node{}
+	err := root.Encode(struct {
+		OTMVersion string      `yaml:"otmVersion"`
+		Project    ProjectInfo `yaml:"project"`
+	}{OTMVersion, project})
+	if err != nil {
+		return nil, err
+	}
+	return &Document{root: root}, nil
+}
+
+// ParseDocument parses an OTM YAML document, an empty string gives an empty document
+func ParseDocument(threatModel string) (*Document, error) {
+	root, err := parseYAMLMapping(threatModel)
+	if err != nil {
+		return nil, err
+	}
+	if root == nil {
+		root = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	}
+	return &Document{root: root}, nil
+}
+
+// HasElement checks whether an element with the ID exists in a section such as components
+func (d *Document) HasElement(section, id string) bool {
+	_, elem := d.element(section, id)
+	return elem != nil
+}
+
+// ElementIDs returns the IDs of the elements of a section, in document order
+func (d *Document) ElementIDs(section string) []string {
+	_, order := indexByID(mappingValue(d.root, section))
+	return order
+}
+
+// AddElement appends an element, which must have an id field, to a section such as threats
+func (d *Document) AddElement(section string, element interface{}) error {
+	node := &yaml.Node{}
+	if err := node.Encode(element); err != nil {
+		return err
+	}
+	idNode := mappingValue(node, "id")
+	if idNode == nil || idNode.Value == "" {
+		return fmt.Errorf("cannot add an element without an id to %s", section)
+	}
+	if d.HasElement(section, idNode.Value) {
+		return fmt.Errorf("%s already has an element with id %s", section, idNode.Value)
+	}
+
+	seq := mappingValue(d.root, section)
+	if seq == nil || seq.Kind != yaml.SequenceNode {
+		seq = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		setMappingValue(d.root, section, seq)
+	}
+	seq.Content = append(seq.Content, node)
+	return nil
+}
+
+// DeleteElement removes the element with the ID from a section
+func (d *Document) DeleteElement(section, id string) error {
+	seq, elem := d.element(section, id)
+	if elem == nil {
+		return fmt.Errorf("%s has no element with id %s", section, id)
+	}
+	content := []*yaml.Node{}
+	for _, n := range seq.Content {
+		if n != elem {
+			content = append(content, n)
+		}
+	}
+	seq.Content = content
+	return nil
+}
+
+// SetField sets a field, which may be a dotted path such as attributes.protocol, of the element with the ID in a section.
+// If the ID is empty, the section itself (e.g. project) is treated as the element. A nil value deletes the field
+func (d *Document) SetField(section, id, field string, value interface{}) error {
+	var target *yaml.Node
+	if id == "" {
+		target = mappingValue(d.root, section)
+		if target == nil {
+			target = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+			setMappingValue(d.root, section, target)
+		}
+	} else if _, target = d.element(section, id); target == nil {
+		return fmt.Errorf("%s has no element with id %s", section, id)
+	}
+	if !isMapping(target) {
+		return fmt.Errorf("%s %s is not a mapping", section, id)
+	}
+
+	path := strings.Split(field, ".")
+	for _, key := range path[:len(path)-1] {
+		next := mappingValue(target, key)
+		if !isMapping(next) {
+			if value == nil {
+				return nil
+			}
+			next = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+			setMappingValue(target, key, next)
+		}
+		target = next
+	}
+
+	key := path[len(path)-1]
+	if value == nil {
+		deleteMappingValue(target, key)
+		return nil
+	}
+	node := &yaml.Node{}
+	if err := node.Encode(value); err != nil {
+		return err
+	}
+	setMappingValue(target, key, node)
+	return nil
+}
+
+// Field decodes a field, which may be a dotted path such as assets.processed, of the element with the ID in a section
+// into a value, reporting whether the field is set
+func (d *Document) Field(section, id, field string, value interface{}) (bool, error) {
+	_, target := d.element(section, id)
+	for _, key := range strings.Split(field, ".") {
+		target = mappingValue(target, key)
+	}
+	if target == nil {
+		return false, nil
+	}
+	return true, target.Decode(value)
+}
+
+// AppendField appends a value to a sequence field, such as threats, of the element with the ID in a section,
+// creating the sequence if the field isn't set
+func (d *Document) AppendField(section, id, field string, value interface{}) error {
+	_, target := d.element(section, id)
+	if target == nil {
+		return fmt.Errorf("%s has no element with id %s", section, id)
+	}
+	node := &yaml.Node{}
+	if err := node.Encode(value); err != nil {
+		return err
+	}
+	seq := mappingValue(target, field)
+	if seq == nil || seq.Kind != yaml.SequenceNode {
+		seq = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		setMappingValue(target, field, seq)
+	}
+	seq.Content = append(seq.Content, node)
+	return nil
+}
+
+// Model parses the document as an OTM model
+func (d *Document) Model() (otm.OpenThreatModel, error) {
+	doc, err := d.String()
+	if err != nil {
+		return otm.OpenThreatModel{}, err
+	}
+	return otm.Parse(strings.NewReader(doc))
+}
+
+// String serialises the document as YAML
+func (d *Document) String() (string, error) {
+	if len(d.root.Content) == 0 {
+		return "", nil
+	}
+	var out strings.Builder
+	enc := yaml.NewEncoder(&out)
+	enc.SetIndent(2)
+	err := enc.Encode(&yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{d.root}})
+	return out.String(), err
+}
+
+func (d *Document) element(section, id string) (seq, elem *yaml.Node) {
+	seq = mappingValue(d.root, section)
+	if seq == nil || seq.Kind != yaml.SequenceNode {
+		return nil, nil
+	}
+	for _, n := range seq.Content {
+		if v := mappingValue(n, "id"); v != nil && v.Value == id {
+			return seq, n
+		}
+	}
+	return seq, nil
+}
+
+func setMappingValue(n *yaml.Node, key string, value *yaml.Node) {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			n.Content[i+1] = value
+			return
+		}
+	}
+	n.Content = append(n.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, value)
+}
+
+func deleteMappingValue(n *yaml.Node, key string) {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			n.Content = append(n.Content[:i], n.Content[i+2:]...)
+			return
+		}
+	}
+}
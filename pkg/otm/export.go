@@ -0,0 +1,64 @@
+package otm_transform
+
+import (
+	"fmt"
+	"strings"
+
+	otm "github.com/adedayo/open-threat-model/pkg"
+)
+
+// ExportFormat describes a text format that an OTM can be exported to
+type ExportFormat struct {
+	Name        string `json:"name"`
+	ContentType string `json:"contentType"`
+	Extension   string `json:"extension"`
+	transform   func(otm.OpenThreatModel) (string, error)
+}
+
+var (
+	// ExportFormats are the text formats that an OTM can be exported to, by name
+	ExportFormats = map[string]ExportFormat{
+		"graphviz": {"graphviz", "text/vnd.graphviz", "dot", OtmToGraphviz},
+		"mermaid":  {"mermaid", "text/plain", "mmd", OtmToMermaid},
+		"plantuml": {"plantuml", "text/plain", "puml", OtmToPlantUML},
+		"drawio":   {"drawio", "application/xml", "drawio", OtmToMXFile},
+		"svg":      {"svg", "image/svg+xml", "svg", OtmToSVG},
+		"netpol":   {"netpol", "application/yaml", "yaml", OtmToNetworkPolicies},
+		"istio":    {"istio", "application/yaml", "yaml", OtmToIstioPolicies},
+	}
+
+	// BundleFormats are the binary formats, such as archives, that an OTM can be exported to, by name. Unlike the
+	// ExportFormats, they can't be carried in text, e.g. in the JSON messages of the websocket
+	BundleFormats = map[string]BundleFormat{
+		"opa": {"opa", "application/gzip", "tar.gz", OtmToOPABundle},
+	}
+)
+
+// BundleFormat describes a binary format that an OTM can be exported to
+type BundleFormat struct {
+	Name        string `json:"name"`
+	ContentType string `json:"contentType"`
+	Extension   string `json:"extension"`
+	transform   func(otm.OpenThreatModel) ([]byte, error)
+}
+
+// Export transforms an OTM to one of the ExportFormats
+func Export(model otm.OpenThreatModel, format string) (string, error) {
+	f, supported := ExportFormats[format]
+	if _, binary := BundleFormats[format]; binary {
+		return "", fmt.Errorf("the %s format is binary and can't be exported as text", format)
+	}
+	if !supported {
+		return "", fmt.Errorf("unsupported export format %s, use one of %s", format, strings.Join(sortedKeys(ExportFormats), ", "))
+	}
+	return f.transform(model)
+}
+
+// ExportBundle transforms an OTM to one of the BundleFormats
+func ExportBundle(model otm.OpenThreatModel, format string) ([]byte, error) {
+	f, supported := BundleFormats[format]
+	if !supported {
+		return nil, fmt.Errorf("unsupported bundle format %s, use one of %s", format, strings.Join(sortedKeys(BundleFormats), ", "))
+	}
+	return f.transform(model)
+}
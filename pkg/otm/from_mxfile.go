@@ -0,0 +1,235 @@
+package otm_transform
+
+import (
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	htmlTags    = regexp.MustCompile(`<[^>]*>`)
+	shapeToType = []struct{ shape, componentType string }{
+		{"cylinder", "database"},
+		{"datastore", "database"},
+		{"umlActor", "actor"},
+		{"actor", "actor"},
+		{"hexagon", "gateway"},
+		{"process", "queue"},
+		{"queue", "queue"},
+		{"cloud", "cloud-service"},
+	}
+)
+
+// MXFileToOtm derives trust zones, components (with their parent containment) and data flows from an mxGraph diagram,
+// and writes them into an OTM document, which may be empty. The OTM IDs are the IDs of the cells, or their otmID attributes.
+// Cells generated by OtmToMXFile are recognised by their otm attribute, other cells by their style:
+// containers (e.g. swimlanes and groups) are trust zones, other shapes are components and edges are data flows.
+// Elements of the OTM that were drawn in the previous version of the diagram, but are no longer in it, are removed.
+// Everything else in the OTM document, such as threats and mitigations, is preserved
+func MXFileToOtm(visualModel, previousVisualModel, threatModel string) (string, error) {
+	current, err := ParseMxGraph(visualModel)
+	if err != nil {
+		return threatModel, err
+	}
+	previous, err := ParseMxGraph(previousVisualModel)
+	if err != nil {
+		return threatModel, err
+	}
+	doc, err := ParseDocument(threatModel)
+	if err != nil {
+		return threatModel, err
+	}
+
+	kinds := classifyCells(current)
+	sections := map[string]string{
+		"trustZone": "trustZones",
+		"component": "components",
+		"dataflow":  "dataflows",
+	}
+
+	//remove the elements that have been deleted from the diagram
+	shown := make(map[string]bool) //section/ID of the elements in the diagram
+	for id, kind := range kinds {
+		shown[sections[kind]+"/"+elementID(current.Cell(id))] = true
+	}
+	for id, kind := range classifyCells(previous) {
+		section, element := sections[kind], elementID(previous.Cell(id))
+		if !shown[section+"/"+element] && doc.HasElement(section, element) {
+			if err := doc.DeleteElement(section, element); err != nil {
+				return threatModel, err
+			}
+		}
+	}
+
+	//add or update the elements in the diagram, zones first, so that they precede their contents
+	for _, kind := range []string{"trustZone", "component", "dataflow"} {
+		for _, cell := range current.Cells {
+			if kinds[cell.ID] != kind {
+				continue
+			}
+			section, element := sections[kind], elementID(cell)
+			fields := cellFields(current, kinds, cell)
+			if !doc.HasElement(section, element) {
+				if err := doc.AddElement(section, map[string]string{"id": element}); err != nil {
+					return threatModel, err
+				}
+			}
+			for _, k := range sortedKeys(fields) {
+				if err := doc.SetField(section, element, k, fields[k]); err != nil {
+					return threatModel, err
+				}
+			}
+		}
+	}
+
+	return doc.String()
+}
+
+// classifyCells identifies the cells that represent trust zones, components and data flows: cell ID -> kind
+func classifyCells(graph *MxGraphModel) map[string]string {
+	kinds := make(map[string]string)
+	layers := make(map[string]bool)
+	for _, c := range graph.Cells {
+		if c.Parent == "" {
+			layers[c.ID] = true //the root
+		} else if p := graph.Cell(c.Parent); p != nil && p.Parent == "" {
+			layers[c.ID] = true
+		}
+	}
+
+	for _, c := range graph.Cells {
+		if layers[c.ID] && !c.Vertex && !c.Edge {
+			continue
+		}
+		if kind := c.Attributes["otm"]; kind != "" {
+			kinds[c.ID] = kind
+			continue
+		}
+		if !c.Vertex {
+			continue
+		}
+		if p := graph.Cell(c.Parent); (p != nil && p.Edge) || c.Extra["connectable"] == "0" ||
+			strings.HasPrefix(c.Style, "text;") || strings.HasPrefix(c.Style, "edgeLabel") {
+			//labels and annotations
+			continue
+		}
+		if isContainerStyle(c) {
+			kinds[c.ID] = "trustZone"
+		} else {
+			kinds[c.ID] = "component"
+		}
+	}
+
+	for _, c := range graph.Cells {
+		if c.Edge && c.Attributes["otm"] == "" {
+			if elementOf(graph, kinds, c.Source) != "" && elementOf(graph, kinds, c.Target) != "" {
+				kinds[c.ID] = "dataflow"
+			}
+		}
+	}
+	return kinds
+}
+
+func isContainerStyle(c *MxCell) bool {
+	if strings.HasPrefix(c.Style, "swimlane") || strings.HasPrefix(c.Style, "group") {
+		return true
+	}
+	container, _ := c.StyleValue("container")
+	return container == "1"
+}
+
+// elementID returns the ID of the OTM element that a cell shows, which is the ID of the cell unless the cell says otherwise
+func elementID(cell *MxCell) string {
+	if cell == nil {
+		return ""
+	}
+	if id := cell.Attributes[mxElementAttr]; id != "" {
+		return id
+	}
+	return cell.ID
+}
+
+// elementOf returns the cell ID of the zone or component that the cell is, or is part of
+func elementOf(graph *MxGraphModel, kinds map[string]string, id string) string {
+	seen := make(map[string]bool)
+	for c := graph.Cell(id); c != nil && !seen[c.ID]; c = graph.Cell(c.Parent) {
+		if k := kinds[c.ID]; k == "trustZone" || k == "component" {
+			return c.ID
+		}
+		seen[c.ID] = true
+	}
+	return ""
+}
+
+// cellFields returns the OTM fields represented by a cell. A nil value means the field should be removed
+func cellFields(graph *MxGraphModel, kinds map[string]string, cell *MxCell) map[string]interface{} {
+	name := cellLabel(cell)
+	fields := map[string]interface{}{"name": name}
+
+	switch kinds[cell.ID] {
+	case "dataflow":
+		if p := cell.Attributes["protocol"]; p != "" {
+			fields["name"] = strings.TrimSuffix(name, " ("+p+")")
+		}
+		fields["source"] = elementID(graph.Cell(elementOf(graph, kinds, cell.Source)))
+		fields["destination"] = elementID(graph.Cell(elementOf(graph, kinds, cell.Target)))
+		startArrow, _ := cell.StyleValue("startArrow")
+		fields["bidirectional"] = cell.Attributes["bidirectional"] == "true" || (startArrow != "" && startArrow != "none")
+		for k, v := range cell.Attributes {
+			if k != "otm" && k != mxElementAttr && k != "bidirectional" && k != boundaryScoreAttr {
+				fields["attributes."+k] = v
+			}
+		}
+		return fields
+	case "trustZone":
+		if r, err := strconv.ParseFloat(cell.Attributes["trustRating"], 64); err == nil {
+			fields["risk.trustRating"] = r
+		}
+		if t := cell.Attributes["type"]; t != "" {
+			fields["type"] = t
+		}
+	case "component":
+		componentType := cell.Attributes["type"]
+		if componentType == "" {
+			componentType = styleToComponentType(cell)
+		}
+		fields["type"] = componentType
+	}
+
+	fields["parent"] = nil
+	if p := graph.Cell(cell.Parent); p != nil {
+		if parent := elementOf(graph, kinds, p.ID); parent != "" {
+			key := "component"
+			if kinds[parent] == "trustZone" {
+				key = "trustZone"
+			}
+			fields["parent"] = map[string]string{key: elementID(graph.Cell(parent))}
+		}
+	}
+	return fields
+}
+
+func styleToComponentType(cell *MxCell) string {
+	shape, _ := cell.StyleValue("shape")
+	if shape == "" {
+		//draw.io styles may start with the shape name, e.g. "ellipse;whiteSpace=wrap"
+		shape = strings.Split(cell.Style, ";")[0]
+	}
+	for _, s := range shapeToType {
+		if strings.Contains(shape, s.shape) {
+			return s.componentType
+		}
+	}
+	return "generic"
+}
+
+// cellLabel returns the plain text of a cell's label, which may be HTML
+func cellLabel(cell *MxCell) string {
+	label := htmlTags.ReplaceAllString(cell.Value, " ")
+	label = strings.Join(strings.Fields(html.UnescapeString(label)), " ")
+	if label == "" {
+		return elementID(cell)
+	}
+	return label
+}
@@ -1,22 +1,62 @@
 package otm_transform
 
 import (
+	"fmt"
 	"strings"
 	"text/template"
 
 	otm "github.com/adedayo/open-threat-model/pkg"
 )
 
+// OtmToGraphviz generates a Graphviz (DOT) digraph of an OTM: trust zones and parent components become nested clusters,
+// trust zones coloured by their trust rating, components become nodes shaped by their type and data flows become edges
+// labelled with their name and protocol
 func OtmToGraphviz(model otm.OpenThreatModel) (g string, err error) {
 
 	if valid, err := model.Validate(); !valid {
 		return "", err
 	}
 
+	components := make(map[string]otm.Component)
+	for _, c := range model.Components {
+		components[c.ID] = c
+	}
+	zones := make(map[string]otm.TrustZone)
+	for _, tz := range model.TrustZones {
+		zones[tz.ID] = tz
+	}
+	containers := genContainers(model)
+	crossings := crossingsByFlow(model)
+
 	temp, err := template.New("graphviz").
 		Funcs(template.FuncMap{
-			"genContainers": genContainers,
-			"sanitiseName":  sanitiseNameForGraphViz,
+			"containers": func() map[string]*containment { return containers },
+			"looseComponents": func() []otm.Component {
+				return looseComponents(model, containers)
+			},
+			"quote":     quoteForGraphViz,
+			"flowLabel": flowLabel,
+			"clusterAttributes": func(c *containment) string {
+				if tz, isZone := zones[c.ID]; isZone {
+					return fmt.Sprintf(`style="filled,rounded" fillcolor=%s`, quoteForGraphViz(trustRatingColour(TrustRating(tz))))
+				}
+				return `style="filled,dashed" fillcolor="#f5f5f5"`
+			},
+			"nodeAttributes": func(id string) string {
+				return graphvizNodeAttributes(components[id])
+			},
+			"crossingAttributes": func(df otm.DataFlow) string {
+				c, crosses := crossings[df.ID]
+				if !crosses {
+					return ""
+				}
+				return fmt.Sprintf(` color=%s penwidth=%g tooltip=%s`, quoteForGraphViz(crossingHighlight), crossingWidth(c.Score),
+					quoteForGraphViz(fmt.Sprintf("%s trust boundary crossing, score %g", c.Direction, c.Score)))
+			},
+			"isComponent": func(id string) bool {
+				_, isComponent := components[id]
+				return isComponent
+			},
 		}).Parse(tplate)
 	if err != nil {
 		return "", err
@@ -30,8 +70,57 @@ func OtmToGraphviz(model otm.OpenThreatModel) (g string, err error) {
 	return buff.String(), nil
 }
 
-func sanitiseNameForGraphViz(name string) string {
-	return strings.ReplaceAll(name, "-", "_")
+// quoteForGraphViz quotes a string as a DOT ID, so that IDs and labels may contain any character
+func quoteForGraphViz(s string) string {
+	return `"` + graphvizEscaper.Replace(s) + `"`
+}
+
+// trustRatingColour maps a trust rating (0-100) to a fill colour, from red for untrusted to green for trusted zones
+func trustRatingColour(rating float64) string {
+	switch {
+	case rating >= 80:
+		return "#d5e8d4"
+	case rating >= 50:
+		return "#fff2cc"
+	case rating >= 20:
+		return "#ffe6cc"
+	default:
+		return "#f8cecc"
+	}
+}
+
+func graphvizNodeAttributes(comp otm.Component) string {
+	shape := graphvizShapes[ComponentKind(comp.Type)]
+	if comp.Type == "" {
+		return shape
+	}
+	return fmt.Sprintf("%s tooltip=%s", shape, quoteForGraphViz(comp.Type))
+}
+
+// looseComponents returns the components that are neither in a container nor contain other elements
+func looseComponents(model otm.OpenThreatModel, containers map[string]*containment) []otm.Component {
+	contained := make(map[string]bool)
+	var mark func(c *containment)
+	mark = func(c *containment) {
+		contained[c.ID] = true
+		for id := range c.LeafChildren {
+			contained[id] = true
+		}
+		for _, child := range c.ParentChildren {
+			mark(child)
+		}
+	}
+	for _, c := range containers {
+		mark(c)
+	}
+
+	loose := []otm.Component{}
+	for _, c := range model.Components {
+		if !contained[c.ID] {
+			loose = append(loose, c)
+		}
+	}
+	return loose
 }
 
 func genContainers(model otm.OpenThreatModel) map[string]*containment {
@@ -62,6 +151,7 @@ func genContainers(model otm.OpenThreatModel) map[string]*containment {
 		}
 	}
 
+	childContainers := []string{} //containers that are children, to be removed from the top level
 	for _, tz := range model.TrustZones {
 
 		if _, exist := containers[tz.ID]; !exist {
@@ -77,6 +167,7 @@ func genContainers(model otm.OpenThreatModel) map[string]*containment {
 		trustZone := containers[tz.ID]
 
 		if tz.Parent != nil {
+			childContainers = append(childContainers, tz.ID)
 			id := tz.Parent.GetID()
 			if cont, exists := containers[id]; exists {
 				cont.ParentChildren = append(cont.ParentChildren, trustZone)
@@ -101,9 +192,8 @@ func genContainers(model otm.OpenThreatModel) map[string]*containment {
 		}
 	}
 
-	childContainers := []string{} //containers that are children, to be removed from the top level
 	for _, cont := range containers {
-		for _, id := range cont.LeafChildren {
+		for id := range cont.LeafChildren {
 			if cc, exists := containers[id]; exists {
 				//if a child is itself a parent, add it to the ParentChildren and remove it from the leaf children map
 				delete(cont.LeafChildren, id)
@@ -127,50 +217,47 @@ type containment struct {
 }
 
 var (
-	tplate = `
-	{{ define "subzone" }} 
-	  	subgraph cluster_{{ sanitiseName .ID }} {
-			label="{{ .Name }}"
-			bgcolor=lightskyblue
-			{{ range .ParentChildren }}
-				{{ template "subzone" . }}
-			{{ end }}
-			{{range $node, $name := .LeafChildren }}
-				{{ sanitiseName $node }}[label="{{ $name }}"]
-			{{ end }}
-	  	}
-	{{end}}
-	digraph G {
-		/* rankdir=LR; */
-		/* Containers/Zones */
-		{{ range genContainers . }}
-			{{ template "subzone" . }}
-		{{ end }}
-		/* Flows */
-		{{ range .DataFlows }}
-			{{ sanitiseName .Source }} -> {{ sanitiseName .Destination }}
-			{{ if .Bidirectional }} {{ sanitiseName .Destination }} -> {{ sanitiseName .Source }} {{ end }}
-		{{ end }}
-		 /* Entities */
-		 X [label="X", shape="square"]
- 
-		 /* Relationships */
-		 G -> X[label=".63"]
-		 a -> b
-		 b -> e [label="ssh"]
-		 e -> a
-		 q -> d
-		 j -> t
-		 subgraph cluster_zone {
-			 label="zone"
-			 bgcolor=lightskyblue
- 
-		  b e[shape=circle]
-		 }
- 
-		 /* Ranks */
-		 { rank=same; X; };
-	 }
-	  
+	graphvizEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", "")
+	graphvizShapes  = map[string]string{
+		"actor":     `shape=ellipse`,
+		"datastore": `shape=cylinder`,
+		"queue":     `shape=cds`,
+		"network":   `shape=hexagon`,
+		"process":   `shape=box style="rounded,filled"`,
+	}
+
+	tplate = `{{ define "subzone" -}}
+subgraph {{ quote (print "cluster_" .ID) }} {
+		label={{ quote .Name }}
+		{{ clusterAttributes . }}
+		{{- if isComponent .ID }}
+		{{ quote .ID }} [label={{ quote .Name }} {{ nodeAttributes .ID }}]
+		{{- end }}
+		{{- range .ParentChildren }}
+		{{ template "subzone" . }}
+		{{- end }}
+		{{- range $node, $name := .LeafChildren }}
+		{{ quote $node }} [label={{ quote $name }} {{ nodeAttributes $node }}]
+		{{- end }}
+	}
+{{- end -}}
+digraph G {
+	node [style="filled" fillcolor="white"]
+
+	/* Containers/Zones */
+	{{- range containers }}
+	{{ template "subzone" . }}
+	{{- end }}
+
+	/* Components outside containers */
+	{{- range looseComponents }}
+	{{ quote .ID }} [label={{ quote .Name }} {{ nodeAttributes .ID }}]
+	{{- end }}
+
+	/* Flows */
+	{{- range .DataFlows }}
+	{{ quote .Source }} -> {{ quote .Destination }} [label={{ quote (flowLabel .) }}{{ if .Bidirectional }} dir=both{{ end }}{{ crossingAttributes . }}]
+	{{- end }}
+}
 `
 )
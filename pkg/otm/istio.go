@@ -0,0 +1,243 @@
+package otm_transform
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+
+	otm "github.com/adedayo/open-threat-model/pkg"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	istioAPIVersion       = "security.istio.io/v1beta1"
+	istioTrustDomain      = "cluster.local"
+	defaultServiceAccount = "default"
+)
+
+var (
+	httpMethods = map[string]bool{
+		"GET": true, "HEAD": true, "POST": true, "PUT": true, "PATCH": true, "DELETE": true, "OPTIONS": true,
+		"TRACE": true, "CONNECT": true,
+	}
+)
+
+type istioPolicy struct {
+	APIVersion string         `yaml:"apiVersion"`
+	Kind       string         `yaml:"kind"`
+	Metadata   policyMetadata `yaml:"metadata"`
+	Spec       interface{}    `yaml:"spec"`
+}
+
+type peerAuthenticationSpec struct {
+	MTLS struct {
+		Mode string `yaml:"mode"`
+	} `yaml:"mtls"`
+}
+
+type authorizationPolicySpec struct {
+	Selector *labelSelector `yaml:"selector,omitempty"`
+	Action   string         `yaml:"action,omitempty"`
+	Rules    []istioRule    `yaml:"rules,omitempty"`
+}
+
+type istioRule struct {
+	From []istioFrom `yaml:"from,omitempty"`
+	To   []istioTo   `yaml:"to,omitempty"`
+}
+
+type istioFrom struct {
+	Source istioSource `yaml:"source"`
+}
+
+type istioSource struct {
+	Principals []string `yaml:"principals,omitempty"`
+	Namespaces []string `yaml:"namespaces,omitempty"`
+	IPBlocks   []string `yaml:"ipBlocks,omitempty"`
+}
+
+type istioTo struct {
+	Operation istioOperation `yaml:"operation"`
+}
+
+type istioOperation struct {
+	Ports   []string `yaml:"ports,omitempty"`
+	Methods []string `yaml:"methods,omitempty"`
+	Paths   []string `yaml:"paths,omitempty"`
+}
+
+// OtmToIstioPolicies generates Istio security policies that allow exactly the data flows of an OTM, by the identities
+// of the workloads rather than their addresses: a STRICT mTLS PeerAuthentication and an allow-nothing
+// AuthorizationPolicy for each namespace, and an AuthorizationPolicy for each workload that receives flows, allowing
+// them from the service accounts of their sources.
+// Trust zones and components are mapped to Kubernetes as for OtmToNetworkPolicies, and the identity of a component is
+// its serviceAccount attribute (or that of its zone), or else the default service account of its namespace. Sources
+// outside the mesh are allowed from their cidr attribute, or from anywhere if they have none, and trust zones without
+// a service account from their whole namespace.
+// Flows are limited to their TCP ports as for network policies, and HTTP flows to their method or methods attribute
+// (e.g. "GET,POST") and their path or paths attribute, where OpenAPI path templates such as /orders/{id} match by
+// prefix
+func OtmToIstioPolicies(model otm.OpenThreatModel) (string, error) {
+	elements := k8sElements(model)
+
+	policies := make(map[string]*istioPolicy)
+	order := []string{}
+	allow := func(df otm.DataFlow, from, to string, operations bool) {
+		e := elements[to]
+		if e.namespace == "" {
+			return //not in the mesh
+		}
+		p, exists := policies[to]
+		if !exists {
+			spec := &authorizationPolicySpec{Action: "ALLOW"}
+			if len(e.labels) > 0 {
+				spec.Selector = &labelSelector{MatchLabels: e.labels}
+			}
+			p = &istioPolicy{
+				APIVersion: istioAPIVersion,
+				Kind:       "AuthorizationPolicy",
+				Metadata: policyMetadata{
+					Name:        dnsLabel("allow-" + to),
+					Namespace:   e.namespace,
+					Labels:      map[string]string{managedByLabel: "zero-trust"},
+					Annotations: map[string]string{},
+				},
+				Spec: spec,
+			}
+			policies[to] = p
+			order = append(order, to)
+		}
+		rule := istioRule{}
+		if source, known := elements[from].source(); known {
+			rule.From = []istioFrom{{Source: source}}
+		}
+		operation := istioOperation{Ports: istioPorts(flowPorts(df, model, to))}
+		if operations {
+			operation.Methods = httpMethodsOf(df)
+			operation.Paths = httpPathsOf(df)
+		}
+		if len(operation.Ports) > 0 || len(operation.Methods) > 0 || len(operation.Paths) > 0 {
+			rule.To = []istioTo{{Operation: operation}}
+		}
+		spec := p.Spec.(*authorizationPolicySpec)
+		spec.Rules = append(spec.Rules, rule)
+		p.Metadata.Annotations[flowsAnnotation] = appendFlow(p.Metadata.Annotations[flowsAnnotation], df.ID)
+	}
+	for _, df := range model.DataFlows {
+		allow(df, df.Source, df.Destination, true)
+		if df.Bidirectional {
+			//the methods and paths of a flow are those of the requests to its destination
+			allow(df, df.Destination, df.Source, false)
+		}
+	}
+
+	namespaces := []string{}
+	seen := make(map[string]bool)
+	for _, e := range elements {
+		if e.namespace != "" && !seen[e.namespace] {
+			seen[e.namespace] = true
+			namespaces = append(namespaces, e.namespace)
+		}
+	}
+	if len(namespaces) == 0 {
+		return "", errors.New("no trust zone or component has a namespace or labels attribute to map it to Kubernetes")
+	}
+	sort.Strings(namespaces)
+
+	var b strings.Builder
+	enc := yaml.NewEncoder(&b)
+	enc.SetIndent(2)
+	for _, ns := range namespaces {
+		meta := policyMetadata{Name: "default", Namespace: ns, Labels: map[string]string{managedByLabel: "zero-trust"}}
+		strict := peerAuthenticationSpec{}
+		strict.MTLS.Mode = "STRICT"
+		if err := enc.Encode(istioPolicy{APIVersion: istioAPIVersion, Kind: "PeerAuthentication", Metadata: meta, Spec: strict}); err != nil {
+			return "", err
+		}
+		meta.Name = "allow-nothing"
+		if err := enc.Encode(istioPolicy{APIVersion: istioAPIVersion, Kind: "AuthorizationPolicy", Metadata: meta, Spec: authorizationPolicySpec{}}); err != nil {
+			return "", err
+		}
+	}
+	for _, id := range order {
+		if err := enc.Encode(policies[id]); err != nil {
+			return "", err
+		}
+	}
+	err := enc.Close()
+	return b.String(), err
+}
+
+// source identifies an element as the source of requests, or is unknown (any source) for elements outside the mesh
+// without a cidr
+func (e k8sElement) source() (istioSource, bool) {
+	switch {
+	case e.namespace != "" && (e.component || e.serviceAccount != ""):
+		account := e.serviceAccount
+		if account == "" {
+			account = defaultServiceAccount
+		}
+		return istioSource{Principals: []string{istioTrustDomain + "/ns/" + e.namespace + "/sa/" + account}}, true
+	case e.namespace != "":
+		return istioSource{Namespaces: []string{e.namespace}}, true
+	case e.cidr != "":
+		return istioSource{IPBlocks: []string{e.cidr}}, true
+	}
+	return istioSource{}, false
+}
+
+// istioPorts are the ports of a flow that Istio can authorise, which are single TCP ports, or none (all ports) if the
+// flow has others, so as not to deny a modelled flow
+func istioPorts(ports []policyPort) []string {
+	out := []string{}
+	for _, p := range ports {
+		number, numbered := p.Port.(int)
+		if p.Protocol != "TCP" || !numbered || p.EndPort != 0 {
+			return nil
+		}
+		out = appendUniqueString(out, strconv.Itoa(number))
+	}
+	return out
+}
+
+// httpMethodsOf are the HTTP methods of a flow, from its method or methods attribute
+func httpMethodsOf(df otm.DataFlow) []string {
+	methods := []string{}
+	for _, key := range []string{"method", "methods"} {
+		for _, m := range strings.Split(Attribute(df.Attributes, key), ",") {
+			if m = strings.ToUpper(strings.TrimSpace(m)); httpMethods[m] {
+				methods = appendUniqueString(methods, m)
+			}
+		}
+	}
+	return methods
+}
+
+// httpPathsOf are the HTTP paths of a flow, from its path or paths attribute, with path templates replaced by a prefix
+// match, since Istio only matches paths exactly, by prefix or by suffix
+func httpPathsOf(df otm.DataFlow) []string {
+	paths := []string{}
+	for _, key := range []string{"path", "paths"} {
+		for _, p := range strings.Split(Attribute(df.Attributes, key), ",") {
+			p = strings.TrimSpace(p)
+			if p == "" {
+				continue
+			}
+			if i := strings.IndexAny(p, "{*"); i >= 0 {
+				p = p[:i] + "*"
+			}
+			paths = appendUniqueString(paths, p)
+		}
+	}
+	return paths
+}
+
+func appendUniqueString(values []string, value string) []string {
+	for _, v := range values {
+		if v == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
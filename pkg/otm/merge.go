@@ -0,0 +1,560 @@
+package otm_transform
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	//top-level OTM sections whose elements are matched by ID when merging
+	idSections = map[string]bool{
+		"representations": true,
+		"assets":          true,
+		"trustZones":      true,
+		"components":      true,
+		"dataflows":       true,
+		"threats":         true,
+		"mitigations":     true,
+	}
+)
+
+// MergeConflict is a change made concurrently, and differently, on both sides of a merge, or a reference that the merge
+// left dangling, e.g. to a component that one side deleted while the other added a data flow to it
+type MergeConflict struct {
+	Section string      `json:"section"`         //top-level OTM section, e.g. components, or visualModel
+	ID      string      `json:"id,omitempty"`    //ID of the conflicting element, if the section is a list of elements
+	Field   string      `json:"field,omitempty"` //conflicting field of the element, empty if the whole element conflicts
+	Base    interface{} `json:"base"`            //value in the common ancestor
+	Ours    interface{} `json:"ours"`            //value in the incoming change
+	Theirs  interface{} `json:"theirs"`          //value that was saved concurrently
+}
+
+// MergeThreatModels performs a three-way merge of OTM YAML documents: ours and theirs are both derived from base.
+// Elements of trust zones, components, data flows etc. are matched by ID and merged field by field,
+// so concurrent edits to different elements, or to different fields of the same element, merge cleanly.
+// The sources, destinations and parents of the merged elements must still exist, otherwise they conflict.
+// If there are conflicts, the merged document should not be used
+func MergeThreatModels(base, ours, theirs string) (merged string, conflicts []MergeConflict, err error) {
+	conflicts = []MergeConflict{}
+	baseDoc, err := parseYAMLMapping(base)
+	if err != nil {
+		return "", conflicts, fmt.Errorf("base model: %w", err)
+	}
+	ourDoc, err := parseYAMLMapping(ours)
+	if err != nil {
+		return "", conflicts, fmt.Errorf("incoming model: %w", err)
+	}
+	theirDoc, err := parseYAMLMapping(theirs)
+	if err != nil {
+		return "", conflicts, fmt.Errorf("current model: %w", err)
+	}
+
+	m := merger{conflicts: conflicts}
+	result := m.mergeMappings("", "", baseDoc, ourDoc, theirDoc, true)
+	m.checkReferences(baseDoc, ourDoc, theirDoc, result)
+	merged, err = (&Document{root: result}).String()
+	return merged, m.conflicts, err
+}
+
+// MergeMxGraphs performs a three-way merge of mxGraph diagrams: ours and theirs are both derived from base.
+// Cells are matched by ID and merged field by field, so concurrent edits to different cells, or to different fields
+// (e.g. the label and the geometry) of the same cell, merge cleanly. The parents, sources and targets of the merged cells
+// must still exist, otherwise they conflict. The merged diagram has the form of theirs.
+// If there are conflicts, the merged diagram should not be used
+func MergeMxGraphs(base, ours, theirs string) (merged string, conflicts []MergeConflict, err error) {
+	conflicts = []MergeConflict{}
+	switch {
+	case ours == theirs, base == theirs:
+		return ours, conflicts, nil
+	case base == ours:
+		return theirs, conflicts, nil
+	}
+
+	baseGraph, err := ParseMxGraph(base)
+	if err != nil {
+		return "", conflicts, fmt.Errorf("base diagram: %w", err)
+	}
+	ourGraph, err := ParseMxGraph(ours)
+	if err != nil {
+		return "", conflicts, fmt.Errorf("incoming diagram: %w", err)
+	}
+	theirGraph, err := ParseMxGraph(theirs)
+	if err != nil {
+		return "", conflicts, fmt.Errorf("current diagram: %w", err)
+	}
+
+	baseCells, _ := indexCells(baseGraph)
+	ourCells, ourOrder := indexCells(ourGraph)
+	theirCells, theirOrder := indexCells(theirGraph)
+	result := &MxGraphModel{Cells: []*MxCell{}}
+	for _, id := range unionOrder(theirOrder, ourOrder) {
+		b, o, t := baseCells[id], ourCells[id], theirCells[id]
+		cell, ok := resolveValue(b, o, t)
+		if !ok {
+			if o != nil && t != nil {
+				cell, conflicts = mergeCells(id, b, o, t, conflicts)
+			} else {
+				//deleted on one side and modified on the other
+				conflicts = append(conflicts, MergeConflict{Section: "visualModel", ID: id, Base: b, Ours: o, Theirs: t})
+				cell = t
+			}
+		}
+		if cell != nil {
+			result.Cells = append(result.Cells, cell)
+		}
+	}
+	conflicts = checkCellReferences(baseCells, ourCells, theirCells, result, conflicts)
+	result.sortCells()
+	return withGraph(theirs, result), conflicts, nil
+}
+
+// checkCellReferences reports the parents, sources and targets of merged cells that are missing from the merged diagram,
+// unless they were already missing from a side that has the same reference, i.e. the merge didn't leave them dangling
+func checkCellReferences(base, ours, theirs map[string]*MxCell, merged *MxGraphModel, conflicts []MergeConflict) []MergeConflict {
+	exists := make(map[string]bool)
+	for _, c := range merged.Cells {
+		exists[c.ID] = true
+	}
+	field := func(c *MxCell, name string) interface{} {
+		if c == nil {
+			return nil
+		}
+		return map[string]string{"parent": c.Parent, "source": c.Source, "target": c.Target}[name]
+	}
+	for _, c := range merged.Cells {
+		for _, ref := range []struct{ field, id string }{{"parent", c.Parent}, {"source", c.Source}, {"target", c.Target}} {
+			if ref.id == "" || exists[ref.id] {
+				continue
+			}
+			dangling := false
+			for _, side := range []map[string]*MxCell{ours, theirs} {
+				if field(side[c.ID], ref.field) == ref.id {
+					if side[ref.id] == nil {
+						dangling = false
+						break
+					}
+					dangling = true
+				}
+			}
+			if dangling {
+				conflicts = append(conflicts, MergeConflict{Section: "visualModel", ID: c.ID, Field: ref.field,
+					Base: field(base[c.ID], ref.field), Ours: field(ours[c.ID], ref.field), Theirs: field(theirs[c.ID], ref.field)})
+			}
+		}
+	}
+	return conflicts
+}
+
+// mergeCells merges the fields of a cell that was changed on both sides
+func mergeCells(id string, base, ours, theirs *MxCell, conflicts []MergeConflict) (*MxCell, []MergeConflict) {
+	if base == nil {
+		base = &MxCell{}
+	}
+	merged := *theirs
+	fields := []struct {
+		name    string
+		b, o, t interface{}
+		set     func(v interface{})
+	}{
+		{"value", base.Value, ours.Value, theirs.Value, func(v interface{}) { merged.Value = v.(string) }},
+		{"style", base.Style, ours.Style, theirs.Style, func(v interface{}) { merged.Style = v.(string) }},
+		{"parent", base.Parent, ours.Parent, theirs.Parent, func(v interface{}) { merged.Parent = v.(string) }},
+		{"source", base.Source, ours.Source, theirs.Source, func(v interface{}) { merged.Source = v.(string) }},
+		{"target", base.Target, ours.Target, theirs.Target, func(v interface{}) { merged.Target = v.(string) }},
+		{"vertex", base.Vertex, ours.Vertex, theirs.Vertex, func(v interface{}) { merged.Vertex = v.(bool) }},
+		{"edge", base.Edge, ours.Edge, theirs.Edge, func(v interface{}) { merged.Edge = v.(bool) }},
+		{"geometry", base.Geometry, ours.Geometry, theirs.Geometry, func(v interface{}) { merged.Geometry = v.(*MxGeometry) }},
+	}
+	for _, f := range fields {
+		if v, ok := resolveValue(f.b, f.o, f.t); ok {
+			f.set(v)
+		} else {
+			conflicts = append(conflicts, MergeConflict{Section: "visualModel", ID: id, Field: f.name, Base: f.b, Ours: f.o, Theirs: f.t})
+		}
+	}
+	merged.Attributes, conflicts = mergeStringMaps(id, base.Attributes, ours.Attributes, theirs.Attributes, conflicts)
+	merged.Extra, conflicts = mergeStringMaps(id, base.Extra, ours.Extra, theirs.Extra, conflicts)
+	return &merged, conflicts
+}
+
+// mergeStringMaps merges the attributes of a cell key by key
+func mergeStringMaps(id string, base, ours, theirs map[string]string, conflicts []MergeConflict) (map[string]string, []MergeConflict) {
+	var merged map[string]string
+	keys := unionOrder(sortedKeys(theirs), sortedKeys(ours), sortedKeys(base))
+	for _, k := range keys {
+		b, bOK := base[k]
+		o, oOK := ours[k]
+		t, tOK := theirs[k]
+		value := func(v string, ok bool) interface{} {
+			if ok {
+				return v
+			}
+			return nil
+		}
+		v, ok := resolveValue(value(b, bOK), value(o, oOK), value(t, tOK))
+		if !ok {
+			conflicts = append(conflicts, MergeConflict{Section: "visualModel", ID: id, Field: k, Base: value(b, bOK), Ours: value(o, oOK), Theirs: value(t, tOK)})
+			v = value(t, tOK)
+		}
+		if v != nil {
+			if merged == nil {
+				merged = make(map[string]string)
+			}
+			merged[k] = v.(string)
+		}
+	}
+	return merged, conflicts
+}
+
+// resolveValue returns the merged value if at most one side changed it (or both changed it the same way)
+func resolveValue[V any](base, ours, theirs V) (V, bool) {
+	switch {
+	case reflect.DeepEqual(ours, theirs), reflect.DeepEqual(base, theirs):
+		return ours, true
+	case reflect.DeepEqual(base, ours):
+		return theirs, true
+	}
+	var none V
+	return none, false
+}
+
+func indexCells(graph *MxGraphModel) (map[string]*MxCell, []string) {
+	index := make(map[string]*MxCell)
+	order := []string{}
+	for i, c := range graph.Cells {
+		id := c.ID
+		if id == "" {
+			id = fmt.Sprintf("#%d", i)
+		}
+		index[id] = c
+		order = append(order, id)
+	}
+	return index, order
+}
+
+// withGraph replaces the cells of a diagram, keeping its form: a draw.io file, an <mxGraphModel> or just its <root>
+func withGraph(diagram string, graph *MxGraphModel) string {
+	content := graph.String()
+	trimmed := strings.TrimSpace(diagram)
+	if !strings.HasPrefix(trimmed, "<mxGraphModel") {
+		content = strings.TrimSuffix(strings.TrimPrefix(content, "<mxGraphModel>"), "</mxGraphModel>")
+	}
+	if strings.HasPrefix(trimmed, "<mxfile") {
+		start := strings.Index(trimmed, "<diagram")
+		end := strings.Index(trimmed, "</diagram>")
+		if start >= 0 && end > start {
+			if open := strings.Index(trimmed[start:], ">"); open >= 0 && start+open < end {
+				return trimmed[:start+open+1] + content + trimmed[end:]
+			}
+		}
+		return `<mxfile host="zero-trust"><diagram>` + content + "</diagram></mxfile>"
+	}
+	return content
+}
+
+type merger struct {
+	conflicts []MergeConflict
+}
+
+// mergeMappings merges the key/values of a mapping, descending into the ID-keyed sections at the top level
+func (m *merger) mergeMappings(section, id string, base, ours, theirs *yaml.Node, topLevel bool) *yaml.Node {
+	result := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	for _, key := range unionKeys(theirs, ours, base) {
+		b, o, t := mappingValue(base, key), mappingValue(ours, key), mappingValue(theirs, key)
+
+		var value *yaml.Node
+		switch {
+		case topLevel && idSections[key]:
+			value = m.mergeSection(key, b, o, t)
+		case topLevel && isMapping(o) && isMapping(t):
+			//e.g. the project section
+			value = m.mergeMappings(key, "", b, o, t, false)
+		default:
+			if v, ok := resolve(b, o, t); ok {
+				value = v
+			} else {
+				if topLevel {
+					m.conflict(key, "", "", b, o, t)
+				} else {
+					m.conflict(section, id, key, b, o, t)
+				}
+				value = t
+			}
+		}
+
+		if value != nil {
+			result.Content = append(result.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, value)
+		}
+	}
+	return result
+}
+
+// mergeSection merges a list of OTM elements by their IDs, keeping the order of theirs and appending our new elements
+func (m *merger) mergeSection(section string, base, ours, theirs *yaml.Node) *yaml.Node {
+	if v, ok := resolve(base, ours, theirs); ok {
+		return v
+	}
+
+	baseElems, _ := indexByID(base)
+	ourElems, ourOrder := indexByID(ours)
+	theirElems, theirOrder := indexByID(theirs)
+
+	result := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+	for _, id := range unionOrder(theirOrder, ourOrder) {
+		b, o, t := baseElems[id], ourElems[id], theirElems[id]
+		v, ok := resolve(b, o, t)
+		if !ok {
+			if isMapping(o) && isMapping(t) {
+				v = m.mergeMappings(section, id, b, o, t, false)
+			} else {
+				//deleted on one side and modified on the other
+				m.conflict(section, id, "", b, o, t)
+				v = t
+			}
+		}
+		if v != nil {
+			result.Content = append(result.Content, v)
+		}
+	}
+	if len(result.Content) == 0 {
+		return nil
+	}
+	return result
+}
+
+// reference is a reference of an element to another, e.g. the source of a data flow or the parent of a component
+type reference struct {
+	section, id, field string   //the referring element and its field
+	target             string   //ID of the referenced element
+	in                 []string //sections the referenced element may be in
+}
+
+// references returns the parents of the trust zones and components of an OTM document, and the sources and
+// destinations of its data flows
+func references(doc *yaml.Node) []reference {
+	refs := []reference{}
+	for _, section := range []string{"trustZones", "components"} {
+		elems, order := indexByID(mappingValue(doc, section))
+		for _, id := range order {
+			parent := mappingValue(elems[id], "parent")
+			for _, key := range mappingKeys(parent) {
+				if target := mappingValue(parent, key).Value; target != "" {
+					refs = append(refs, reference{section, id, "parent", target, []string{key + "s"}})
+				}
+			}
+		}
+	}
+	flows, order := indexByID(mappingValue(doc, "dataflows"))
+	for _, id := range order {
+		for _, field := range []string{"source", "destination"} {
+			if target := mappingValue(flows[id], field); target != nil && target.Value != "" {
+				refs = append(refs, reference{"dataflows", id, field, target.Value, []string{"components", "trustZones"}})
+			}
+		}
+	}
+	return refs
+}
+
+// resolves checks whether the referenced element is in an OTM document
+func (r reference) resolves(doc *yaml.Node) bool {
+	for _, section := range r.in {
+		if elems, _ := indexByID(mappingValue(doc, section)); elems[r.target] != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// checkReferences reports the references of the merged document to elements it doesn't have, unless they were already
+// dangling on a side that has the same reference, i.e. the merge didn't leave them dangling
+func (m *merger) checkReferences(base, ours, theirs, merged *yaml.Node) {
+	field := func(doc *yaml.Node, r reference) *yaml.Node {
+		elems, _ := indexByID(mappingValue(doc, r.section))
+		return mappingValue(elems[r.id], r.field)
+	}
+	has := func(doc *yaml.Node, r reference) bool {
+		for _, ref := range references(doc) {
+			if ref.section == r.section && ref.id == r.id && ref.field == r.field && ref.target == r.target {
+				return true
+			}
+		}
+		return false
+	}
+	for _, r := range references(merged) {
+		if r.resolves(merged) {
+			continue
+		}
+		dangling := false
+		for _, side := range []*yaml.Node{ours, theirs} {
+			if has(side, r) {
+				if !r.resolves(side) {
+					dangling = false
+					break
+				}
+				dangling = true
+			}
+		}
+		if dangling {
+			m.conflict(r.section, r.id, r.field, field(base, r), field(ours, r), field(theirs, r))
+		}
+	}
+}
+
+func (m *merger) conflict(section, id, field string, base, ours, theirs *yaml.Node) {
+	m.conflicts = append(m.conflicts, MergeConflict{
+		Section: section,
+		ID:      id,
+		Field:   field,
+		Base:    decodeNode(base),
+		Ours:    decodeNode(ours),
+		Theirs:  decodeNode(theirs),
+	})
+}
+
+// resolve returns the merged value if at most one side changed it (or both changed it the same way); nil means deleted
+func resolve(base, ours, theirs *yaml.Node) (*yaml.Node, bool) {
+	switch {
+	case nodesEqual(ours, theirs), nodesEqual(base, theirs):
+		return ours, true
+	case nodesEqual(base, ours):
+		return theirs, true
+	}
+	return nil, false
+}
+
+func parseYAMLMapping(doc string) (*yaml.Node, error) {
+	var node yaml.Node
+	if strings.TrimSpace(doc) == "" {
+		return nil, nil
+	}
+	if err := yaml.Unmarshal([]byte(doc), &node); err != nil {
+		return nil, err
+	}
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		if root := node.Content[0]; root.Kind == yaml.MappingNode {
+			return root, nil
+		}
+	}
+	return nil, fmt.Errorf("expecting an OTM document (a YAML mapping)")
+}
+
+func isMapping(n *yaml.Node) bool {
+	return n != nil && n.Kind == yaml.MappingNode
+}
+
+func mappingValue(n *yaml.Node, key string) *yaml.Node {
+	if !isMapping(n) {
+		return nil
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return n.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func mappingKeys(n *yaml.Node) []string {
+	keys := []string{}
+	if !isMapping(n) {
+		return keys
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		keys = append(keys, n.Content[i].Value)
+	}
+	return keys
+}
+
+func unionKeys(nodes ...*yaml.Node) []string {
+	orders := [][]string{}
+	for _, n := range nodes {
+		orders = append(orders, mappingKeys(n))
+	}
+	return unionOrder(orders...)
+}
+
+// unionOrder returns the distinct items of the lists, in order of first appearance
+func unionOrder(lists ...[]string) []string {
+	seen := make(map[string]bool)
+	out := []string{}
+	for _, list := range lists {
+		for _, item := range list {
+			if !seen[item] {
+				seen[item] = true
+				out = append(out, item)
+			}
+		}
+	}
+	return out
+}
+
+// indexByID indexes the elements of a sequence by their id field; elements without an ID are keyed by position
+func indexByID(n *yaml.Node) (map[string]*yaml.Node, []string) {
+	index := make(map[string]*yaml.Node)
+	order := []string{}
+	if n == nil || n.Kind != yaml.SequenceNode {
+		return index, order
+	}
+	for i, elem := range n.Content {
+		id := fmt.Sprintf("#%d", i)
+		if v := mappingValue(elem, "id"); v != nil && v.Value != "" {
+			id = v.Value
+		}
+		index[id] = elem
+		order = append(order, id)
+	}
+	return index, order
+}
+
+// nodesEqual compares the content of YAML nodes, ignoring style, comments and positions
+func nodesEqual(a, b *yaml.Node) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if a.Kind == yaml.AliasNode {
+		return nodesEqual(a.Alias, b)
+	}
+	if b.Kind == yaml.AliasNode {
+		return nodesEqual(a, b.Alias)
+	}
+	if a.Kind != b.Kind || a.ShortTag() != b.ShortTag() || a.Value != b.Value {
+		return false
+	}
+
+	if a.Kind == yaml.MappingNode {
+		//key order is not significant
+		if len(a.Content) != len(b.Content) {
+			return false
+		}
+		for _, key := range mappingKeys(a) {
+			if !nodesEqual(mappingValue(a, key), mappingValue(b, key)) {
+				return false
+			}
+		}
+		return true
+	}
+
+	if len(a.Content) != len(b.Content) {
+		return false
+	}
+	for i := range a.Content {
+		if !nodesEqual(a.Content[i], b.Content[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func decodeNode(n *yaml.Node) interface{} {
+	if n == nil {
+		return nil
+	}
+	var v interface{}
+	if err := n.Decode(&v); err != nil {
+		return n.Value
+	}
+	return v
+}
@@ -0,0 +1,270 @@
+package otm_transform
+
+import (
+	"strings"
+	"testing"
+)
+
+const baseDiagram = `<root><mxCell id="0"/><mxCell id="1" parent="0"/>` +
+	`<object id="web" label="Web" otm="component"><mxCell style="rounded=1;" vertex="1" parent="1"><mxGeometry x="10" y="10" width="120" height="60" as="geometry"/></mxCell></object>` +
+	`<object id="db" label="DB" otm="component"><mxCell style="shape=cylinder3;" vertex="1" parent="1"><mxGeometry x="200" y="10" width="120" height="60" as="geometry"/></mxCell></object>` +
+	`</root>`
+
+func TestMergeMxGraphs(t *testing.T) {
+	edit := func(replacements ...string) string {
+		return strings.NewReplacer(replacements...).Replace(baseDiagram)
+	}
+	cases := []struct {
+		name           string
+		ours, theirs   string
+		conflicts      int
+		contains       []string
+		doesNotContain []string
+	}{
+		{
+			name:     "only ours changed",
+			ours:     edit(`label="Web"`, `label="Web app"`),
+			theirs:   baseDiagram,
+			contains: []string{`label="Web app"`},
+		},
+		{
+			name:     "only theirs changed",
+			ours:     baseDiagram,
+			theirs:   edit(`label="DB"`, `label="Orders"`),
+			contains: []string{`label="Orders"`},
+		},
+		{
+			name:     "different cells changed",
+			ours:     edit(`label="Web"`, `label="Web app"`),
+			theirs:   edit(`x="200"`, `x="400"`),
+			contains: []string{`label="Web app"`, `x="400"`},
+		},
+		{
+			name:     "different fields of a cell changed",
+			ours:     edit(`label="Web"`, `label="Web app"`),
+			theirs:   edit(`x="10" y="10"`, `x="50" y="10"`),
+			contains: []string{`label="Web app"`, `x="50"`},
+		},
+		{
+			name:     "different attributes of a cell changed",
+			ours:     edit(`label="Web" otm="component"`, `label="Web" otm="component" owner="shop"`),
+			theirs:   edit(`label="Web" otm="component"`, `label="Web" otm="component" tier="front"`),
+			contains: []string{`owner="shop"`, `tier="front"`},
+		},
+		{
+			name:     "cells added on both sides",
+			ours:     edit(`</root>`, `<mxCell id="a" value="A" vertex="1" parent="1"/></root>`),
+			theirs:   edit(`</root>`, `<mxCell id="b" value="B" vertex="1" parent="1"/></root>`),
+			contains: []string{`id="a"`, `id="b"`},
+		},
+		{
+			name:           "a cell deleted on one side",
+			ours:           edit(`label="Web"`, `label="Web app"`),
+			theirs:         baseDiagram[:strings.Index(baseDiagram, `<object id="db"`)] + `</root>`,
+			contains:       []string{`label="Web app"`},
+			doesNotContain: []string{`id="db"`},
+		},
+		{
+			name:      "the same field changed differently",
+			ours:      edit(`label="Web"`, `label="Web app"`),
+			theirs:    edit(`label="Web"`, `label="Website"`),
+			conflicts: 1,
+		},
+		{
+			name:      "a cell deleted on one side and changed on the other",
+			ours:      edit(`label="DB"`, `label="Orders"`),
+			theirs:    baseDiagram[:strings.Index(baseDiagram, `<object id="db"`)] + `</root>`,
+			conflicts: 1,
+		},
+		{
+			name:      "an edge added to a cell deleted on the other side",
+			ours:      edit(`</root>`, `<mxCell id="web-db" edge="1" parent="1" source="web" target="db"/></root>`),
+			theirs:    baseDiagram[:strings.Index(baseDiagram, `<object id="db"`)] + `</root>`,
+			conflicts: 1,
+		},
+		{
+			name:      "a cell added to a container deleted on the other side",
+			ours:      edit(`</root>`, `<mxCell id="cache" vertex="1" parent="db"/></root>`),
+			theirs:    baseDiagram[:strings.Index(baseDiagram, `<object id="db"`)] + `</root>`,
+			conflicts: 1,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			merged, conflicts, err := MergeMxGraphs(baseDiagram, c.ours, c.theirs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(conflicts) != c.conflicts {
+				t.Fatalf("got %d conflicts, want %d: %+v", len(conflicts), c.conflicts, conflicts)
+			}
+			if c.conflicts > 0 {
+				return
+			}
+			if !strings.HasPrefix(merged, "<root>") {
+				t.Errorf("the merged diagram should keep the form of theirs: %s", merged)
+			}
+			if _, err := ParseMxGraph(merged); err != nil {
+				t.Errorf("the merged diagram doesn't parse: %v", err)
+			}
+			for _, s := range c.contains {
+				if !strings.Contains(merged, s) {
+					t.Errorf("the merged diagram doesn't contain %s: %s", s, merged)
+				}
+			}
+			for _, s := range c.doesNotContain {
+				if strings.Contains(merged, s) {
+					t.Errorf("the merged diagram contains %s: %s", s, merged)
+				}
+			}
+		})
+	}
+}
+
+const baseModel = `otmVersion: 0.1.0
+project:
+  name: shop
+  id: shop
+trustZones:
+  - id: internet
+    name: Internet
+    risk:
+      trustRating: 10
+components:
+  - id: web
+    name: Web
+    type: web-server
+    parent:
+      trustZone: internet
+  - id: db
+    name: DB
+    type: database
+dataflows:
+  - id: web-db
+    name: query
+    source: web
+    destination: db
+`
+
+func TestMergeThreatModels(t *testing.T) {
+	edit := func(replacements ...string) string {
+		return strings.NewReplacer(replacements...).Replace(baseModel)
+	}
+	withoutDB := edit("  - id: db\n    name: DB\n    type: database\n", "")
+	cases := []struct {
+		name         string
+		ours, theirs string
+		conflicts    []MergeConflict //sections, IDs and fields of the expected conflicts
+		contains     []string
+		absent       []string
+	}{
+		{
+			name:     "only ours changed",
+			ours:     edit("name: Web\n", "name: Web app\n"),
+			theirs:   baseModel,
+			contains: []string{"name: Web app"},
+		},
+		{
+			name:     "different elements changed",
+			ours:     edit("name: Web\n", "name: Web app\n"),
+			theirs:   edit("name: DB\n", "name: Orders\n"),
+			contains: []string{"name: Web app", "name: Orders"},
+		},
+		{
+			name:     "different fields of an element changed",
+			ours:     edit("name: Web\n", "name: Web app\n"),
+			theirs:   edit("type: web-server", "type: web-application"),
+			contains: []string{"name: Web app", "type: web-application"},
+		},
+		{
+			name:     "the project and an element changed",
+			ours:     edit("  name: shop\n", "  name: online shop\n"),
+			theirs:   edit("trustRating: 10", "trustRating: 5"),
+			contains: []string{"name: online shop", "trustRating: 5"},
+		},
+		{
+			name:     "elements added on both sides",
+			ours:     baseModel + "  - id: web-cache\n    name: cache\n    source: web\n    destination: cache\n",
+			theirs:   baseModel + "  - id: web-queue\n    name: publish\n    source: web\n    destination: queue\n",
+			contains: []string{"id: web-cache", "id: web-queue"},
+		},
+		{
+			name:     "an element deleted on one side",
+			ours:     edit("name: Web\n", "name: Web app\n"),
+			theirs:   withoutDB,
+			contains: []string{"name: Web app"},
+			absent:   []string{"id: db"},
+		},
+		{
+			name:     "the same change on both sides",
+			ours:     edit("name: Web\n", "name: Web app\n"),
+			theirs:   edit("name: Web\n", "name: Web app\n"),
+			contains: []string{"name: Web app"},
+		},
+		{
+			name:      "the same field changed differently",
+			ours:      edit("name: Web\n", "name: Web app\n"),
+			theirs:    edit("name: Web\n", "name: Website\n"),
+			conflicts: []MergeConflict{{Section: "components", ID: "web", Field: "name"}},
+		},
+		{
+			name:      "an element deleted on one side and changed on the other",
+			ours:      edit("name: DB\n", "name: Orders\n"),
+			theirs:    withoutDB,
+			conflicts: []MergeConflict{{Section: "components", ID: "db"}},
+		},
+		{
+			name:      "a data flow added to an element deleted on the other side",
+			ours:      baseModel + "  - id: db-web\n    name: reply\n    source: db\n    destination: web\n",
+			theirs:    withoutDB,
+			conflicts: []MergeConflict{{Section: "dataflows", ID: "db-web", Field: "source"}},
+		},
+		{
+			name: "an element moved into a zone deleted on the other side",
+			ours: edit("    type: database\n", "    type: database\n    parent:\n      trustZone: internet\n"),
+			theirs: edit("trustZones:\n  - id: internet\n    name: Internet\n    risk:\n      trustRating: 10\n", "",
+				"    parent:\n      trustZone: internet\n", ""),
+			conflicts: []MergeConflict{{Section: "components", ID: "db", Field: "parent"}},
+		},
+		{
+			name:      "a top-level value changed differently",
+			ours:      edit("otmVersion: 0.1.0", "otmVersion: 0.2.0"),
+			theirs:    edit("otmVersion: 0.1.0", "otmVersion: 0.1.1"),
+			conflicts: []MergeConflict{{Section: "otmVersion"}},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			merged, conflicts, err := MergeThreatModels(baseModel, c.ours, c.theirs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(conflicts) != len(c.conflicts) {
+				t.Fatalf("got %d conflicts, want %d: %+v", len(conflicts), len(c.conflicts), conflicts)
+			}
+			for i, want := range c.conflicts {
+				got := conflicts[i]
+				if got.Section != want.Section || got.ID != want.ID || got.Field != want.Field {
+					t.Errorf("got conflict %s/%s/%s, want %s/%s/%s", got.Section, got.ID, got.Field, want.Section, want.ID, want.Field)
+				}
+			}
+			for _, s := range c.contains {
+				if !strings.Contains(merged, s) {
+					t.Errorf("the merged model doesn't contain %q:\n%s", s, merged)
+				}
+			}
+			for _, s := range c.absent {
+				if strings.Contains(merged, s) {
+					t.Errorf("the merged model contains %q:\n%s", s, merged)
+				}
+			}
+		})
+	}
+}
+
+func TestMergeThreatModelsRejectsInvalidModels(t *testing.T) {
+	if _, _, err := MergeThreatModels(baseModel, "- not\n- a mapping\n", baseModel); err == nil ||
+		!strings.HasPrefix(err.Error(), "incoming model:") {
+		t.Errorf("got %v, want an error about the incoming model", err)
+	}
+}
@@ -0,0 +1,128 @@
+package otm_transform
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	otm "github.com/adedayo/open-threat-model/pkg"
+)
+
+var (
+	unsafeIDChars = regexp.MustCompile(`[^A-Za-z0-9_]`)
+	//words that can't be used as Mermaid or PlantUML identifiers
+	reservedIDs = map[string]bool{
+		"end": true, "graph": true, "subgraph": true, "flowchart": true, "style": true, "class": true,
+		"classdef": true, "click": true, "linkstyle": true, "direction": true, "as": true,
+	}
+	mermaidShapes = map[string][2]string{
+		"actor":     {"([", "])"},
+		"datastore": {"[(", ")]"},
+		"queue":     {"[[", "]]"},
+		"network":   {"{{", "}}"},
+		"process":   {"(", ")"},
+	}
+)
+
+// diagramIDs maps OTM IDs, which may contain any character, to unique identifiers that text diagram languages accept
+type diagramIDs struct {
+	ids  map[string]string
+	used map[string]bool
+}
+
+func newDiagramIDs() *diagramIDs {
+	return &diagramIDs{ids: make(map[string]string), used: make(map[string]bool)}
+}
+
+func (d *diagramIDs) of(id string) string {
+	if safe, exists := d.ids[id]; exists {
+		return safe
+	}
+	safe := unsafeIDChars.ReplaceAllString(id, "_")
+	if safe == "" || reservedIDs[strings.ToLower(safe)] || (safe[0] >= '0' && safe[0] <= '9') {
+		safe = "_" + safe
+	}
+	for candidate, i := safe, 2; ; i++ {
+		if !d.used[candidate] {
+			safe = candidate
+			break
+		}
+		candidate = fmt.Sprintf("%s_%d", safe, i)
+	}
+	d.ids[id] = safe
+	d.used[safe] = true
+	return safe
+}
+
+// OtmToMermaid generates a Mermaid flowchart of an OTM, which Markdown renderers such as GitHub's display natively.
+// Trust zones and parent components become nested subgraphs, drawn like OtmToGraphviz draws its clusters
+func OtmToMermaid(model otm.OpenThreatModel) (string, error) {
+	if valid, err := model.Validate(); !valid {
+		return "", err
+	}
+
+	components := make(map[string]otm.Component)
+	for _, c := range model.Components {
+		components[c.ID] = c
+	}
+	zones := make(map[string]otm.TrustZone)
+	for _, tz := range model.TrustZones {
+		zones[tz.ID] = tz
+	}
+	ids := newDiagramIDs()
+	styles := []string{}
+
+	var b strings.Builder
+	b.WriteString("flowchart LR\n")
+	node := func(indent, id, name string) {
+		shape := mermaidShapes[ComponentKind(components[id].Type)]
+		fmt.Fprintf(&b, "%s%s%s%s%s\n", indent, ids.of(id), shape[0], mermaidLabel(name), shape[1])
+	}
+	var subgraph func(c *containment, indent string)
+	subgraph = func(c *containment, indent string) {
+		fmt.Fprintf(&b, "%ssubgraph %s[%s]\n", indent, ids.of(c.ID), mermaidLabel(c.Name))
+		for _, child := range c.ParentChildren {
+			subgraph(child, indent+"  ")
+		}
+		for _, id := range sortedKeys(c.LeafChildren) {
+			node(indent+"  ", id, c.LeafChildren[id])
+		}
+		fmt.Fprintf(&b, "%send\n", indent)
+
+		fill := groupFill
+		if tz, isZone := zones[c.ID]; isZone {
+			fill = trustRatingColour(TrustRating(tz))
+		}
+		styles = append(styles, fmt.Sprintf("style %s fill:%s,stroke:%s", ids.of(c.ID), fill, strokeColour))
+	}
+
+	containers := genContainers(model)
+	for _, id := range sortedKeys(containers) {
+		subgraph(containers[id], "  ")
+	}
+	for _, c := range looseComponents(model, containers) {
+		node("  ", c.ID, c.Name)
+	}
+
+	for _, df := range model.DataFlows {
+		arrow := "-->"
+		if df.Bidirectional {
+			arrow = "<-->"
+		}
+		label := ""
+		if l := flowLabel(df); l != "" {
+			label = "|" + mermaidLabel(l) + "|"
+		}
+		fmt.Fprintf(&b, "  %s %s%s %s\n", ids.of(df.Source), arrow, label, ids.of(df.Destination))
+	}
+
+	for _, s := range styles {
+		fmt.Fprintf(&b, "  %s\n", s)
+	}
+	return b.String(), nil
+}
+
+// mermaidLabel quotes a label, escaping the characters that Mermaid would otherwise interpret
+func mermaidLabel(label string) string {
+	return `"` + strings.NewReplacer(`"`, "#quot;", "\n", " ").Replace(label) + `"`
+}
@@ -0,0 +1,377 @@
+package otm_transform
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MxGraphModel is the cell list of an mxGraph (draw.io) diagram, in document order
+// see https://jgraph.github.io/mxgraph/docs/js-api/files/model/mxCell-js.html
+type MxGraphModel struct {
+	Cells []*MxCell `json:"cells"`
+}
+
+type MxCell struct {
+	ID       string      `json:"id"`
+	Value    string      `json:"value,omitempty"`
+	Style    string      `json:"style,omitempty"`
+	Parent   string      `json:"parent,omitempty"`
+	Source   string      `json:"source,omitempty"`
+	Target   string      `json:"target,omitempty"`
+	Vertex   bool        `json:"vertex,omitempty"`
+	Edge     bool        `json:"edge,omitempty"`
+	Geometry *MxGeometry `json:"geometry,omitempty"`
+	//user-defined attributes, which draw.io stores on an <object> wrapping the cell
+	Attributes map[string]string `json:"attributes,omitempty"`
+	//other attributes of the cell, e.g. connectable or collapsed
+	Extra map[string]string `json:"extra,omitempty"`
+}
+
+// mxCellAttrs are the attributes held by the fields of MxCell
+var mxCellAttrs = map[string]bool{
+	"id": true, "value": true, "label": true, "style": true, "parent": true,
+	"source": true, "target": true, "vertex": true, "edge": true,
+}
+
+type MxGeometry struct {
+	X        float64 `xml:"x,attr,omitempty" json:"x,omitempty"`
+	Y        float64 `xml:"y,attr,omitempty" json:"y,omitempty"`
+	Width    float64 `xml:"width,attr,omitempty" json:"width,omitempty"`
+	Height   float64 `xml:"height,attr,omitempty" json:"height,omitempty"`
+	Relative string  `xml:"relative,attr,omitempty" json:"relative,omitempty"`
+	As       string  `xml:"as,attr" json:"as,omitempty"`
+	Inner    string  `xml:",innerxml" json:"inner,omitempty"` //e.g. edge waypoints, kept verbatim
+}
+
+// xmlElement is a generic <mxCell>, or an <object>/<UserObject> wrapping one
+type xmlElement struct {
+	XMLName  xml.Name
+	Attrs    []xml.Attr  `xml:",any,attr"`
+	Cell     *xmlElement `xml:"mxCell"`
+	Geometry *MxGeometry `xml:"mxGeometry"`
+}
+
+type xmlRoot struct {
+	Elements []xmlElement `xml:",any"`
+}
+
+type xmlGraphModel struct {
+	Root xmlRoot `xml:"root"`
+}
+
+// ParseMxGraph parses mxGraph XML, either a complete <mxGraphModel> or just its <root>, as stored in projects
+func ParseMxGraph(visualModel string) (*MxGraphModel, error) {
+	model := &MxGraphModel{Cells: []*MxCell{}}
+	visualModel = strings.TrimSpace(visualModel)
+	if visualModel == "" {
+		return model, nil
+	}
+
+	if strings.HasPrefix(visualModel, "<mxfile") {
+		var err error
+		if visualModel, err = diagramOfMxFile(visualModel); err != nil {
+			return model, err
+		}
+	}
+
+	var root xmlRoot
+	if strings.HasPrefix(visualModel, "<mxGraphModel") {
+		var gm xmlGraphModel
+		if err := xml.Unmarshal([]byte(visualModel), &gm); err != nil {
+			return model, err
+		}
+		root = gm.Root
+	} else if err := xml.Unmarshal([]byte(visualModel), &root); err != nil {
+		return model, err
+	}
+
+	for _, elem := range root.Elements {
+		cell := &MxCell{}
+		switch elem.XMLName.Local {
+		case "mxCell":
+			cell.setAttributes(elem.Attrs, false)
+			cell.Geometry = elem.Geometry
+		case "object", "UserObject":
+			cell.setAttributes(elem.Attrs, true)
+			if elem.Cell != nil {
+				cell.setAttributes(elem.Cell.Attrs, false)
+				cell.Geometry = elem.Cell.Geometry
+			}
+		default:
+			continue
+		}
+		model.Cells = append(model.Cells, cell)
+	}
+	return model, nil
+}
+
+// diagramOfMxFile extracts the (first) diagram of a draw.io file, which may be compressed
+func diagramOfMxFile(mxFile string) (string, error) {
+	var file struct {
+		Diagrams []struct {
+			Content string `xml:",innerxml"`
+		} `xml:"diagram"`
+	}
+	if err := xml.Unmarshal([]byte(mxFile), &file); err != nil {
+		return "", err
+	}
+	if len(file.Diagrams) == 0 {
+		return "", nil
+	}
+
+	content := strings.TrimSpace(file.Diagrams[0].Content)
+	if content == "" || strings.HasPrefix(content, "<") {
+		return content, nil
+	}
+	//compressed: base64 encoded, deflated, URI-encoded XML
+	data, err := base64.StdEncoding.DecodeString(content)
+	if err != nil {
+		return "", err
+	}
+	inflated, err := io.ReadAll(flate.NewReader(bytes.NewReader(data)))
+	if err != nil {
+		return "", err
+	}
+	decoded, err := url.PathUnescape(string(inflated))
+	return strings.TrimSpace(decoded), err
+}
+
+func (c *MxCell) setAttributes(attrs []xml.Attr, wrapper bool) {
+	for _, a := range attrs {
+		switch a.Name.Local {
+		case "id":
+			if c.ID == "" {
+				c.ID = a.Value
+			}
+		case "value", "label":
+			c.Value = a.Value
+		case "style":
+			c.Style = a.Value
+		case "parent":
+			c.Parent = a.Value
+		case "source":
+			c.Source = a.Value
+		case "target":
+			c.Target = a.Value
+		case "vertex":
+			c.Vertex = a.Value == "1"
+		case "edge":
+			c.Edge = a.Value == "1"
+		default:
+			if wrapper {
+				if c.Attributes == nil {
+					c.Attributes = make(map[string]string)
+				}
+				c.Attributes[a.Name.Local] = a.Value
+			} else {
+				if c.Extra == nil {
+					c.Extra = make(map[string]string)
+				}
+				c.Extra[a.Name.Local] = a.Value
+			}
+		}
+	}
+}
+
+// Cell returns the cell with the given ID, or nil
+func (m *MxGraphModel) Cell(id string) *MxCell {
+	for _, c := range m.Cells {
+		if c.ID == id {
+			return c
+		}
+	}
+	return nil
+}
+
+// Copy returns a deep copy of the cell, sharing none of its attributes or geometry
+func (c *MxCell) Copy() *MxCell {
+	cell := *c
+	if c.Geometry != nil {
+		geometry := *c.Geometry
+		cell.Geometry = &geometry
+	}
+	cell.Attributes = copyAttributes(c.Attributes)
+	cell.Extra = copyAttributes(c.Extra)
+	return &cell
+}
+
+func copyAttributes(attrs map[string]string) map[string]string {
+	if attrs == nil {
+		return nil
+	}
+	out := make(map[string]string, len(attrs))
+	for k, v := range attrs {
+		out[k] = v
+	}
+	return out
+}
+
+// StyleValue returns the value of a key in a cell style such as "shape=cylinder;whiteSpace=wrap"
+func (c *MxCell) StyleValue(key string) (string, bool) {
+	for _, kv := range strings.Split(c.Style, ";") {
+		if k, v, found := strings.Cut(kv, "="); found && k == key {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// String serialises the model as an <mxGraphModel> document
+func (m *MxGraphModel) String() string {
+	var b strings.Builder
+	b.WriteString("<mxGraphModel><root>")
+	for _, c := range m.Cells {
+		c.writeXML(&b)
+	}
+	b.WriteString("</root></mxGraphModel>")
+	return b.String()
+}
+
+func (c *MxCell) writeXML(b *strings.Builder) {
+	wrapped := len(c.Attributes) > 0
+	if wrapped {
+		b.WriteString("<object")
+		writeAttr(b, "id", c.ID)
+		writeAttr(b, "label", c.Value)
+		writeAttrs(b, c.Attributes)
+		b.WriteString("><mxCell")
+	} else {
+		b.WriteString("<mxCell")
+		writeAttr(b, "id", c.ID)
+		if c.Value != "" {
+			writeAttr(b, "value", c.Value)
+		}
+	}
+	if c.Style != "" {
+		writeAttr(b, "style", c.Style)
+	}
+	if c.Vertex {
+		writeAttr(b, "vertex", "1")
+	}
+	if c.Edge {
+		writeAttr(b, "edge", "1")
+	}
+	if c.Parent != "" {
+		writeAttr(b, "parent", c.Parent)
+	}
+	if c.Source != "" {
+		writeAttr(b, "source", c.Source)
+	}
+	if c.Target != "" {
+		writeAttr(b, "target", c.Target)
+	}
+	writeAttrs(b, c.Extra)
+
+	if c.Geometry == nil {
+		b.WriteString("/>")
+	} else {
+		b.WriteString(">")
+		c.Geometry.writeXML(b)
+		b.WriteString("</mxCell>")
+	}
+	if wrapped {
+		b.WriteString("</object>")
+	}
+}
+
+func (g *MxGeometry) writeXML(b *strings.Builder) {
+	b.WriteString("<mxGeometry")
+	for _, a := range []struct {
+		name  string
+		value float64
+	}{{"x", g.X}, {"y", g.Y}, {"width", g.Width}, {"height", g.Height}} {
+		if a.value != 0 {
+			writeAttr(b, a.name, strconv.FormatFloat(a.value, 'f', -1, 64))
+		}
+	}
+	if g.Relative != "" {
+		writeAttr(b, "relative", g.Relative)
+	}
+	as := g.As
+	if as == "" {
+		as = "geometry"
+	}
+	writeAttr(b, "as", as)
+	if g.Inner == "" {
+		b.WriteString("/>")
+	} else {
+		fmt.Fprintf(b, ">%s</mxGeometry>", g.Inner)
+	}
+}
+
+// writeAttrs writes user-defined or other attributes in the order of their names. Attributes that the fields of a cell
+// hold are written separately, and a duplicate would make the XML invalid, as would a name that isn't an XML name
+func writeAttrs(b *strings.Builder, attrs map[string]string) {
+	for _, k := range sortedKeys(attrs) {
+		if !mxCellAttrs[k] && ValidAttributeName(k) {
+			writeAttr(b, k, attrs[k])
+		}
+	}
+}
+
+// ValidAttributeName checks whether a name can be the name of an attribute of a cell: an XML name without a namespace
+// prefix, which is also how encoding/xml parses it back. see https://www.w3.org/TR/xml-names/#NT-NCName
+func ValidAttributeName(name string) bool {
+	if name == "" || name == "xmlns" {
+		return false
+	}
+	for i, r := range name {
+		if !isNameStartChar(r) && (i == 0 || !isNameChar(r)) {
+			return false
+		}
+	}
+	return true
+}
+
+// isNameStartChar checks whether r may start an XML name, see https://www.w3.org/TR/xml/#NT-NameStartChar.
+// The colon, which separates a namespace prefix, is left out
+func isNameStartChar(r rune) bool {
+	return r >= 'A' && r <= 'Z' || r == '_' || r >= 'a' && r <= 'z' ||
+		r >= 0xC0 && r <= 0xD6 || r >= 0xD8 && r <= 0xF6 || r >= 0xF8 && r <= 0x2FF ||
+		r >= 0x370 && r <= 0x37D || r >= 0x37F && r <= 0x1FFF || r >= 0x200C && r <= 0x200D ||
+		r >= 0x2070 && r <= 0x218F || r >= 0x2C00 && r <= 0x2FEF || r >= 0x3001 && r <= 0xD7FF ||
+		r >= 0xF900 && r <= 0xFDCF || r >= 0xFDF0 && r <= 0xFFFD || r >= 0x10000 && r <= 0xEFFFF
+}
+
+func isNameChar(r rune) bool {
+	return r == '-' || r == '.' || r >= '0' && r <= '9' || r == 0xB7 ||
+		r >= 0x300 && r <= 0x36F || r >= 0x203F && r <= 0x2040
+}
+
+func writeAttr(b *strings.Builder, name, value string) {
+	fmt.Fprintf(b, ` %s="`, name)
+	xml.EscapeText(b, []byte(value))
+	b.WriteString(`"`)
+}
+
+// sortCells orders cells so that every parent precedes its children, otherwise preserving the order
+func (m *MxGraphModel) sortCells() {
+	depth := make(map[string]int)
+	var depthOf func(c *MxCell, seen map[string]bool) int
+	depthOf = func(c *MxCell, seen map[string]bool) int {
+		if d, ok := depth[c.ID]; ok {
+			return d
+		}
+		d := 0
+		if p := m.Cell(c.Parent); p != nil && !seen[p.ID] {
+			seen[c.ID] = true
+			d = depthOf(p, seen) + 1
+		}
+		depth[c.ID] = d
+		return d
+	}
+	for _, c := range m.Cells {
+		depthOf(c, map[string]bool{})
+	}
+	sort.SliceStable(m.Cells, func(i, j int) bool {
+		return depth[m.Cells[i].ID] < depth[m.Cells[j].ID]
+	})
+}
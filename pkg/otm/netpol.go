@@ -0,0 +1,372 @@
+package otm_transform
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	otm "github.com/adedayo/open-threat-model/pkg"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	netpolAPIVersion   = "networking.k8s.io/v1"
+	namespaceNameLabel = "kubernetes.io/metadata.name"
+	managedByLabel     = "app.kubernetes.io/managed-by"
+	flowsAnnotation    = "zero-trust/dataflows"
+	anywhere           = "0.0.0.0/0"
+	defaultNamespace   = "default"
+	dnsNamespace       = "kube-system"
+)
+
+var (
+	dnsLabelUnsafe   = regexp.MustCompile(`[^a-z0-9\-]+`)
+	labelValueUnsafe = regexp.MustCompile(`[^A-Za-z0-9_.\-]+`)
+	//ports of application protocols, for flows that don't give a port
+	wellKnownPorts = map[string]string{
+		"http": "80", "https": "443", "grpc": "443", "ssh": "22", "sftp": "22", "ftp": "21", "smtp": "25", "smtps": "465",
+		"dns": "53/UDP", "ldap": "389", "ldaps": "636", "postgres": "5432", "postgresql": "5432", "mysql": "3306",
+		"mssql": "1433", "redis": "6379", "mongodb": "27017", "amqp": "5672", "amqps": "5671", "kafka": "9092",
+		"mqtt": "1883", "mqtts": "8883", "memcached": "11211", "elasticsearch": "9200", "nats": "4222",
+	}
+)
+
+type networkPolicy struct {
+	APIVersion string         `yaml:"apiVersion"`
+	Kind       string         `yaml:"kind"`
+	Metadata   policyMetadata `yaml:"metadata"`
+	Spec       policySpec     `yaml:"spec"`
+}
+
+type policyMetadata struct {
+	Name        string            `yaml:"name"`
+	Namespace   string            `yaml:"namespace"`
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+type policySpec struct {
+	PodSelector labelSelector `yaml:"podSelector"`
+	PolicyTypes []string      `yaml:"policyTypes"`
+	Ingress     []policyRule  `yaml:"ingress,omitempty"`
+	Egress      []policyRule  `yaml:"egress,omitempty"`
+}
+
+type labelSelector struct {
+	MatchLabels map[string]string `yaml:"matchLabels,omitempty"`
+}
+
+// policyRule is an ingress rule (with From) or an egress rule (with To)
+type policyRule struct {
+	From  []policyPeer `yaml:"from,omitempty"`
+	To    []policyPeer `yaml:"to,omitempty"`
+	Ports []policyPort `yaml:"ports,omitempty"`
+}
+
+type policyPeer struct {
+	PodSelector       *labelSelector `yaml:"podSelector,omitempty"`
+	NamespaceSelector *labelSelector `yaml:"namespaceSelector,omitempty"`
+	IPBlock           *ipBlock       `yaml:"ipBlock,omitempty"`
+}
+
+type ipBlock struct {
+	CIDR string `yaml:"cidr"`
+}
+
+type policyPort struct {
+	Protocol string      `yaml:"protocol"`
+	Port     interface{} `yaml:"port,omitempty"` //number or name
+	EndPort  int         `yaml:"endPort,omitempty"`
+}
+
+// k8sElement is a trust zone or component mapped to Kubernetes by its attributes, or those of its ancestors
+type k8sElement struct {
+	namespace      string
+	labels         map[string]string
+	cidr           string
+	serviceAccount string
+	component      bool
+}
+
+// OtmToNetworkPolicies generates Kubernetes NetworkPolicy manifests that allow exactly the data flows of an OTM:
+// a default-deny policy for each namespace, which still allows DNS lookups from its pods to kube-dns, and a policy for
+// each workload allowing its modelled flows.
+// Trust zones and components are mapped to Kubernetes with attributes, which their contents inherit:
+//
+//	namespace: the namespace of the workloads of a zone or component
+//	labels:    pod labels, e.g. "tier=web, app=shop", in the default namespace unless a namespace is given;
+//	           components without labels of their own are selected by app=<id>, if their ID is a valid label
+//	           value, otherwise by a valid value derived from it
+//	cidr:      the addresses of elements outside the cluster, which are otherwise allowed anywhere
+//
+// Flows are limited to their port or ports attribute (e.g. "443", "53/UDP" or "8000-8080"), or else to the port of
+// their protocol or of the destination component's port attribute
+func OtmToNetworkPolicies(model otm.OpenThreatModel) (string, error) {
+	elements := k8sElements(model)
+
+	namespaces := []string{}
+	policies := make(map[string]*networkPolicy)
+	order := []string{}
+	policyFor := func(id string) *networkPolicy {
+		if p, exists := policies[id]; exists {
+			return p
+		}
+		e := elements[id]
+		p := &networkPolicy{
+			APIVersion: netpolAPIVersion,
+			Kind:       "NetworkPolicy",
+			Metadata: policyMetadata{
+				Name:        dnsLabel("allow-" + id),
+				Namespace:   e.namespace,
+				Labels:      map[string]string{managedByLabel: "zero-trust"},
+				Annotations: map[string]string{},
+			},
+			Spec: policySpec{
+				PodSelector: labelSelector{MatchLabels: e.labels},
+				PolicyTypes: []string{"Ingress", "Egress"},
+			},
+		}
+		policies[id] = p
+		order = append(order, id)
+		return p
+	}
+	allow := func(df otm.DataFlow, from, to string) {
+		ports := flowPorts(df, model, to)
+		if e := elements[from]; e.namespace != "" {
+			p := policyFor(from)
+			p.Spec.Egress = append(p.Spec.Egress, policyRule{To: []policyPeer{elements[to].peer()}, Ports: ports})
+			p.Metadata.Annotations[flowsAnnotation] = appendFlow(p.Metadata.Annotations[flowsAnnotation], df.ID)
+		}
+		if e := elements[to]; e.namespace != "" {
+			p := policyFor(to)
+			p.Spec.Ingress = append(p.Spec.Ingress, policyRule{From: []policyPeer{elements[from].peer()}, Ports: ports})
+			p.Metadata.Annotations[flowsAnnotation] = appendFlow(p.Metadata.Annotations[flowsAnnotation], df.ID)
+		}
+	}
+	for _, df := range model.DataFlows {
+		allow(df, df.Source, df.Destination)
+		if df.Bidirectional {
+			allow(df, df.Destination, df.Source)
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, e := range elements {
+		if e.namespace != "" && !seen[e.namespace] {
+			seen[e.namespace] = true
+			namespaces = append(namespaces, e.namespace)
+		}
+	}
+	if len(namespaces) == 0 {
+		return "", errors.New("no trust zone or component has a namespace or labels attribute to map it to Kubernetes")
+	}
+	sort.Strings(namespaces)
+
+	var b strings.Builder
+	enc := yaml.NewEncoder(&b)
+	enc.SetIndent(2)
+	for _, ns := range namespaces {
+		err := enc.Encode(networkPolicy{
+			APIVersion: netpolAPIVersion,
+			Kind:       "NetworkPolicy",
+			Metadata: policyMetadata{
+				Name:      "default-deny",
+				Namespace: ns,
+				Labels:    map[string]string{managedByLabel: "zero-trust"},
+			},
+			Spec: policySpec{
+				PolicyTypes: []string{"Ingress", "Egress"},
+				Egress: []policyRule{{
+					To: []policyPeer{{
+						NamespaceSelector: &labelSelector{MatchLabels: map[string]string{namespaceNameLabel: dnsNamespace}},
+						PodSelector:       &labelSelector{MatchLabels: map[string]string{"k8s-app": "kube-dns"}},
+					}},
+					Ports: []policyPort{{Protocol: "UDP", Port: 53}, {Protocol: "TCP", Port: 53}},
+				}},
+			},
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	for _, id := range order {
+		if err := enc.Encode(policies[id]); err != nil {
+			return "", err
+		}
+	}
+	err := enc.Close()
+	return b.String(), err
+}
+
+// k8sElements resolves the Kubernetes mapping of the trust zones and components of a model
+func k8sElements(model otm.OpenThreatModel) map[string]k8sElement {
+	attributes := make(map[string]map[string]string)
+	parents := make(map[string]string)
+	components := make(map[string]bool)
+	for _, tz := range model.TrustZones {
+		attributes[tz.ID] = stringAttributes(tz.Attributes)
+		if tz.Parent != nil {
+			parents[tz.ID] = tz.Parent.GetID()
+		}
+	}
+	for _, c := range model.Components {
+		attributes[c.ID] = stringAttributes(c.Attributes)
+		components[c.ID] = true
+		if c.Parent != nil {
+			parents[c.ID] = c.Parent.GetID()
+		}
+	}
+
+	elements := make(map[string]k8sElement)
+	for id := range attributes {
+		e := k8sElement{labels: make(map[string]string), component: components[id]}
+		labelled := false
+		seen := make(map[string]bool)
+		for ancestor := id; ancestor != "" && !seen[ancestor]; ancestor = parents[ancestor] {
+			seen[ancestor] = true
+			attrs := attributes[ancestor]
+			if e.namespace == "" {
+				e.namespace = strings.TrimSpace(attrs["namespace"])
+			}
+			labelled = labelled || strings.TrimSpace(attrs["labels"]) != ""
+			if e.cidr == "" {
+				e.cidr = strings.TrimSpace(attrs["cidr"])
+			}
+			if e.serviceAccount == "" {
+				e.serviceAccount = strings.TrimSpace(attrs["serviceAccount"])
+			}
+			for k, v := range parseLabels(attrs["labels"]) {
+				if _, set := e.labels[k]; !set {
+					e.labels[k] = v
+				}
+			}
+			if ancestor == id && e.component && attrs["labels"] == "" {
+				e.labels["app"] = labelValue(id)
+			}
+		}
+		if e.namespace == "" && labelled {
+			e.namespace = defaultNamespace
+		}
+		elements[id] = e
+	}
+	return elements
+}
+
+// peer selects an element in a policy rule
+func (e k8sElement) peer() policyPeer {
+	switch {
+	case e.namespace != "":
+		p := policyPeer{NamespaceSelector: &labelSelector{MatchLabels: map[string]string{namespaceNameLabel: e.namespace}}}
+		if len(e.labels) > 0 {
+			p.PodSelector = &labelSelector{MatchLabels: e.labels}
+		}
+		return p
+	case e.cidr != "":
+		return policyPeer{IPBlock: &ipBlock{CIDR: e.cidr}}
+	}
+	return policyPeer{IPBlock: &ipBlock{CIDR: anywhere}}
+}
+
+// flowPorts are the ports of a flow to a destination, or none (all ports) if they aren't known
+func flowPorts(df otm.DataFlow, model otm.OpenThreatModel, destination string) []policyPort {
+	spec := Attribute(df.Attributes, "ports")
+	if spec == "" {
+		spec = Attribute(df.Attributes, "port")
+	}
+	protocol := strings.ToLower(Attribute(df.Attributes, "protocol"))
+	if spec == "" {
+		spec = wellKnownPorts[protocol]
+	}
+	if spec == "" {
+		for _, c := range model.Components {
+			if c.ID == destination {
+				spec = Attribute(c.Attributes, "ports")
+				if spec == "" {
+					spec = Attribute(c.Attributes, "port")
+				}
+			}
+		}
+	}
+
+	ports := []policyPort{}
+	for _, p := range strings.FieldsFunc(spec, func(r rune) bool { return r == ',' || r == ' ' }) {
+		port := policyPort{Protocol: "TCP"}
+		switch protocol {
+		case "udp", "sctp":
+			port.Protocol = strings.ToUpper(protocol)
+		}
+		if number, proto, found := strings.Cut(p, "/"); found {
+			p, port.Protocol = number, strings.ToUpper(proto)
+		}
+		if from, to, isRange := strings.Cut(p, "-"); isRange {
+			start, e1 := strconv.Atoi(from)
+			end, e2 := strconv.Atoi(to)
+			if e1 == nil && e2 == nil {
+				port.Port, port.EndPort = start, end
+				ports = append(ports, port)
+				continue
+			}
+		}
+		if number, err := strconv.Atoi(p); err == nil {
+			port.Port = number
+		} else {
+			port.Port = p //a named port
+		}
+		ports = append(ports, port)
+	}
+	return ports
+}
+
+// parseLabels parses labels such as "app=web, tier=frontend"
+func parseLabels(labels string) map[string]string {
+	parsed := make(map[string]string)
+	for _, l := range strings.Split(labels, ",") {
+		if k, v, found := strings.Cut(l, "="); found && strings.TrimSpace(k) != "" {
+			parsed[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
+	return parsed
+}
+
+// dnsLabel turns a name into a valid Kubernetes object name
+func dnsLabel(name string) string {
+	return k8sName(name, strings.Trim(dnsLabelUnsafe.ReplaceAllString(strings.ToLower(name), "-"), "-"))
+}
+
+// labelValue turns a name into a valid Kubernetes label value
+func labelValue(name string) string {
+	return k8sName(name, strings.Trim(labelValueUnsafe.ReplaceAllString(name, "-"), "-_."))
+}
+
+// k8sName returns a name, or if it isn't valid, the valid name it was cleaned to. A cleaned name could be that of
+// another element, e.g. Web_1 and web-1 are both cleaned to web-1, or long names with the same prefix are both
+// truncated to it, so it gets a short hash of the name to keep them apart
+func k8sName(name, cleaned string) string {
+	if cleaned == name && len(name) <= 63 {
+		return name
+	}
+	hash := fmt.Sprintf("%x", sha256.Sum256([]byte(name)))[:8]
+	if len(cleaned) > 63-len(hash)-1 {
+		cleaned = cleaned[:63-len(hash)-1]
+	}
+	if cleaned = strings.TrimRight(cleaned, "-_."); cleaned == "" {
+		return hash
+	}
+	return cleaned + "-" + hash
+}
+
+func appendFlow(flows, id string) string {
+	for _, f := range strings.Split(flows, ",") {
+		if f == id {
+			return flows
+		}
+	}
+	if flows == "" {
+		return id
+	}
+	return flows + "," + id
+}
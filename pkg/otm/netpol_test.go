@@ -0,0 +1,91 @@
+package otm_transform
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestNetworkPolicyNames(t *testing.T) {
+	long := strings.Repeat("payments-service-", 4)
+	doc, err := ParseDocument(`otmVersion: 0.1.0
+project:
+  name: shop
+  id: shop
+trustZones:
+  - id: cluster
+    name: Cluster
+    attributes:
+      namespace: shop
+components:
+  - id: web-1
+    name: Web
+    type: web-server
+    parent:
+      trustZone: cluster
+  - id: Web_1
+    name: Other web
+    type: web-server
+    parent:
+      trustZone: cluster
+  - id: ` + long + `a
+    name: Payments A
+    parent:
+      trustZone: cluster
+  - id: ` + long + `b
+    name: Payments B
+    parent:
+      trustZone: cluster
+dataflows:
+  - id: web-1-a
+    source: web-1
+    destination: ` + long + `a
+  - id: web_1-b
+    source: Web_1
+    destination: ` + long + `b
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	model, err := doc.Model()
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifests, err := OtmToNetworkPolicies(model)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	validName := regexp.MustCompile(`^[a-z0-9]([-a-z0-9]{0,61}[a-z0-9])?$`)
+	validLabel := regexp.MustCompile(`^[A-Za-z0-9]([-A-Za-z0-9_.]{0,61}[A-Za-z0-9])?$`)
+	names := make(map[string]bool)
+	apps := make(map[string]bool)
+	dec := yaml.NewDecoder(strings.NewReader(manifests))
+	for {
+		var p networkPolicy
+		if err := dec.Decode(&p); err != nil {
+			break
+		}
+		if p.Metadata.Name == "default-deny" {
+			continue
+		}
+		if names[p.Metadata.Name] || !validName.MatchString(p.Metadata.Name) {
+			t.Errorf("the policy name %s is taken or not valid", p.Metadata.Name)
+		}
+		names[p.Metadata.Name] = true
+		app := p.Spec.PodSelector.MatchLabels["app"]
+		if apps[app] || !validLabel.MatchString(app) {
+			t.Errorf("the app label %s is taken or not valid", app)
+		}
+		apps[app] = true
+	}
+	if len(names) != 4 {
+		t.Fatalf("got policies %v, want one for each component:\n%s", names, manifests)
+	}
+	//valid names and labels are kept as they are
+	if !names["allow-web-1"] || !apps["web-1"] || !apps["Web_1"] {
+		t.Errorf("got policies %v selecting %v, want allow-web-1 selecting app=web-1, and app=Web_1", names, apps)
+	}
+}
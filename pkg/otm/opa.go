@@ -0,0 +1,521 @@
+package otm_transform
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	otm "github.com/adedayo/open-threat-model/pkg"
+)
+
+const (
+	opaRoot         = "zerotrust"
+	opaDataFile     = "/" + opaRoot + "/data.json"
+	opaPolicyFile   = "/" + opaRoot + "/authz/policy.rego"
+	opaManifestFile = "/.manifest"
+	//the query of EvaluateWithOPA: the decision of each of the requests of its input
+	opaDecisionsQuery = "[d | r := input.requests[_]; d := data.zerotrust.authz.decision with input as r]"
+)
+
+var (
+	// HostAttributes are the component attributes that may name the hosts of a component, as in flow logs
+	HostAttributes = []string{"host", "hostname", "hosts", "ip", "ips", "address", "addresses"}
+
+	pathTemplate = regexp.MustCompile(`\{[^}]*\}`)
+)
+
+// opaPolicy decides whether the source of a request may connect to its destination, by the data of the bundle. The
+// Evaluate method of PolicyData makes the same decisions
+const opaPolicy = `# Generated from a threat model by zero-trust: allows exactly the data flows of the model.
+#
+# input: {"source": "web", "destination": "10.0.2.9", "port": 5432, "protocol": "TCP", "method": "GET", "path": "/"}
+# Hosts are element IDs or names, host attributes, service identities or IP addresses. The attributes of the request
+# that aren't given, aren't checked.
+package zerotrust.authz
+
+import rego.v1
+
+default allow := false
+
+allow if count(flows) > 0
+
+decision := {"allow": allow, "flows": flows}
+
+# the IDs of the data flows that allow the request
+flows contains flow.id if {
+	some flow in data.zerotrust.flows
+	flow.source in sources
+	flow.destination in destinations
+	port_allowed(flow)
+	method_allowed(flow)
+	path_allowed(flow)
+}
+
+# responses of bidirectional flows, to which their methods and paths don't apply
+flows contains flow.id if {
+	some flow in data.zerotrust.flows
+	flow.bidirectional
+	flow.destination in sources
+	flow.source in destinations
+	port_allowed(flow)
+}
+
+sources := elements(input.source)
+
+destinations := elements(input.destination)
+
+# the elements of the model that a host is, and the elements they are in
+elements(host) := ids if {
+	direct := identified(host)
+	ids := direct | {a | some id in direct; some a in data.zerotrust.elements[id].ancestors}
+}
+
+identified(host) := ids if {
+	ids := {id | some id, e in data.zerotrust.elements; identifies(e, host)}
+	count(ids) > 0
+}
+
+# a host that no element identifies may be any of the elements that may be anywhere
+identified(host) := ids if {
+	count({id | some id, e in data.zerotrust.elements; identifies(e, host)}) == 0
+	ids := {id | some id, e in data.zerotrust.elements; e.anywhere}
+}
+
+identifies(e, host) if lower(host) in e.names
+
+identifies(e, host) if {
+	regex.match("^[0-9a-fA-F:.]+$", host)
+	some cidr in e.cidrs
+	net.cidr_contains(cidr, host)
+}
+
+# the transport protocol of the request, TCP unless given
+default protocol := "TCP"
+
+protocol := upper(input.protocol) if input.protocol != ""
+
+port_allowed(_) if object.get(input, "port", 0) == 0
+
+port_allowed(flow) if count(flow.ports) == 0
+
+port_allowed(flow) if {
+	some p in flow.ports
+	p.protocol == protocol
+	p.port <= input.port
+	input.port <= p.endPort
+}
+
+method_allowed(_) if object.get(input, "method", "") == ""
+
+method_allowed(flow) if count(flow.methods) == 0
+
+method_allowed(flow) if upper(input.method) in flow.methods
+
+path_allowed(_) if object.get(input, "path", "") == ""
+
+path_allowed(flow) if count(flow.paths) == 0
+
+path_allowed(flow) if {
+	some pattern in flow.paths
+	glob.match(pattern, ["/"], input.path)
+}
+`
+
+// PolicyData is the connectivity intent of a model: its elements, how hosts are identified as them, and the data flows
+// allowed between them. It is the data of the OPA bundle of a model
+type PolicyData struct {
+	Elements map[string]PolicyElement `json:"elements"`
+	Flows    []PolicyFlow             `json:"flows"`
+}
+
+// PolicyElement is a trust zone or component of a model
+type PolicyElement struct {
+	Kind string `json:"kind"` //component or trustZone
+	Name string `json:"name"`
+	Type string `json:"type,omitempty"`
+	//lower-cased names that identify hosts as the element: its ID, name, host attributes and service identities
+	Names []string `json:"names"`
+	//the networks of the hosts of the element, or of the nearest element it is in that has any
+	CIDRs []string `json:"cidrs"`
+	//whether the hosts of the element may be anywhere: it isn't in the mesh and has no networks or host attributes
+	Anywhere bool `json:"anywhere"`
+	//the trust zones and components that the element is in, innermost first
+	Ancestors []string `json:"ancestors"`
+	Namespace string   `json:"namespace,omitempty"`
+	Principal string   `json:"principal,omitempty"`
+}
+
+// PolicyFlow is a data flow of a model that is allowed
+type PolicyFlow struct {
+	ID            string       `json:"id"`
+	Name          string       `json:"name"`
+	Source        string       `json:"source"`
+	Destination   string       `json:"destination"`
+	Bidirectional bool         `json:"bidirectional"`
+	Ports         []PolicyPort `json:"ports"`   //any port if empty
+	Methods       []string     `json:"methods"` //any method if empty
+	Paths         []string     `json:"paths"`   //glob patterns of paths, any path if empty
+}
+
+// PolicyPort is a port or range of ports of a transport protocol
+type PolicyPort struct {
+	Protocol string `json:"protocol"`
+	Port     int    `json:"port"`
+	EndPort  int    `json:"endPort"`
+}
+
+// PolicyRequest asks whether a source may connect to a destination. Hosts are element IDs or names, host attributes,
+// service identities or IP addresses. Attributes that aren't given (zero) aren't checked
+type PolicyRequest struct {
+	Source      string `json:"source" yaml:"source"`
+	Destination string `json:"destination" yaml:"destination"`
+	Port        int    `json:"port,omitempty" yaml:"port,omitempty"`
+	Protocol    string `json:"protocol,omitempty" yaml:"protocol,omitempty"` //TCP unless given
+	Method      string `json:"method,omitempty" yaml:"method,omitempty"`
+	Path        string `json:"path,omitempty" yaml:"path,omitempty"`
+}
+
+// PolicyDecision is the answer to a PolicyRequest, with the data flows that allow it
+type PolicyDecision struct {
+	Allow bool     `json:"allow"`
+	Flows []string `json:"flows"`
+}
+
+// OtmToPolicyData compiles the elements and data flows of a model into PolicyData. Trust zones and components are
+// mapped to Kubernetes as for OtmToNetworkPolicies, and those in the mesh are identified by their service identities
+// as for OtmToIstioPolicies. Flows are limited to their ports as for network policies, and to their HTTP methods and
+// paths as for Istio policies, where path templates such as /orders/{id} match a single path segment
+func OtmToPolicyData(model otm.OpenThreatModel) PolicyData {
+	k8s := k8sElements(model)
+	parents := make(map[string]string)
+	data := PolicyData{Elements: make(map[string]PolicyElement), Flows: []PolicyFlow{}}
+	add := func(id, kind, name, typ string, attributes map[string]string) {
+		e := PolicyElement{Kind: kind, Name: name, Type: typ, Names: []string{}, CIDRs: []string{}, Ancestors: []string{}}
+		hosts := hostNames(attributes)
+		for _, n := range append([]string{id, name}, hosts...) {
+			if n = strings.ToLower(strings.TrimSpace(n)); n != "" {
+				e.Names = appendUniqueString(e.Names, n)
+			}
+		}
+		ke := k8s[id]
+		for _, cidr := range strings.FieldsFunc(ke.cidr, func(r rune) bool { return r == ',' || r == ' ' }) {
+			if _, _, err := net.ParseCIDR(cidr); err == nil {
+				e.CIDRs = appendUniqueString(e.CIDRs, cidr)
+			}
+		}
+		e.Namespace = ke.namespace
+		e.Anywhere = e.Namespace == "" && len(e.CIDRs) == 0 && len(hosts) == 0
+		if source, _ := ke.source(); len(source.Principals) > 0 {
+			e.Principal = source.Principals[0]
+			e.Names = appendUniqueString(e.Names, strings.ToLower(e.Principal))
+			e.Names = appendUniqueString(e.Names, strings.ToLower("spiffe://"+e.Principal))
+		}
+		data.Elements[id] = e
+	}
+	for _, tz := range model.TrustZones {
+		add(tz.ID, "trustZone", tz.Name, "", stringAttributes(tz.Attributes))
+		if tz.Parent != nil {
+			parents[tz.ID] = tz.Parent.GetID()
+		}
+	}
+	for _, c := range model.Components {
+		add(c.ID, "component", c.Name, c.Type, stringAttributes(c.Attributes))
+		if c.Parent != nil {
+			parents[c.ID] = c.Parent.GetID()
+		}
+	}
+	for id, e := range data.Elements {
+		seen := map[string]bool{id: true}
+		for ancestor := parents[id]; ancestor != "" && !seen[ancestor]; ancestor = parents[ancestor] {
+			seen[ancestor] = true
+			e.Ancestors = append(e.Ancestors, ancestor)
+		}
+		data.Elements[id] = e
+	}
+
+	for _, df := range model.DataFlows {
+		data.Flows = append(data.Flows, PolicyFlow{
+			ID:            df.ID,
+			Name:          df.Name,
+			Source:        df.Source,
+			Destination:   df.Destination,
+			Bidirectional: df.Bidirectional,
+			Ports:         policyPorts(flowPorts(df, model, df.Destination)),
+			Methods:       httpMethodsOf(df),
+			Paths:         pathGlobs(df),
+		})
+	}
+	return data
+}
+
+// Evaluate decides a request as the policy of the OPA bundle of the data does, with the IDs of the flows that allow it
+// in order, as OPA gives them
+func (data PolicyData) Evaluate(req PolicyRequest) PolicyDecision {
+	decision := PolicyDecision{Flows: []string{}}
+	sources, destinations := data.elements(req.Source), data.elements(req.Destination)
+	for _, f := range data.Flows {
+		allowed := sources[f.Source] && destinations[f.Destination] && f.allowsPort(req) && f.allowsOperation(req)
+		response := f.Bidirectional && sources[f.Destination] && destinations[f.Source] && f.allowsPort(req)
+		if allowed || response {
+			decision.Flows = appendUniqueString(decision.Flows, f.ID)
+		}
+	}
+	sort.Strings(decision.Flows)
+	decision.Allow = len(decision.Flows) > 0
+	return decision
+}
+
+// elements are the elements of the model that a host is, and the elements they are in. A host that no element
+// identifies may be any of the elements that may be anywhere
+func (data PolicyData) elements(host string) map[string]bool {
+	direct := []string{}
+	ip := net.ParseIP(host)
+	for id, e := range data.Elements {
+		identified := false
+		for _, n := range e.Names {
+			identified = identified || n == strings.ToLower(host)
+		}
+		for _, cidr := range e.CIDRs {
+			if _, network, err := net.ParseCIDR(cidr); err == nil && ip != nil {
+				identified = identified || network.Contains(ip)
+			}
+		}
+		if identified {
+			direct = append(direct, id)
+		}
+	}
+	if len(direct) == 0 {
+		for id, e := range data.Elements {
+			if e.Anywhere {
+				direct = append(direct, id)
+			}
+		}
+	}
+
+	ids := make(map[string]bool)
+	for _, id := range direct {
+		ids[id] = true
+		for _, a := range data.Elements[id].Ancestors {
+			ids[a] = true
+		}
+	}
+	return ids
+}
+
+func (f PolicyFlow) allowsPort(req PolicyRequest) bool {
+	if req.Port == 0 || len(f.Ports) == 0 {
+		return true
+	}
+	protocol := strings.ToUpper(req.Protocol)
+	if protocol == "" {
+		protocol = "TCP"
+	}
+	for _, p := range f.Ports {
+		if p.Protocol == protocol && p.Port <= req.Port && req.Port <= p.EndPort {
+			return true
+		}
+	}
+	return false
+}
+
+func (f PolicyFlow) allowsOperation(req PolicyRequest) bool {
+	method := req.Method == "" || len(f.Methods) == 0
+	for _, m := range f.Methods {
+		method = method || m == strings.ToUpper(req.Method)
+	}
+	paths := req.Path == "" || len(f.Paths) == 0
+	for _, pattern := range f.Paths {
+		matched, _ := path.Match(pattern, req.Path)
+		paths = paths || matched
+	}
+	return method && paths
+}
+
+// OtmToOPABundle generates an OPA bundle (a gzipped tarball) that allows exactly the data flows of a model: a Rego
+// policy, zerotrust.authz, that decides whether a source may connect to a destination, and the PolicyData of the model.
+// An authorization sidecar can then query data.zerotrust.authz.allow, or data.zerotrust.authz.decision for the flows
+// that allow a request
+func OtmToOPABundle(model otm.OpenThreatModel) ([]byte, error) {
+	data, err := json.MarshalIndent(OtmToPolicyData(model), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(append(data, opaPolicy...))
+	manifest, err := json.MarshalIndent(map[string]interface{}{
+		"revision": hex.EncodeToString(sum[:8]),
+		"roots":    []string{opaRoot},
+	}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	gz := gzip.NewWriter(&b)
+	tw := tar.NewWriter(gz)
+	for _, file := range []struct {
+		name    string
+		content []byte
+	}{
+		{opaManifestFile, manifest},
+		{opaPolicyFile, []byte(opaPolicy)},
+		{opaDataFile, data},
+	} {
+		header := &tar.Header{Name: file.name, Mode: 0644, Size: int64(len(file.content)), ModTime: time.Unix(0, 0)}
+		if err := tw.WriteHeader(header); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(file.content); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	err = gz.Close()
+	return b.Bytes(), err
+}
+
+// ReadOPABundle reads the PolicyData of an OPA bundle generated by OtmToOPABundle
+func ReadOPABundle(in io.Reader) (PolicyData, error) {
+	data := PolicyData{}
+	gz, err := gzip.NewReader(in)
+	if err != nil {
+		return data, fmt.Errorf("not a gzipped bundle: %w", err)
+	}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return data, fmt.Errorf("the bundle has no %s", opaDataFile)
+		}
+		if err != nil {
+			return data, err
+		}
+		if "/"+strings.TrimPrefix(header.Name, "/") == opaDataFile {
+			err := json.NewDecoder(tr).Decode(&data)
+			return data, err
+		}
+	}
+}
+
+// EvaluateWithOPA decides requests with the Rego policy of an OPA bundle, by running opa eval with the opa executable
+func EvaluateWithOPA(opa string, bundle []byte, requests []PolicyRequest) ([]PolicyDecision, error) {
+	dir, err := os.MkdirTemp("", "zero-trust-opa")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "bundle.tar.gz")
+	if err := os.WriteFile(file, bundle, 0600); err != nil {
+		return nil, err
+	}
+	input, err := json.Marshal(map[string][]PolicyRequest{"requests": requests})
+	if err != nil {
+		return nil, err
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(opa, "eval", "--format", "json", "--bundle", file, "--stdin-input", opaDecisionsQuery)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = bytes.NewReader(input), &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			err = fmt.Errorf("%w: %s", err, message)
+		}
+		return nil, fmt.Errorf("opa eval: %w", err)
+	}
+	var output struct {
+		Result []struct {
+			Expressions []struct {
+				Value []PolicyDecision `json:"value"`
+			} `json:"expressions"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &output); err != nil {
+		return nil, fmt.Errorf("opa eval: %w", err)
+	}
+	if len(output.Result) == 0 || len(output.Result[0].Expressions) == 0 {
+		return nil, errors.New("opa eval gave no decisions: is the bundle one of zero-trust?")
+	}
+	decisions := output.Result[0].Expressions[0].Value
+	if len(decisions) != len(requests) {
+		return nil, fmt.Errorf("opa eval gave %d decisions for %d requests", len(decisions), len(requests))
+	}
+	for i, d := range decisions {
+		if d.Flows == nil {
+			decisions[i].Flows = []string{}
+		}
+		sort.Strings(d.Flows)
+	}
+	return decisions, nil
+}
+
+// hostNames are the hosts named by the host attributes of an element
+func hostNames(attributes map[string]string) []string {
+	hosts := []string{}
+	for _, k := range HostAttributes {
+		hosts = append(hosts, strings.FieldsFunc(attributes[k], func(r rune) bool { return r == ',' || r == ' ' })...)
+	}
+	return hosts
+}
+
+// policyPorts are the ports of a flow as port ranges, or none (all ports) if the flow has a named port
+func policyPorts(ports []policyPort) []PolicyPort {
+	out := []PolicyPort{}
+	for _, p := range ports {
+		number, numbered := p.Port.(int)
+		if !numbered {
+			return []PolicyPort{}
+		}
+		end := p.EndPort
+		if end == 0 {
+			end = number
+		}
+		out = append(out, PolicyPort{Protocol: p.Protocol, Port: number, EndPort: end})
+	}
+	return out
+}
+
+// pathGlobs are the HTTP paths of a flow as glob patterns, where a path template matches a single path segment
+func pathGlobs(df otm.DataFlow) []string {
+	globs := []string{}
+	for _, key := range []string{"path", "paths"} {
+		for _, p := range strings.Split(Attribute(df.Attributes, key), ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				globs = appendUniqueString(globs, pathTemplate.ReplaceAllString(p, "*"))
+			}
+		}
+	}
+	return globs
+}
+
+// String describes a request, e.g. web -> db 5432/TCP
+func (req PolicyRequest) String() string {
+	s := req.Source + " -> " + req.Destination
+	if req.Port != 0 {
+		protocol := strings.ToUpper(req.Protocol)
+		if protocol == "" {
+			protocol = "TCP"
+		}
+		s += " " + strconv.Itoa(req.Port) + "/" + protocol
+	}
+	if req.Method != "" || req.Path != "" {
+		s += " " + strings.TrimSpace(strings.ToUpper(req.Method)+" "+req.Path)
+	}
+	return s
+}
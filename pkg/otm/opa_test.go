@@ -0,0 +1,125 @@
+package otm_transform
+
+import (
+	"os/exec"
+	"reflect"
+	"testing"
+)
+
+const policyModel = `otmVersion: 0.1.0
+project:
+  name: shop
+  id: shop
+trustZones:
+  - id: internal
+    name: Internal
+    risk:
+      trustRating: 80
+    attributes:
+      cidr: 10.0.0.0/16
+components:
+  - id: user
+    name: User
+    type: browser
+  - id: web
+    name: Web
+    type: web-server
+    parent:
+      trustZone: internal
+    attributes:
+      host: web.shop.local
+  - id: db
+    name: DB
+    type: database
+    parent:
+      trustZone: internal
+  - id: dns
+    name: DNS
+    type: dns-server
+    parent:
+      trustZone: internal
+dataflows:
+  - id: user-web
+    name: order
+    source: user
+    destination: web
+    attributes:
+      protocol: https
+      methods: GET, POST
+      path: /orders/{id}
+  - id: web-db
+    name: query
+    source: web
+    destination: db
+    attributes:
+      port: 5432
+  - id: web-dns
+    name: resolve
+    source: web
+    destination: dns
+    bidirectional: true
+    attributes:
+      protocol: udp
+      port: 53
+`
+
+// TestPolicyParity checks the decisions of the built-in evaluator of the policy, and that opa eval makes the same
+// decisions with the Rego policy of the bundle, if opa is on the PATH
+func TestPolicyParity(t *testing.T) {
+	doc, err := ParseDocument(policyModel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	model, err := doc.Model()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name string
+		req  PolicyRequest
+		want PolicyDecision
+	}{
+		{"any port", PolicyRequest{Source: "web", Destination: "db"}, PolicyDecision{true, []string{"web-db"}}},
+		{"port", PolicyRequest{Source: "web", Destination: "db", Port: 5432}, PolicyDecision{true, []string{"web-db"}}},
+		{"other port", PolicyRequest{Source: "web", Destination: "db", Port: 22}, PolicyDecision{false, []string{}}},
+		{"other protocol", PolicyRequest{Source: "web", Destination: "db", Port: 5432, Protocol: "udp"}, PolicyDecision{false, []string{}}},
+		{"by name", PolicyRequest{Source: "Web", Destination: "DB", Port: 5432, Protocol: "tcp"}, PolicyDecision{true, []string{"web-db"}}},
+		{"reverse", PolicyRequest{Source: "db", Destination: "web"}, PolicyDecision{false, []string{}}},
+		{"host attribute", PolicyRequest{Source: "web.shop.local", Destination: "dns", Port: 53, Protocol: "UDP"}, PolicyDecision{true, []string{"web-dns"}}},
+		{"response", PolicyRequest{Source: "dns", Destination: "web", Port: 53, Protocol: "udp"}, PolicyDecision{true, []string{"web-dns"}}},
+		{"anywhere", PolicyRequest{Source: "203.0.113.9", Destination: "web", Port: 443, Method: "get", Path: "/orders/7"}, PolicyDecision{true, []string{"user-web"}}},
+		{"method", PolicyRequest{Source: "user", Destination: "web", Port: 443, Method: "DELETE", Path: "/orders/7"}, PolicyDecision{false, []string{}}},
+		{"path", PolicyRequest{Source: "user", Destination: "web", Port: 443, Method: "GET", Path: "/orders/7/items"}, PolicyDecision{false, []string{}}},
+		{"in a zone", PolicyRequest{Source: "10.0.3.4", Destination: "db", Port: 5432}, PolicyDecision{true, []string{"web-db"}}},
+		{"outside the zones", PolicyRequest{Source: "10.1.3.4", Destination: "db", Port: 5432}, PolicyDecision{false, []string{}}},
+		{"to a zone", PolicyRequest{Source: "user", Destination: "10.0.3.4", Port: 443}, PolicyDecision{true, []string{"user-web"}}},
+	}
+
+	data := OtmToPolicyData(model)
+	requests := []PolicyRequest{}
+	for _, c := range cases {
+		if got := data.Evaluate(c.req); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: %s: got %+v, want %+v", c.name, c.req, got, c.want)
+		}
+		requests = append(requests, c.req)
+	}
+
+	opa, err := exec.LookPath("opa")
+	if err != nil {
+		t.Skip("opa isn't on the PATH, so the Rego policy isn't evaluated")
+	}
+	bundle, err := OtmToOPABundle(model)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decisions, err := EvaluateWithOPA(opa, bundle, requests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, c := range cases {
+		if !reflect.DeepEqual(decisions[i], c.want) {
+			t.Errorf("%s: %s: opa eval gave %+v, want %+v", c.name, c.req, decisions[i], c.want)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package otm_transform
+
+import (
+	"fmt"
+	"strings"
+
+	otm "github.com/adedayo/open-threat-model/pkg"
+)
+
+var (
+	plantUMLElements = map[string]string{
+		"actor":     "actor",
+		"datastore": "database",
+		"queue":     "queue",
+		"network":   "node",
+		"process":   "component",
+	}
+)
+
+// OtmToPlantUML generates a PlantUML deployment diagram of an OTM. Trust zones and parent components become
+// nested containers, drawn like OtmToGraphviz draws its clusters
+func OtmToPlantUML(model otm.OpenThreatModel) (string, error) {
+	if valid, err := model.Validate(); !valid {
+		return "", err
+	}
+
+	components := make(map[string]otm.Component)
+	for _, c := range model.Components {
+		components[c.ID] = c
+	}
+	zones := make(map[string]otm.TrustZone)
+	for _, tz := range model.TrustZones {
+		zones[tz.ID] = tz
+	}
+	ids := newDiagramIDs()
+
+	var b strings.Builder
+	b.WriteString("@startuml\n")
+	fmt.Fprintf(&b, "title %s\n", plantUMLText(model.Project.Name))
+	node := func(indent, id, name string) {
+		fmt.Fprintf(&b, "%s%s %s as %s\n", indent, plantUMLElements[ComponentKind(components[id].Type)], plantUMLLabel(name), ids.of(id))
+	}
+	var container func(c *containment, indent string)
+	container = func(c *containment, indent string) {
+		if tz, isZone := zones[c.ID]; isZone {
+			fmt.Fprintf(&b, "%srectangle %s as %s %s {\n", indent, plantUMLLabel(c.Name), ids.of(c.ID), trustRatingColour(TrustRating(tz)))
+		} else {
+			fmt.Fprintf(&b, "%s%s %s as %s %s {\n", indent, plantUMLElements[ComponentKind(components[c.ID].Type)],
+				plantUMLLabel(c.Name), ids.of(c.ID), groupFill)
+		}
+		for _, child := range c.ParentChildren {
+			container(child, indent+"  ")
+		}
+		for _, id := range sortedKeys(c.LeafChildren) {
+			node(indent+"  ", id, c.LeafChildren[id])
+		}
+		fmt.Fprintf(&b, "%s}\n", indent)
+	}
+
+	containers := genContainers(model)
+	for _, id := range sortedKeys(containers) {
+		container(containers[id], "")
+	}
+	for _, c := range looseComponents(model, containers) {
+		node("", c.ID, c.Name)
+	}
+
+	for _, df := range model.DataFlows {
+		arrow := "-->"
+		if df.Bidirectional {
+			arrow = "<-->"
+		}
+		label := ""
+		if l := flowLabel(df); l != "" {
+			label = " : " + plantUMLText(l)
+		}
+		fmt.Fprintf(&b, "%s %s %s%s\n", ids.of(df.Source), arrow, ids.of(df.Destination), label)
+	}
+
+	b.WriteString("@enduml\n")
+	return b.String(), nil
+}
+
+func plantUMLLabel(label string) string {
+	return `"` + strings.ReplaceAll(plantUMLText(label), `"`, "'") + `"`
+}
+
+func plantUMLText(text string) string {
+	return strings.NewReplacer("\r", "", "\n", `\n`).Replace(text)
+}
@@ -0,0 +1,174 @@
+package otm_transform
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"sort"
+	"strconv"
+
+	otm "github.com/adedayo/open-threat-model/pkg"
+)
+
+const (
+	pngScale     = 2.0 //pixels per diagram unit
+	glyphPixel   = 3   //pixels per dot of the bitmap font
+	glyphAdvance = (glyphWidth + 1) * glyphPixel
+)
+
+// OtmToPNG renders an OTM as a PNG image of the same diagram as OtmToSVG. Labels use a built-in bitmap font,
+// so that rendering doesn't depend on fonts being installed
+func OtmToPNG(model otm.OpenThreatModel) ([]byte, error) {
+	return OtmToPNGHighlighting(model, nil)
+}
+
+// OtmToPNGHighlighting renders an OTM as a PNG image like OtmToPNG, highlighting the data flows with the given IDs
+func OtmToPNGHighlighting(model otm.OpenThreatModel, flows []string) ([]byte, error) {
+	d, err := layoutDiagram(model, flows)
+	if err != nil {
+		return nil, err
+	}
+
+	c := newCanvas(d.width, d.height)
+	stroke := hexColour(strokeColour)
+	for _, s := range d.shapes {
+		polygon, decorations := s.outline()
+		c.fillPolygon(polygon, hexColour(s.fill))
+		c.strokePolyline(append(polygon, polygon[0]), stroke, 1)
+		for _, line := range decorations {
+			c.strokePolyline(line, stroke, 1)
+		}
+		p := s.labelPosition()
+		c.drawText(p, s.label, s.w, stroke, nil)
+	}
+
+	white := color.RGBA{0xff, 0xff, 0xff, 0xff}
+	for _, e := range d.edges {
+		colour := hexColour(e.colour)
+		from, to := e.shaft()
+		c.strokePolyline([]point{from, to}, colour, e.width)
+		c.fillPolygon(arrowHead(e.from, e.to), colour)
+		if e.bidirectional {
+			c.fillPolygon(arrowHead(e.to, e.from), colour)
+		}
+		if e.label != "" {
+			mid := point{(e.from.x + e.to.x) / 2, (e.from.y + e.to.y) / 2}
+			c.drawText(mid, e.label, math.Inf(1), stroke, white)
+		}
+	}
+
+	var out bytes.Buffer
+	err = png.Encode(&out, c.img)
+	return out.Bytes(), err
+}
+
+type canvas struct {
+	img *image.RGBA
+}
+
+func newCanvas(width, height float64) *canvas {
+	img := image.NewRGBA(image.Rect(0, 0, int(math.Ceil(width*pngScale)), int(math.Ceil(height*pngScale))))
+	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+	return &canvas{img: img}
+}
+
+// fillPolygon fills a polygon (in diagram units) with the even-odd rule, by scanning the pixel rows it covers
+func (c *canvas) fillPolygon(polygon []point, colour color.RGBA) {
+	if len(polygon) < 3 {
+		return
+	}
+	minY, maxY := math.Inf(1), math.Inf(-1)
+	for _, p := range polygon {
+		minY, maxY = math.Min(minY, p.y*pngScale), math.Max(maxY, p.y*pngScale)
+	}
+
+	for y := int(math.Floor(minY)); y <= int(math.Ceil(maxY)); y++ {
+		scan := float64(y) + 0.5
+		crossings := []float64{}
+		for i, a := range polygon {
+			b := polygon[(i+1)%len(polygon)]
+			ay, by := a.y*pngScale, b.y*pngScale
+			if (ay <= scan && by > scan) || (by <= scan && ay > scan) {
+				crossings = append(crossings, (a.x+(scan-ay)/(by-ay)*(b.x-a.x))*pngScale)
+			}
+		}
+		sort.Float64s(crossings)
+		for i := 0; i+1 < len(crossings); i += 2 {
+			for x := int(math.Round(crossings[i])); x < int(math.Round(crossings[i+1])); x++ {
+				c.img.SetRGBA(x, y, colour)
+			}
+		}
+	}
+}
+
+// strokePolyline draws connected line segments (in diagram units) of a width in diagram units
+func (c *canvas) strokePolyline(line []point, colour color.RGBA, width float64) {
+	brush := int(math.Max(1, math.Round(width*pngScale)))
+	for i := 0; i+1 < len(line); i++ {
+		a, b := line[i], line[i+1]
+		steps := int(math.Ceil(math.Hypot(b.x-a.x, b.y-a.y)*pngScale)) + 1
+		for s := 0; s <= steps; s++ {
+			t := float64(s) / float64(steps)
+			x := int(math.Floor((a.x + (b.x-a.x)*t) * pngScale))
+			y := int(math.Floor((a.y + (b.y-a.y)*t) * pngScale))
+			for dx := 0; dx < brush; dx++ {
+				for dy := 0; dy < brush; dy++ {
+					c.img.SetRGBA(x+dx-brush/2, y+dy-brush/2, colour)
+				}
+			}
+		}
+	}
+}
+
+// drawText draws a line of text centred on a point, truncated to maxWidth (in diagram units),
+// optionally on a background
+func (c *canvas) drawText(centre point, text string, maxWidth float64, colour color.RGBA, background color.Color) {
+	runes := []rune(text)
+	fits := len(runes)
+	if !math.IsInf(maxWidth, 1) {
+		fits = int(math.Max(0, (maxWidth-8)*pngScale/glyphAdvance))
+	}
+	if len(runes) > fits {
+		if fits <= 2 {
+			runes = runes[:fits]
+		} else {
+			runes = append(runes[:fits-2], '.', '.')
+		}
+	}
+
+	width := len(runes) * glyphAdvance
+	left := int(centre.x*pngScale) - width/2
+	top := int(centre.y*pngScale) - glyphHeight*glyphPixel/2
+	if background != nil && width > 0 {
+		draw.Draw(c.img, image.Rect(left-glyphPixel, top-glyphPixel, left+width, top+(glyphHeight+1)*glyphPixel),
+			image.NewUniform(background), image.Point{}, draw.Src)
+	}
+
+	for i, r := range runes {
+		glyph := glyphOf(r)
+		for col, bits := range glyph {
+			for row := 0; row < glyphHeight; row++ {
+				if bits&(1<<row) == 0 {
+					continue
+				}
+				x, y := left+i*glyphAdvance+col*glyphPixel, top+row*glyphPixel
+				draw.Draw(c.img, image.Rect(x, y, x+glyphPixel, y+glyphPixel), image.NewUniform(colour), image.Point{}, draw.Src)
+			}
+		}
+	}
+}
+
+// hexColour parses a colour such as #1a2b3c, defaulting to black
+func hexColour(hex string) color.RGBA {
+	if len(hex) != 7 || hex[0] != '#' {
+		return color.RGBA{0, 0, 0, 0xff}
+	}
+	v, err := strconv.ParseUint(hex[1:], 16, 32)
+	if err != nil {
+		return color.RGBA{0, 0, 0, 0xff}
+	}
+	return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 0xff}
+}
@@ -0,0 +1,27 @@
+package otm_transform
+
+import (
+	"image/color"
+	"testing"
+)
+
+func TestHexColour(t *testing.T) {
+	black := color.RGBA{0, 0, 0, 0xff}
+	cases := []struct {
+		hex  string
+		want color.RGBA
+	}{
+		{"#1a2b3c", color.RGBA{0x1a, 0x2b, 0x3c, 0xff}},
+		{"#FFFFFF", color.RGBA{0xff, 0xff, 0xff, 0xff}},
+		{"", black},
+		{"#", black},
+		{"#fff", black},
+		{"1a2b3c0", black},
+		{"#1a2b3g", black},
+	}
+	for _, c := range cases {
+		if got := hexColour(c.hex); got != c.want {
+			t.Errorf("%q: got %v, want %v", c.hex, got, c.want)
+		}
+	}
+}
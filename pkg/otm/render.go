@@ -0,0 +1,314 @@
+package otm_transform
+
+import (
+	"encoding/xml"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	otm "github.com/adedayo/open-threat-model/pkg"
+)
+
+const (
+	diagramMargin   = 20.0
+	diagramFontSize = 12.0
+	arrowLength     = 10.0
+	arrowWidth      = 4.0
+	groupFill       = "#f5f5f5"
+	strokeColour    = "#333333"
+	highlightColour = "#9673a6" //flows highlighted on a diagram, e.g. attack paths
+	highlightWidth  = 4.0
+)
+
+var (
+	componentFills = map[string]string{
+		"actor":     "#ffffff",
+		"datastore": "#e1d5e7",
+		"queue":     "#fff2cc",
+		"network":   "#ffe6cc",
+		"process":   "#dae8fc",
+	}
+)
+
+type point struct{ x, y float64 }
+
+// diagram is an OTM laid out for rendering, with absolute coordinates
+type diagram struct {
+	width, height float64
+	shapes        []diagramShape //containers precede their contents
+	edges         []diagramEdge
+}
+
+type diagramShape struct {
+	label     string
+	kind      string //trustZone, group, or the component kind (see ComponentKind)
+	fill      string
+	container bool
+	x, y      float64
+	w, h      float64
+}
+
+type diagramEdge struct {
+	label         string
+	from, to      point
+	bidirectional bool
+	colour        string
+	width         float64
+}
+
+// layoutDiagram lays out an OTM with the same grid layout as OtmToMXFile, resolving cell positions to absolute coordinates.
+// Edges keep the stroke of their cells (e.g. of trust boundary crossings), unless they are among the highlighted flows
+func layoutDiagram(model otm.OpenThreatModel, highlight []string) (*diagram, error) {
+	graph, err := OtmToMxGraph(model)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &diagram{}
+	highlighted := make(map[string]bool)
+	for _, id := range highlight {
+		highlighted[id] = true
+	}
+	boxes := make(map[string]diagramShape)
+	origin := func(id string) point {
+		if box, exists := boxes[id]; exists {
+			return point{box.x, box.y}
+		}
+		return point{diagramMargin, diagramMargin}
+	}
+
+	for _, c := range graph.Cells {
+		if !c.Vertex || c.Geometry == nil {
+			continue
+		}
+		o := origin(c.Parent)
+		shape := diagramShape{
+			label: c.Value,
+			x:     o.x + c.Geometry.X,
+			y:     o.y + c.Geometry.Y,
+			w:     c.Geometry.Width,
+			h:     c.Geometry.Height,
+		}
+		switch {
+		case c.Attributes["otm"] == "trustZone":
+			rating, _ := strconv.ParseFloat(c.Attributes["trustRating"], 64)
+			shape.kind, shape.fill, shape.container = "trustZone", trustRatingColour(rating), true
+		case c.Style == mxGroupStyle:
+			shape.kind, shape.fill, shape.container = "group", groupFill, true
+		default:
+			shape.kind = ComponentKind(c.Attributes["type"])
+			shape.fill = componentFills[shape.kind]
+		}
+		boxes[c.ID] = shape
+		d.shapes = append(d.shapes, shape)
+		d.width = math.Max(d.width, shape.x+shape.w+diagramMargin)
+		d.height = math.Max(d.height, shape.y+shape.h+diagramMargin)
+	}
+
+	for _, c := range graph.Cells {
+		if !c.Edge {
+			continue
+		}
+		source, target := boxes[c.Source], boxes[c.Target]
+		e := diagramEdge{
+			label:         c.Value,
+			from:          source.borderPoint(target.centre()),
+			to:            target.borderPoint(source.centre()),
+			bidirectional: c.Attributes["bidirectional"] == "true",
+			colour:        strokeColour,
+			width:         1,
+		}
+		if colour, styled := c.StyleValue("strokeColor"); styled {
+			e.colour = colour
+		}
+		if width, styled := c.StyleValue("strokeWidth"); styled {
+			if w, err := strconv.ParseFloat(width, 64); err == nil {
+				e.width = w
+			}
+		}
+		if highlighted[elementID(c)] {
+			e.colour, e.width = highlightColour, highlightWidth
+		}
+		d.edges = append(d.edges, e)
+	}
+	return d, nil
+}
+
+func (s diagramShape) centre() point {
+	return point{s.x + s.w/2, s.y + s.h/2}
+}
+
+// borderPoint is where the line from the centre of the shape towards p leaves the shape's bounding box
+func (s diagramShape) borderPoint(p point) point {
+	c := s.centre()
+	dx, dy := p.x-c.x, p.y-c.y
+	if dx == 0 && dy == 0 {
+		return c
+	}
+	t := math.Inf(1)
+	if dx != 0 {
+		t = math.Min(t, s.w/2/math.Abs(dx))
+	}
+	if dy != 0 {
+		t = math.Min(t, s.h/2/math.Abs(dy))
+	}
+	t = math.Min(t, 1)
+	return point{c.x + dx*t, c.y + dy*t}
+}
+
+// outline returns the polygon of a shape, and any lines drawn over it, e.g. the lid of a cylinder
+func (s diagramShape) outline() (polygon []point, decorations [][]point) {
+	x, y, w, h := s.x, s.y, s.w, s.h
+	switch s.kind {
+	case "trustZone":
+		return roundedRectangle(x, y, w, h, 10), [][]point{{{x, y + mxHeaderHeight}, {x + w, y + mxHeaderHeight}}}
+	case "group":
+		return rectangle(x, y, w, h), [][]point{{{x, y + mxHeaderHeight}, {x + w, y + mxHeaderHeight}}}
+	case "actor":
+		return ellipse(x+w/2, y+h/2, w/2, h/2), nil
+	case "datastore":
+		ry := math.Min(10, h/4)
+		body := arc(x+w/2, y+ry, w/2, ry, math.Pi, 2*math.Pi)
+		body = append(body, arc(x+w/2, y+h-ry, w/2, ry, 0, math.Pi)...)
+		return body, [][]point{arc(x+w/2, y+ry, w/2, ry, 0, math.Pi)}
+	case "queue":
+		return rectangle(x, y, w, h), [][]point{{{x + 10, y}, {x + 10, y + h}}, {{x + w - 10, y}, {x + w - 10, y + h}}}
+	case "network":
+		inset := math.Min(20, w/4)
+		return []point{{x + inset, y}, {x + w - inset, y}, {x + w, y + h/2}, {x + w - inset, y + h}, {x + inset, y + h}, {x, y + h/2}}, nil
+	default:
+		return roundedRectangle(x, y, w, h, 8), nil
+	}
+}
+
+// labelPosition is the centre of a shape's label: in the header of containers, in the middle of other shapes
+func (s diagramShape) labelPosition() point {
+	if s.container {
+		return point{s.x + s.w/2, s.y + mxHeaderHeight/2}
+	}
+	return s.centre()
+}
+
+// shaft returns the ends of the line of an edge, which stops short of its arrow heads so that wide lines don't hide them
+func (e diagramEdge) shaft() (from, to point) {
+	dx, dy := e.to.x-e.from.x, e.to.y-e.from.y
+	length := math.Hypot(dx, dy)
+	if length <= 2*arrowLength {
+		return e.from, e.to
+	}
+	ux, uy := dx/length*arrowLength, dy/length*arrowLength
+	from, to = e.from, point{e.to.x - ux, e.to.y - uy}
+	if e.bidirectional {
+		from = point{e.from.x + ux, e.from.y + uy}
+	}
+	return from, to
+}
+
+// arrowHead returns the triangle of an arrow pointing at tip from the direction of from
+func arrowHead(from, tip point) []point {
+	dx, dy := tip.x-from.x, tip.y-from.y
+	length := math.Hypot(dx, dy)
+	if length == 0 {
+		return nil
+	}
+	ux, uy := dx/length, dy/length
+	base := point{tip.x - ux*arrowLength, tip.y - uy*arrowLength}
+	return []point{tip, {base.x - uy*arrowWidth, base.y + ux*arrowWidth}, {base.x + uy*arrowWidth, base.y - ux*arrowWidth}}
+}
+
+func rectangle(x, y, w, h float64) []point {
+	return []point{{x, y}, {x + w, y}, {x + w, y + h}, {x, y + h}}
+}
+
+func roundedRectangle(x, y, w, h, r float64) []point {
+	r = math.Min(r, math.Min(w, h)/2)
+	pts := arc(x+w-r, y+r, r, r, 1.5*math.Pi, 2*math.Pi)
+	pts = append(pts, arc(x+w-r, y+h-r, r, r, 0, 0.5*math.Pi)...)
+	pts = append(pts, arc(x+r, y+h-r, r, r, 0.5*math.Pi, math.Pi)...)
+	return append(pts, arc(x+r, y+r, r, r, math.Pi, 1.5*math.Pi)...)
+}
+
+func ellipse(cx, cy, rx, ry float64) []point {
+	return arc(cx, cy, rx, ry, 0, 2*math.Pi)
+}
+
+// arc approximates an elliptical arc, with angles measured clockwise from the positive x axis (y points down)
+func arc(cx, cy, rx, ry, from, to float64) []point {
+	const segments = 12
+	n := int(math.Ceil(segments * (to - from) / (math.Pi / 2)))
+	pts := make([]point, 0, n+1)
+	for i := 0; i <= n; i++ {
+		a := from + (to-from)*float64(i)/float64(n)
+		pts = append(pts, point{cx + rx*math.Cos(a), cy + ry*math.Sin(a)})
+	}
+	return pts
+}
+
+// OtmToSVG renders an OTM as an SVG diagram, laid out like OtmToMXFile, without needing Graphviz or a browser
+func OtmToSVG(model otm.OpenThreatModel) (string, error) {
+	return OtmToSVGHighlighting(model, nil)
+}
+
+// OtmToSVGHighlighting renders an OTM as an SVG diagram like OtmToSVG, highlighting the data flows with the given IDs,
+// e.g. to overlay attack paths
+func OtmToSVGHighlighting(model otm.OpenThreatModel, flows []string) (string, error) {
+	d, err := layoutDiagram(model, flows)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%s" height="%s" viewBox="0 0 %[1]s %[2]s" font-family="Helvetica, Arial, sans-serif" font-size="%s">`,
+		svgNumber(d.width), svgNumber(d.height), svgNumber(diagramFontSize))
+	b.WriteString(`<rect width="100%" height="100%" fill="#ffffff"/>`)
+
+	for _, s := range d.shapes {
+		polygon, decorations := s.outline()
+		fmt.Fprintf(&b, `<polygon points="%s" fill="%s" stroke="%s"/>`, svgPoints(polygon), s.fill, strokeColour)
+		for _, line := range decorations {
+			fmt.Fprintf(&b, `<polyline points="%s" fill="none" stroke="%s"/>`, svgPoints(line), strokeColour)
+		}
+		p := s.labelPosition()
+		weight := ""
+		if s.container {
+			weight = ` font-weight="bold"`
+		}
+		fmt.Fprintf(&b, `<text x="%s" y="%s" text-anchor="middle" dominant-baseline="central"%s>%s</text>`, svgNumber(p.x), svgNumber(p.y), weight, svgText(s.label))
+	}
+
+	for _, e := range d.edges {
+		from, to := e.shaft()
+		fmt.Fprintf(&b, `<line x1="%s" y1="%s" x2="%s" y2="%s" stroke="%s" stroke-width="%s"/>`,
+			svgNumber(from.x), svgNumber(from.y), svgNumber(to.x), svgNumber(to.y), e.colour, svgNumber(e.width))
+		fmt.Fprintf(&b, `<polygon points="%s" fill="%s"/>`, svgPoints(arrowHead(e.from, e.to)), e.colour)
+		if e.bidirectional {
+			fmt.Fprintf(&b, `<polygon points="%s" fill="%s"/>`, svgPoints(arrowHead(e.to, e.from)), e.colour)
+		}
+		if e.label != "" {
+			fmt.Fprintf(&b, `<text x="%s" y="%s" text-anchor="middle" dominant-baseline="central" stroke="#ffffff" stroke-width="3" paint-order="stroke">%s</text>`,
+				svgNumber((e.from.x+e.to.x)/2), svgNumber((e.from.y+e.to.y)/2), svgText(e.label))
+		}
+	}
+
+	b.WriteString("</svg>")
+	return b.String(), nil
+}
+
+func svgNumber(f float64) string {
+	return strconv.FormatFloat(math.Round(f*100)/100, 'f', -1, 64)
+}
+
+func svgPoints(pts []point) string {
+	coords := make([]string, len(pts))
+	for i, p := range pts {
+		coords[i] = svgNumber(p.x) + "," + svgNumber(p.y)
+	}
+	return strings.Join(coords, " ")
+}
+
+func svgText(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
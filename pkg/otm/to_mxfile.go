@@ -1,9 +1,368 @@
 package otm_transform
 
-import otm "github.com/adedayo/open-threat-model/pkg"
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
 
-//Converts an OTM to MXFile XML format
+	otm "github.com/adedayo/open-threat-model/pkg"
+)
+
+const (
+	mxRootID       = "0"
+	mxLayerID      = "1"
+	mxElementAttr  = "otmID" //ID of the element a cell shows, if the cell has another ID
+	mxLeafWidth    = 120.0
+	mxLeafHeight   = 60.0
+	mxPadding      = 20.0
+	mxHeaderHeight = 30.0
+	mxZoneStyle    = "swimlane;whiteSpace=wrap;html=1;container=1;collapsible=0;startSize=30;rounded=1;fillColor=#dae8fc;strokeColor=#6c8ebf;"
+	mxGroupStyle   = "swimlane;whiteSpace=wrap;html=1;container=1;collapsible=0;startSize=30;fillColor=#f5f5f5;strokeColor=#666666;"
+	mxFlowStyle    = "edgeStyle=orthogonalEdgeStyle;rounded=0;orthogonalLoop=1;html=1;endArrow=classic;"
+)
+
+var (
+	mxComponentStyles = map[string]string{
+		"actor":     "shape=umlActor;verticalLabelPosition=bottom;verticalAlign=top;html=1;outlineConnect=0;",
+		"datastore": "shape=cylinder3;whiteSpace=wrap;html=1;boundedLbl=1;backgroundOutline=1;size=15;",
+		"queue":     "shape=process;whiteSpace=wrap;html=1;backgroundOutline=1;",
+		"network":   "shape=hexagon;perimeter=hexagonPerimeter2;whiteSpace=wrap;html=1;fixedSize=1;",
+		"process":   "rounded=1;whiteSpace=wrap;html=1;",
+	}
+)
+
+// Converts an OTM to MXFile XML format, which can be opened in draw.io or the visual model editor.
+// Trust zones become nested containers, components become shapes in their parent and data flows become labelled edges.
+// Cells take the IDs of the OTM elements they represent, unless the ID is that of the root or layer of the diagram, or of
+// another cell. Such cells get a unique ID, and keep the ID of their element in their otmID attribute
 // see https://github.com/jgraph/mxgraph/blob/master/javascript/src/js/io/mxObjectCodec.js
 func OtmToMXFile(model otm.OpenThreatModel) (g string, err error) {
-	return
+	graph, err := OtmToMxGraph(model)
+	if err != nil {
+		return
+	}
+	var b strings.Builder
+	b.WriteString(`<mxfile host="zero-trust"><diagram`)
+	writeAttr(&b, "id", model.Project.ID)
+	writeAttr(&b, "name", model.Project.Name)
+	b.WriteString(">")
+	b.WriteString(graph.String())
+	b.WriteString("</diagram></mxfile>")
+	return b.String(), nil
+}
+
+// OtmToMxGraph lays out an OTM as the cells of an mxGraph model
+func OtmToMxGraph(model otm.OpenThreatModel) (*MxGraphModel, error) {
+	if valid, err := model.Validate(); !valid {
+		return nil, err
+	}
+
+	graph := &MxGraphModel{
+		Cells: []*MxCell{
+			{ID: mxRootID},
+			{ID: mxLayerID, Parent: mxRootID},
+		},
+	}
+
+	components := make(map[string]otm.Component)
+	for _, c := range model.Components {
+		components[c.ID] = c
+	}
+	zones := make(map[string]otm.TrustZone)
+	for _, tz := range model.TrustZones {
+		zones[tz.ID] = tz
+	}
+
+	top := []*mxNode{}
+	containers := genContainers(model)
+	contained := make(map[string]bool)
+	for _, id := range sortedKeys(containers) {
+		top = append(top, newMxNode(containers[id], contained))
+	}
+	//components that are neither in a container nor contain others
+	for _, c := range model.Components {
+		if !contained[c.ID] {
+			if _, isContainer := containers[c.ID]; !isContainer {
+				top = append(top, &mxNode{id: c.ID, name: c.Name})
+			}
+		}
+	}
+
+	ids := newMxCellIDs(model, mxRootID, mxLayerID)
+	layer := &mxNode{id: mxLayerID, children: top}
+	layer.layout()
+	layer.addCells(graph, mxLayerID, ids, zones, components)
+
+	crossings := crossingsByFlow(model)
+	for _, df := range model.DataFlows {
+		source, target := ids.cells[df.Source], ids.cells[df.Destination]
+		if source == "" || target == "" {
+			continue
+		}
+		style := mxFlowStyle
+		if df.Bidirectional {
+			style += "startArrow=classic;"
+		}
+		attrs := map[string]string{"otm": "dataflow"}
+		for k, v := range stringAttributes(df.Attributes) {
+			if k != mxElementAttr {
+				attrs[k] = v
+			}
+		}
+		if df.Bidirectional {
+			attrs["bidirectional"] = "true"
+		}
+		if c, crosses := crossings[df.ID]; crosses {
+			style += fmt.Sprintf("strokeColor=%s;strokeWidth=%g;", crossingHighlight, crossingWidth(c.Score))
+			attrs[boundaryScoreAttr] = fmt.Sprint(c.Score)
+		}
+		graph.Cells = append(graph.Cells, &MxCell{
+			ID:         ids.assign(df.ID, attrs),
+			Value:      flowLabel(df),
+			Style:      style,
+			Parent:     mxLayerID,
+			Source:     source,
+			Target:     target,
+			Edge:       true,
+			Geometry:   &MxGeometry{Relative: "1", As: "geometry"},
+			Attributes: attrs,
+		})
+	}
+
+	return graph, nil
+}
+
+// AddToMxGraph adds cells for the elements of a model that a diagram (e.g. the visual model of a project) doesn't show
+// yet, keeping its cells as they are. The new cells are laid out as by OtmToMxGraph, and placed below the cells already
+// in the container they are added to, which grows to fit them. An empty diagram stays empty
+func AddToMxGraph(diagram string, model otm.OpenThreatModel) (string, error) {
+	if strings.TrimSpace(diagram) == "" {
+		return diagram, nil
+	}
+	graph, err := ParseMxGraph(diagram)
+	if err != nil {
+		return diagram, err
+	}
+	laidOut, err := OtmToMxGraph(model)
+	if err != nil {
+		return diagram, err
+	}
+
+	existing := make(map[string]bool)
+	for _, c := range graph.Cells {
+		existing[c.ID] = true
+	}
+	//cells of elements that the diagram already shows take the IDs of the cells showing them, new cells take IDs
+	//that the diagram doesn't use yet
+	shown := make(map[string]string) //kind/element ID -> ID of the cell in the diagram
+	for id, kind := range classifyCells(graph) {
+		shown[kind+"/"+elementID(graph.Cell(id))] = id
+	}
+	ids := newMxCellIDs(model, sortedKeys(existing)...)
+	renamed := map[string]string{mxRootID: mxRootID, mxLayerID: mxLayerID}
+	for _, c := range laidOut.Cells {
+		if kind := c.Attributes["otm"]; kind != "" {
+			element := elementID(c)
+			if id, isShown := shown[kind+"/"+element]; isShown {
+				renamed[c.ID] = id
+			} else {
+				delete(c.Attributes, mxElementAttr)
+				renamed[c.ID] = ids.assign(element, c.Attributes)
+			}
+		}
+	}
+	for _, c := range laidOut.Cells {
+		c.ID, c.Parent, c.Source, c.Target = renamed[c.ID], renamed[c.Parent], renamed[c.Source], renamed[c.Target]
+	}
+	//the new shapes added directly to existing containers, and the top of their layout in each container
+	added := make(map[string][]*MxCell)
+	tops := make(map[string]float64)
+	for _, c := range laidOut.Cells {
+		if existing[c.ID] || !existing[c.Parent] || !c.Vertex || c.Geometry == nil {
+			continue
+		}
+		if top, seen := tops[c.Parent]; !seen || c.Geometry.Y < top {
+			tops[c.Parent] = c.Geometry.Y
+		}
+		added[c.Parent] = append(added[c.Parent], c)
+	}
+	for _, parent := range sortedKeys(added) {
+		bottom := mxPadding
+		if p := graph.Cell(parent); p != nil && strings.HasPrefix(p.Style, "swimlane") {
+			bottom += mxHeaderHeight
+		}
+		for _, c := range graph.Cells {
+			if c.Parent == parent && c.Vertex && c.Geometry != nil {
+				bottom = math.Max(bottom, c.Geometry.Y+c.Geometry.Height+mxPadding)
+			}
+		}
+		right, end := 0.0, 0.0
+		for _, c := range added[parent] {
+			c.Geometry.Y += bottom - tops[parent]
+			right = math.Max(right, c.Geometry.X+c.Geometry.Width+mxPadding)
+			end = math.Max(end, c.Geometry.Y+c.Geometry.Height+mxPadding)
+		}
+		if p := graph.Cell(parent); p != nil && p.Vertex && p.Geometry != nil {
+			p.Geometry.Width, p.Geometry.Height = math.Max(p.Geometry.Width, right), math.Max(p.Geometry.Height, end)
+		}
+	}
+
+	for _, c := range laidOut.Cells {
+		if existing[c.ID] {
+			continue
+		}
+		if c.Edge && (graph.Cell(c.Source) == nil || graph.Cell(c.Target) == nil) {
+			continue
+		}
+		graph.Cells = append(graph.Cells, c)
+		existing[c.ID] = true
+	}
+	graph.sortCells()
+	return withGraph(diagram, graph), nil
+}
+
+// mxNode is a container or shape being laid out, with its geometry relative to its parent
+type mxNode struct {
+	id, name   string
+	container  bool
+	children   []*mxNode
+	x, y, w, h float64
+}
+
+func newMxNode(c *containment, contained map[string]bool) *mxNode {
+	n := &mxNode{id: c.ID, name: c.Name, container: true}
+	contained[c.ID] = true
+
+	children := append([]*containment{}, c.ParentChildren...)
+	sort.Slice(children, func(i, j int) bool { return children[i].ID < children[j].ID })
+	for _, child := range children {
+		n.children = append(n.children, newMxNode(child, contained))
+	}
+	for _, id := range sortedKeys(c.LeafChildren) {
+		contained[id] = true
+		n.children = append(n.children, &mxNode{id: id, name: c.LeafChildren[id]})
+	}
+	return n
+}
+
+// layout sizes a node and positions its children in a grid
+func (n *mxNode) layout() {
+	if len(n.children) == 0 {
+		n.w, n.h = mxLeafWidth, mxLeafHeight
+		if n.container {
+			n.w, n.h = mxLeafWidth+2*mxPadding, mxLeafHeight+mxHeaderHeight
+		}
+		return
+	}
+
+	for _, c := range n.children {
+		c.layout()
+	}
+
+	cols := int(math.Ceil(math.Sqrt(float64(len(n.children)))))
+	top := mxPadding
+	if n.container {
+		top += mxHeaderHeight
+	}
+	y, width := top, 0.0
+	for row := 0; row*cols < len(n.children); row++ {
+		x, rowHeight := mxPadding, 0.0
+		for _, c := range n.children[row*cols : int(math.Min(float64((row+1)*cols), float64(len(n.children))))] {
+			c.x, c.y = x, y
+			x += c.w + mxPadding
+			rowHeight = math.Max(rowHeight, c.h)
+		}
+		width = math.Max(width, x)
+		y += rowHeight + mxPadding
+	}
+	n.w, n.h = width, y
+}
+
+// addCells adds the cells of the children of a node, whose cell has the ID parent
+func (n *mxNode) addCells(graph *MxGraphModel, parent string, ids *mxCellIDs, zones map[string]otm.TrustZone, components map[string]otm.Component) {
+	for _, c := range n.children {
+		cell := &MxCell{
+			Value:    c.name,
+			Parent:   parent,
+			Vertex:   true,
+			Geometry: &MxGeometry{X: c.x, Y: c.y, Width: c.w, Height: c.h, As: "geometry"},
+		}
+
+		if tz, isZone := zones[c.id]; isZone {
+			cell.Style = mxZoneStyle
+			cell.Attributes = map[string]string{
+				"otm":         "trustZone",
+				"trustRating": fmt.Sprint(TrustRating(tz)),
+			}
+			if tz.Type != "" {
+				cell.Attributes["type"] = tz.Type
+			}
+		} else {
+			comp := components[c.id]
+			cell.Style = mxComponentStyles[ComponentKind(comp.Type)]
+			if c.container {
+				cell.Style = mxGroupStyle
+			}
+			cell.Attributes = map[string]string{"otm": "component"}
+			if comp.Type != "" {
+				cell.Attributes["type"] = comp.Type
+			}
+		}
+		cell.ID = ids.assign(c.id, cell.Attributes)
+		ids.cells[c.id] = cell.ID
+		graph.Cells = append(graph.Cells, cell)
+		c.addCells(graph, cell.ID, ids, zones, components)
+	}
+}
+
+// mxCellIDs gives the cells of a diagram unique IDs
+type mxCellIDs struct {
+	used     map[string]bool   //IDs of the cells so far
+	elements map[string]bool   //IDs of the elements of the model, which cells with other IDs avoid
+	cells    map[string]string //zone or component ID -> ID of its cell
+}
+
+func newMxCellIDs(model otm.OpenThreatModel, used ...string) *mxCellIDs {
+	ids := &mxCellIDs{used: make(map[string]bool), elements: make(map[string]bool), cells: make(map[string]string)}
+	for _, id := range used {
+		ids.used[id] = true
+	}
+	for _, tz := range model.TrustZones {
+		ids.elements[tz.ID] = true
+	}
+	for _, c := range model.Components {
+		ids.elements[c.ID] = true
+	}
+	for _, df := range model.DataFlows {
+		ids.elements[df.ID] = true
+	}
+	return ids
+}
+
+// assign returns the ID of the cell of an element: the ID of the element if no other cell has it, otherwise the ID
+// with a numeric suffix, recording the ID of the element in the attributes of the cell
+func (ids *mxCellIDs) assign(element string, attrs map[string]string) string {
+	id := element
+	for n := 2; ids.used[id]; n++ {
+		if id = fmt.Sprintf("%s-%d", element, n); ids.elements[id] {
+			id = element
+		}
+	}
+	if id != element {
+		attrs[mxElementAttr] = element
+	}
+	ids.used[id] = true
+	return id
+}
+
+func flowLabel(df otm.DataFlow) string {
+	label := df.Name
+	if p := Attribute(df.Attributes, "protocol"); p != "" {
+		if label == "" {
+			return p
+		}
+		label = fmt.Sprintf("%s (%s)", label, p)
+	}
+	return label
 }
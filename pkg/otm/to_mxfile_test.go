@@ -0,0 +1,202 @@
+package otm_transform
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAddToMxGraph(t *testing.T) {
+	doc, err := ParseDocument(baseModel + `  - id: web-cache
+    name: lookup
+    source: web
+    destination: cache
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.AddElement("components", map[string]interface{}{
+		"id": "cache", "name": "Cache", "type": "redis", "parent": map[string]string{"trustZone": "internet"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	model, err := doc.Model()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//the diagram of the base model, with the web server moved and a note that isn't in the model
+	diagram := `<root><mxCell id="0"/><mxCell id="1" parent="0"/>` +
+		`<object id="internet" label="Internet" otm="trustZone"><mxCell style="swimlane;" vertex="1" parent="1"><mxGeometry x="500" y="500" width="200" height="130" as="geometry"/></mxCell></object>` +
+		`<object id="web" label="Web" otm="component"><mxCell style="rounded=1;" vertex="1" parent="internet"><mxGeometry x="20" y="50" width="120" height="60" as="geometry"/></mxCell></object>` +
+		`<object id="db" label="DB" otm="component"><mxCell style="shape=cylinder3;" vertex="1" parent="1"><mxGeometry x="800" y="10" width="120" height="60" as="geometry"/></mxCell></object>` +
+		`<mxCell id="note" value="keep me" vertex="1" parent="1"><mxGeometry x="5" y="5" width="80" height="20" as="geometry"/></mxCell>` +
+		`<mxCell id="web-db" value="query" edge="1" parent="1" source="web" target="db"><mxGeometry relative="1" as="geometry"/></mxCell>` +
+		`</root>`
+
+	cases := []struct {
+		name, diagram string
+	}{
+		{"no diagram", ""},
+		{"a diagram", diagram},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			merged, err := AddToMxGraph(c.diagram, model)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.diagram == "" {
+				if merged != "" {
+					t.Errorf("got %s, want no diagram", merged)
+				}
+				return
+			}
+			if !strings.HasPrefix(merged, "<root>") {
+				t.Errorf("the diagram should keep its form: %s", merged)
+			}
+			graph, err := ParseMxGraph(merged)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, id := range []string{"note", "web", "db", "web-db", "cache", "web-cache"} {
+				if graph.Cell(id) == nil {
+					t.Errorf("the diagram has no cell %s: %s", id, merged)
+				}
+			}
+			if g := graph.Cell("db").Geometry; g == nil || g.X != 800 || g.Y != 10 {
+				t.Errorf("the existing cell db has moved: %+v", g)
+			}
+			cache := graph.Cell("cache")
+			if cache.Parent != "internet" || cache.Geometry == nil || cache.Geometry.Y < 50+60 {
+				t.Errorf("the new cell cache should be below web in the internet zone: %+v %+v", cache, cache.Geometry)
+			}
+			if zone := graph.Cell("internet").Geometry; zone.Y != 500 || zone.Height < cache.Geometry.Y+cache.Geometry.Height {
+				t.Errorf("the internet zone should grow to fit cache, in place: %+v", zone)
+			}
+		})
+	}
+}
+
+func TestOtmToMxGraphIDs(t *testing.T) {
+	//elements with the IDs of the root and layer of the diagram, and a data flow with the ID of a component
+	doc, err := ParseDocument(`otmVersion: 0.1.0
+project:
+  name: shop
+  id: shop
+trustZones:
+  - id: "1"
+    name: Internet
+    risk:
+      trustRating: 10
+components:
+  - id: "0"
+    name: Browser
+    type: browser
+    parent:
+      trustZone: "1"
+  - id: web
+    name: Web
+    type: web-server
+dataflows:
+  - id: web
+    name: order
+    source: "0"
+    destination: web
+    attributes:
+      protocol: https
+      otmID: db
+      "bad name": x
+  - id: web-2
+    name: reply
+    source: web
+    destination: "0"
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	model, err := doc.Model()
+	if err != nil {
+		t.Fatal(err)
+	}
+	graph, err := OtmToMxGraph(model)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := make(map[string]bool)
+	for _, c := range graph.Cells {
+		if ids[c.ID] {
+			t.Errorf("more than one cell has ID %s", c.ID)
+		}
+		ids[c.ID] = true
+	}
+	cells := map[string]string{"trustZone/1": "1-2", "component/0": "0-2", "component/web": "web", "dataflow/web": "web-3", "dataflow/web-2": "web-2"}
+	for _, c := range graph.Cells {
+		if kind := c.Attributes["otm"]; kind != "" {
+			if want := cells[kind+"/"+elementID(c)]; c.ID != want {
+				t.Errorf("%s %s has cell ID %s, want %s", kind, elementID(c), c.ID, want)
+			}
+		}
+	}
+	if browser := graph.Cell("0-2"); browser.Parent != "1-2" {
+		t.Errorf("the browser should be in the cell of its zone: %+v", browser)
+	}
+	if flow := graph.Cell("web-3"); flow.Source != "0-2" || flow.Target != "web" {
+		t.Errorf("the order flow should connect the cells of its elements: %+v", flow)
+	}
+	diagram := graph.String()
+	if _, err := ParseMxGraph(diagram); err != nil || strings.Contains(diagram, "bad name") {
+		t.Errorf("the diagram isn't valid XML (%v): %s", err, diagram)
+	}
+
+	//the elements of the diagram keep their IDs
+	tm, err := MXFileToOtm(diagram, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	derived, err := ParseDocument(tm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for section, want := range map[string][]string{
+		"trustZones": {"1"},
+		"components": {"0", "web"},
+		"dataflows":  {"web", "web-2"},
+	} {
+		if got := derived.ElementIDs(section); strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("got %s %v, want %v", section, got, want)
+		}
+	}
+	for _, want := range []string{"source: \"0\"", "trustZone: \"1\""} {
+		if !strings.Contains(tm, want) {
+			t.Errorf("the derived model doesn't contain %s:\n%s", want, tm)
+		}
+	}
+}
+
+func TestValidAttributeName(t *testing.T) {
+	cases := []struct {
+		name  string
+		valid bool
+	}{
+		{"protocol", true},
+		{"_private", true},
+		{"data-classification", true},
+		{"v1.2", true},
+		{"Größe", true},
+		{"", false},
+		{"1st", false},
+		{"-dash", false},
+		{"two words", false},
+		{"a\"b", false},
+		{"a=b", false},
+		{"ns:name", false},
+		{"xmlns", false},
+		{"a/>", false},
+	}
+	for _, c := range cases {
+		if got := ValidAttributeName(c.name); got != c.valid {
+			t.Errorf("%q: got %v, want %v", c.name, got, c.valid)
+		}
+	}
+}
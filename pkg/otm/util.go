@@ -0,0 +1,161 @@
+package otm_transform
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	otm "github.com/adedayo/open-threat-model/pkg"
+)
+
+var (
+	//attribute values that deny a security property, e.g. authentication: none
+	denials = map[string]bool{"none": true, "false": true, "no": true, "anonymous": true, "off": true, "disabled": true}
+	//attributes that describe the authentication of a data flow
+	authenticationAttributes = []string{"authentication", "authenticated", "auth"}
+)
+
+// TrustRating returns the trust rating (0-100) of a trust zone
+func TrustRating(tz otm.TrustZone) float64 {
+	return float64(tz.Risk.TrustRating)
+}
+
+// parentRef describes a parent as trustZone:<id> or component:<id>, and is empty if there is no parent
+func parentRef(p otm.Parent) string {
+	if p == nil {
+		return ""
+	}
+	if p.IsTrustZone() {
+		return fmt.Sprintf("trustZone:%s", p.GetID())
+	}
+	return fmt.Sprintf("component:%s", p.GetID())
+}
+
+var (
+	//the words of component types that identify their kinds, in order of precedence
+	componentKindWords = []struct {
+		kind  string
+		words []string
+	}{
+		{"datastore", []string{"database", "datastore", "db", "storage", "store", "bucket", "cache", "sql", "nosql", "mysql",
+			"postgres", "postgresql", "mariadb", "mongodb", "redis", "memcached", "dynamodb", "rds", "s3", "blob", "file", "files"}},
+		{"queue", []string{"queue", "topic", "stream", "kafka", "bus", "broker", "sqs", "sns", "pubsub", "rabbitmq", "kinesis",
+			"eventhub"}},
+		{"network", []string{"loadbalancer", "balancer", "gateway", "proxy", "firewall", "ingress", "cdn", "waf", "lb"}},
+		{"actor", []string{"actor", "user", "users", "client", "browser", "person", "human"}},
+	}
+	//words that make a client or user a piece of software rather than an actor, e.g. api-client
+	softwareQualifiers = map[string]bool{"api": true, "service": true, "sdk": true, "library": true, "lib": true,
+		"http": true, "grpc": true, "rest": true}
+	typeWordSeparators = regexp.MustCompile(`[^a-z0-9]+`)
+	camelCaseBoundary  = regexp.MustCompile(`([a-z0-9])([A-Z])`)
+)
+
+// ComponentKind classifies a component by its (free-form) OTM type into actor, datastore, queue, network or process,
+// by the words of the type, e.g. user-service or LoadBalancer. The last word, as the noun the others qualify, takes
+// precedence, and only it can make a component an actor: user-service is a process
+func ComponentKind(componentType string) string {
+	words := strings.Fields(typeWordSeparators.ReplaceAllString(
+		strings.ToLower(camelCaseBoundary.ReplaceAllString(componentType, "$1 $2")), " "))
+	if len(words) == 0 {
+		return "process"
+	}
+	//compound words written apart, e.g. load balancer or data-store
+	compounds := []string{}
+	for i := 0; i+1 < len(words); i++ {
+		compounds = append(compounds, words[i]+words[i+1])
+	}
+	head := []string{words[len(words)-1]}
+	if len(compounds) > 0 {
+		head = append(head, compounds[len(compounds)-1])
+	}
+	software := false
+	for _, w := range words[:len(words)-1] {
+		software = software || softwareQualifiers[w]
+	}
+
+	matches := func(candidates []string, actor bool) string {
+		for _, k := range componentKindWords {
+			if (k.kind == "actor") != actor {
+				continue
+			}
+			for _, w := range candidates {
+				if containsString(k.words, w) {
+					return k.kind
+				}
+			}
+		}
+		return ""
+	}
+	if kind := matches(head, false); kind != "" {
+		return kind
+	}
+	if kind := matches(head, true); kind != "" && !software {
+		return kind
+	}
+	if kind := matches(append(words[:len(words)-1], compounds...), false); kind != "" {
+		return kind
+	}
+	return "process"
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Attribute returns an OTM attribute as a string, or empty if it isn't set
+func Attribute[V any](attrs map[string]V, key string) string {
+	if v, exists := attrs[key]; exists {
+		return fmt.Sprint(v)
+	}
+	return ""
+}
+
+// Denies checks whether an attribute value denies a security property, e.g. none or false
+func Denies(value string) bool {
+	return denials[strings.ToLower(value)]
+}
+
+// Denied checks whether any of the attributes denies a property, e.g. encrypted: false
+func Denied[V any](attrs map[string]V, keys ...string) bool {
+	for _, k := range keys {
+		if Denies(Attribute(attrs, k)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Asserted checks whether any of the attributes asserts a property, e.g. authentication: mTLS
+func Asserted[V any](attrs map[string]V, keys ...string) bool {
+	for _, k := range keys {
+		if v := Attribute(attrs, k); v != "" && !Denies(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticated checks whether a data flow declares how it is authenticated, e.g. authentication: mTLS
+func Authenticated(df otm.DataFlow) bool {
+	return Asserted(df.Attributes, authenticationAttributes...)
+}
+
+// Unauthenticated checks whether a data flow declares that it needs no authentication, e.g. authentication: none.
+// A flow that says nothing about authentication is neither authenticated nor unauthenticated
+func Unauthenticated(df otm.DataFlow) bool {
+	return Denied(df.Attributes, authenticationAttributes...)
+}
+
+func stringAttributes[V any](attrs map[string]V) map[string]string {
+	out := make(map[string]string)
+	for k, v := range attrs {
+		out[k] = fmt.Sprint(v)
+	}
+	return out
+}
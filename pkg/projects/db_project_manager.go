@@ -9,7 +9,9 @@ import (
 	"path"
 	"sort"
 	"strings"
+	"time"
 
+	otm_transform "github.com/0-trust/service/pkg/otm"
 	"github.com/0-trust/service/pkg/util"
 	"github.com/dgraph-io/badger/v3"
 )
@@ -17,9 +19,23 @@ import (
 var (
 	projectFile          = "project-summary.yaml"
 	defaultCodeDirPrefix = "code"
+	maxSaveAttempts      = 3
+
+	ErrMergeConflict = errors.New("the model was changed concurrently and the changes conflict")
 )
 
+// NewDBProjectManager opens the project database of a service, taking over the lock of a previous service that crashed
 func NewDBProjectManager(ztBaseDir string) (ProjectManager, error) {
+	return openDBProjectManager(ztBaseDir, true)
+}
+
+// OpenDBProjectManager opens the project database for a command line tool. Unlike NewDBProjectManager it leaves the
+// lock of the database alone, and fails if another process, e.g. a running API service, has the database open
+func OpenDBProjectManager(ztBaseDir string) (ProjectManager, error) {
+	return openDBProjectManager(ztBaseDir, false)
+}
+
+func openDBProjectManager(ztBaseDir string, removeLock bool) (ProjectManager, error) {
 
 	pm := dbProjectManager{
 		baseDir:          ztBaseDir,
@@ -27,6 +43,7 @@ func NewDBProjectManager(ztBaseDir string) (ProjectManager, error) {
 		projectTable:     "proj_",
 		workspaceTable:   "works_",
 		modelTable:       "model_",
+		revisionTable:    "rev_",
 	}
 
 	//attempt to create the project location if it doesn't exist
@@ -35,12 +52,18 @@ func NewDBProjectManager(ztBaseDir string) (ProjectManager, error) {
 	//attempt to manage memory by setting WithNum...
 	opts := badger.DefaultOptions(pm.projectsLocation) //.WithNumMemtables(1).WithNumLevelZeroTables(1).WithNumLevelZeroTablesStall(5)
 
-	//clean up lock on the DB if previous crash
-	lockFile := path.Join(opts.Dir, "LOCK")
-	_ = os.Remove(lockFile)
+	if removeLock {
+		//clean up lock on the DB if previous crash
+		lockFile := path.Join(opts.Dir, "LOCK")
+		_ = os.Remove(lockFile)
+	}
 
 	db, err := badger.Open(opts)
 	if err != nil {
+		if !removeLock && strings.Contains(err.Error(), "directory lock") {
+			return pm, fmt.Errorf("the project database %s is in use, e.g. by a running API service; stop it or use the API: %w",
+				pm.projectsLocation, err)
+		}
 		return pm, err
 	}
 	pm.db = db
@@ -52,7 +75,7 @@ type dbProjectManager struct {
 	baseDir, projectsLocation    string
 	db                           *badger.DB
 	projectTable, workspaceTable string
-	modelTable                   string
+	modelTable, revisionTable    string
 }
 
 // GetModel implements ProjectManager
@@ -88,17 +111,264 @@ func (pm dbProjectManager) UpdateModel(projectID string, msg *Message) (*Message
 		ThreatIsUpdated: strings.TrimSpace(msg.ThreatModel) != "",
 	}
 
-	data, err := json.Marshal(model)
+	var rev *Revision
+	err := badger.ErrConflict
+	//retry if another save of the model commits while this one is in progress; the retry will merge with it
+	for attempt := 0; attempt < maxSaveAttempts && errors.Is(err, badger.ErrConflict); attempt++ {
+		err = pm.db.Update(func(txn *badger.Txn) (e error) {
+			toSave := model
+			if e = pm.regenerateThreatModel(txn, projectID, msg.BaseRevision, &toSave); e != nil {
+				return
+			}
+			if msg.BaseRevision != "" {
+				if toSave, e = pm.mergeWithHead(txn, projectID, msg, toSave); e != nil {
+					return
+				}
+			}
+			rev, e = pm.saveRevision(txn, projectID, toSave, msg.Author, msg.Comment)
+			return
+		})
+	}
 
 	if err != nil {
 		return msg, err
 	}
 
-	err = pm.db.Update(func(txn *badger.Txn) error {
-		return txn.Set(toKey(pm.modelTable, projectID), data)
+	msg.Revision = rev.ID
+	msg.BaseRevision = rev.ID
+	return msg, nil
+}
+
+// regenerateThreatModel derives the threat model from the visual model, if only the visual model changed
+// relative to the base revision, or the current model if there is no base revision
+func (pm dbProjectManager) regenerateThreatModel(txn *badger.Txn, projectID, baseRevision string, model *Model) error {
+	if !model.VisualIsUpdated {
+		return nil
+	}
+
+	reference := &Model{}
+	if baseRevision != "" {
+		rev, err := pm.getRevision(txn, projectID, baseRevision)
+		if err != nil {
+			return fmt.Errorf("base revision %s: %w", baseRevision, err)
+		}
+		reference = rev.Model
+	} else if head, err := pm.getModel(txn, projectID); err == nil {
+		reference = head
+	} else if !errors.Is(err, badger.ErrKeyNotFound) {
+		return err
+	}
+
+	if model.VisualModel == reference.VisualModel ||
+		(model.ThreatIsUpdated && model.ThreatModel != reference.ThreatModel) {
+		return nil
+	}
+
+	threatModel := reference.ThreatModel
+	if strings.TrimSpace(threatModel) == "" {
+		var proj Project
+		if item, err := txn.Get(pm.toProjectKey(projectID)); err == nil {
+			item.Value(func(val []byte) error {
+				return json.Unmarshal(val, &proj)
+			})
+		}
+		doc, err := otm_transform.NewDocument(otm_transform.ProjectInfo{
+			Name:         proj.Name,
+			ID:           projectID,
+			Description:  proj.Description,
+			Owner:        proj.Owner,
+			OwnerContact: proj.OwnerContact,
+		})
+		if err != nil {
+			return err
+		}
+		if threatModel, err = doc.String(); err != nil {
+			return err
+		}
+	}
+
+	tm, err := otm_transform.MXFileToOtm(model.VisualModel, reference.VisualModel, threatModel)
+	if err != nil {
+		//keep the threat model as it is, a visual model that can't be parsed shouldn't prevent saving
+		log.Printf("Could not derive the threat model of project %s from its visual model: %v", projectID, err)
+		return nil
+	}
+	model.ThreatModel = tm
+	model.ThreatIsUpdated = strings.TrimSpace(tm) != ""
+	return nil
+}
+
+// mergeWithHead merges the model derived from msg.BaseRevision with the changes saved since that revision.
+// Conflicting changes are reported in msg.Conflicts and result in ErrMergeConflict
+func (pm dbProjectManager) mergeWithHead(txn *badger.Txn, projectID string, msg *Message, model Model) (Model, error) {
+	head, err := pm.getModel(txn, projectID)
+	if err != nil {
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return model, nil
+		}
+		return model, err
+	}
+	if head.Revision == msg.BaseRevision {
+		return model, nil
+	}
+
+	base, err := pm.getRevision(txn, projectID, msg.BaseRevision)
+	if err != nil {
+		return model, fmt.Errorf("base revision %s: %w", msg.BaseRevision, err)
+	}
+
+	tm, conflicts, err := otm_transform.MergeThreatModels(base.Model.ThreatModel, model.ThreatModel, head.ThreatModel)
+	if err != nil {
+		return model, err
+	}
+	vm, visualConflicts, err := otm_transform.MergeMxGraphs(base.Model.VisualModel, model.VisualModel, head.VisualModel)
+	if err != nil {
+		return model, err
+	}
+	conflicts = append(conflicts, visualConflicts...)
+
+	if len(conflicts) > 0 {
+		msg.Conflicts = conflicts
+		return model, ErrMergeConflict
+	}
+
+	msg.ThreatModel = tm
+	msg.VisualModel = vm
+	msg.Merged = true
+	return Model{
+		ThreatModel:     tm,
+		VisualModel:     vm,
+		VisualIsUpdated: strings.TrimSpace(vm) != "",
+		ThreatIsUpdated: strings.TrimSpace(tm) != "",
+	}, nil
+}
+
+// saveRevision records the model as a new revision of the project and makes it the current model
+func (pm dbProjectManager) saveRevision(txn *badger.Txn, projectID string, model Model, author, message string) (*Revision, error) {
+	parent := ""
+	head, err := pm.getModel(txn, projectID)
+	if err == nil {
+		parent = head.Revision
+	} else if !errors.Is(err, badger.ErrKeyNotFound) {
+		return nil, err
+	}
+
+	rev := &Revision{
+		ID:        util.NewRandomUUID().String(),
+		ProjectID: projectID,
+		Parent:    parent,
+		Author:    author,
+		Message:   message,
+		Timestamp: time.Now(),
+	}
+	model.Revision = rev.ID
+	rev.Model = &model
+
+	data, err := json.Marshal(rev)
+	if err != nil {
+		return nil, err
+	}
+	if err := txn.Set(pm.toRevisionKey(projectID, rev.ID), data); err != nil {
+		return nil, err
+	}
+
+	data, err = json.Marshal(model)
+	if err != nil {
+		return nil, err
+	}
+	return rev, txn.Set(toKey(pm.modelTable, projectID), data)
+}
+
+func (pm dbProjectManager) getModel(txn *badger.Txn, projectID string) (*Model, error) {
+	var model Model
+	item, err := txn.Get(toKey(pm.modelTable, projectID))
+	if err != nil {
+		return nil, err
+	}
+	return &model, item.Value(func(val []byte) error {
+		return json.Unmarshal(val, &model)
 	})
+}
 
-	return msg, nil
+// ListRevisions implements ProjectManager
+func (pm dbProjectManager) ListRevisions(projectID string) ([]*Revision, error) {
+	revs := RevisionSlice{}
+	err := pm.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		prefix := pm.toRevisionKey(projectID, "")
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			err := it.Item().Value(func(val []byte) error {
+				var rev Revision
+				if err := json.Unmarshal(val, &rev); err != nil {
+					return err
+				}
+				rev.Model = nil
+				revs = append(revs, &rev)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	sort.Sort(revs)
+
+	return revs, err
+}
+
+// GetRevision implements ProjectManager
+func (pm dbProjectManager) GetRevision(projectID, revisionID string) (rev *Revision, err error) {
+	err = pm.db.View(func(txn *badger.Txn) (e error) {
+		rev, e = pm.getRevision(txn, projectID, revisionID)
+		return
+	})
+	if rev == nil {
+		rev = &Revision{}
+	}
+	return rev, err
+}
+
+func (pm dbProjectManager) getRevision(txn *badger.Txn, projectID, revisionID string) (*Revision, error) {
+	var rev Revision
+	item, err := txn.Get(pm.toRevisionKey(projectID, revisionID))
+	if err != nil {
+		return nil, err
+	}
+	err = item.Value(func(val []byte) error {
+		return json.Unmarshal(val, &rev)
+	})
+	if rev.Model == nil {
+		rev.Model = &Model{}
+	}
+	return &rev, err
+}
+
+// RollbackModel implements ProjectManager
+func (pm dbProjectManager) RollbackModel(projectID, revisionID, author string) (*Message, error) {
+	rev, err := pm.GetRevision(projectID, revisionID)
+	if err != nil {
+		return &Message{
+			ProjectID: projectID,
+			HasError:  true,
+			Error:     err.Error(),
+		}, err
+	}
+
+	return pm.UpdateModel(projectID, &Message{
+		Type:        "update_ui",
+		ProjectID:   projectID,
+		ThreatModel: rev.Model.ThreatModel,
+		VisualModel: rev.Model.VisualModel,
+		Author:      author,
+		Comment:     fmt.Sprintf("Rolled back to revision %s", revisionID),
+	})
+}
+
+func (pm dbProjectManager) toRevisionKey(projectID, revisionID string) []byte {
+	return toTableKey(pm.revisionTable, projectID+":", revisionID)
 }
 
 func stripMXGraph(s string) string {
@@ -113,7 +383,9 @@ func (pm dbProjectManager) DeleteProject(id string) error {
 	}
 
 	//delete project
-	pm.deleteProject(id)
+	if err := pm.deleteProject(id); err != nil {
+		return err
+	}
 	//remove it from workspaces
 	if ws, err := pm.GetWorkspaces(); err == nil {
 		ws.RemoveProject(proj, pm)
@@ -148,7 +420,10 @@ func (pm dbProjectManager) CreateProject(projectDescription ProjectDescription)
 	})
 
 	if err == nil {
-		pm.UpdateModel(project.ID, &Message{})
+		pm.UpdateModel(project.ID, &Message{
+			Author:  projectDescription.Owner,
+			Comment: "Project created",
+		})
 	}
 	return project, err
 }
@@ -182,9 +457,25 @@ func (pm dbProjectManager) GetProject(projectID string) (*Project, error) {
 	return &pSum, err
 }
 
+// deleteProject deletes a project together with its model and the history of its revisions
 func (pm dbProjectManager) deleteProject(projectID string) error {
 	return pm.db.Update(func(txn *badger.Txn) error {
-		return txn.Delete(pm.toProjectKey(projectID))
+		keys := [][]byte{pm.toProjectKey(projectID), toKey(pm.modelTable, projectID)}
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		prefix := pm.toRevisionKey(projectID, "")
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			keys = append(keys, it.Item().KeyCopy(nil))
+		}
+		it.Close()
+
+		for _, key := range keys {
+			if err := txn.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
 	})
 }
 
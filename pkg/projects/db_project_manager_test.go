@@ -0,0 +1,90 @@
+package projects
+
+import (
+	"testing"
+)
+
+func newTestProjectManager(t *testing.T) dbProjectManager {
+	t.Helper()
+	pm, err := NewDBProjectManager(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := pm.(dbProjectManager)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestRevisions(t *testing.T) {
+	pm := newTestProjectManager(t)
+	proj, err := pm.CreateProject(ProjectDescription{Name: "shop", Owner: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	models := []string{"otmVersion: 0.1.0\n# first\n", "otmVersion: 0.1.0\n# second\n"}
+	saved := []string{}
+	for i, tm := range models {
+		msg, err := pm.UpdateModel(proj.ID, &Message{ThreatModel: tm, Author: "bob", Comment: models[i]})
+		if err != nil {
+			t.Fatal(err)
+		}
+		saved = append(saved, msg.Revision)
+	}
+
+	revs, err := pm.ListRevisions(proj.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revs) != 3 {
+		t.Fatalf("got %d revisions, want the created project and two saves", len(revs))
+	}
+	if revs[0].Message != "Project created" || revs[0].Author != "alice" || revs[0].Parent != "" {
+		t.Errorf("got first revision %+v, want the creation of the project by its owner", *revs[0])
+	}
+	for i, rev := range revs[1:] {
+		if rev.ID != saved[i] || rev.Parent != revs[i].ID || rev.Author != "bob" {
+			t.Errorf("got revision %+v, want %s derived from %s", *rev, saved[i], revs[i].ID)
+		}
+		if rev.Model != nil {
+			t.Errorf("revision %s is listed with its model", rev.ID)
+		}
+	}
+
+	first, err := pm.GetRevision(proj.ID, saved[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Model.ThreatModel != models[0] || first.Model.Revision != saved[0] {
+		t.Errorf("got model %+v of revision %s, want the first save", *first.Model, saved[0])
+	}
+	if _, err := pm.GetRevision(proj.ID, "missing"); err == nil {
+		t.Error("got a revision that was never saved")
+	}
+
+	msg, err := pm.RollbackModel(proj.ID, saved[0], "carol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, err := pm.GetModel(proj.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head.ThreatModel != models[0] || head.Revision != msg.Revision {
+		t.Errorf("got model %q at revision %s, want the first save restored as revision %s", head.ThreatModel, head.Revision, msg.Revision)
+	}
+	rollback, err := pm.GetRevision(proj.ID, msg.Revision)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rollback.Parent != saved[1] || rollback.Author != "carol" || rollback.Message != "Rolled back to revision "+saved[0] {
+		t.Errorf("got rollback revision %+v, want a new revision derived from the last save", *rollback)
+	}
+	if revs, _ := pm.ListRevisions(proj.ID); len(revs) != 4 {
+		t.Errorf("got %d revisions after the rollback, want 4: rollbacks don't rewrite history", len(revs))
+	}
+
+	if _, err := pm.RollbackModel(proj.ID, "missing", "carol"); err == nil {
+		t.Error("rolled back to a revision that was never saved")
+	}
+}
@@ -16,6 +16,11 @@ type ProjectManager interface {
 		wsSummariser WorkspaceSummariser) (*Project, error)
 	UpdateModel(projectID string, msg *Message) (*Message, error)
 	GetModel(projectID string) (*Message, error)
+	//ListRevisions returns the revision history of a project's model, oldest first, without the model content
+	ListRevisions(projectID string) ([]*Revision, error)
+	GetRevision(projectID, revisionID string) (*Revision, error)
+	//RollbackModel restores the model of the given revision by saving it as a new revision
+	RollbackModel(projectID, revisionID, author string) (*Message, error)
 	GetProjectLocation(projID string) string
 	//ZeroTrust base directory
 	GetBaseDir() string
@@ -1,6 +1,10 @@
 package projects
 
 import (
+	"time"
+
+	"github.com/0-trust/service/pkg/collab"
+	otm_transform "github.com/0-trust/service/pkg/otm"
 	otm "github.com/adedayo/open-threat-model/pkg"
 )
 
@@ -95,8 +99,22 @@ type Message struct {
 	Workspace   string `json:"workspace"`
 	ThreatModel string `json:"threatModel"`
 	VisualModel string `json:"visualModel"`
-	HasError    bool   `json:"hasError"`
-	Error       string `json:"error"`
+	Format      string `json:"format"`   //output format requested by process_model, e.g. graphviz or mermaid
+	Revision    string `json:"revision"` //revision ID of the model carried by this message
+	//revision the sender's model was derived from. If set, and the project has since moved on,
+	//the sender's changes are merged into the current model instead of overwriting it
+	BaseRevision string                        `json:"baseRevision"`
+	Merged       bool                          `json:"merged"` //the model carried was merged with concurrent changes
+	Conflicts    []otm_transform.MergeConflict `json:"conflicts,omitempty"`
+	//collaborative editing: the session version, fine-grained edit operations and participants' presence
+	Version    int                `json:"version"`
+	Operations []collab.Operation `json:"operations,omitempty"`
+	Rejected   []collab.Rejection `json:"rejected,omitempty"`
+	Presence   []collab.Presence  `json:"presence,omitempty"`
+	Author     string             `json:"author"`
+	Comment    string             `json:"comment"` //revision message recorded when the model is saved
+	HasError   bool               `json:"hasError"`
+	Error      string             `json:"error"`
 }
 
 type Model struct {
@@ -104,4 +122,30 @@ type Model struct {
 	VisualModel     string `json:"visualModel"`
 	VisualIsUpdated bool   `json:"visualIsUpdated"`
 	ThreatIsUpdated bool   `json:"threatIsUpdated"`
+	Revision        string `json:"revision"`
+}
+
+// Revision is an immutable snapshot of a project's model, taken every time the model is saved
+type Revision struct {
+	ID        string    `json:"id"`
+	ProjectID string    `json:"projectID"`
+	Parent    string    `json:"parent"` //ID of the revision this one was derived from, empty for the first revision
+	Author    string    `json:"author"`
+	Message   string    `json:"message"`
+	Timestamp time.Time `json:"timestamp"`
+	Model     *Model    `json:"model,omitempty"` //not populated when listing revisions
+}
+
+type RevisionSlice []*Revision
+
+func (t RevisionSlice) Len() int {
+	return len(t)
+}
+
+func (t RevisionSlice) Less(i, j int) bool {
+	return t[i].Timestamp.Before(t[j].Timestamp)
+}
+
+func (t RevisionSlice) Swap(i, j int) {
+	t[i], t[j] = t[j], t[i]
 }